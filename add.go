@@ -0,0 +1,349 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// addReport is what add prints with -json: what happened to each image, plus the error that stopped the run, if any
+type addReport struct {
+	Database string          `json:"database"`
+	DryRun   bool            `json:"dry_run,omitempty"`
+	Added    []addReportItem `json:"added"`
+	Replaced []addReportItem `json:"replaced"`
+	// Skipped are images left out because of -no-overwrite or -replace-only, & Errors are those that couldn't be loaded
+	Skipped []addReportItem `json:"skipped"`
+	Errors  []addReportItem `json:"errors"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// addReportItem is a single image in an addReport. Reason is why it was skipped or failed.
+type addReportItem struct {
+	Signature hexSig `json:"signature"`
+	File      string `json:"file"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// newAddReport returns an empty report, with empty rather than nil lists so they're written as [] rather than null
+func newAddReport(labelsDB string, dryRun bool) *addReport {
+	return &addReport{Database: labelsDB, DryRun: dryRun, Added: []addReportItem{}, Replaced: []addReportItem{},
+		Skipped: []addReportItem{}, Errors: []addReportItem{}}
+}
+
+// put records an image that was added, or that replaced an existing entry. Like the other methods, it does nothing on
+// a nil report.
+func (r *addReport) put(img Image, replaced bool) {
+	if r == nil {
+		return
+	}
+	item := addReportItem{Signature: hexSig(img.Signature), File: img.Filepath}
+	if replaced {
+		r.Replaced = append(r.Replaced, item)
+	} else {
+		r.Added = append(r.Added, item)
+	}
+}
+
+// skip records an image that was left out on purpose
+func (r *addReport) skip(img Image, reason string) {
+	if r != nil {
+		r.Skipped = append(r.Skipped, addReportItem{hexSig(img.Signature), img.Filepath, reason})
+	}
+}
+
+// fail records an image that couldn't be loaded
+func (r *addReport) fail(img Image, err error) {
+	if r != nil {
+		r.Errors = append(r.Errors, addReportItem{hexSig(img.Signature), img.Filepath, err.Error()})
+	}
+}
+
+// write prints the report to stdout as JSON, along with err if it isn't nil
+func (r *addReport) write(err error) error {
+	if err != nil {
+		r.Error = err.Error()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	// The reasons are error messages, which are full of &s that shouldn't be escaped
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+// add implements `add {labels.db} {image files}`, converting each image & adding or replacing its entry. This is also
+// what runs when the first argument isn't a command, so the original `a3dlabels {labels.db} {image files}` still works.
+func add(args []string) error {
+	return runAdd("add", args)
+}
+
+// deploy implements `deploy {image files}`, which is add with the labels.db on a mounted Analogue 3D SD card found
+// automatically. The database is checked once it's been written.
+func deploy(args []string) error {
+	return runAdd("deploy", args)
+}
+
+// runAdd implements add & deploy
+func runAdd(name string, args []string) (err error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var card *string
+	if name == "deploy" {
+		card = fs.String("card", "", "where the SD card is mounted, if it can't be found automatically")
+	}
+	// A card is exactly the kind of slow, easily unplugged target resumable writes are for
+	fs.BoolVar(&resumableWrites, "resumable", card != nil,
+		"write labels.db in verified chunks that an interrupted run can pick up from, for slow SD cards")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	noCache := fs.Bool("no-cache", false, "convert every image from scratch instead of reusing earlier conversions")
+	noProfile := fs.Bool("no-profile", false, "don't apply the calibration profile saved with the calibration command")
+	fs.Int64Var(&maxImageBytes, "max-image-bytes", defaultMaxImageBytes,
+		"skip source images larger than this many bytes (0 for no limit)")
+	fs.IntVar(&maxImageDimension, "max-image-dimension", defaultMaxImageDimension,
+		"skip source images wider or taller than this many pixels (0 for no limit)")
+	registerROMCheck(fs)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to warn about art a manifest titles for a "+
+		"different region than the dump with its signature")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	padding := fs.String("padding", "auto",
+		"hex byte pattern to pad new entries with, or auto to copy the padding used by the existing entries")
+	cleanIndex := fs.Bool("clean-index", false, "blank any leftover data in the index after the EOF marker")
+	preservePadding := fs.Bool("preserve-padding", true,
+		"keep the existing padding of entries being replaced, rather than using the padding for new entries")
+	var scale scaleOptions
+	fs.StringVar(&scale.Mode, "scale-mode", "stretch",
+		"how to fit images to the label: stretch, fit (letterbox), fill (scale & trim), or crop (no scaling)")
+	fs.StringVar(&scale.Filter, "filter", "lanczos",
+		"resampling filter: nearest, box, linear, catmullrom, mitchell, or lanczos")
+	var stamps stampFlag
+	fs.Var(&stamps, "stamp", "draw text on every label, as text[,position[,RRGGBB]]; can be repeated")
+	var badges badgeFlag
+	fs.Var(&badges, "badge", "draw an image on every label, as file[,position]; can be repeated")
+	padColor := fs.String("pad-color", "000000", "colour for the space around fitted or cropped images, as RRGGBB[AA]")
+	fs.BoolVar(&scale.TrimEdges, "auto-trim-edges", false,
+		"trim a thin transparent or white edge left by a bad crop, so it doesn't show as a border")
+	fs.Float64Var(&scale.Sharpen, "sharpen", 0,
+		"sharpen the art once it's scaled, as the sigma of an unsharp mask such as 0.5 (0 for none)")
+	preset := registerPreset(fs)
+	rememberFraming := fs.Bool("remember-framing", false, "remember -scale-mode, -filter, -pad-color, & "+
+		"-auto-trim-edges as the framing for every signature added, so later runs fit new art for them the same way")
+	noFraming := fs.Bool("no-framing", false, "ignore the framing remembered for each signature")
+	var alpha alphaOptions
+	alpha.register(fs)
+	dryRun := fs.Bool("dry-run", false, "convert everything & show what would change, without writing anything")
+	sandbox := fs.Bool("sandbox", false, "make the changes to a temporary copy of labels.db & show what changed")
+	commit := fs.Bool("commit", false, "with -sandbox, copy the result back over the real labels.db")
+	shadow := fs.Bool("shadow-diff", false,
+		"build the new labels.db in memory, list every byte range that would change, & ask before writing it")
+	salvage := fs.Bool("salvage", false,
+		"replace entries that can't be read from labels.db with blank ones instead of failing")
+	sinceState := fs.String("since-state", "",
+		"record each run in this file & only add images that are new or changed since the last one")
+	aliasFile := fs.String("aliases", "", "a file of alias groups: each image is also added under the other signatures "+
+		"in its group (default the table saved with alias import)")
+	noAliases := fs.Bool("no-aliases", false, "don't use the saved alias table")
+	var also alsoFlag
+	fs.Var(&also, "also", "with a single image, add it under these signatures too; can be repeated")
+	var dirs dirFlag
+	fs.Var(&dirs, "dir", "add the images in this art directory, named after their signatures or listed in manifests; "+
+		"can be repeated, with each directory overriding the ones before it")
+	asJSON := fs.Bool("json", false, "print what happened to each image as JSON, leaving the log on stderr")
+	noQA := fs.Bool("no-qa", false, "don't check images against the QA thresholds in the config file")
+	qaReport := fs.String("qa-report", "", "where to write the QA report when there are QA thresholds "+
+		"(default labels.db.qa.json next to labels.db)")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err = parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	// What's given on the command line is noted before the preset fills in the rest, since only that overrides the
+	// remembered framing
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if err := applyPreset(fs, *preset); err != nil {
+		return err
+	}
+	if card != nil {
+		if len(args) < 1 && len(dirs) == 0 {
+			return errors.New("usage: deploy [flags] [-card mount point] [-dir art dir...] {image files}")
+		}
+		db, err := findCardDB(*card)
+		if err != nil {
+			return err
+		}
+		args = append([]string{db}, args...)
+	}
+	if len(args) < 1 || (len(args) < 2 && len(dirs) == 0) {
+		return errors.New("usage: add [flags] [-dir art dir...] {labels.db} {image files}")
+	}
+	labelsDB, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	// Images given as arguments come after the -dir layers, so they override all of them
+	customImgs, err := layerDirs(dirs)
+	if err != nil {
+		return err
+	}
+	listed, err := generateListFromArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	customImgs = append(customImgs, listed...)
+	// Duplicates are settled before aliasing, so every alias of a signature gets the image that won it
+	customImgs = dedupeImages(customImgs)
+	// Checked before aliasing, since an alias puts art on another region's signature on purpose
+	if *roms != "" {
+		rc, err := newRegionCheck(*roms, *dat)
+		if err != nil {
+			return err
+		}
+		rc.warn(customImgs)
+	} else if *dat != "" {
+		return errors.New("-dat needs -roms")
+	}
+	for _, d := range dirs {
+		notePack(d)
+	}
+	for _, a := range args[1:] {
+		if fi, err := os.Stat(a); isRemote(a) || isManifest(a) || (err == nil && fi.IsDir()) {
+			notePack(a)
+		}
+	}
+	if customImgs, err = aliasImages(customImgs, *aliasFile, *noAliases, also); err != nil {
+		return err
+	}
+
+	settings := addSettings{PreservePadding: *preservePadding, CleanIndex: *cleanIndex, DryRun: *dryRun, Salvage: *salvage,
+		ShadowDiff: *shadow, Policy: policy}
+	if *asJSON {
+		settings.Report = newAddReport(labelsDB, *dryRun || (*sandbox && !*commit))
+		defer func() {
+			if werr := settings.Report.write(err); werr != nil && err == nil {
+				err = werr
+			}
+		}()
+	}
+	if !*noCache {
+		if dir, err := defaultStoreDir(); err != nil {
+			log.Printf("Not caching conversions: %v", err)
+		} else if settings.Store, err = openStore(dir); err != nil {
+			log.Printf("Not caching conversions: %v", err)
+		}
+	}
+	if err := scale.validate(); err != nil {
+		return err
+	}
+	if scale.PadColor, err = parseColor(*padColor); err != nil {
+		return err
+	}
+	settings.Scale = scale
+	// Remembered framing is used for its signatures, apart from any of it that's overridden on the command line
+	var framings framingTable
+	if !*noFraming || *rememberFraming {
+		if framings, err = loadFraming(); err != nil {
+			return err
+		}
+	}
+	if !*noFraming {
+		if settings.Framing, err = framings.options(scale, explicit); err != nil {
+			return err
+		}
+	}
+	if err := alpha.validate(); err != nil {
+		return err
+	}
+	settings.Alpha = alpha
+	settings.Stamps, settings.Badges = stamps, badges
+	if !*noProfile {
+		if settings.Colour, err = loadColourProfile(); err != nil {
+			return err
+		}
+	}
+	if *padding != "auto" {
+		if settings.Padding, err = parsePadding(*padding); err != nil {
+			return err
+		}
+	}
+	if !*noQA {
+		t, err := loadQAThresholds()
+		if err != nil {
+			return err
+		}
+		settings.QA = newQARun(labelsDB, t)
+	}
+
+	// With -since-state, a run where nothing has changed is silent so that it can be left to cron
+	var state *runState
+	allImgs := customImgs
+	if *sinceState != "" {
+		if state, err = loadRunState(*sinceState); err != nil {
+			return err
+		}
+		if customImgs = state.changed(labelsDB, customImgs, settings.stateKey()); len(customImgs) == 0 {
+			return nil
+		}
+		log.Printf("%s of %s images are new or changed since the last run", formatCount(len(customImgs)),
+			formatCount(len(allImgs)))
+	}
+
+	if *backup && !*dryRun {
+		if err := backupFile(labelsDB, labelsDB+".bak"); err != nil {
+			return err
+		}
+	}
+	if !settings.Colour.isZero() {
+		log.Printf("Applying calibration profile %s", settings.Colour)
+	}
+
+	var skipped int
+	if *sandbox {
+		skipped, err = addInSandbox(labelsDB, customImgs, settings, *commit)
+	} else {
+		skipped, err = addImages(labelsDB, customImgs, settings)
+	}
+	if settings.QA != nil {
+		path := cmp.Or(*qaReport, labelsDB+".qa.json")
+		if werr := settings.QA.write(path); werr != nil {
+			log.Printf("Writing the QA report: %v", werr)
+		} else {
+			log.Printf("%s of %s images passed QA, wrote the report to %s",
+				formatCount(settings.QA.Checked-settings.QA.Failed), formatCount(settings.QA.Checked), path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if card != nil && !*dryRun && (!*sandbox || *commit) {
+		if err := verifyDeployed(labelsDB); err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+	}
+	if settings.QA != nil && settings.QA.Failed > 0 {
+		return withCode(codeImageQA, fmt.Errorf("%d images failed QA & were left out", settings.QA.Failed))
+	}
+	// Anything that didn't end up in the real labels.db mustn't be recorded, or the next run would skip it
+	if state != nil && !*dryRun && (!*sandbox || *commit) {
+		if err := state.save(*sinceState, labelsDB, allImgs, settings.stateKey()); err != nil {
+			return err
+		}
+	}
+	if *rememberFraming && !*dryRun && (!*sandbox || *commit) {
+		for _, img := range customImgs {
+			framings.remember(img.Signature, settings.scaleFor(img.Signature))
+		}
+		if err := framings.save(); err != nil {
+			return err
+		}
+		log.Printf("Remembered the framing for %s signatures", formatCount(len(customImgs)))
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// aliasGroup is the signatures of the regions & revisions of one game, which would normally all use the same label
+type aliasGroup struct {
+	Sigs []uint32
+	// Name is the comment the group was written with, usually the game's name. It's only kept for writing the group out.
+	Name string
+}
+
+// String formats the group the way it's written in an alias file
+func (g *aliasGroup) String() string {
+	if g.Name == "" {
+		return g.sigs()
+	}
+	return g.sigs() + "  # " + g.Name
+}
+
+// sigs returns the group's signatures as a comma separated list
+func (g *aliasGroup) sigs() string {
+	s := make([]string, len(g.Sigs))
+	for i, sig := range g.Sigs {
+		s[i] = fmt.Sprintf("%08X", sig)
+	}
+	return strings.Join(s, ", ")
+}
+
+// describe returns the group's signatures with its name, if it has one, for messages
+func (g *aliasGroup) describe() string {
+	if g.Name == "" {
+		return "(" + g.sigs() + ")"
+	}
+	return fmt.Sprintf("%s (%s)", g.Name, g.sigs())
+}
+
+// aliasTable maps every signature in a group of aliases to its group
+type aliasTable map[uint32]*aliasGroup
+
+// aliasesPath returns where the saved alias table is kept, in the user's config directory
+func aliasesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "aliases.txt"), nil
+}
+
+// loadSavedAliases returns the alias table saved with `alias import`, or an empty one if nothing has been imported
+func loadSavedAliases() (aliasTable, error) {
+	path, err := aliasesPath()
+	if err != nil {
+		return nil, err
+	}
+	a, err := loadAliases(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(aliasTable), nil
+	}
+	return a, err
+}
+
+// loadAliases reads a file of alias groups, one group per line with the signatures separated by commas, tabs, or
+// spaces. Anything after a # is a comment, so a group can be followed by the game's name. A signature can only be in
+// one group.
+func loadAliases(filename string) (aliasTable, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	aliases := make(aliasTable)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, name, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == '\t' || r == ' ' })
+		group := &aliasGroup{Sigs: make([]uint32, 0, len(fields)), Name: strings.TrimSpace(name)}
+		for _, s := range fields {
+			sig, err := HexStringTransform(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+			}
+			group.Sigs = append(group.Sigs, sig)
+		}
+		if err := aliases.addGroup(group); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+	}
+	return aliases, scanner.Err()
+}
+
+// addGroup adds a group of aliases. Groups of fewer than 2 signatures are ignored.
+func (a aliasTable) addGroup(g *aliasGroup) error {
+	slices.Sort(g.Sigs)
+	g.Sigs = slices.Compact(g.Sigs)
+	if len(g.Sigs) < 2 {
+		return nil
+	}
+	for _, sig := range g.Sigs {
+		if _, ok := a[sig]; ok {
+			return fmt.Errorf("%08X is already in another group", sig)
+		}
+	}
+	for _, sig := range g.Sigs {
+		a[sig] = g
+	}
+	return nil
+}
+
+// groups returns every group in the table, ordered by their first signature
+func (a aliasTable) groups() []*aliasGroup {
+	seen := make(map[*aliasGroup]bool)
+	groups := make([]*aliasGroup, 0)
+	for _, sig := range slices.Sorted(maps.Keys(a)) {
+		if g := a[sig]; !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// write writes the table in the alias file format
+func (a aliasTable) write(w io.Writer) error {
+	for _, g := range a.groups() {
+		if _, err := fmt.Fprintln(w, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aliasMerge is the outcome of merging one alias table into another
+type aliasMerge struct {
+	Added, Extended, Joined int
+	// Conflicts describes each incoming group that overlaps the existing groups in a way that can't be merged safely
+	Conflicts []string
+}
+
+// merge adds the groups in other to the table. A group that's new is added, one that adds signatures to a single
+// existing group extends it, & one that's already covered changes nothing. Anything else, a group that overlaps more
+// than one existing group or that leaves out some of the one it overlaps, is a conflict: with union set the groups are
+// joined into one, otherwise it's listed in the result & left out.
+func (a aliasTable) merge(other aliasTable, union bool) aliasMerge {
+	var m aliasMerge
+	for _, g := range other.groups() {
+		overlaps := make([]*aliasGroup, 0)
+		for _, sig := range g.Sigs {
+			if e, ok := a[sig]; ok && !slices.Contains(overlaps, e) {
+				overlaps = append(overlaps, e)
+			}
+		}
+		sigs := slices.Clone(g.Sigs)
+		for _, e := range overlaps {
+			sigs = append(sigs, e.Sigs...)
+		}
+		slices.Sort(sigs)
+		sigs = slices.Compact(sigs)
+
+		switch {
+		case len(overlaps) == 0:
+			m.Added++
+		case len(overlaps) == 1 && len(sigs) == len(overlaps[0].Sigs):
+			continue
+		case len(overlaps) == 1 && len(sigs) == len(g.Sigs):
+			m.Extended++
+		case union:
+			m.Joined++
+		default:
+			existing := make([]string, len(overlaps))
+			for i, e := range overlaps {
+				existing[i] = e.describe()
+			}
+			m.Conflicts = append(m.Conflicts, fmt.Sprintf("%s overlaps %s", g.describe(), strings.Join(existing, " & ")))
+			continue
+		}
+
+		// The existing name wins, since it's the one that's been in use
+		name := ""
+		for _, e := range overlaps {
+			if name == "" {
+				name = e.Name
+			}
+			for _, sig := range e.Sigs {
+				delete(a, sig)
+			}
+		}
+		if name == "" {
+			name = g.Name
+		}
+		// Can't fail: every signature that was in the table has just been removed
+		a.addGroup(&aliasGroup{Sigs: sigs, Name: name})
+	}
+	return m
+}
+
+// expand adds an image for every alias of each image's signature, so one image covers all of a game's regions &
+// revisions. An image given explicitly for a signature always wins over one added because it's an alias, & where more
+// than one image could cover an alias, the first one does.
+func (a aliasTable) expand(imgs []Image) []Image {
+	covered := make(map[uint32]bool, len(imgs))
+	for _, img := range imgs {
+		covered[img.Signature] = true
+	}
+	expanded := slices.Clone(imgs)
+	for _, img := range imgs {
+		g, ok := a[img.Signature]
+		if !ok {
+			continue
+		}
+		for _, sig := range g.Sigs {
+			if covered[sig] {
+				continue
+			}
+			covered[sig] = true
+			log.Printf("Also adding %s as %08X, an alias of %08X", img.Filepath, sig, img.Signature)
+			expanded = append(expanded, Image{Filepath: img.Filepath, Signature: sig})
+		}
+	}
+	return expanded
+}
+
+// alsoFlag collects the signatures given with -also, which add a single image under more signatures without an alias
+// file. It can be repeated & each value can be a comma separated list.
+type alsoFlag []uint32
+
+func (f *alsoFlag) String() string {
+	s := make([]string, len(*f))
+	for i, sig := range *f {
+		s[i] = fmt.Sprintf("%08X", sig)
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *alsoFlag) Set(v string) error {
+	sigs, err := parseSignatures(nil, v)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, sigs...)
+	return nil
+}
+
+// aliasImages applies the alias table & -also signatures to imgs. The table comes from aliasFile, or is the saved one
+// if aliasFile is empty. It's skipped entirely if noAliases is set.
+func aliasImages(imgs []Image, aliasFile string, noAliases bool, also alsoFlag) ([]Image, error) {
+	aliases := make(aliasTable)
+	var err error
+	if aliasFile != "" {
+		aliases, err = loadAliases(aliasFile)
+	} else if !noAliases {
+		aliases, err = loadSavedAliases()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(also) > 0 {
+		if len(imgs) != 1 {
+			return nil, errors.New("-also needs exactly one image; use an alias file for more")
+		}
+		// -also adds to whatever group the image is already in, rather than conflicting with it
+		extra := make(aliasTable)
+		extra.addGroup(&aliasGroup{Sigs: append([]uint32{imgs[0].Signature}, also...)})
+		aliases.merge(extra, true)
+	}
+	return aliases.expand(imgs), nil
+}
+
+// alias implements `alias {export|import|merge}`, for managing alias tables separately from art packs
+func alias(args []string) error {
+	usage := errors.New("usage: alias export [-o file] | alias import {file...} [-union] [-dry-run] | " +
+		"alias merge {file...} -o {file} [-union]")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "export":
+		return aliasExport(args[1:])
+	case "import":
+		return aliasImport(args[1:])
+	case "merge":
+		return aliasMergeCmd(args[1:])
+	}
+	return usage
+}
+
+// aliasExport implements `alias export [-o file]`, writing out the saved alias table so that it can be shared
+func aliasExport(args []string) error {
+	fs := flag.NewFlagSet("alias export", flag.ExitOnError)
+	out := fs.String("o", "", "the file to write the table to (default stdout)")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	aliases, err := loadSavedAliases()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return aliases.write(os.Stdout)
+	}
+	var buf bytes.Buffer
+	if err := aliases.write(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, buf.Bytes()); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s alias groups to %s", formatCount(len(aliases.groups())), *out)
+	return nil
+}
+
+// aliasImport implements `alias import {file...}`, merging alias files into the saved table that add uses by default
+func aliasImport(args []string) error {
+	fs := flag.NewFlagSet("alias import", flag.ExitOnError)
+	union := fs.Bool("union", false, "join conflicting groups into one instead of leaving them out")
+	dryRun := fs.Bool("dry-run", false, "show what would change without saving anything")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("usage: alias import {file...} [-union] [-dry-run]")
+	}
+	aliases, err := loadSavedAliases()
+	if err != nil {
+		return err
+	}
+	if err := mergeAliasFiles(aliases, args, *union); err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Println("Dry run: the saved alias table was not modified.")
+		return nil
+	}
+
+	path, err := aliasesPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := aliases.write(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return err
+	}
+	log.Printf("Saved %s alias groups to %s", formatCount(len(aliases.groups())), path)
+	return nil
+}
+
+// aliasMergeCmd implements `alias merge {file...} -o {file}`, combining alias files without touching the saved table
+func aliasMergeCmd(args []string) error {
+	fs := flag.NewFlagSet("alias merge", flag.ExitOnError)
+	out := fs.String("o", "", "the merged alias file to write")
+	union := fs.Bool("union", false, "join conflicting groups into one instead of leaving them out")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || *out == "" {
+		return errors.New("usage: alias merge {file...} -o {file} [-union]")
+	}
+	aliases := make(aliasTable)
+	if err := mergeAliasFiles(aliases, args, *union); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := aliases.write(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, buf.Bytes()); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s alias groups to %s", formatCount(len(aliases.groups())), *out)
+	return nil
+}
+
+// mergeAliasFiles merges each file into aliases in turn, reporting what changed. Any conflicts are listed & make it
+// fail, so nothing is saved until they're fixed or -union is given.
+func mergeAliasFiles(aliases aliasTable, files []string, union bool) error {
+	conflicts := 0
+	for _, file := range files {
+		other, err := loadAliases(file)
+		if err != nil {
+			return err
+		}
+		m := aliases.merge(other, union)
+		fmt.Printf("%s: %d groups added, %d extended, %d joined, %d conflicts\n", file, m.Added, m.Extended, m.Joined,
+			len(m.Conflicts))
+		for _, c := range m.Conflicts {
+			fmt.Printf("  %s\n", c)
+		}
+		conflicts += len(m.Conflicts)
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d conflicting groups; fix them or rerun with -union to join them", conflicts)
+	}
+	return nil
+}
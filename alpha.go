@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+)
+
+// alphaModes are the ways transparency in a source image can be handled
+var alphaModes = []string{"keep", "flatten", "premultiply"}
+
+const (
+	// cornerRadius is the radius in pixels of the corner mask applied by -rounded-corners, roughly matching the corners
+	// of an N64 cartridge label at the label's size
+	cornerRadius = 6
+	// cornerSamples is the number of samples taken across each pixel in each direction to antialias the corner mask
+	cornerSamples = 4
+)
+
+// alphaOptions control what happens to the transparency in an image once it's been scaled. The zero value keeps it as it
+// is, which is what the tool has always done.
+type alphaOptions struct {
+	// Mode is one of alphaModes:
+	//   - keep copies the alpha channel into the entry unchanged
+	//   - flatten blends the image onto Background, leaving it fully opaque
+	//   - premultiply multiplies the colours by the alpha, so transparent pixels are black whatever was underneath
+	Mode       string
+	Background color.NRGBA
+	// RoundedCorners makes the corners transparent, to match the shape of a cartridge label. It's done before Mode, so
+	// flatten fills the corners with Background.
+	RoundedCorners bool
+}
+
+// register adds the alpha flags to fs
+func (o *alphaOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Mode, "alpha", "keep",
+		"what to do with transparency: keep, flatten (onto -background), or premultiply")
+	fs.Func("background", "the colour to flatten transparency onto, as RRGGBB (default 000000)", func(s string) error {
+		c, err := parseColor(s)
+		o.Background = c
+		return err
+	})
+	fs.BoolVar(&o.RoundedCorners, "rounded-corners", false, "round off the corners to match a cartridge label")
+}
+
+// isDefault returns true if the options leave the image alone, so that store keys made before these options existed
+// still match
+func (o alphaOptions) isDefault() bool {
+	return (o.Mode == "" || o.Mode == "keep") && !o.RoundedCorners
+}
+
+// String describes the options, for store keys
+func (o alphaOptions) String() string {
+	s := fmt.Sprintf("alpha=%s rounded=%t", o.Mode, o.RoundedCorners)
+	if o.Mode == "flatten" {
+		s += fmt.Sprintf(" background=%02X%02X%02X", o.Background.R, o.Background.G, o.Background.B)
+	}
+	return s
+}
+
+// validate checks the mode is a known one
+func (o alphaOptions) validate() error {
+	if o.Mode != "" && !slices.Contains(alphaModes, o.Mode) {
+		return fmt.Errorf("unknown alpha mode %q, expected one of %s", o.Mode, strings.Join(alphaModes, ", "))
+	}
+	return nil
+}
+
+// apply returns img with the corners rounded & its transparency handled according to the options. img is only modified
+// if the options change anything, in which case a copy is made first.
+func (o alphaOptions) apply(img *image.NRGBA) *image.NRGBA {
+	if o.isDefault() {
+		return img
+	}
+	out := image.NewNRGBA(img.Bounds())
+	copy(out.Pix, img.Pix)
+	if o.RoundedCorners {
+		roundCorners(out, cornerRadius)
+	}
+
+	bg := o.Background
+	for i := 0; i < len(out.Pix); i += 4 {
+		p := out.Pix[i : i+4 : i+4]
+		a := int(p[3])
+		switch o.Mode {
+		case "flatten":
+			p[0] = uint8((int(p[0])*a + int(bg.R)*(0xFF-a) + 0x7F) / 0xFF)
+			p[1] = uint8((int(p[1])*a + int(bg.G)*(0xFF-a) + 0x7F) / 0xFF)
+			p[2] = uint8((int(p[2])*a + int(bg.B)*(0xFF-a) + 0x7F) / 0xFF)
+			p[3] = 0xFF
+		case "premultiply":
+			for c := range 3 {
+				p[c] = uint8((int(p[c])*a + 0x7F) / 0xFF)
+			}
+		}
+	}
+	return out
+}
+
+// roundCorners scales the alpha of the pixels outside a quarter circle of radius r in each corner of img, using the
+// fraction of each pixel that's inside the curve so the edge is antialiased
+func roundCorners(img *image.NRGBA, r int) {
+	b := img.Bounds()
+	r = min(r, b.Dx()/2, b.Dy()/2)
+	for y := range r {
+		for x := range r {
+			cov := cornerCoverage(x, y, r)
+			if cov == 1 {
+				continue
+			}
+			// (x, y) counts in from the corner, so mirror it into all four
+			for _, pt := range []image.Point{
+				{b.Min.X + x, b.Min.Y + y}, {b.Max.X - 1 - x, b.Min.Y + y},
+				{b.Min.X + x, b.Max.Y - 1 - y}, {b.Max.X - 1 - x, b.Max.Y - 1 - y},
+			} {
+				i := img.PixOffset(pt.X, pt.Y)
+				img.Pix[i+3] = uint8(math.Round(float64(img.Pix[i+3]) * cov))
+			}
+		}
+	}
+}
+
+// cornerCoverage returns how much of the pixel x, y in from the top left corner is inside a circle of radius r centred
+// r pixels in from both edges
+func cornerCoverage(x, y, r int) float64 {
+	inside := 0
+	for sy := range cornerSamples {
+		for sx := range cornerSamples {
+			dx := float64(r) - (float64(x) + (float64(sx)+0.5)/cornerSamples)
+			dy := float64(r) - (float64(y) + (float64(sy)+0.5)/cornerSamples)
+			if dx*dx+dy*dy <= float64(r*r) {
+				inside++
+			}
+		}
+	}
+	return float64(inside) / (cornerSamples * cornerSamples)
+}
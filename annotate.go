@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// markMargin is the gap in pixels between a stamp or badge & the edge of the label
+const markMargin = 2
+
+// positions maps the names a stamp or badge position can be given as to a point on a 3x3 grid, where 0 is the left or
+// top, 1 the centre, & 2 the right or bottom
+var positions = map[string]image.Point{
+	"tl": {0, 0}, "top-left": {0, 0},
+	"t": {1, 0}, "top": {1, 0},
+	"tr": {2, 0}, "top-right": {2, 0},
+	"l": {0, 1}, "left": {0, 1},
+	"c": {1, 1}, "center": {1, 1}, "centre": {1, 1},
+	"r": {2, 1}, "right": {2, 1},
+	"bl": {0, 2}, "bottom-left": {0, 2},
+	"b": {1, 2}, "bottom": {1, 2},
+	"br": {2, 2}, "bottom-right": {2, 2},
+}
+
+// stamp is a short piece of text drawn onto a label, e.g. JP or HACK
+type stamp struct {
+	Text  string
+	Pos   string
+	Color color.NRGBA
+}
+
+// badge is a small image drawn onto a label, e.g. a completion star
+type badge struct {
+	Path string
+	Pos  string
+	img  image.Image
+	// hash is the SHA-256 of the badge file, so that the store notices when the badge changes
+	hash string
+}
+
+// stampFlag collects the -stamp flags, each of which is text[,position[,colour]]
+type stampFlag []stamp
+
+func (s *stampFlag) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *stampFlag) Set(v string) error {
+	parts := strings.Split(v, ",")
+	st := stamp{Text: parts[0], Pos: "br", Color: color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}}
+	if len(parts) > 1 {
+		st.Pos = strings.ToLower(strings.TrimSpace(parts[1]))
+	}
+	if len(parts) > 2 {
+		c, err := parseColor(parts[2])
+		if err != nil {
+			return err
+		}
+		st.Color = c
+	}
+	if len(parts) > 3 || st.Text == "" {
+		return fmt.Errorf("invalid stamp %q, expected text[,position[,colour]]", v)
+	}
+	if _, ok := positions[st.Pos]; !ok {
+		return fmt.Errorf("unknown position %q for stamp %q", st.Pos, st.Text)
+	}
+	*s = append(*s, st)
+	return nil
+}
+
+// badgeFlag collects the -badge flags, each of which is image[,position]. The image is loaded straight away so that a
+// bad badge stops the run before anything is converted.
+type badgeFlag []badge
+
+func (b *badgeFlag) String() string {
+	return fmt.Sprint(*b)
+}
+
+func (b *badgeFlag) Set(v string) error {
+	path, pos, _ := strings.Cut(v, ",")
+	bd := badge{Path: path, Pos: strings.ToLower(strings.TrimSpace(pos))}
+	if bd.Pos == "" {
+		bd.Pos = "tr"
+	}
+	if _, ok := positions[bd.Pos]; !ok {
+		return fmt.Errorf("unknown position %q for badge %s", bd.Pos, path)
+	}
+
+	img, err := getImg(path)
+	if err != nil {
+		return err
+	}
+	// Badges are markers, so they're kept to a quarter of the label at most
+	if b := img.Bounds(); b.Dx() > labelsdb.Width/2 || b.Dy() > labelsdb.Height/2 {
+		img = imaging.Fit(img, labelsdb.Width/2, labelsdb.Height/2, imaging.Lanczos)
+	}
+	bd.img = img
+	if bd.hash, err = sourceKey(path); err != nil {
+		return err
+	}
+	*b = append(*b, bd)
+	return nil
+}
+
+// markKey returns a string identifying the stamps & badges, for store keys
+func markKey(stamps []stamp, badges []badge) string {
+	h := sha256.New()
+	for _, s := range stamps {
+		fmt.Fprintf(h, "stamp\x00%s\x00%s\x00%v\x00", s.Text, s.Pos, s.Color)
+	}
+	for _, b := range badges {
+		fmt.Fprintf(h, "badge\x00%s\x00%s\x00", b.hash, b.Pos)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// place returns where the top left of something size big goes to be at pos on the label
+func place(pos string, size image.Point) image.Point {
+	grid := positions[pos]
+	free := image.Pt(labelsdb.Width-size.X-2*markMargin, labelsdb.Height-size.Y-2*markMargin)
+	return image.Pt(markMargin+free.X*grid.X/2, markMargin+free.Y*grid.Y/2)
+}
+
+// annotate draws the stamps & badges onto img, in that order
+func annotate(img *image.NRGBA, stamps []stamp, badges []badge) *image.NRGBA {
+	if len(stamps) == 0 && len(badges) == 0 {
+		return img
+	}
+	img = imaging.Clone(img)
+
+	face := basicfont.Face7x13
+	for _, s := range stamps {
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(s.Color), Face: face}
+		width := d.MeasureString(s.Text).Ceil()
+		// The text sits on a translucent box, 1 pixel bigger all round, so it stands out from any art
+		box := image.Pt(width+2, face.Height+2)
+		at := place(s.Pos, box)
+		draw.Draw(img, image.Rectangle{at, at.Add(box)}, image.NewUniform(color.NRGBA{A: 0xA0}), image.Point{}, draw.Over)
+		d.Dot = fixed.P(at.X+1, at.Y+1+face.Ascent)
+		d.DrawString(s.Text)
+	}
+
+	for _, b := range badges {
+		size := b.img.Bounds().Size()
+		at := place(b.Pos, size)
+		draw.Draw(img, image.Rectangle{at, at.Add(size)}, b.img, b.img.Bounds().Min, draw.Over)
+	}
+	return img
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// apply implements `apply {labels.db} -roms {rom dir} -images {image dir}`, for applying a label pack to an organised
+// collection. Each ROM is matched to the image with the same name, or failing that the closest name once region tags &
+// punctuation are ignored, & every match is added in one go. ROMs without any art are listed at the end.
+func apply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	roms := fs.String("roms", "", "the directory of ROMs")
+	images := fs.String("images", "", "the directory of images named after the games")
+	scaleMode := fs.String("scale-mode", "stretch", "how to fit images to the label: stretch, fit, fill, or crop")
+	var alpha alphaOptions
+	alpha.register(fs)
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	registerROMCheck(fs)
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *roms == "" || *images == "" {
+		return errors.New("usage: apply {labels.db} -roms {rom dir} -images {image dir} [flags]")
+	}
+	scale := scaleOptions{Mode: *scaleMode}
+	if err := scale.validate(); err != nil {
+		return err
+	}
+	if err := alpha.validate(); err != nil {
+		return err
+	}
+
+	idx, _, err := indexROMs(*roms, nil)
+	if err != nil {
+		return err
+	}
+	notePack(*images)
+	art, err := findArt(*images)
+	if err != nil {
+		return err
+	}
+
+	imgs := make([]Image, 0, len(idx.ROMs))
+	seen := make(map[uint32]bool)
+	var unmatched []romEntry
+	var queue []ambiguousMatch
+	for _, r := range idx.ROMs {
+		if seen[uint32(r.Signature)] {
+			continue
+		}
+		base := filepath.Base(filepath.FromSlash(r.Path))
+		paths, exact := art.match(strings.TrimSuffix(base, filepath.Ext(base)))
+		switch {
+		case len(paths) == 0:
+			unmatched = append(unmatched, r)
+			continue
+		case len(paths) > 1:
+			queue = append(queue, ambiguousMatch{r, paths})
+			continue
+		case !exact:
+			log.Printf("Using %s for %s", filepath.Base(paths[0]), base)
+		}
+		seen[uint32(r.Signature)] = true
+		imgs = append(imgs, Image{Filepath: paths[0], Signature: uint32(r.Signature)})
+	}
+
+	// Ambiguous matches are left until the end so they can be reviewed together. One whose signature has art from
+	// another ROM already doesn't need choosing.
+	queue = slices.DeleteFunc(queue, func(a ambiguousMatch) bool { return seen[uint32(a.ROM.Signature)] })
+	chosen, err := reviewMatches(queue, !policy.Yes && !policy.Force)
+	if err != nil {
+		return err
+	}
+	for i, a := range queue {
+		if chosen[i] == "" || seen[uint32(a.ROM.Signature)] {
+			unmatched = append(unmatched, a.ROM)
+			continue
+		}
+		seen[uint32(a.ROM.Signature)] = true
+		imgs = append(imgs, Image{Filepath: chosen[i], Signature: uint32(a.ROM.Signature)})
+	}
+	// A ROM that shares its signature with one that did get art isn't really missing any
+	var missing []string
+	for _, r := range unmatched {
+		if !seen[uint32(r.Signature)] {
+			missing = append(missing, r.Path)
+		}
+	}
+
+	log.Printf("Matched %s of %s ROMs to art", formatCount(len(imgs)), formatCount(len(idx.ROMs)))
+	if len(imgs) > 0 {
+		settings := addSettings{PreservePadding: true, Scale: scale, Alpha: alpha, DryRun: *dryRun, Policy: policy}
+		if storeDir, err := defaultStoreDir(); err == nil {
+			settings.Store, _ = openStore(storeDir)
+		}
+		if settings.Colour, err = loadColourProfile(); err != nil {
+			return err
+		}
+		skipped, err := addImages(args[0], imgs, settings)
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+		}
+	}
+
+	if len(missing) > 0 {
+		fmt.Printf("\nNo art found for %s ROMs:\n", formatCount(len(missing)))
+		for _, m := range missing {
+			fmt.Printf("  %s\n", m)
+		}
+	}
+	return nil
+}
+
+// artIndex is the images in a directory, looked up by name
+type artIndex struct {
+	// exact is keyed by the lower case filename without its extension
+	exact map[string]string
+	// fuzzy is keyed by fuzzyName, as several images can differ only in their tags
+	fuzzy map[string][]string
+}
+
+// findArt indexes every image under dir
+func findArt(dir string) (*artIndex, error) {
+	art := &artIndex{exact: make(map[string]string), fuzzy: make(map[string][]string)}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isImageFile(path) {
+			return err
+		}
+		stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if _, ok := art.exact[strings.ToLower(stem)]; !ok {
+			art.exact[strings.ToLower(stem)] = path
+		}
+		name, _ := fuzzyName(stem)
+		art.fuzzy[name] = append(art.fuzzy[name], path)
+		return nil
+	})
+	return art, err
+}
+
+// match returns the images for a ROM named stem & whether it was an exact match. Where several images are equally
+// close, the one sharing the most tags with the ROM wins, so `Game (Europe).z64` gets `Game (Europe).png` over `Game
+// (USA).png`; if that still leaves more than one, they're all returned for the user to choose between. It returns
+// nothing if there's no match at all.
+func (a *artIndex) match(stem string) ([]string, bool) {
+	if path, ok := a.exact[strings.ToLower(stem)]; ok {
+		return []string{path}, true
+	}
+	name, tags := fuzzyName(stem)
+	var best []string
+	bestScore := -1
+	for _, path := range a.fuzzy[name] {
+		_, imgTags := fuzzyName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		score := 0
+		for _, t := range imgTags {
+			for _, u := range tags {
+				if t == u {
+					score++
+				}
+			}
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore = []string{path}, score
+		case score == bestScore:
+			best = append(best, path)
+		}
+	}
+	return best, false
+}
+
+// fuzzyName reduces a game's name to something that survives the differences between naming schemes: the tags in
+// brackets are split off, "Title, The" becomes "the title", & case & punctuation are dropped. The tags are returned
+// separately, lower cased, e.g. "usa" & "rev a".
+func fuzzyName(s string) (string, []string) {
+	var name strings.Builder
+	var tags []string
+	for {
+		i := strings.IndexAny(s, "([")
+		if i < 0 {
+			name.WriteString(s)
+			break
+		}
+		name.WriteString(s[:i])
+		end := strings.IndexAny(s[i:], ")]")
+		if end < 0 {
+			break
+		}
+		for _, t := range strings.Split(s[i+1:i+end], ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		s = s[i+end+1:]
+	}
+
+	n := strings.ToLower(strings.TrimSpace(name.String()))
+	// The article can be moved to the end of the main title rather than of the whole name, as in "Legend of Zelda, The -
+	// Ocarina of Time"
+	for _, article := range []string{"the", "a", "an"} {
+		moved := ", " + article
+		if i := strings.Index(n, moved); i >= 0 {
+			if rest := n[i+len(moved):]; rest == "" || strings.HasPrefix(rest, " -") || strings.HasPrefix(rest, ":") {
+				n = article + " " + n[:i] + rest
+			}
+		}
+	}
+	words := strings.FieldsFunc(n, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	return strings.Join(words, " "), tags
+}
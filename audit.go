@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// auditFinding is a problem found with an entry's artwork
+type auditFinding struct {
+	Index     int
+	Signature uint32
+	Problem   string
+}
+
+// lowDetailThreshold is the mean per-channel difference between an entry & a half-resolution copy of it below which
+// the art is considered low resolution. Upscaled thumbnails survive being halved almost unchanged.
+const lowDetailThreshold = 2.0
+
+// auditEntries checks every entry for blank art, art that was upscaled from something tiny, & art that's identical to
+// another entry's
+func auditEntries(db *labelsdb.DB) []auditFinding {
+	findings := make([]auditFinding, 0)
+	seen := make(map[[sha256.Size]byte]uint32)
+	for i, e := range db.Entries() {
+		if labelsdb.IsBlank(e.Data) {
+			findings = append(findings, auditFinding{i, e.Signature, "blank"})
+		} else if d := detailScore(labelsdb.Decode(e.Data)); d < lowDetailThreshold {
+			findings = append(findings, auditFinding{i, e.Signature, fmt.Sprintf("low resolution (detail %.1f)", d)})
+		}
+
+		sum := sha256.Sum256(e.Data[:labelsdb.EntrySize-labelsdb.PaddingSize])
+		if other, ok := seen[sum]; ok {
+			findings = append(findings, auditFinding{i, e.Signature, fmt.Sprintf("duplicate of %08X", other)})
+		} else {
+			seen[sum] = e.Signature
+		}
+	}
+	return findings
+}
+
+// detailScore halves the image & scales it back up, returning the mean per-channel difference from the original. Art
+// that has real detail at full resolution scores high; art that was blown up from a small thumbnail scores near 0.
+func detailScore(img *image.NRGBA) float64 {
+	b := img.Bounds()
+	small := imaging.Resize(img, b.Dx()/2, b.Dy()/2, imaging.Box)
+	back := imaging.Resize(small, b.Dx(), b.Dy(), imaging.Linear)
+
+	total := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := int(img.Pix[i+c]) - int(back.Pix[i+c])
+			if d < 0 {
+				d = -d
+			}
+			total += d
+		}
+	}
+	return float64(total) / float64(len(img.Pix)/4*3)
+}
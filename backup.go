@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// backupFile copies src to dst. Where the filesystem supports it (APFS, btrfs, XFS, etc.) the copy is made as a
+// reflink/clone, which is near instant & doesn't use any additional disk space until one of the two files is modified.
+// Anywhere else it falls back to a regular byte-for-byte copy. dst is overwritten if it already exists.
+func backupFile(src, dst string) error {
+	defer beginWrite()()
+	// A clone will fail if the destination already exists, so clear out any previous backup first
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := cloneFile(src, dst); err == nil {
+		log.Printf("Backed up %s to %s (clone)", src, dst)
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	log.Printf("Backed up %s to %s", src, dst)
+	return nil
+}
+
+// copyFile does a plain copy of src to dst, preserving the permissions, owner, & extended attributes of the original
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	// OpenFile's permissions are cut down by the umask & ignored if dst already existed
+	if err := keepAttributes(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+	"golang.org/x/term"
+)
+
+// previewWidth is the number of columns the label preview takes up on screen
+const previewWidth = labelsdb.Width / 2
+
+// browser is the state of a browse session
+type browser struct {
+	path   string
+	backup bool
+	db     *labelsdb.DB
+	sigs   []uint32
+	titles titleLookup
+	opts   convertOptions
+
+	// cursor is the selected entry & top is the first one shown in the list
+	cursor, top int
+	// dirty is set once there are changes that haven't been written
+	dirty  bool
+	status string
+
+	// previews holds the rendered previews of recently shown entries, so scrolling back & forth through a big database
+	// doesn't decode & resize the same entries over & over. It's keyed on the entry's data rather than its signature:
+	// entries are only ever replaced, never changed in place, so a replaced entry simply misses.
+	previews *lru[*byte, []string]
+
+	in    *bufio.Reader
+	fd    int
+	state *term.State
+}
+
+// browse implements `browse {labels.db}`, a full screen terminal UI for looking through the database & replacing,
+// removing, or exporting entries. Changes are kept in memory until they're written with w.
+func browse(args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	cacheSize := fs.Int("cache-size", 512, "the number of rendered previews to keep in memory (0 to turn off)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || (*dat != "" && *roms == "") {
+		return errors.New("usage: browse {labels.db} [-roms roms.idx [-dat file]] [-titles file] [-backup] " +
+			"[-cache-size n]")
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("browse needs to be run in a terminal")
+	}
+
+	b := &browser{path: args[0], backup: *backup, previews: newLRU[*byte, []string](*cacheSize),
+		in: bufio.NewReader(os.Stdin), fd: fd}
+	if b.db, err = labelsdb.Open(args[0]); err != nil {
+		return err
+	}
+	b.sigs = b.db.Signatures()
+	if b.titles, err = loadTitles(*titleFile, *dat, *roms); err != nil {
+		return err
+	}
+	b.opts = defaultConvertOptions()
+	b.opts.Padding = b.db.Padding()
+	if b.opts.Colour, err = loadColourProfile(); err != nil {
+		return err
+	}
+
+	if b.state, err = term.MakeRaw(fd); err != nil {
+		return err
+	}
+	// Anything logged while converting images would be drawn over the screen, so errors go on the status line instead
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	// Use the alternate screen & hide the cursor, putting both back on the way out
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		term.Restore(fd, b.state)
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+	}()
+	return b.run()
+}
+
+// run is the main loop: draw the screen, then act on a key press
+func (b *browser) run() error {
+	for {
+		b.draw()
+		key, err := b.readKey()
+		if err != nil {
+			return err
+		}
+		b.status = ""
+
+		switch key {
+		case "up", "k":
+			b.move(-1)
+		case "down", "j":
+			b.move(1)
+		case "pgup":
+			b.move(-b.listRows())
+		case "pgdn":
+			b.move(b.listRows())
+		case "home", "g":
+			b.move(-len(b.sigs))
+		case "end", "G":
+			b.move(len(b.sigs))
+		case "r":
+			b.replace()
+		case "d":
+			b.remove()
+		case "e":
+			b.export()
+		case "w":
+			b.write()
+		case "q", "ctrl-c":
+			if !b.dirty {
+				return nil
+			}
+			if a := strings.ToLower(b.prompt("Quit without writing your changes? [y/N] ")); a == "y" || a == "yes" {
+				return nil
+			}
+		}
+	}
+}
+
+// readKey waits for a key press & returns its name. Arrow & paging keys arrive as escape sequences.
+func (b *browser) readKey() (string, error) {
+	c, err := b.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case c == 3:
+		return "ctrl-c", nil
+	case c != 0x1b:
+		return string(c), nil
+	case b.in.Buffered() == 0:
+		// A lone escape, rather than the start of a sequence
+		return "esc", nil
+	}
+
+	seq := make([]byte, 0, 4)
+	for b.in.Buffered() > 0 && len(seq) < cap(seq) {
+		c, _ := b.in.ReadByte()
+		seq = append(seq, c)
+		if c >= 'A' && c <= 'Z' || c == '~' {
+			break
+		}
+	}
+	switch string(seq) {
+	case "[A", "OA":
+		return "up", nil
+	case "[B", "OB":
+		return "down", nil
+	case "[5~":
+		return "pgup", nil
+	case "[6~":
+		return "pgdn", nil
+	case "[H", "OH", "[1~":
+		return "home", nil
+	case "[F", "OF", "[4~":
+		return "end", nil
+	}
+	return "", nil
+}
+
+// size returns the terminal's size, falling back to 80x24 if it can't be read
+func (b *browser) size() (int, int) {
+	w, h, err := term.GetSize(b.fd)
+	if err != nil || w == 0 || h == 0 {
+		return 80, 24
+	}
+	return w, h
+}
+
+// listRows returns the number of entries that fit on screen between the title & status lines
+func (b *browser) listRows() int {
+	_, h := b.size()
+	return max(h-2, 1)
+}
+
+// move moves the cursor by n entries, scrolling the list to keep it in view
+func (b *browser) move(n int) {
+	b.cursor = max(min(b.cursor+n, len(b.sigs)-1), 0)
+	rows := b.listRows()
+	if b.cursor < b.top {
+		b.top = b.cursor
+	} else if b.cursor >= b.top+rows {
+		b.top = b.cursor - rows + 1
+	}
+}
+
+// draw redraws the whole screen
+func (b *browser) draw() {
+	w, h := b.size()
+	listWidth := max(w-previewWidth-3, 10)
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+
+	heading := fmt.Sprintf("%s: %s entries", b.path, formatCount(len(b.sigs)))
+	if b.dirty {
+		heading += " (modified)"
+	}
+	fmt.Fprintf(&sb, "\x1b[1;1H\x1b[7m%-*s\x1b[0m", w, truncate(heading, w))
+
+	for row := 0; row < b.listRows() && b.top+row < len(b.sigs); row++ {
+		i := b.top + row
+		line := truncate(fmt.Sprintf("%08X  %s", b.sigs[i], b.titles[b.sigs[i]].Title), listWidth)
+		fmt.Fprintf(&sb, "\x1b[%d;1H", row+2)
+		if i == b.cursor {
+			fmt.Fprintf(&sb, "\x1b[7m%-*s\x1b[0m", listWidth, line)
+		} else {
+			sb.WriteString(line)
+		}
+	}
+
+	if len(b.sigs) > 0 {
+		sig := b.sigs[b.cursor]
+		col := listWidth + 3
+		lines := b.preview(sig)
+		for n, line := range lines {
+			fmt.Fprintf(&sb, "\x1b[%d;%dH%s", n+2, col, line)
+		}
+		info := []string{fmt.Sprintf("%08X, slot %d", sig, b.cursor)}
+		if t, ok := b.titles[sig]; ok {
+			info = append(info, truncate(t.Title, previewWidth), "from "+t.Source)
+		}
+		for n, line := range info {
+			fmt.Fprintf(&sb, "\x1b[%d;%dH%s", len(lines)+3+n, col, line)
+		}
+	} else {
+		sb.WriteString("\x1b[2;1HThe database is empty")
+	}
+
+	status := b.status
+	if status == "" {
+		status = "↑/↓ move  r replace  d remove  e export  w write  q quit"
+	}
+	fmt.Fprintf(&sb, "\x1b[%d;1H%s", h, truncate(status, w))
+	os.Stdout.WriteString(sb.String())
+}
+
+// preview returns the rendered preview of the entry for sig, from the cache if it's been shown recently
+func (b *browser) preview(sig uint32) []string {
+	entry, _ := b.db.Entry(sig)
+	if lines, ok := b.previews.get(&entry[0]); ok {
+		return lines
+	}
+	lines := previewLines(labelsdb.Decode(entry))
+	b.previews.put(&entry[0], lines)
+	return lines
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// prompt asks a question on the status line & returns the answer. The terminal is put back into its normal mode while
+// the answer is typed so that line editing works.
+func (b *browser) prompt(question string) string {
+	_, h := b.size()
+	fmt.Printf("\x1b[%d;1H\x1b[2K\x1b[?25h%s", h, question)
+	term.Restore(b.fd, b.state)
+	answer, _ := b.in.ReadString('\n')
+	term.MakeRaw(b.fd)
+	fmt.Print("\x1b[?25l")
+	return strings.TrimSpace(answer)
+}
+
+// replace converts an image file & puts it in place of the selected entry, keeping the entry's padding
+func (b *browser) replace() {
+	if len(b.sigs) == 0 {
+		return
+	}
+	path := b.prompt("Image file: ")
+	if path == "" {
+		return
+	}
+	entry, err := loadImage(path, b.opts)
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+	sig := b.sigs[b.cursor]
+	old, _ := b.db.Entry(sig)
+	if err := b.db.PutEntry(sig, keepPadding(entry, old)); err != nil {
+		b.status = err.Error()
+		return
+	}
+	b.dirty = true
+	b.status = fmt.Sprintf("Replaced %08X", sig)
+}
+
+// remove deletes the selected entry after confirming
+func (b *browser) remove() {
+	if len(b.sigs) == 0 {
+		return
+	}
+	sig := b.sigs[b.cursor]
+	if a := strings.ToLower(b.prompt(fmt.Sprintf("Remove %08X? [y/N] ", sig))); a != "y" && a != "yes" {
+		return
+	}
+	b.db.Remove(sig)
+	b.sigs = b.db.Signatures()
+	b.move(0)
+	b.dirty = true
+	b.status = fmt.Sprintf("Removed %08X", sig)
+}
+
+// export saves the selected entry as a PNG
+func (b *browser) export() {
+	if len(b.sigs) == 0 {
+		return
+	}
+	sig := b.sigs[b.cursor]
+	name := fmt.Sprintf("%08X.png", sig)
+	path := b.prompt(fmt.Sprintf("Save as [%s]: ", name))
+	if path == "" {
+		path = name
+	}
+	entry, _ := b.db.Entry(sig)
+	if err := writePNG(path, entry, false); err != nil {
+		b.status = err.Error()
+		return
+	}
+	b.status = "Exported " + path
+}
+
+// write saves the changes to the labels.db, making the backup first if one was asked for
+func (b *browser) write() {
+	if !b.dirty {
+		b.status = "No changes to write"
+		return
+	}
+	if b.backup {
+		if err := backupFile(b.path, b.path+".bak"); err != nil {
+			b.status = err.Error()
+			return
+		}
+		// Only back up the original, not the result of an earlier write in the same session
+		b.backup = false
+	}
+	if err := saveDB(b.path, b.db); err != nil {
+		b.status = err.Error()
+		return
+	}
+	b.dirty = false
+	b.status = fmt.Sprintf("Wrote %s entries to %s", formatCount(b.db.Len()), b.path)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// bundleManifest is the name of the manifest in a bundle directory
+const bundleManifest = "manifest.json"
+
+// exportBundle implements `export-bundle {labels.db} {bundle dir} [signature...]`. A bundle is a directory of PNGs
+// named after their signatures along with a JSON manifest listing each one & its title. It's a portable way to
+// distribute a label pack that diffs well under version control, & since the manifest is a regular manifest the bundle
+// can also be added directly.
+func exportBundle(args []string) error {
+	fs := flag.NewFlagSet("export-bundle", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	optimize := fs.Bool("optimize", false, "make the PNGs as small as possible without losing anything, for publishing")
+	packName := fs.String("pack-name", "", "name the pack in its pack.json, so that other packs can require it")
+	packVersion := fs.String("pack-version", "", "the pack's version, for pack.json")
+	minTool := fs.String("min-tool-version", "", "the oldest a3dlabels that can apply the pack, for pack.json")
+	var requires requiresFlag
+	fs.Var(&requires, "requires", "a pack that has to be applied first, as name[@min version], for pack.json; "+
+		"can be repeated")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || (*dat != "" && *roms == "") {
+		return errors.New("usage: export-bundle {labels.db} {bundle dir} [signature...] [-roms roms.idx [-dat file]] " +
+			"[-titles file] [-optimize] [-pack-name name [-pack-version v] [-min-tool-version v] [-requires name@v...]]")
+	}
+	// Like the provenance in the manifest, an existing pack.json is carried over when exporting again
+	info, err := loadPackInfo(args[1])
+	if err != nil {
+		return err
+	}
+	if *packName != "" {
+		if info == nil {
+			info = &packInfo{}
+		}
+		info.Name = *packName
+	}
+	if info == nil && (*packVersion != "" || *minTool != "" || len(requires) > 0) {
+		return errors.New("-pack-version, -min-tool-version, & -requires need -pack-name")
+	}
+
+	want := make([]uint32, 0, len(args)-2)
+	for _, a := range args[2:] {
+		sig, err := HexStringTransform(a)
+		if err != nil {
+			return err
+		}
+		want = append(want, sig)
+	}
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+	dir := args[1]
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// The provenance recorded in a bundle's manifest isn't in labels.db, so it's carried over when exporting again
+	prev := make(map[hexSig]manifestEntry)
+	if old, err := readManifest(filepath.Join(dir, bundleManifest)); err == nil {
+		for _, e := range old {
+			prev[e.Signature] = e
+		}
+	}
+
+	entries := make([]manifestEntry, 0, db.Len())
+	for _, e := range db.Entries() {
+		if len(want) > 0 && !slices.Contains(want, e.Signature) {
+			continue
+		}
+		name := fmt.Sprintf("%08X.png", e.Signature)
+		if err := writePNG(filepath.Join(dir, name), e.Data, *optimize); err != nil {
+			return err
+		}
+		p := prev[hexSig(e.Signature)]
+		entries = append(entries, manifestEntry{ImagePath: name, Signature: hexSig(e.Signature),
+			Title: cmp.Or(titles[e.Signature].Title, p.Title), Author: p.Author, License: p.License, Source: p.Source})
+	}
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, bundleManifest), append(b, '\n')); err != nil {
+		return err
+	}
+	if info != nil {
+		info.Version = cmp.Or(*packVersion, info.Version)
+		info.MinToolVersion = cmp.Or(*minTool, info.MinToolVersion)
+		if len(requires) > 0 {
+			info.Requires = requires
+		}
+		if info.DBVersion, err = formatVersion(args[0]); err != nil {
+			return err
+		}
+		if err := info.save(dir); err != nil {
+			return err
+		}
+	}
+	log.Printf("Exported %s images to %s", formatCount(len(entries)), dir)
+	return nil
+}
+
+// importBundle implements `import-bundle {labels.db} {bundle dir}`, adding everything in a bundle made by
+// export-bundle. The images in a bundle are already 74x86, so they go in unchanged unless a calibration profile is
+// applied. If the bundle has a pack.json, what it declares it needs is checked first.
+func importBundle(args []string) error {
+	fs := flag.NewFlagSet("import-bundle", flag.ExitOnError)
+	noProfile := fs.Bool("no-profile", false, "don't apply the calibration profile saved with the calibration command")
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	ignoreCompat := fs.Bool("ignore-compat", false,
+		"apply the bundle even if its pack.json says it isn't meant for this labels.db or this version of the tool")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: import-bundle {labels.db} {bundle dir} [flags]")
+	}
+
+	notePack(args[1])
+	info, err := loadPackInfo(args[1])
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		problems, err := info.check(args[0])
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			log.Printf("%s: %s", info, p)
+		}
+		if len(problems) > 0 && !*ignoreCompat {
+			return fmt.Errorf("%s isn't meant for %s, so nothing was changed; -ignore-compat applies it anyway", info,
+				args[0])
+		}
+		appliedPacks[info.Name] = info.Version
+	}
+	imgs, err := loadManifest(filepath.Join(args[1], bundleManifest))
+	if err != nil {
+		return err
+	}
+	if *backup && !*dryRun {
+		if err := backupFile(args[0], args[0]+".bak"); err != nil {
+			return err
+		}
+	}
+
+	settings := addSettings{PreservePadding: true, DryRun: *dryRun, Policy: policy}
+	if storeDir, err := defaultStoreDir(); err == nil {
+		settings.Store, _ = openStore(storeDir)
+	}
+	if !*noProfile {
+		if settings.Colour, err = loadColourProfile(); err != nil {
+			return err
+		}
+	}
+	skipped, err := addImages(args[0], imgs, settings)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+	}
+	return nil
+}
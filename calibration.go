@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// colourProfile holds adjustments that make labels look on the console the way they look on the computer they were made
+// on. The zero value makes no changes.
+type colourProfile struct {
+	// Gamma is applied first. Values above 1 brighten the midtones, below 1 darken them. 0 is treated as 1.
+	Gamma float64 `json:"gamma,omitempty"`
+	// Brightness, Contrast & Saturation are percentages from -100 to 100
+	Brightness float64 `json:"brightness,omitempty"`
+	Contrast   float64 `json:"contrast,omitempty"`
+	Saturation float64 `json:"saturation,omitempty"`
+}
+
+// isZero returns true if the profile doesn't change anything
+func (p colourProfile) isZero() bool {
+	return (p.Gamma == 0 || p.Gamma == 1) && p.Brightness == 0 && p.Contrast == 0 && p.Saturation == 0
+}
+
+// String describes the profile, for logs & store keys
+func (p colourProfile) String() string {
+	return fmt.Sprintf("gamma=%g brightness=%g contrast=%g saturation=%g", p.Gamma, p.Brightness, p.Contrast,
+		p.Saturation)
+}
+
+// apply returns img with the profile's adjustments made
+func (p colourProfile) apply(img *image.NRGBA) *image.NRGBA {
+	if p.isZero() {
+		return img
+	}
+	if p.Gamma != 0 && p.Gamma != 1 {
+		img = imaging.AdjustGamma(img, p.Gamma)
+	}
+	if p.Brightness != 0 {
+		img = imaging.AdjustBrightness(img, p.Brightness)
+	}
+	if p.Contrast != 0 {
+		img = imaging.AdjustContrast(img, p.Contrast)
+	}
+	if p.Saturation != 0 {
+		img = imaging.AdjustSaturation(img, p.Saturation)
+	}
+	return img
+}
+
+// profilePath returns where the calibration profile is kept, in the user's config directory
+func profilePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "profile.json"), nil
+}
+
+// loadColourProfile returns the saved calibration profile, or the zero profile if none has been saved
+func loadColourProfile() (colourProfile, error) {
+	var p colourProfile
+	path, err := profilePath()
+	if err != nil {
+		return p, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// calibrationChart draws a label-sized test chart: a row each of grey, red, green, & blue steps from black to full
+// intensity. Shown on the console next to the same file on a computer, it makes differences in gamma, brightness, &
+// saturation easy to spot.
+func calibrationChart() *image.NRGBA {
+	const steps = 8
+	img := image.NewNRGBA(image.Rect(0, 0, labelsdb.Width, labelsdb.Height))
+	for y := 0; y < labelsdb.Height; y++ {
+		band := y * 4 / labelsdb.Height
+		for x := 0; x < labelsdb.Width; x++ {
+			v := uint8(min(x*steps/labelsdb.Width, steps-1) * 255 / (steps - 1))
+			i := img.PixOffset(x, y)
+			px := img.Pix[i : i+4]
+			px[3] = 0xFF
+			switch band {
+			case 0:
+				px[0], px[1], px[2] = v, v, v
+			default:
+				px[band-1] = v
+			}
+		}
+	}
+	return img
+}
+
+// calibration implements `calibration`. With -o it writes the test chart. With any of the adjustment flags it saves
+// them as the profile that every later conversion uses. With -reset it removes the profile. Otherwise it prints the
+// current profile.
+func calibration(args []string) error {
+	fs := flag.NewFlagSet("calibration", flag.ExitOnError)
+	out := fs.String("o", "", "write the calibration chart to this PNG")
+	reset := fs.Bool("reset", false, "remove the saved profile")
+	var p colourProfile
+	fs.Float64Var(&p.Gamma, "gamma", 1, "gamma correction; above 1 brightens the midtones")
+	fs.Float64Var(&p.Brightness, "brightness", 0, "brightness change, -100 to 100")
+	fs.Float64Var(&p.Contrast, "contrast", 0, "contrast change, -100 to 100")
+	fs.Float64Var(&p.Saturation, "saturation", 0, "saturation change, -100 to 100")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: calibration [-o chart.png] [-gamma G] [-brightness B] [-contrast C] [-saturation S] [-reset]")
+	}
+	adjusting := false
+	fs.Visit(func(f *flag.Flag) {
+		adjusting = adjusting || (f.Name != "o" && f.Name != "reset")
+	})
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := encodeSRGB(f, calibrationChart()); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("Wrote the calibration chart to %s. Add it under a test signature & compare it on the console.", *out)
+	}
+
+	path, err := profilePath()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *reset:
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Printf("Removed the calibration profile")
+	case adjusting:
+		if p.Gamma <= 0 {
+			return errors.New("gamma must be greater than 0")
+		}
+		b, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, b); err != nil {
+			return err
+		}
+		log.Printf("Saved calibration profile %s to %s", p, path)
+	case *out == "":
+		current, err := loadColourProfile()
+		if err != nil {
+			return err
+		}
+		if current.isZero() {
+			fmt.Println("No calibration profile is set")
+		} else {
+			fmt.Printf("Calibration profile: %s\n", current)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The exit statuses for a run that's cancelled, following the shell's 128 + signal number & GNU timeout's 124
+const (
+	cancelledInterrupt exitStatus = 130
+	cancelledTerminate exitStatus = 143
+	cancelledTimeout   exitStatus = 124
+)
+
+var (
+	// timeout is set by -timeout to cancel the command if it's still running after that long
+	timeout time.Duration
+	// startTimeout starts the -timeout clock once, however many times the flags are parsed
+	startTimeout sync.Once
+)
+
+// interrupt tracks what Ctrl-C, SIGTERM, or -timeout is waiting for. A write unit (a database, its journal, a backup,
+// & so on) that's in progress is always finished rather than cut off, & the run stops once nothing is being written.
+var interrupt struct {
+	sync.Mutex
+	// writing is how many write units are in progress
+	writing int
+	// reason is why the run is being cancelled, or "" if it isn't
+	reason string
+	status exitStatus
+	// cancel is set by commands that stop by themselves when cancelled, rather than being stopped
+	cancel context.CancelFunc
+}
+
+// handleSignals catches SIGINT & SIGTERM for the rest of the run, so that they cancel it safely instead of killing it
+// wherever it happens to be. A second one stops the run straight away.
+func handleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range ch {
+			if sig == syscall.SIGTERM {
+				cancelRun("terminated", cancelledTerminate)
+			} else {
+				cancelRun("interrupted", cancelledInterrupt)
+			}
+		}
+	}()
+}
+
+// startTimer starts the -timeout clock, if there is one
+func startTimer() {
+	if timeout <= 0 {
+		return
+	}
+	startTimeout.Do(func() {
+		time.AfterFunc(timeout, func() {
+			cancelRun(fmt.Sprintf("still running after the -timeout of %s", timeout), cancelledTimeout)
+		})
+	})
+}
+
+// cancelRun cancels the run for reason. If a write unit is in progress, the run stops as soon as it's finished;
+// otherwise it stops now, unless the command is one that stops by itself.
+func cancelRun(reason string, status exitStatus) {
+	interrupt.Lock()
+	defer interrupt.Unlock()
+	if interrupt.reason != "" {
+		fmt.Fprintf(os.Stderr, "\nStopping straight away (%s). A write in progress may have been left unfinished; "+
+			"labels.db itself is only ever replaced whole.\n", reason)
+		os.Exit(int(status))
+	}
+	interrupt.reason, interrupt.status = reason, status
+	switch {
+	case interrupt.cancel != nil:
+		interrupt.cancel()
+	case interrupt.writing > 0:
+		fmt.Fprintf(os.Stderr, "\nCancelling (%s) once the write in progress is finished. Press Ctrl-C again to stop "+
+			"straight away.\n", reason)
+	default:
+		exitCancelled()
+	}
+}
+
+// exitCancelled ends a cancelled run. It's only called with interrupt locked & nothing being written.
+func exitCancelled() {
+	if stopProfiling != nil {
+		stopProfiling()
+	}
+	fmt.Fprintf(os.Stderr, "Safely cancelled (%s). Nothing was left half-written; writes that finished before this "+
+		"are kept, but the post_write hooks weren't run.\n", interrupt.reason)
+	os.Exit(int(interrupt.status))
+}
+
+// beginWrite marks the start of a write unit that a cancellation has to wait for. It returns the function that marks
+// its end, which stops the run if it was cancelled in the meantime. Write units can be nested.
+func beginWrite() func() {
+	interrupt.Lock()
+	interrupt.writing++
+	interrupt.Unlock()
+	return func() {
+		interrupt.Lock()
+		defer interrupt.Unlock()
+		interrupt.writing--
+		if interrupt.writing == 0 && interrupt.reason != "" && interrupt.cancel == nil {
+			exitCancelled()
+		}
+	}
+}
+
+// cancelling reports whether the run has been cancelled, for long write units that can stop partway without leaving
+// anything broken
+func cancelling() bool {
+	interrupt.Lock()
+	defer interrupt.Unlock()
+	return interrupt.reason != ""
+}
+
+// cancelContext returns a context that's cancelled when the run is, for commands that stop by themselves, such as
+// watch. Rather than the run being stopped, the command is left to finish up & return.
+func cancelContext() context.Context {
+	interrupt.Lock()
+	defer interrupt.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	if interrupt.reason != "" {
+		cancel()
+	}
+	interrupt.cancel = cancel
+	return ctx
+}
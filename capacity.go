@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// defaultCapacityWarn are the fill thresholds warned about when capacity_warn isn't set in the config file
+var defaultCapacityWarn = []string{"80%", "95%"}
+
+// capacityCrossing is a write that took a database past one of the capacity_warn thresholds, for the capacity_hook
+// hooks
+type capacityCrossing struct {
+	DB        string
+	Threshold string
+	Entries   int
+}
+
+// crossings is every threshold passed by the command being run, in the order they were passed
+var crossings []capacityCrossing
+
+// capacityThreshold is a capacity_warn threshold as an entry count, along with how it was written
+type capacityThreshold struct {
+	Name    string
+	Entries int
+}
+
+// capacityThresholds reads capacity_warn from the config file: each threshold is a percentage of the index, such as
+// "80%", or a number of entries. They're returned lowest first. `capacity_warn = []` turns the warnings off.
+func capacityThresholds(cfg map[string][]string) ([]capacityThreshold, error) {
+	names, ok := cfg["capacity_warn"]
+	if !ok {
+		names = defaultCapacityWarn
+	}
+	thresholds := make([]capacityThreshold, len(names))
+	for i, s := range names {
+		pct, isPct := strings.CutSuffix(strings.TrimSpace(s), "%")
+		v, err := strconv.ParseFloat(pct, 64)
+		if err != nil || v <= 0 || (isPct && v > 100) || (!isPct && v > labelsdb.MaxEntries) {
+			return nil, fmt.Errorf("capacity_warn: expected a percentage or up to %d entries, got %q",
+				labelsdb.MaxEntries, s)
+		}
+		if isPct {
+			v = v * labelsdb.MaxEntries / 100
+		}
+		thresholds[i] = capacityThreshold{s, int(math.Ceil(v))}
+	}
+	slices.SortStableFunc(thresholds, func(a, b capacityThreshold) int { return a.Entries - b.Entries })
+	return thresholds, nil
+}
+
+// entryCount returns how many entries the database at path has, from its index alone, or 0 if it can't be read
+func entryCount(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sigs, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		return 0
+	}
+	return len(sigs)
+}
+
+// warnCapacity warns when a write has left the database at path at or past a capacity_warn threshold, so that nobody
+// finds out it's full from an add failing halfway through a batch. The warning goes straight to stderr, since -quiet
+// shouldn't hide it. Thresholds passed by this write, rather than ones it was already past, are noted for the
+// capacity_hook hooks.
+func warnCapacity(path string, before, after int) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return
+	}
+	thresholds, err := capacityThresholds(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	var reached *capacityThreshold
+	for _, t := range thresholds {
+		if after < t.Entries {
+			break
+		}
+		reached = &t
+		if before < t.Entries {
+			writesMu.Lock()
+			crossings = append(crossings, capacityCrossing{DB: abs, Threshold: t.Name, Entries: after})
+			writesMu.Unlock()
+		}
+	}
+	if reached == nil {
+		return
+	}
+
+	name := reached.Name
+	if !strings.HasSuffix(name, "%") {
+		name += " entry"
+	}
+	msg := fmt.Sprintf("Warning: %s is %d%% full (%s of %s entries), past the %s warning threshold. Only %s more "+
+		"labels will fit.", path, after*100/labelsdb.MaxEntries, formatCount(after), formatCount(labelsdb.MaxEntries),
+		name, formatCount(labelsdb.MaxEntries-after))
+	if plainOutput {
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+		return
+	}
+	bar := strings.Repeat("!", min(len(msg), 80))
+	fmt.Fprintf(os.Stderr, "\n%s\n%s\n%s\n\n", bar, msg, bar)
+}
+
+// runCapacityHooks runs each capacity_hook from the config file once for every threshold passed by a database that was
+// written, the same way as the post_write hooks, with {threshold} & {entries} replaced as well as {db}
+func runCapacityHooks(cfg map[string][]string) error {
+	for _, c := range crossings {
+		if !slices.Contains(written, c.DB) {
+			continue
+		}
+		for _, hook := range cfg["capacity_hook"] {
+			err := runHook("capacity_hook", hook, map[string]string{
+				"db": c.DB, "threshold": c.Threshold, "entries": strconv.Itoa(c.Entries),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// cardSearchDepth is how many directories deep labels.db is looked for on a card
+const cardSearchDepth = 4
+
+// mountPoints returns the directories removable volumes are usually mounted under on this OS
+func mountPoints() []string {
+	var roots []string
+	switch runtime.GOOS {
+	case "darwin":
+		roots = []string{"/Volumes"}
+	case "windows":
+		// Drive letters rather than mount points. A & B are floppies & C is almost always the system drive.
+		vols := make([]string, 0, 23)
+		for d := 'D'; d <= 'Z'; d++ {
+			vols = append(vols, string(d)+`:\`)
+		}
+		return vols
+	default:
+		roots = []string{"/media", "/run/media", "/mnt"}
+		if u := os.Getenv("USER"); u != "" {
+			roots = append(roots, filepath.Join("/media", u), filepath.Join("/run/media", u))
+		}
+	}
+
+	vols := make([]string, 0)
+	for _, r := range roots {
+		entries, err := os.ReadDir(r)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				vols = append(vols, filepath.Join(r, e.Name()))
+			}
+		}
+	}
+	return vols
+}
+
+// cardDB returns the path of the labels.db on an Analogue 3D SD card mounted at vol, or "" if vol doesn't look like one.
+// A card is recognised by the System directory at its root, & labels.db is looked for a few directories down rather
+// than at a fixed path so that a firmware update moving it doesn't break deploy.
+func cardDB(vol string) string {
+	if fi, err := os.Stat(filepath.Join(vol, "System")); err != nil || !fi.IsDir() {
+		return ""
+	}
+	found := ""
+	filepath.WalkDir(vol, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if rel, _ := filepath.Rel(vol, path); strings.Count(rel, string(filepath.Separator)) >= cardSearchDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(d.Name(), "labels.db") {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// findCardDB returns the labels.db on the SD card at mount, or on the only mounted card if mount is empty
+func findCardDB(mount string) (string, error) {
+	if mount != "" {
+		if db := cardDB(mount); db != "" {
+			return db, nil
+		}
+		return "", withCode(codeNoCard,
+			fmt.Errorf("%s doesn't look like an Analogue 3D SD card: no System directory or labels.db", mount))
+	}
+
+	var found []string
+	for _, vol := range mountPoints() {
+		if db := cardDB(vol); db != "" {
+			found = append(found, db)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", withCode(codeNoCard,
+			errors.New("no Analogue 3D SD card found; make sure it's mounted, or give its location with -card"))
+	case 1:
+		log.Printf("Found an Analogue 3D SD card with %s", found[0])
+		return found[0], nil
+	}
+	return "", fmt.Errorf("found more than one Analogue 3D SD card, pick one with -card: %s", strings.Join(found, ", "))
+}
+
+// verifyDeployed checks the labels.db written to a card: that it's structurally sound & that every entry can be read
+// back
+func verifyDeployed(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, problems, err := labelsdb.Verify(f)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		if p.Fatal {
+			return fmt.Errorf("the labels.db written to the card failed verification: %s", p)
+		}
+	}
+	db, err := labelsdb.Read(f)
+	if err != nil {
+		return fmt.Errorf("the labels.db written to the card can't be read back: %w", err)
+	}
+	log.Printf("Verified the %s entries in %s", formatCount(db.Len()), path)
+	return nil
+}
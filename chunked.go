@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// copyChunkSize is how much of the database is copied to the card & verified at a time by writeDBResumable
+const copyChunkSize = 1 << 20
+
+// resumableWrites is set by -resumable to write databases with writeDBResumable
+var resumableWrites bool
+
+// writeIntent is kept next to a database while it's being written with writeDBResumable, recording which chunks of
+// the partial file have been written & verified
+type writeIntent struct {
+	Size      int64 `json:"size"`
+	ChunkSize int   `json:"chunk_size"`
+	// Verified holds the SHA-256 of each chunk that's known to be on disk, or "" for those that aren't
+	Verified []string `json:"verified"`
+}
+
+// partialPaths returns the partial file & intent file used while writing the database at path
+func partialPaths(path string) (string, string) {
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	return filepath.Join(dir, "."+base+".partial"), filepath.Join(dir, "."+base+".intent")
+}
+
+// writeDBResumable is writeDB for slow targets such as SD cards, where a write can take long enough for the card to be
+// pulled partway through. The database is built up in a partial file next to path, a chunk at a time, & each chunk is
+// read back & checked before the intent file is updated to say it's done. If the write is interrupted, the next one
+// only writes the chunks that aren't already there, so rerunning the same command picks up where it left off. Once
+// every chunk is verified, the partial file is renamed over path in one step, as with writeDB.
+func writeDBResumable(path string, db *labelsdb.DB) error {
+	var buf bytes.Buffer
+	if _, err := db.WriteTo(&buf); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	partial, intentPath := partialPaths(path)
+
+	intent := writeIntent{Size: int64(len(data)), ChunkSize: copyChunkSize}
+	if b, err := os.ReadFile(intentPath); err == nil {
+		var prev writeIntent
+		if json.Unmarshal(b, &prev) == nil && prev.ChunkSize == copyChunkSize {
+			intent.Verified = prev.Verified
+		}
+	}
+	chunks := (len(data) + copyChunkSize - 1) / copyChunkSize
+	intent.Verified = append(intent.Verified, make([]string, max(0, chunks-len(intent.Verified)))...)[:chunks]
+
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	reused, written := 0, 0
+	for i := range chunks {
+		chunk := data[i*copyChunkSize : min(len(data), (i+1)*copyChunkSize)]
+		sum := sha256.Sum256(chunk)
+		want := hex.EncodeToString(sum[:])
+		// A chunk is only trusted if the intent file says it was verified & the partial file is long enough to hold it
+		if intent.Verified[i] == want && fi.Size() >= int64(i*copyChunkSize+len(chunk)) {
+			reused++
+			continue
+		}
+		// Every chunk written so far is verified & recorded, so the next -resumable write picks up from here
+		if cancelling() {
+			err := fmt.Errorf("stopped writing %s after %s of %s chunks; the next -resumable write carries on from "+
+				"there", path, formatCount(i), formatCount(chunks))
+			fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		if err := writeChunk(f, int64(i*copyChunkSize), chunk); err != nil {
+			return fmt.Errorf("writing %s: %w", partial, err)
+		}
+		intent.Verified[i] = want
+		b, err := json.Marshal(intent)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(intentPath, b); err != nil {
+			return err
+		}
+		written++
+		if written%16 == 0 {
+			log.Printf("Written %s of %s", formatBytes(int64(min(len(data), (i+1)*copyChunkSize))),
+				formatBytes(int64(len(data))))
+		}
+	}
+	if reused > 0 {
+		log.Printf("Resumed an interrupted write: %s of %s chunks were already there", formatCount(reused),
+			formatCount(chunks))
+	}
+
+	if err := f.Truncate(int64(len(data))); err != nil {
+		return err
+	}
+	if err := keepAttributes(f, path); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return err
+	}
+	return os.Remove(intentPath)
+}
+
+// writeChunk writes chunk at off, syncs it, & reads it back to check it made it to disk intact
+func writeChunk(f *os.File, off int64, chunk []byte) error {
+	if _, err := f.WriteAt(chunk, off); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	// Otherwise the read would most likely come straight back out of the page cache
+	dropCache(f, off, int64(len(chunk)))
+	back := make([]byte, len(chunk))
+	if _, err := f.ReadAt(back, off); err != nil {
+		return err
+	}
+	if !bytes.Equal(back, chunk) {
+		return errors.New("the data read back doesn't match what was written")
+	}
+	return nil
+}
+
+// removePartial clears away anything left by an interrupted writeDBResumable once the database has been written some
+// other way, since it's out of date
+func removePartial(path string) {
+	partial, intent := partialPaths(path)
+	for _, p := range []string{partial, intent} {
+		if err := os.Remove(p); err == nil {
+			debugf("Removed %s, left from an interrupted write", p)
+		}
+	}
+}
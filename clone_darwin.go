@@ -0,0 +1,9 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// cloneFile creates dst as an APFS clone of src. Returns an error on HFS+ & FAT volumes, in which case the caller
+// should fall back to a copy.
+func cloneFile(src, dst string) error {
+	return unix.Clonefile(src, dst, 0)
+}
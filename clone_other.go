@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// cloneFile is unsupported on this platform, so backups will always be done with a regular copy.
+func cloneFile(src, dst string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCloneFile checks that a clone, where the filesystem supports one, keeps the data & mode of the original, & that
+// a failed clone leaves nothing behind for the copy to trip over
+func TestCloneFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "labels.db"), filepath.Join(dir, "labels.db.bak")
+	data := bytes.Repeat([]byte{0xA3, 0xD0}, 4096)
+	if err := os.WriteFile(src, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(src, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cloneFile(src, dst); err != nil {
+		t.Logf("no clone support here (%v)", err)
+		if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("a failed clone left %s behind: %v", dst, err)
+		}
+		return
+	}
+	checkCopy(t, src, dst)
+}
+
+// TestBackupFile checks that a backup is the same as the original whether it's made as a clone or falls back to a copy,
+// & that it replaces an earlier backup
+func TestBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "labels.db"), filepath.Join(dir, "labels.db.bak")
+	if err := os.WriteFile(src, bytes.Repeat([]byte{0x5A}, 25600), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(src, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("an older backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backupFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkCopy(t, src, dst)
+}
+
+// checkCopy fails the test if dst doesn't have the same contents & mode as src
+func checkCopy(t *testing.T, src, dst string) {
+	t.Helper()
+	want, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s has %d bytes that don't match %s", dst, len(got), src)
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dstInfo.Mode().Perm() != srcInfo.Mode().Perm() {
+		t.Errorf("%s has mode %v, want %v", dst, dstInfo.Mode().Perm(), srcInfo.Mode().Perm())
+	}
+}
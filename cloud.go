@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxDownloadBytes caps the size of anything downloaded from a share link
+const maxDownloadBytes = 1 << 30
+
+// imageExts are the file extensions treated as images when pulling files out of folders & archives
+var imageExts = []string{".png", ".jpg", ".jpeg", ".gif", ".bmp", ".tif", ".tiff", ".webp"}
+
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
+// isImageFile returns true if name has one of the image file extensions
+func isImageFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range imageExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// download implements `download {share url} -o {dir} [-google-api-key key] [-db labels.db]`. It fetches every image in
+// a publicly shared Dropbox or Google Drive folder into dir, & optionally adds them straight to a labels.db.
+func download(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	out := fs.String("o", ".", "the directory to save the images to")
+	// The key isn't the flag's default, so that -help doesn't print it
+	apiKey := fs.String("google-api-key", "",
+		"Google API key with the Drive API enabled, needed for Google Drive folders (default $GOOGLE_API_KEY)")
+	db := fs.String("db", "", "add the downloaded images to this labels.db")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: download {share url} -o {dir} [-google-api-key key] [-db labels.db]")
+	}
+	if *apiKey == "" {
+		*apiKey = os.Getenv("GOOGLE_API_KEY")
+	}
+
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+
+	var files []string
+	switch host := strings.TrimPrefix(u.Hostname(), "www."); host {
+	case "dropbox.com":
+		files, err = downloadDropbox(u, *out)
+	case "drive.google.com":
+		if *apiKey == "" {
+			return errors.New("downloading from Google Drive needs an API key: pass -google-api-key or set GOOGLE_API_KEY")
+		}
+		files, err = downloadGoogleDrive(u, *apiKey, *out)
+	default:
+		return fmt.Errorf("unsupported share host %q: only Dropbox & Google Drive links are supported", host)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Downloaded %s images to %s", formatCount(len(files)), *out)
+
+	if *db == "" {
+		return nil
+	}
+	imgs := make([]Image, 0, len(files))
+	for _, f := range files {
+		img, err := generateListFromArgs([]string{f})
+		if err != nil {
+			log.Printf("Not adding %s: the filename isn't a signature", filepath.Base(f))
+			continue
+		}
+		imgs = append(imgs, img...)
+	}
+	skipped, err := addImages(*db, imgs, addSettings{Policy: confirmPolicy{Threshold: -1}})
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+	}
+	return nil
+}
+
+// downloadDropbox fetches a Dropbox shared folder. Setting dl=1 on a folder link makes Dropbox send the whole folder
+// as a zip, which saves having to enumerate it through the API.
+func downloadDropbox(u *url.URL, dir string) ([]string, error) {
+	q := u.Query()
+	q.Set("dl", "1")
+	u.RawQuery = q.Encode()
+
+	tmp, err := os.CreateTemp("", "a3dlabels-dropbox-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	log.Printf("Downloading %s", u)
+	if err := fetch(u.String(), tmp); err != nil {
+		return nil, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return nil, fmt.Errorf("dropbox didn't return a folder archive (is this a folder link?): %w", err)
+	}
+
+	files := make([]string, 0)
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !isImageFile(zf.Name) {
+			continue
+		}
+		// Only ever use the base name so an archive can't write outside of dir. Some zip tools use \ as the separator.
+		dst := filepath.Join(dir, zf.Name[strings.LastIndexAny(zf.Name, `/\`)+1:])
+		if err := extractZipFile(zf, dst); err != nil {
+			return files, err
+		}
+		files = append(files, dst)
+	}
+	return files, nil
+}
+
+// extractZipFile writes a single file out of a zip archive
+func extractZipFile(zf *zip.File, dst string) error {
+	if maxImageBytes > 0 && zf.UncompressedSize64 > uint64(maxImageBytes) {
+		return fmt.Errorf("%s: %w", zf.Name, errImageTooLarge)
+	}
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.LimitReader(r, int64(zf.UncompressedSize64))); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// driveFolderID pulls the folder ID out of the various forms of Google Drive folder link
+func driveFolderID(u *url.URL) (string, error) {
+	if id := u.Query().Get("id"); id != "" {
+		return id, nil
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "folders" {
+			return parts[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("couldn't find a folder ID in %s", u)
+}
+
+// downloadGoogleDrive fetches every image in a publicly shared Google Drive folder using the Drive v3 API. Unlike
+// Dropbox, Drive has no way to download a folder without authenticating, so an API key is required.
+func downloadGoogleDrive(u *url.URL, apiKey, dir string) ([]string, error) {
+	id, err := driveFolderID(u)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("q", fmt.Sprintf("'%s' in parents and trashed = false", id))
+		q.Set("fields", "nextPageToken, files(id, name, mimeType)")
+		q.Set("pageSize", "1000")
+		q.Set("key", apiKey)
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		var list struct {
+			NextPageToken string `json:"nextPageToken"`
+			Files         []struct {
+				ID       string `json:"id"`
+				Name     string `json:"name"`
+				MimeType string `json:"mimeType"`
+			} `json:"files"`
+		}
+		if err := fetchJSON("https://www.googleapis.com/drive/v3/files?"+q.Encode(), &list); err != nil {
+			return files, err
+		}
+
+		for _, f := range list.Files {
+			if !strings.HasPrefix(f.MimeType, "image/") && !isImageFile(f.Name) {
+				continue
+			}
+			dst := filepath.Join(dir, filepath.Base(f.Name))
+			log.Printf("Downloading %s", f.Name)
+			if err := fetchFile(fmt.Sprintf("https://www.googleapis.com/drive/v3/files/%s?alt=media&key=%s",
+				url.PathEscape(f.ID), url.QueryEscape(apiKey)), dst); err != nil {
+				return files, err
+			}
+			files = append(files, dst)
+		}
+
+		if list.NextPageToken == "" {
+			return files, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// fetch GETs url & copies the body to w, up to maxDownloadBytes
+func fetch(rawURL string, w io.Writer) error {
+	resp, err := httpClient.Get(rawURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", redactURL(rawURL), resp.Status)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, maxDownloadBytes+1))
+	if err != nil {
+		return err
+	}
+	if n > maxDownloadBytes {
+		return fmt.Errorf("GET %s: response is larger than %s", redactURL(rawURL), formatBytes(maxDownloadBytes))
+	}
+	return nil
+}
+
+// secretParams are the query parameters that carry API keys & tokens
+var secretParams = []string{"key", "api_key", "access_token", "token"}
+
+// redactURL returns rawURL with the values of secretParams & any password in it replaced, so that it can be shown in
+// errors & logs without giving away the credentials it was fetched with
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	q := u.Query()
+	redacted := false
+	for k := range q {
+		if slices.Contains(secretParams, strings.ToLower(k)) {
+			q.Set(k, "REDACTED")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// fetchFile downloads url to the file dst
+func fetchFile(url, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := fetch(url, f); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+	return f.Close()
+}
+
+// fetchJSON downloads url & decodes it as JSON into v
+func fetchJSON(url string, v any) error {
+	var sb strings.Builder
+	if err := fetch(url, &sb); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(sb.String()), v)
+}
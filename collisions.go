@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// collisionTable maps each signature that's shared by more than one game to their titles. The signature is only a
+// CRC32 of the first 8KiB, so unrelated ROMs can end up with the same one, & the console then shows the same label for
+// all of them.
+type collisionTable map[uint32][]string
+
+// collisionsPath returns where the known collisions are kept, in the user's config directory
+func collisionsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "collisions.txt"), nil
+}
+
+// loadCollisions reads a collisions file. A missing one gives an empty table.
+//
+// Each line is a signature followed by the title of one of the games that has it, so a collision is two or more lines
+// with the same signature. Anything after a # is ignored.
+func loadCollisions(path string) (collisionTable, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return collisionTable{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := make(collisionTable)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		sig, err := HexStringTransform(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		t.add(sig, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0])))
+	}
+	return t, sc.Err()
+}
+
+// add records that the game called title has sig, returning false if it was already known
+func (t collisionTable) add(sig uint32, title string) bool {
+	if title == "" || slices.ContainsFunc(t[sig], func(s string) bool { return strings.EqualFold(s, title) }) {
+		return false
+	}
+	t[sig] = append(t[sig], title)
+	return true
+}
+
+// write writes the table in the collisions file format, in signature order
+func (t collisionTable) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, sig := range slices.Sorted(maps.Keys(t)) {
+		for _, title := range t[sig] {
+			fmt.Fprintf(bw, "%08X  %s\n", sig, title)
+		}
+	}
+	return bw.Flush()
+}
+
+// shared returns the titles of every game known to have sig, if there's more than one
+func (t collisionTable) shared(sig uint32) []string {
+	if len(t[sig]) < 2 {
+		return nil
+	}
+	return t[sig]
+}
+
+// loadSavedCollisions reads the collisions file in the user's config directory
+func loadSavedCollisions() (collisionTable, error) {
+	path, err := collisionsPath()
+	if err != nil {
+		return nil, err
+	}
+	return loadCollisions(path)
+}
+
+// warnCollisions logs a warning for every image being added under a signature that's known to be shared by more than
+// one game, since its label will show up for all of them
+func warnCollisions(imgs []Image) {
+	t, err := loadSavedCollisions()
+	if err != nil {
+		debugf("Not checking for signature collisions: %v", err)
+		return
+	}
+	for _, img := range imgs {
+		if titles := t.shared(img.Signature); titles != nil {
+			log.Printf("Warning: %08X is shared by %d games, so this label will show for all of them: %s",
+				img.Signature, len(titles), strings.Join(titles, "; "))
+		}
+	}
+}
+
+// collisions implements `collisions`, which lists the known signature collisions, finds new ones in a ROM index, or
+// imports a list of them from someone else
+func collisions(args []string) error {
+	fs := flag.NewFlagSet("collisions", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms to look for signatures shared by different games in")
+	importFile := fs.String("import", "", "a collisions file to add to the known collisions")
+	save := fs.Bool("save", false, "with -roms, add the collisions found to the known collisions")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || (*save && *roms == "") {
+		return errors.New("usage: collisions [-roms roms.idx [-save]] [-import file]")
+	}
+	path, err := collisionsPath()
+	if err != nil {
+		return err
+	}
+	known, err := loadCollisions(path)
+	if err != nil {
+		return err
+	}
+
+	if *importFile != "" || *roms != "" {
+		found := make(collisionTable)
+		if *importFile != "" {
+			if found, err = loadCollisions(*importFile); err != nil {
+				return err
+			}
+		} else {
+			idx, err := loadROMIndex(*roms)
+			if err != nil {
+				return err
+			}
+			for _, r := range idx.ROMs {
+				found.add(uint32(r.Signature), romTitle(r))
+			}
+			maps.DeleteFunc(found, func(_ uint32, titles []string) bool { return len(titles) < 2 })
+		}
+		if *roms != "" && !*save {
+			return printCollisions(found, "No signatures shared by different games in "+*roms)
+		}
+
+		added := 0
+		for sig, titles := range found {
+			for _, title := range titles {
+				if known.add(sig, title) {
+					added++
+				}
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := known.write(&buf); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+			return err
+		}
+		log.Printf("Added %s titles to %s", formatCount(added), path)
+		return nil
+	}
+	return printCollisions(known, "No signature collisions known")
+}
+
+// printCollisions lists each signature in t that's shared by more than one game, or prints none if there aren't any
+func printCollisions(t collisionTable, none string) error {
+	tab := newTable(os.Stdout, "Signature", "Games")
+	n := 0
+	for _, sig := range slices.Sorted(maps.Keys(t)) {
+		if titles := t.shared(sig); titles != nil {
+			tab.row(fmt.Sprintf("%08X", sig), strings.Join(titles, "; "))
+			n++
+		}
+	}
+	if n == 0 {
+		fmt.Println(none)
+		return nil
+	}
+	return tab.flush()
+}
+
+// romTitle returns the best name there is for a ROM in the index: its internal title, or failing that its filename
+func romTitle(r romEntry) string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return strings.TrimSuffix(filepath.Base(r.Path), filepath.Ext(r.Path))
+}
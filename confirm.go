@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// promptMu stops prompts from the targets a profile writes at the same time from being asked over each other
+var promptMu sync.Mutex
+
+// confirmPolicy controls how commands that would replace existing entries behave: whether existing entries may be
+// replaced at all, & whether the user needs to be asked first.
+type confirmPolicy struct {
+	// Yes answers yes to any confirmation prompt
+	Yes bool
+	// NoOverwrite skips any entry that already exists rather than replacing it
+	NoOverwrite bool
+	// ReplaceOnly skips any entry that doesn't already exist, so only existing entries are replaced
+	ReplaceOnly bool
+	// Force adds & replaces everything without asking, overriding the other settings
+	Force bool
+	// Threshold is the number of replacements allowed before the user is asked to confirm. Negative means never ask.
+	Threshold int
+	// Target names what's being written in prompts, when more than one thing is written in a run
+	Target string
+}
+
+// register adds the policy's flags to fs
+func (p *confirmPolicy) register(fs *flag.FlagSet) {
+	fs.BoolVar(&p.Yes, "yes", false, "don't ask for confirmation before replacing entries")
+	fs.BoolVar(&p.NoOverwrite, "no-overwrite", false, "skip signatures that already exist instead of replacing them")
+	fs.BoolVar(&p.ReplaceOnly, "replace-only", false, "only replace signatures that already exist & skip new ones")
+	fs.BoolVar(&p.Force, "force", false,
+		"add & replace everything without asking, overriding -no-overwrite, -replace-only & -confirm-threshold")
+	fs.IntVar(&p.Threshold, "confirm-threshold", -1,
+		"ask for confirmation if more than this many existing entries would be replaced (-1 to never ask)")
+}
+
+// registerRemove adds the flags that apply to removing entries to fs. -no-overwrite & -replace-only don't mean
+// anything there, so they're left out.
+func (p *confirmPolicy) registerRemove(fs *flag.FlagSet) {
+	fs.BoolVar(&p.Yes, "yes", false, "don't ask for confirmation before removing entries")
+	fs.BoolVar(&p.Force, "force", false, "remove everything without asking, overriding -confirm-threshold")
+	fs.IntVar(&p.Threshold, "confirm-threshold", -1,
+		"ask for confirmation if more than this many entries would be removed (-1 to never ask)")
+}
+
+// validate rejects settings that would skip everything
+func (p *confirmPolicy) validate() error {
+	if p.NoOverwrite && p.ReplaceOnly && !p.Force {
+		return errors.New("-no-overwrite & -replace-only together would skip everything")
+	}
+	return nil
+}
+
+// skipReason returns why an entry is being skipped, or "" if it isn't. exists is whether the signature is already in
+// the database.
+func (p *confirmPolicy) skipReason(exists bool) string {
+	switch {
+	case p.Force:
+		return ""
+	case exists && p.NoOverwrite:
+		return "already in labels.db"
+	case !exists && p.ReplaceOnly:
+		return "not in labels.db & -replace-only is set"
+	}
+	return ""
+}
+
+// confirmReplace checks whether replacing n existing entries is allowed, prompting the user on stdin if the policy says
+// to. It returns an error if the user declines or if a prompt is needed but stdin isn't a terminal.
+func (p *confirmPolicy) confirmReplace(n int) error {
+	return p.confirm(n, "replaced")
+}
+
+// confirmRemove is confirmReplace for removing n entries
+func (p *confirmPolicy) confirmRemove(n int) error {
+	return p.confirm(n, "removed")
+}
+
+// confirm asks whether n existing entries may be replaced or removed, as done says
+func (p *confirmPolicy) confirm(n int, done string) error {
+	if p.Yes || p.Force || p.Threshold < 0 || n <= p.Threshold {
+		return nil
+	}
+
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%d existing entries would be %s; rerun with -yes to allow this without a prompt", n, done)
+	}
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	prefix := ""
+	if p.Target != "" {
+		prefix = p.Target + ": "
+	}
+	fmt.Fprintf(os.Stderr, "%s%d existing entries will be %s. Continue? [y/N] ", prefix, n, done)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("cancelled, nothing was written")
+}
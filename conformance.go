@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// conformanceCase is one reference image: how to draw it, the format it's saved in before being converted, the options
+// it's converted with, & the SHA-256 of the entry the conversion has to produce
+type conformanceCase struct {
+	Name   string
+	Format string
+	Draw   func() image.Image
+	Opts   func(*convertOptions)
+	Want   string
+}
+
+// conformanceCases are the reference conversions. The images are drawn rather than shipped, so every build has them,
+// & each one goes down a different path through decoding & scaling. If a change to the conversion is intended to alter
+// its output, the sums have to be updated from `conformance -print-sums`, since every community pack built afterwards
+// will come out different.
+var conformanceCases = []conformanceCase{
+	{Name: "stretch an 8-bit PNG with Lanczos", Format: "png",
+		Draw: func() image.Image { return conformanceGradient(148, 172, false) },
+		Want: "d607b348916f41d62985c353004ac2f778e87b236f36d7023b50875cac59a36f"},
+	{Name: "stretch a 16-bit PNG", Format: "png",
+		Draw: func() image.Image { return conformanceGradient16(300, 200) },
+		Want: "d009fc29f71a6b319de39e8da8aabf9d795eec8a7807ea64ed2c2fbb6a87454d"},
+	{Name: "decode a baseline JPEG", Format: "jpeg",
+		Draw: func() image.Image { return conformancePattern(200, 232) },
+		Want: "b4fd296096ac577f9482abab6c5ab9501fa4c1c31342b51788c8a23a4a20e735"},
+	{Name: "decode a paletted GIF", Format: "gif",
+		Draw: func() image.Image { return conformancePattern(111, 129) },
+		Want: "a8e90d24045dbfe589b22f7722ab08a1f8781e43f93572edd1429b9fb60e1a24"},
+	{Name: "keep partial transparency", Format: "png",
+		Draw: func() image.Image { return conformanceGradient(90, 90, true) },
+		Want: "44d8c0292a9a927b36ef0d58c890efe5c3773868c2a4112fcc2a7733e17d72e2"},
+	{Name: "fit with Catmull-Rom & a pad colour", Format: "png",
+		Draw: func() image.Image { return conformancePattern(200, 100) },
+		Opts: func(o *convertOptions) {
+			o.Scale = scaleOptions{Mode: "fit", Filter: "catmullrom", PadColor: color.NRGBA{0x20, 0x40, 0x60, 0xFF}}
+		},
+		Want: "21949a0ffdd5270e45a590b4b9ccb60dfeb67fd9c336198f961c9a9f19d782d1"},
+	{Name: "fill with Mitchell-Netravali", Format: "png",
+		Draw: func() image.Image { return conformanceGradient(160, 90, false) },
+		Opts: func(o *convertOptions) { o.Scale = scaleOptions{Mode: "fill", Filter: "mitchell"} },
+		Want: "3b001f619da78e7527ae4894cf671907dd54dc8ba07938e16036063bbd2761f7"},
+	{Name: "crop a small image without scaling", Format: "png",
+		Draw: func() image.Image { return conformancePattern(50, 60) },
+		Opts: func(o *convertOptions) { o.Scale = scaleOptions{Mode: "crop", PadColor: color.NRGBA{A: 0xFF}} },
+		Want: "495899a8c932db3b1f69a60949c8b42e3de00e230c09c32cecf72074483ebb1b"},
+	{Name: "upscale pixel art with nearest neighbour", Format: "png",
+		Draw: func() image.Image { return conformancePattern(37, 43) },
+		Opts: func(o *convertOptions) { o.Scale = scaleOptions{Filter: "nearest"} },
+		Want: "42f5c029ab500e79c48f4bc23fc7058eb64479a09dd093f0e9ce3fcd138625a3"},
+	{Name: "flatten rounded corners onto a background", Format: "png",
+		Draw: func() image.Image { return conformanceGradient(148, 172, true) },
+		Opts: func(o *convertOptions) {
+			o.Alpha = alphaOptions{Mode: "flatten", Background: color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}, RoundedCorners: true}
+		},
+		Want: "b0144832bf1039979c6a90b24acbd1ae8224b19e7f97254a88f44c5593eff83e"},
+	{Name: "pad with a custom pattern", Format: "png",
+		Draw: func() image.Image { return conformancePattern(74, 86) },
+		Opts: func(o *convertOptions) { o.Padding = labelsdb.RepeatPadding([]byte{0x00, 0xFF}) },
+		Want: "c6df498970c30afa420ccd2027f0447078a2bc049809a97f3b47ee123d743197"},
+}
+
+// conformanceGradient draws an 8-bit diagonal gradient, fading out towards the bottom if alpha is set
+func conformanceGradient(w, h int, alpha bool) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			a := uint8(0xFF)
+			if alpha {
+				a = uint8(255 - y*255/(h-1))
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / (w - 1)), uint8(y * 255 / (h - 1)), uint8((x + y) * 3), a})
+		}
+	}
+	return img
+}
+
+// conformanceGradient16 draws a gradient with more precision than 8 bits can hold, so rounding to 8 bits too early
+// shows up
+func conformanceGradient16(w, h int) image.Image {
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 65535 / (w - 1)), uint16(y * 65535 / (h - 1)),
+				uint16(x*y*7 + 1), 0xFFFF})
+		}
+	}
+	return img
+}
+
+// conformancePattern draws hard edged stripes & checks with a little hashed noise, which is what ringing & rounding
+// differences between resampling implementations show up in
+func conformancePattern(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			noise := uint8((uint32(x)*2654435761 ^ uint32(y)*2246822519) >> 27)
+			c := color.NRGBA{uint8(x * 255 / w), 0x30, uint8(y * 255 / h), 0xFF}
+			if (x/5+y/7)%2 == 0 {
+				c = color.NRGBA{0xF0, 0xE0 - noise, 0x10 + noise, 0xFF}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// encodeConformance saves img in format, the same way for every build
+func encodeConformance(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	case "gif":
+		return gif.Encode(w, img, &gif.Options{NumColors: 256})
+	}
+	return png.Encode(w, img)
+}
+
+// conformance implements `conformance`, which converts the reference images in conformanceCases & compares each
+// result with the entry every correct build produces, so anyone contributing to a pack that's meant to be rebuilt
+// byte for byte can check their platform & build first. Like selftest, it works in a temporary directory & bypasses
+// the conversion store.
+func conformance(args []string) error {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	keep := fs.Bool("keep", false, "don't delete the temporary directory of reference images & results afterwards")
+	printSums := fs.Bool("print-sums", false, "print the sums this build produces, for updating the reference ones")
+	fs.BoolVar(&verbose, "verbose", false, "show the log output of each conversion")
+	fs.BoolVar(&verbose, "v", false, "the same as -verbose")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "a3dlabels-conformance")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Printf("Working in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	if !verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	fmt.Printf("%s %s/%s, a3dlabels %s\n\n", runtime.Version(), runtime.GOOS, runtime.GOARCH, toolVersion())
+
+	failed := 0
+	for i, c := range conformanceCases {
+		entry, err := c.convert(filepath.Join(dir, fmt.Sprintf("%02d.%s", i+1, c.Format)))
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", c.Name, err)
+			continue
+		}
+		sum := sha256.Sum256(entry)
+		got := hex.EncodeToString(sum[:])
+		if *printSums {
+			fmt.Printf("%s  %s\n", got, c.Name)
+			continue
+		}
+		if got == c.Want {
+			fmt.Printf("ok    %s\n", c.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s: the entry's SHA-256 is %s, expected %s\n", c.Name, got, c.Want)
+		if *keep {
+			out := filepath.Join(dir, fmt.Sprintf("%02d.entry", i+1))
+			if err := os.WriteFile(out, entry, 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("      the entry produced is in %s\n", out)
+		}
+	}
+
+	if *printSums {
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d conversions differ from the reference; this build won't reproduce packs exactly",
+			failed, len(conformanceCases))
+	}
+	fmt.Printf("\nAll %d conversions match the reference byte for byte\n", len(conformanceCases))
+	return nil
+}
+
+// convert draws the case's image, saves it to path, & converts it the way add would with the case's options
+func (c conformanceCase) convert(path string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeConformance(&buf, c.Draw(), c.Format); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	opts := defaultConvertOptions()
+	if c.Opts != nil {
+		c.Opts(&opts)
+	}
+	return loadImage(path, opts)
+}
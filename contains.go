@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// Exit statuses used by contains, following grep: 0 when everything was found, 1 when something wasn't, & 2 when the
+// question couldn't be answered at all
+const (
+	containsMissing exitStatus = 1
+	containsFailed  exitStatus = 2
+)
+
+// contains implements `contains {labels.db} {signature...}`, printing yes or no for each signature. Only the index is
+// read, so it's quick enough to call from a script for every game in a collection.
+func contains(args []string) error {
+	fs := flag.NewFlagSet("contains", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "print nothing; only set the exit status")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		log.Print(err)
+		return containsFailed
+	}
+	if len(args) < 2 {
+		log.Print("usage: contains {labels.db} {signature...} [-q]")
+		return containsFailed
+	}
+
+	want := make([]uint32, 0, len(args)-1)
+	for _, a := range args[1:] {
+		sig, err := HexStringTransform(a)
+		if err != nil {
+			log.Print(err)
+			return containsFailed
+		}
+		want = append(want, sig)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Print(err)
+		return containsFailed
+	}
+	defer f.Close()
+	sigs, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		log.Print(err)
+		return containsFailed
+	}
+
+	missing := false
+	for _, sig := range want {
+		_, found := slices.BinarySearch(sigs, sig)
+		missing = missing || !found
+		if *quiet {
+			continue
+		}
+		if found {
+			fmt.Printf("%08X yes\n", sig)
+		} else {
+			fmt.Printf("%08X no\n", sig)
+		}
+	}
+	if missing {
+		return containsMissing
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// convertOptions are the settings used to turn a source image into an entry. Anything in here that changes the output
+// must also be reflected in key, otherwise the store will hand back conversions made with different settings.
+type convertOptions struct {
+	// Padding is the labelsdb.PaddingSize bytes appended after the pixel data
+	Padding []byte
+	// Scale controls how the image is fitted to the label
+	Scale scaleOptions
+	// Stamps & Badges are drawn onto the image after it's scaled
+	Stamps []stamp
+	Badges []badge
+	// Alpha controls the corners & transparency, after the stamps & badges are drawn
+	Alpha alphaOptions
+	// Colour is the calibration profile applied after resizing
+	Colour colourProfile
+}
+
+// defaultConvertOptions returns the options that match a stock entry
+func defaultConvertOptions() convertOptions {
+	return convertOptions{Padding: labelsdb.DefaultPadding()}
+}
+
+// key returns a string that uniquely identifies the options, for use in store keys
+func (o convertOptions) key() string {
+	k := fmt.Sprintf("padding=%X", o.Padding)
+	// These are left out when unset so that conversions stored before the options existed are still used
+	if !o.Scale.isDefault() {
+		k += " " + o.Scale.String()
+	}
+	if len(o.Stamps) > 0 || len(o.Badges) > 0 {
+		k += " marks=" + markKey(o.Stamps, o.Badges)
+	}
+	if !o.Alpha.isDefault() {
+		k += " " + o.Alpha.String()
+	}
+	if !o.Colour.isZero() {
+		k += " " + o.Colour.String()
+	}
+	return k
+}
+
+// parsePadding parses a padding pattern given on the command line as hex, e.g. FF or 00FF. The pattern is repeated to
+// fill the padding, so it must be no longer than the padding itself.
+func parsePadding(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "0x")
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid padding pattern %q: %w", s, err)
+	}
+	if len(b) == 0 || len(b) > labelsdb.PaddingSize {
+		return nil, fmt.Errorf("padding pattern must be between 1 & %d bytes", labelsdb.PaddingSize)
+	}
+	return labelsdb.RepeatPadding(b), nil
+}
+
+// keepPadding returns a copy of entry with its padding replaced by the padding from old, the entry it's replacing
+func keepPadding(entry, old []byte) []byte {
+	b := slices.Clone(entry)
+	copy(labelsdb.PaddingOf(b), labelsdb.PaddingOf(old))
+	return b
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// curate implements `curate {labels.db}`, an interactive session that steps through every entry the audit flags as
+// blank, low resolution, or a duplicate, showing a preview of each & offering to replace or export it. Nothing is
+// written until the end of the session, & only after confirming.
+func curate(args []string) error {
+	fs := flag.NewFlagSet("curate", flag.ExitOnError)
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: curate {labels.db} [-backup]")
+	}
+
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+
+	findings := auditEntries(db)
+	if len(findings) == 0 {
+		fmt.Println("Nothing to curate: no blank, low resolution, or duplicate entries found")
+		return nil
+	}
+
+	opts := defaultConvertOptions()
+	opts.Padding = db.Padding()
+	if opts.Colour, err = loadColourProfile(); err != nil {
+		return err
+	}
+	in := bufio.NewReader(os.Stdin)
+	ask := func(prompt string) (string, error) {
+		fmt.Print(prompt)
+		s, err := in.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && s != "") {
+			return "", err
+		}
+		return strings.TrimSpace(s), nil
+	}
+
+	replaced := 0
+session:
+	for n, fd := range findings {
+		fmt.Printf("\n[%d/%d] %08X: %s\n", n+1, len(findings), fd.Signature, fd.Problem)
+		entry, _ := db.Entry(fd.Signature)
+		if !plainOutput {
+			printPreview(os.Stdout, labelsdb.Decode(entry))
+		}
+
+		for {
+			answer, err := ask("[r]eplace, [e]xport, [s]kip, [q]uit: ")
+			if errors.Is(err, io.EOF) {
+				break session
+			} else if err != nil {
+				return err
+			}
+
+			switch strings.ToLower(answer) {
+			case "r", "replace":
+				path, err := ask("Image file: ")
+				if err != nil {
+					return err
+				}
+				b, err := loadImage(path, opts)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				b = keepPadding(b, entry)
+				if err := db.PutEntry(fd.Signature, b); err != nil {
+					return err
+				}
+				entry = b
+				replaced++
+				if !plainOutput {
+					printPreview(os.Stdout, labelsdb.Decode(b))
+				}
+			case "e", "export":
+				name := fmt.Sprintf("%08X.png", fd.Signature)
+				path, err := ask(fmt.Sprintf("Save as [%s]: ", name))
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					path = name
+				}
+				if err := writePNG(path, entry, false); err != nil {
+					fmt.Println(err)
+					continue
+				}
+			case "s", "skip", "":
+			case "q", "quit":
+				break session
+			default:
+				continue
+			}
+			break
+		}
+	}
+
+	if replaced == 0 {
+		return nil
+	}
+	answer, err := ask(fmt.Sprintf("\nWrite %d replaced entries to %s? [y/N] ", replaced, args[0]))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if a := strings.ToLower(answer); a != "y" && a != "yes" {
+		fmt.Println("Nothing was written")
+		return nil
+	}
+
+	if *backup {
+		if err := backupFile(args[0], args[0]+".bak"); err != nil {
+			return err
+		}
+	}
+	log.Printf("Writing %s images to %s", formatCount(db.Len()), args[0])
+	return saveDB(args[0], db)
+}
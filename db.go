@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// openDB reads the labels.db at path. With salvage set, entries that can't be read are logged & replaced with blank
+// ones instead of failing, so that as much as possible can be recovered from a failing card.
+func openDB(path string, salvage bool) (*labelsdb.DB, error) {
+	if !salvage {
+		return labelsdb.Open(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, lost, err := labelsdb.Salvage(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range lost {
+		log.Printf("Couldn't read the entry for %08X in %s; it's been replaced with a blank one", s, path)
+	}
+	return db, nil
+}
+
+// saveDB writes db to path without ever leaving a half-written labels.db behind. The new contents go to a temporary
+// file in the same directory, which is synced & then renamed over the original, so a crash or power loss partway
+// through leaves either the old file or the new one. The original's permissions are kept, along with its owner where
+// that's allowed.
+//
+// With journaling on, whatever the write displaces is recorded in the undo journal once it's done.
+func saveDB(path string, db *labelsdb.DB) error {
+	defer beginWrite()()
+	var old *labelsdb.DB
+	if journalOn() {
+		// A database that can't be read, such as one being salvaged, is still written, just without a record
+		var err error
+		if old, err = labelsdb.Open(path); errors.Is(err, os.ErrNotExist) {
+			old = labelsdb.New()
+		} else if err != nil {
+			log.Printf("Not journaling this write: can't read %s: %v", path, err)
+			old = nil
+		}
+	}
+	if err := writeDB(path, db); err != nil {
+		return err
+	}
+	if old != nil {
+		if err := journalWrite(path, old, db); err != nil {
+			return fmt.Errorf("%s was written, but the undo journal couldn't be updated: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// writeDB is saveDB without the journal. With -resumable it writes with writeDBResumable instead. Either way, it warns
+// if the write has left the database close to full.
+func writeDB(path string, db *labelsdb.DB) error {
+	defer beginWrite()()
+	before := entryCount(path)
+	if resumableWrites {
+		if err := writeDBResumable(path, db); err != nil {
+			return err
+		}
+		recordWrite(path)
+		warnCapacity(path, before, db.Len())
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := db.WriteTo(tmp); err != nil {
+		return fail(err)
+	}
+	if err := keepAttributes(tmp, path); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	removePartial(path)
+	recordWrite(path)
+	warnCapacity(path, before, db.Len())
+	return nil
+}
+
+// keepAttributes gives tmp, the replacement for path, the same permissions, owner, & extended attributes as path, so
+// that replacing a file with a rename doesn't change anything about it but its contents. A file that's new gets 0644
+// rather than the 0600 temporary files are created with.
+func keepAttributes(tmp *os.File, path string) error {
+	orig, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tmp.Chmod(0o644)
+	} else if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(orig.Mode().Perm()); err != nil {
+		return err
+	}
+	keepOwner(tmp, orig)
+	keepXattrs(tmp, path)
+	return nil
+}
+
+// create implements `create {labels.db}`, making a new empty database. It won't overwrite an existing file.
+func create(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: create {labels.db}")
+	}
+	if err := labelsdb.Create(args[0]); err != nil {
+		return err
+	}
+	recordWrite(args[0])
+	log.Printf("Created empty database %s", args[0])
+	return nil
+}
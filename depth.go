@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// lanczos3 is the same Lanczos kernel imaging uses, for resizing images that imaging can't handle at full precision
+var lanczos3 = &draw.Kernel{
+	Support: 3,
+	At: func(t float64) float64 {
+		if t == 0 {
+			return 1
+		}
+		x := math.Pi * t
+		return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+	},
+}
+
+// is16Bit returns true if the image stores more than 8 bits per channel. Archival scans are often saved this way.
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// resize16 resizes a 16 bit per channel image to w x h, then reduces it to 8 bits per channel with Floyd-Steinberg
+// dithering. imaging.Resize truncates 16 bit images to 8 bits before resampling, which throws away the extra precision
+// & leaves visible banding in smooth gradients.
+func resize16(src image.Image, w, h int) *image.NRGBA {
+	scaled := image.NewRGBA64(image.Rect(0, 0, w, h))
+	lanczos3.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	// Un-premultiply into floats on the 8 bit scale so the quantisation error can be carried between pixels
+	px := make([][4]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := scaled.RGBA64At(x, y)
+			p := &px[y*w+x]
+			p[3] = float64(c.A) / 257
+			if c.A == 0 {
+				continue
+			}
+			scale := 255 / float64(c.A)
+			p[0], p[1], p[2] = float64(c.R)*scale, float64(c.G)*scale, float64(c.B)*scale
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := px[y*w+x]
+			i := dst.PixOffset(x, y)
+			for ch := 0; ch < 4; ch++ {
+				q := math.Round(math.Max(0, math.Min(255, p[ch])))
+				dst.Pix[i+ch] = uint8(q)
+				if ch == 3 {
+					// Dithering the alpha channel would give fully opaque images speckled edges, so it's just rounded
+					continue
+				}
+				spread(px, w, h, x, y, ch, p[ch]-q)
+			}
+		}
+	}
+	return dst
+}
+
+// spread distributes the quantisation error for one channel of pixel x,y to its neighbours using the Floyd-Steinberg
+// weights
+func spread(px [][4]float64, w, h, x, y, ch int, err float64) {
+	add := func(dx, dy int, weight float64) {
+		nx, ny := x+dx, y+dy
+		if nx < 0 || nx >= w || ny >= h {
+			return
+		}
+		px[ny*w+nx][ch] += err * weight
+	}
+	add(1, 0, 7.0/16)
+	add(-1, 1, 3.0/16)
+	add(0, 1, 5.0/16)
+	add(1, 1, 1.0/16)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+func TestIs16Bit(t *testing.T) {
+	r := image.Rect(0, 0, 2, 2)
+	for _, tt := range []struct {
+		img  image.Image
+		want bool
+	}{
+		{image.NewGray16(r), true},
+		{image.NewNRGBA64(r), true},
+		{image.NewRGBA64(r), true},
+		{image.NewGray(r), false},
+		{image.NewNRGBA(r), false},
+		{image.NewRGBA(r), false},
+		{image.NewPaletted(r, color.Palette{color.Black}), false},
+	} {
+		if got := is16Bit(tt.img); got != tt.want {
+			t.Errorf("is16Bit(%T) = %v, want %v", tt.img, got, tt.want)
+		}
+	}
+}
+
+// TestResize16Precision scales flat 16 bit images whose channels fall between two 8 bit levels. If the extra precision
+// were thrown away before the entry is encoded, every pixel would be truncated to the level below; kept until then,
+// the dithering averages out to the 16 bit value.
+func TestResize16Precision(t *testing.T) {
+	// Each value is on the 8 bit scale, a quarter, half, & three quarters of the way between two levels
+	const r, g, b = 100.25, 50.5, 200.75
+	level := func(v float64) uint16 { return uint16(math.Round(v * 257)) }
+	bounds := image.Rect(0, 0, labelsdb.Width*2, labelsdb.Height*2)
+
+	gray := image.NewGray16(bounds)
+	nrgba := image.NewNRGBA64(bounds)
+	rgba := image.NewRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.SetGray16(x, y, color.Gray16{Y: level(r)})
+			nrgba.SetNRGBA64(x, y, color.NRGBA64{R: level(r), G: level(g), B: level(b), A: 0xFFFF})
+			rgba.SetRGBA64(x, y, color.RGBA64{R: level(r), G: level(g), B: level(b), A: 0xFFFF})
+		}
+	}
+
+	for _, tt := range []struct {
+		src     image.Image
+		r, g, b float64
+	}{
+		{gray, r, r, r},
+		{nrgba, r, g, b},
+		{rgba, r, g, b},
+	} {
+		entry := labelsdb.Encode(scaleImage(tt.src, scaleOptions{Mode: "stretch"}), nil)
+		var sum [4]float64
+		for i := 0; i < len(entry); i += 4 {
+			for ch := range sum {
+				sum[ch] += float64(entry[i+ch])
+			}
+		}
+		n := float64(len(entry) / 4)
+		// The entry is BGRA
+		for ch, want := range []float64{tt.b, tt.g, tt.r, 255} {
+			if got := sum[ch] / n; math.Abs(got-want) > 0.05 {
+				t.Errorf("%T: channel %c averages %.3f, want %.2f", tt.src, "BGRA"[ch], got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// diff implements `diff {old labels.db} {new labels.db}`, listing the entries that were added, replaced, & removed
+// between the two
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: diff {old labels.db} {new labels.db}")
+	}
+
+	oldDB, err := labelsdb.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", args[0], err)
+	}
+	newDB, err := labelsdb.Open(args[1])
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", args[1], err)
+	}
+	printDiff(labelsdb.Compare(oldDB, newDB), oldDB.Len(), newDB.Len())
+	return nil
+}
@@ -0,0 +1,23 @@
+#!/bin/sh
+# With no arguments, adds everything in /data/art to /data/db/labels.db, skipping anything unchanged since the last
+# run. Extra flags for that can be passed in A3DLABELS_FLAGS. Any arguments are passed straight to a3dlabels instead,
+# e.g. `stats /data/db/labels.db`.
+set -e
+
+if [ "$#" -gt 0 ]; then
+	exec a3dlabels "$@"
+fi
+
+if [ ! -f /data/db/labels.db ]; then
+	echo "No labels.db found: mount the directory containing it at /data/db" >&2
+	exit 1
+fi
+set -- /data/art/*
+if [ ! -e "$1" ]; then
+	echo "Nothing to add: mount your art at /data/art" >&2
+	exit 1
+fi
+
+# A3DLABELS_FLAGS is split on spaces deliberately
+# shellcheck disable=SC2086
+exec a3dlabels add -since-state /data/db/.a3dlabels-state.json $A3DLABELS_FLAGS /data/db/labels.db "$@"
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache asks the kernel to forget the cached pages for part of f, so that reading it back comes from the disk
+func dropCache(f *os.File, off, length int64) {
+	unix.Fadvise(int(f.Fd()), off, length, unix.FADV_DONTNEED)
+}
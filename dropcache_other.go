@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// dropCache does nothing where there's no portable way to drop the cache for part of a file, so reading it back may
+// come from memory rather than the disk
+func dropCache(f *os.File, off, length int64) {}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// extract implements `extract {labels.db} {output dir} [signature...]`, writing each stored image out as a PNG named
+// after its signature. If any signatures are given, only those are extracted. With -by-title, images are named after
+// the game instead wherever a title can be found.
+func extract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	byTitle := fs.Bool("by-title", false, "name the images after the game's title where one can be found")
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	salvage := fs.Bool("salvage", false, "extract what can be read from a damaged database, skipping unreadable entries")
+	optimize := fs.Bool("optimize", false, "make the PNGs as small as possible without losing anything, for publishing")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || (*dat != "" && *roms == "") {
+		return errors.New("usage: extract {labels.db} {output dir} [signature...] [-by-title -roms roms.idx [-dat file] " +
+			"-titles file] [-optimize]")
+	}
+
+	want := make([]uint32, 0, len(args)-2)
+	for _, a := range args[2:] {
+		sig, err := HexStringTransform(a)
+		if err != nil {
+			return err
+		}
+		want = append(want, sig)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	var db *labelsdb.DB
+	var lost []uint32
+	if *salvage {
+		db, lost, err = labelsdb.Salvage(f)
+	} else {
+		db, err = labelsdb.Read(f)
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	var titles titleLookup
+	if *byTitle {
+		if titles, err = loadTitles(*titleFile, *dat, *roms); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(args[1], 0o755); err != nil {
+		return err
+	}
+
+	// Names already used, in lower case since Windows, FAT32 & macOS all ignore case
+	used := make(map[string]bool)
+	n := 0
+	for _, e := range db.Entries() {
+		if len(want) > 0 && !slices.Contains(want, e.Signature) {
+			continue
+		}
+		if slices.Contains(lost, e.Signature) {
+			log.Printf("Skipping %08X: it couldn't be read", e.Signature)
+			continue
+		}
+		name := fmt.Sprintf("%08X", e.Signature)
+		if t := safeFilename(titleCase(titles[e.Signature].Title)); t != "" {
+			name = t
+			if used[strings.ToLower(name)] {
+				// Signatures are unique, so adding one always makes the name unique too
+				name = fmt.Sprintf("%s (%08X)", name, e.Signature)
+			}
+		}
+		used[strings.ToLower(name)] = true
+
+		if err := writePNG(filepath.Join(args[1], name+".png"), e.Data, *optimize); err != nil {
+			return err
+		}
+		n++
+	}
+
+	for _, sig := range want {
+		if !db.Contains(sig) {
+			log.Printf("%08X is not in %s", sig, args[0])
+		}
+	}
+	log.Printf("Extracted %s images to %s", formatCount(n), args[1])
+	return nil
+}
+
+// writePNG converts an entry to an image & saves it as a PNG tagged as sRGB, made as small as possible if optimize is
+// set
+func writePNG(path string, entry []byte, optimize bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	img := labelsdb.Decode(entry)
+	if optimize {
+		err = encodeOptimized(f, img)
+	} else {
+		err = encodeSRGB(f, img)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// encodeSRGB is png.Encode, but with sRGB & gAMA chunks added so that image editors show the colours the same way the
+// console does instead of guessing at a colour space. The gAMA chunk is the one the PNG spec says to pair with sRGB,
+// for older decoders that don't understand sRGB.
+func encodeSRGB(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	_, err := w.Write(tagSRGB(buf.Bytes()))
+	return err
+}
+
+// tagSRGB adds the sRGB & gAMA chunks to an encoded PNG
+func tagSRGB(b []byte) []byte {
+	// The 8 byte signature & the IHDR chunk always come first, & the colour chunks have to follow straight after
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	chunks := append(pngChunk("sRGB", []byte{0}), pngChunk("gAMA", binary.BigEndian.AppendUint32(nil, 45455))...)
+	return slices.Concat(b[:ihdrEnd], chunks, b[ihdrEnd:])
+}
+
+// pngChunk returns a PNG chunk of the given type: its length, type, data & CRC
+func pngChunk(typ string, data []byte) []byte {
+	c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	c = append(c, typ...)
+	c = append(c, data...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
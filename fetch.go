@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultThumbnailURL is where libretro serves its thumbnail collection. {type} & {title} are replaced with the
+// thumbnail type & the game's libretro name.
+const defaultThumbnailURL = "https://thumbnails.libretro.com/Nintendo%20-%20Nintendo%2064/{type}/{title}.png"
+
+// thumbnailTypes maps the -type names to libretro's thumbnail folders
+var thumbnailTypes = map[string]string{
+	"boxart": "Named_Boxarts",
+	"title":  "Named_Titles",
+	"snap":   "Named_Snaps",
+	"logo":   "Named_Logos",
+}
+
+// fetchCmd implements `fetch {labels.db} [rom dir] [-roms roms.idx] [-dat file] [-titles file]`. It looks up the title
+// of every ROM, downloads the matching thumbnail from libretro-thumbnails (or another server laid out the same way), &
+// adds them all to labels.db. Downloads are cached, so later runs only fetch what's new. With -source, or fetch_sources
+// in the config file, each game's art is taken from the first of several sources that has some instead.
+func fetchCmd(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used instead of scanning a ROM directory")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	kind := fs.String("type", "boxart", "the kind of thumbnail to fetch: boxart, title, snap, or logo")
+	template := fs.String("url", defaultThumbnailURL,
+		"the URL to fetch thumbnails from, with {type} & {title} placeholders")
+	cache := fs.String("cache", "",
+		"the directory to keep downloaded thumbnails in (default is in the user cache directory)")
+	var sourceSpecs sourceFlag
+	fs.Var(&sourceSpecs, "source", "where to look for art, as dir:path, cache[:type], or libretro[:type], with optional "+
+		",region=Name|Name & ,min-size=WxH filters; can be repeated to try several in order")
+	scaleMode := fs.String("scale-mode", "stretch", "how to fit thumbnails to the label: stretch, fit, fill, or crop")
+	var alpha alphaOptions
+	alpha.register(fs)
+	dryRun := fs.Bool("dry-run", false, "download everything & show what would change, without writing anything")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	_, ok := thumbnailTypes[*kind]
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2) == (*roms != "") || !ok {
+		return errors.New("usage: fetch {labels.db} {rom dir | -roms roms.idx} [-dat file] [-titles file] " +
+			"[-type boxart|title|snap|logo] [-source spec...] [-url template] [-cache dir] [flags]")
+	}
+	sources, err := artSources(sourceSpecs, *kind)
+	if err != nil {
+		return err
+	}
+	scale := scaleOptions{Mode: *scaleMode}
+	if err := scale.validate(); err != nil {
+		return err
+	}
+	if err := alpha.validate(); err != nil {
+		return err
+	}
+
+	var idx *romIndex
+	if *roms != "" {
+		idx, err = loadROMIndex(*roms)
+	} else {
+		idx, _, err = indexROMs(args[1], nil)
+	}
+	if err != nil {
+		return err
+	}
+	titles, err := thumbnailTitles(idx, *titleFile, *dat)
+	if err != nil {
+		return err
+	}
+
+	tf := thumbnailFetcher{Cache: *cache, Template: *template}
+	if tf.Cache == "" {
+		if tf.Cache, err = os.UserCacheDir(); err != nil {
+			return err
+		}
+		tf.Cache = filepath.Join(tf.Cache, "a3dlabels", "thumbnails")
+	}
+	regions := idx.Regions()
+
+	sigs := slices.Sorted(maps.Keys(titles))
+
+	// Each game's art comes from the first source that has some, so the count of each source's is the summary
+	imgs := make([]Image, 0, len(sigs))
+	used := make([]int, len(sources))
+	downloaded, missing := 0, 0
+	for _, s := range sigs {
+		found := false
+		for i := range sources {
+			src := &sources[i]
+			if !src.allows(regions[s]) {
+				continue
+			}
+			path, fetched, err := src.find(s, titles[s], tf)
+			if err == nil {
+				err = src.bigEnough(path)
+			}
+			if err != nil {
+				debugf("No art for %08X (%s) from %s: %v", s, titles[s], src.spec, err)
+				continue
+			}
+			if fetched {
+				downloaded++
+			}
+			used[i]++
+			imgs = append(imgs, Image{Filepath: path, Signature: s})
+			found = true
+			break
+		}
+		if !found {
+			log.Printf("No art for %08X (%s) from any source", s, titles[s])
+			missing++
+		}
+	}
+	from := make([]string, 0, len(sources))
+	for i, src := range sources {
+		from = append(from, fmt.Sprintf("%s from %s", formatCount(used[i]), src.spec))
+	}
+	log.Printf("Found art for %s games (%s; %s downloaded), %s missing", formatCount(len(imgs)),
+		strings.Join(from, ", "), formatCount(downloaded), formatCount(missing))
+	if len(imgs) == 0 {
+		return nil
+	}
+
+	settings := addSettings{PreservePadding: true, Scale: scale, Alpha: alpha, DryRun: *dryRun, Policy: policy}
+	if storeDir, err := defaultStoreDir(); err == nil {
+		settings.Store, _ = openStore(storeDir)
+	}
+	if settings.Colour, err = loadColourProfile(); err != nil {
+		return err
+	}
+	skipped, err := addImages(args[0], imgs, settings)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+	}
+	return nil
+}
+
+// thumbnailTitles names the ROMs in idx the way libretro does, which is by their No-Intro name. A title file is
+// preferred, then the DAT, then the ROM's own filename, which is the No-Intro name for any properly named set. The
+// internal names in the ROM headers are never used, since they're nothing like the names libretro uses.
+func thumbnailTitles(idx *romIndex, titleFile, dat string) (map[uint32]string, error) {
+	titles := make(titleLookup)
+	if titleFile != "" {
+		t, err := loadTitleFile(titleFile)
+		if err != nil {
+			return nil, err
+		}
+		titles.add("titles", t)
+	}
+	if dat != "" {
+		t, err := datTitles(dat, idx)
+		if err != nil {
+			return nil, err
+		}
+		titles.add("dat", t)
+	}
+	names := make(map[uint32]string)
+	for _, r := range idx.ROMs {
+		base := path.Base(r.Path)
+		names[uint32(r.Signature)] = strings.TrimSuffix(base, path.Ext(base))
+	}
+	titles.add("file", names)
+
+	t := make(map[uint32]string, len(titles))
+	for s, g := range titles {
+		t[s] = g.Title
+	}
+	return t, nil
+}
+
+// libretroName converts a game's name to the name of its libretro thumbnail, which has the characters that aren't
+// allowed in filenames on some systems replaced with underscores
+func libretroName(title string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`&*/:`+"`"+`<>?\|"`, r) {
+			return '_'
+		}
+		return r
+	}, title)
+}
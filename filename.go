@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// maxFilenameLength is the longest filename safeFilename produces, in bytes. Most filesystems allow 255; a little is
+// left over for the extension & a signature to tell clashing names apart.
+const maxFilenameLength = 200
+
+// windowsReserved are device names that Windows won't accept as a filename, even with an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true,
+	"COM9": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true,
+	"LPT8": true, "LPT9": true,
+}
+
+// titleCase converts titles that are entirely in capitals, like the internal names in ROM headers, to title case.
+// Anything with lowercase letters in it is assumed to be cased properly already & left alone.
+func titleCase(s string) string {
+	if strings.ToUpper(s) != s {
+		return s
+	}
+	return cases.Title(language.English).String(s)
+}
+
+// safeFilename turns a title into a filename that's valid on Windows & FAT32 as well as everywhere else. Colons become
+// dashes, as in "Zelda - Ocarina of Time", & the other characters those systems forbid become underscores. Trailing
+// dots & spaces, which Windows silently drops, are removed, & reserved device names get an underscore added. An empty
+// string is returned if nothing usable is left.
+func safeFilename(title string) string {
+	var sb strings.Builder
+	for _, r := range title {
+		switch {
+		case r == ':':
+			sb.WriteString(" -")
+		case strings.ContainsRune(`<>"/\|?*`, r) || unicode.IsControl(r):
+			sb.WriteRune('_')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	name := strings.Join(strings.Fields(sb.String()), " ")
+
+	if len(name) > maxFilenameLength {
+		name = name[:maxFilenameLength]
+		// Don't leave half of a multi-byte character on the end
+		name = strings.ToValidUTF8(name, "")
+	}
+	name = strings.TrimRight(name, ". ")
+
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var (
+	// rawOutput turns off the human-friendly formatting of numbers & sizes in reports, for scripts that parse the output
+	rawOutput bool
+	// plainOutput is set by -plain for screen readers: reports are printed one labelled fact per line, with no columns,
+	// bar charts, or pictures drawn in the terminal
+	plainOutput bool
+)
+
+// numbers formats numbers using the grouping separators of the user's locale
+var numbers = message.NewPrinter(userLocale())
+
+// userLocale works out the locale to format numbers with from the usual POSIX environment variables, falling back to
+// English if none are set or they can't be parsed
+func userLocale() language.Tag {
+	for _, env := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		v := os.Getenv(env)
+		if v == "" || v == "C" || v == "POSIX" {
+			continue
+		}
+		// e.g. de_DE.UTF-8@euro -> de-DE
+		v, _, _ = strings.Cut(v, ".")
+		v, _, _ = strings.Cut(v, "@")
+		if tag, err := language.Parse(strings.ReplaceAll(v, "_", "-")); err == nil {
+			return tag
+		}
+	}
+	return language.English
+}
+
+// formatCount formats n with thousands separators
+func formatCount(n int) string {
+	if rawOutput {
+		return fmt.Sprint(n)
+	}
+	return numbers.Sprint(n)
+}
+
+// formatPercent formats a percentage to one decimal place
+func formatPercent(pct float64) string {
+	if rawOutput {
+		return fmt.Sprintf("%.1f", pct)
+	}
+	return numbers.Sprintf("%.1f%%", pct)
+}
+
+// formatBytes formats a size in bytes using binary units, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	if rawOutput {
+		return fmt.Sprint(n)
+	}
+	const unit = 1024
+	if n < unit {
+		return numbers.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return numbers.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// table prints rows under a row of headers, lined up in columns, or with -plain as one "header: value" line per cell
+// with a blank line after each row
+type table struct {
+	w       io.Writer
+	tw      *tabwriter.Writer
+	headers []string
+}
+
+// newTable returns a table that writes to w
+func newTable(w io.Writer, headers ...string) *table {
+	t := &table{w: w, headers: headers}
+	if !plainOutput {
+		t.tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(t.tw, strings.Join(headers, "\t"))
+	}
+	return t
+}
+
+// row adds a row. With -plain, empty values are left out rather than read out as nothing.
+func (t *table) row(values ...string) {
+	if t.tw != nil {
+		fmt.Fprintln(t.tw, strings.Join(values, "\t"))
+		return
+	}
+	for i, v := range values {
+		if v != "" && i < len(t.headers) {
+			fmt.Fprintf(t.w, "%s: %s\n", t.headers[i], v)
+		}
+	}
+	fmt.Fprintln(t.w)
+}
+
+// flush writes out anything still buffered
+func (t *table) flush() error {
+	if t.tw != nil {
+		return t.tw.Flush()
+	}
+	return nil
+}
+
+// fields prints labelled lines, with the values lined up two spaces after the longest label, which is width characters.
+// A line with an empty label continues the one before it: indented to match normally, or with -plain given the same
+// label again so every line stands alone.
+type fields struct {
+	w     io.Writer
+	width int
+	last  string
+}
+
+// printf prints a line labelled label
+func (f *fields) printf(label, format string, a ...any) {
+	if label == "" {
+		label = f.last
+		if !plainOutput {
+			fmt.Fprintf(f.w, "%*s", f.width+3, "")
+			fmt.Fprintf(f.w, format+"\n", a...)
+			return
+		}
+	}
+	f.last = label
+	if plainOutput {
+		fmt.Fprintf(f.w, "%s: "+format+"\n", append([]any{label}, a...)...)
+		return
+	}
+	fmt.Fprintf(f.w, "%-*s  "+format+"\n", append([]any{f.width + 1, label + ":"}, a...)...)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// savedFraming is how one signature's art is fitted to the label, as remembered in framing.json
+type savedFraming struct {
+	Mode      string `json:"scale_mode"`
+	Filter    string `json:"filter"`
+	PadColor  string `json:"pad_color"`
+	TrimEdges bool   `json:"auto_trim_edges,omitempty"`
+}
+
+// framingTable is the remembered framing for each signature that's had its own
+type framingTable map[hexSig]savedFraming
+
+// framingPath returns where the remembered framing is kept, in the user's config directory
+func framingPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "framing.json"), nil
+}
+
+// loadFraming returns the remembered framing, or an empty table if none has been saved
+func loadFraming() (framingTable, error) {
+	t := make(framingTable)
+	path, err := framingPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// save writes the table to framing.json
+func (t framingTable) save() error {
+	path, err := framingPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// remember records opts as the framing for sig
+func (t framingTable) remember(sig uint32, opts scaleOptions) {
+	c := opts.PadColor
+	t[hexSig(sig)] = savedFraming{Mode: opts.Mode, Filter: opts.Filter, TrimEdges: opts.TrimEdges,
+		PadColor: fmt.Sprintf("%02X%02X%02X%02X", c.R, c.G, c.B, c.A)}
+}
+
+// options returns the framing remembered for each signature as scaleOptions. The flags in explicit were given on the
+// command line, & override what was remembered.
+func (t framingTable) options(flags scaleOptions, explicit map[string]bool) (map[uint32]scaleOptions, error) {
+	opts := make(map[uint32]scaleOptions, len(t))
+	for sig, f := range t {
+		o := scaleOptions{Mode: f.Mode, Filter: f.Filter, TrimEdges: f.TrimEdges}
+		var err error
+		if o.PadColor, err = parseColor(f.PadColor); err != nil {
+			return nil, fmt.Errorf("the framing remembered for %08X: %w", uint32(sig), err)
+		}
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("the framing remembered for %08X: %w", uint32(sig), err)
+		}
+		if explicit["scale-mode"] {
+			o.Mode = flags.Mode
+		}
+		if explicit["filter"] {
+			o.Filter = flags.Filter
+		}
+		if explicit["pad-color"] {
+			o.PadColor = flags.PadColor
+		}
+		if explicit["auto-trim-edges"] {
+			o.TrimEdges = flags.TrimEdges
+		}
+		// Sharpening isn't part of the framing, so it's always taken from the flags
+		o.Sharpen = flags.Sharpen
+		opts[uint32(sig)] = o
+	}
+	return opts, nil
+}
+
+// scaleFor returns how the image for sig is fitted to the label: its own framing if it has some, or else Scale
+func (s addSettings) scaleFor(sig uint32) scaleOptions {
+	if o, ok := s.Framing[sig]; ok {
+		return o
+	}
+	return s.Scale
+}
+
+// framingKey describes per-signature framing for state keys, so that changing a signature's framing makes -since-state
+// convert everything again
+func framingKey(framing map[uint32]scaleOptions) string {
+	h := sha256.New()
+	for _, sig := range slices.Sorted(maps.Keys(framing)) {
+		fmt.Fprintf(h, "%08X %s\n", sig, framing[sig])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)[:8])
+}
+
+// framing implements `framing [{signature}...]`. With any of the scale flags, it remembers them as the framing for each
+// signature given, so that adding new art for those signatures later fits it the same way without the flags having to
+// be given again. With -forget it forgets the signatures' framing. Otherwise it lists what's remembered.
+func framing(args []string) error {
+	fs := flag.NewFlagSet("framing", flag.ExitOnError)
+	var scale scaleOptions
+	fs.StringVar(&scale.Mode, "scale-mode", "stretch",
+		"how to fit images to the label: stretch, fit (letterbox), fill (scale & trim), or crop (no scaling)")
+	fs.StringVar(&scale.Filter, "filter", "lanczos",
+		"resampling filter: nearest, box, linear, catmullrom, mitchell, or lanczos")
+	padColor := fs.String("pad-color", "000000", "colour for the space around fitted or cropped images, as RRGGBB[AA]")
+	fs.BoolVar(&scale.TrimEdges, "auto-trim-edges", false,
+		"trim a thin transparent or white edge left by a bad crop, so it doesn't show as a border")
+	forget := fs.Bool("forget", false, "forget the framing remembered for the signatures")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	adjusting := explicit["scale-mode"] || explicit["filter"] || explicit["pad-color"] || explicit["auto-trim-edges"]
+	if (adjusting || *forget) && len(args) == 0 || adjusting && *forget {
+		return errors.New("usage: framing [{signature}...] [-scale-mode m] [-filter f] [-pad-color RRGGBB[AA]] " +
+			"[-auto-trim-edges] | framing {signature}... -forget")
+	}
+	sigs := make([]uint32, len(args))
+	for i, a := range args {
+		if sigs[i], err = HexStringTransform(a); err != nil {
+			return err
+		}
+	}
+
+	t, err := loadFraming()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *forget:
+		for _, sig := range sigs {
+			delete(t, hexSig(sig))
+		}
+		if err := t.save(); err != nil {
+			return err
+		}
+		fmt.Printf("Forgot the framing for %s signatures\n", formatCount(len(sigs)))
+		return nil
+	case adjusting:
+		if scale.PadColor, err = parseColor(*padColor); err != nil {
+			return err
+		}
+		// Flags that weren't given keep what was remembered before
+		prev, err := t.options(scale, explicit)
+		if err != nil {
+			return err
+		}
+		for _, sig := range sigs {
+			o, ok := prev[sig]
+			if !ok {
+				o = scale
+			}
+			if err := o.validate(); err != nil {
+				return err
+			}
+			t.remember(sig, o)
+			fmt.Printf("%08X  %s\n", sig, o)
+		}
+		return t.save()
+	}
+
+	if len(t) == 0 {
+		fmt.Println("No framing remembered. Set it with `framing {signature} -scale-mode ...` or `add -remember-framing`.")
+		return nil
+	}
+	table := newTable(os.Stdout, "Signature", "Scale mode", "Filter", "Pad colour", "Trim edges")
+	for _, sig := range slices.Sorted(maps.Keys(t)) {
+		if len(sigs) > 0 && !slices.Contains(sigs, uint32(sig)) {
+			continue
+		}
+		f := t[sig]
+		trim := "no"
+		if f.TrimEdges {
+			trim = "yes"
+		}
+		table.row(fmt.Sprintf("%08X", uint32(sig)), f.Mode, f.Filter, f.PadColor, trim)
+	}
+	return table.flush()
+}
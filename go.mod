@@ -2,6 +2,10 @@ module github.com/g026r/analogue3d_labels_tool
 
 go 1.25.3
 
-require github.com/disintegration/imaging v1.6.2
-
-require golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
+require (
+	github.com/disintegration/imaging v1.6.2
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
+	golang.org/x/sys v0.38.0
+	golang.org/x/term v0.37.0
+	golang.org/x/text v0.30.0
+)
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// written is every database written by the command being run, in the order they were first written, for the
+	// post_write hooks
+	written []string
+	// writesMu guards written & crossings, since a profile writes its targets at the same time
+	writesMu sync.Mutex
+	// noHooks is set by -no-hooks to stop the post_write hooks running
+	noHooks bool
+)
+
+// recordWrite notes that the database at path has been written, so the post_write hooks are run for it, & marks it as
+// customized
+func recordWrite(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	markCustomized(path)
+	writesMu.Lock()
+	defer writesMu.Unlock()
+	if !slices.Contains(written, path) {
+		written = append(written, path)
+	}
+}
+
+// forgetWrite undoes recordWrite, for databases that are only scratch copies
+func forgetWrite(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	writesMu.Lock()
+	defer writesMu.Unlock()
+	written = slices.DeleteFunc(written, func(p string) bool { return p == path })
+}
+
+// configPath returns where the config file is kept: $A3DLABELS_CONFIG if it's set, otherwise config.toml in the user's
+// config directory
+func configPath() (string, error) {
+	if p := os.Getenv("A3DLABELS_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "config.toml"), nil
+}
+
+// loadConfig reads the config file, returning each key's values. A missing file is the same as an empty one.
+//
+// Only the little of TOML the config needs is understood: `key = "value"` & `key = ["value", ...]` lines, with the
+// strings quoted as TOML basic strings & # comments. Arrays must be on a single line.
+func loadConfig() (map[string][]string, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := make(map[string][]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		values, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		cfg[strings.TrimSpace(key)] = values
+	}
+	return cfg, sc.Err()
+}
+
+// parseConfigValue parses a quoted string or a single line array of them, ignoring any comment after it
+func parseConfigValue(s string) ([]string, error) {
+	array := strings.HasPrefix(s, "[")
+	if array {
+		s = strings.TrimSpace(s[1:])
+	}
+	values := make([]string, 0)
+	for {
+		if array && strings.HasPrefix(s, "]") {
+			s = strings.TrimSpace(s[1:])
+			break
+		}
+		q, err := strconv.QuotedPrefix(s)
+		if err != nil || q[0] == '\'' {
+			return nil, fmt.Errorf("expected a quoted string at %q", s)
+		}
+		v, _ := strconv.Unquote(q)
+		values = append(values, v)
+		s = strings.TrimSpace(s[len(q):])
+		if !array {
+			break
+		}
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, fmt.Errorf("expected , or ] at %q", s)
+		}
+	}
+	if s != "" && s[0] != '#' {
+		return nil, fmt.Errorf("unexpected %q after the value", s)
+	}
+	return values, nil
+}
+
+// runHooks runs each post_write hook from the config file once for every database that was written, followed by the
+// capacity_hook hooks for any capacity thresholds passed
+func runHooks() error {
+	if noHooks || len(written) == 0 {
+		return nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	for _, db := range written {
+		for _, hook := range cfg["post_write"] {
+			if err := runHook("post_write", hook, map[string]string{"db": db}); err != nil {
+				return err
+			}
+		}
+	}
+	if n := len(cfg["post_write"]); n > 0 {
+		log.Printf("Ran %s post_write hooks", formatCount(n*len(written)))
+	}
+	return runCapacityHooks(cfg)
+}
+
+// runHook runs a single hook from the config file. Hooks are split into arguments like a shell would, but run directly
+// rather than through one, with each {name} in vars replaced by its value, which is also in the environment as
+// A3DLABELS_NAME. Their output goes to stderr so it can't get mixed up with a command's own output.
+func runHook(kind, hook string, vars map[string]string) error {
+	argv, err := splitCommand(hook)
+	if err != nil {
+		return fmt.Errorf("%s hook %q: %w", kind, hook, err)
+	}
+	if len(argv) == 0 {
+		return nil
+	}
+	env := os.Environ()
+	for name, v := range vars {
+		for i := range argv {
+			argv[i] = strings.ReplaceAll(argv[i], "{"+name+"}", v)
+		}
+		env = append(env, "A3DLABELS_"+strings.ToUpper(name)+"="+v)
+	}
+	debugf("Running %s hook %q", kind, argv)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q failed for %s: %w", kind, hook, vars["db"], err)
+	}
+	return nil
+}
+
+// splitCommand splits s into arguments on spaces, keeping anything in single or double quotes together. Backslashes
+// aren't special, so Windows paths work as they are.
+func splitCommand(s string) ([]string, error) {
+	args := make([]string, 0)
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// defaultOCRCommand reads the text from an image with Tesseract, printing it to stdout
+const defaultOCRCommand = "tesseract {image} stdout"
+
+// titleCandidate is a title that the text read from a label might belong to
+type titleCandidate struct {
+	Signature uint32
+	Title     gameTitle
+	// Matched is the number of the title's words found in the text, & Score is that as a fraction of all of them
+	Matched int
+	Score   float64
+}
+
+// identify implements `identify {scan} [-db labels.db] [-roms roms.idx] [-dat file] [-titles file]`. It reads the text
+// on a photo or scan of a cartridge label with an OCR program & lists the titles it most likely belongs to, along with
+// their signatures, for loose cartridges that have no ROM dump to work the signature out from. The OCR program isn't
+// built in: Tesseract is run by default, & -ocr-command or ocr_command in the config file can name another.
+func identify(args []string) error {
+	fs := flag.NewFlagSet("identify", flag.ExitOnError)
+	dbPath := fs.String("db", "", "a labels.db to check the candidates against")
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	count := fs.Int("n", 5, "the number of candidates to list")
+	ocr := fs.String("ocr-command", "", fmt.Sprintf("the command that prints the text in {image} (default %q, "+
+		"or ocr_command in the config file)", defaultOCRCommand))
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *count < 1 || (*dat != "" && *roms == "") {
+		return errors.New("usage: identify {scan} [-db labels.db] [-roms roms.idx [-dat file]] [-titles file] [-n 5] " +
+			"[-ocr-command command]")
+	}
+	if *ocr == "" {
+		*ocr = defaultOCRCommand
+		if cfg, err := loadConfig(); err == nil && len(cfg["ocr_command"]) > 0 {
+			*ocr = cfg["ocr_command"][0]
+		}
+	}
+
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+	if len(titles) == 0 {
+		return errors.New("there are no titles to match the label against; give -titles, -roms, or -roms & -dat, " +
+			"or list a title_registry in the config file")
+	}
+	var sigs []uint32
+	if *dbPath != "" {
+		f, err := os.Open(*dbPath)
+		if err != nil {
+			return err
+		}
+		sigs, err = labelsdb.ReadIndex(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", *dbPath, err)
+		}
+	}
+
+	text, err := readLabelText(*ocr, args[0])
+	if err != nil {
+		return err
+	}
+	debugf("Read from %s: %q", args[0], text)
+	candidates := rankTitles(text, titles)
+	if len(candidates) == 0 {
+		fmt.Printf("None of the %s known titles appear in the text read from %s:\n%s\n", formatCount(len(titles)),
+			args[0], strings.TrimSpace(text))
+		return nil
+	}
+	candidates = candidates[:min(len(candidates), *count)]
+
+	headers := []string{"Signature", "Match", "Title", "Source"}
+	if *dbPath != "" {
+		headers = append(headers, "In labels.db")
+	}
+	t := newTable(os.Stdout, headers...)
+	for _, c := range candidates {
+		row := []string{fmt.Sprintf("%08X", c.Signature), formatPercent(c.Score * 100), c.Title.Title, c.Title.Source}
+		if *dbPath != "" {
+			in := "no"
+			if _, found := slices.BinarySearch(sigs, c.Signature); found {
+				in = "yes"
+			}
+			row = append(row, in)
+		}
+		t.row(row...)
+	}
+	return t.flush()
+}
+
+// readLabelText runs the OCR command on the image at path & returns what it prints. {image} in the command is replaced
+// with the path; without it, the path is added as the last argument.
+func readLabelText(command, path string) (string, error) {
+	argv, err := splitCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("OCR command %q: %w", command, err)
+	}
+	if len(argv) == 0 {
+		return "", errors.New("the OCR command is empty")
+	}
+	if !slices.ContainsFunc(argv, func(a string) bool { return strings.Contains(a, "{image}") }) {
+		argv = append(argv, path)
+	}
+	for i := range argv {
+		argv[i] = strings.ReplaceAll(argv[i], "{image}", path)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout, cmd.Stderr = &out, os.Stderr
+	if err := cmd.Run(); errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%s isn't installed; install it, or name another OCR program with -ocr-command", argv[0])
+	} else if err != nil {
+		return "", fmt.Errorf("OCR command %q failed: %w", command, err)
+	}
+	return out.String(), nil
+}
+
+// labelWords are printed on nearly every cartridge label, or are too common in titles to tell them apart, so they
+// don't count towards a match
+var labelWords = []string{"nintendo", "64", "the", "of", "and", "a"}
+
+// rankTitles scores every title by the fraction of its words that appear in text, best first. Titles with none of their
+// words in the text are left out. Titles are cleaned up the same way as by fuzzyName, & a word of five or more letters
+// still counts if OCR got one letter of it wrong. Where two titles score the same, the one with more words matched is
+// the more convincing.
+func rankTitles(text string, titles titleLookup) []titleCandidate {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	candidates := make([]titleCandidate, 0)
+	for sig, t := range titles {
+		name, _ := fuzzyName(t.Title)
+		want := slices.DeleteFunc(strings.Fields(name), func(w string) bool { return slices.Contains(labelWords, w) })
+		if len(want) == 0 {
+			continue
+		}
+		matched := 0
+		for _, w := range want {
+			if slices.ContainsFunc(words, func(r string) bool { return ocrMatch(w, r) }) {
+				matched++
+			}
+		}
+		if matched > 0 {
+			candidates = append(candidates, titleCandidate{sig, t, matched, float64(matched) / float64(len(want))})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b titleCandidate) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), b.Matched-a.Matched, strings.Compare(a.Title.Title, b.Title.Title),
+			cmp.Compare(a.Signature, b.Signature))
+	})
+	return candidates
+}
+
+// ocrMatch reports whether the word read by OCR is the word wanted, allowing for one letter being misread, dropped, or
+// added in words of five or more letters
+func ocrMatch(want, read string) bool {
+	if want == read {
+		return true
+	}
+	if len(want) < 5 || max(len(want)-len(read), len(read)-len(want)) > 1 {
+		return false
+	}
+	// Skip the common prefix & suffix, then at most one letter can be left over on either side
+	i := 0
+	for i < len(want) && i < len(read) && want[i] == read[i] {
+		i++
+	}
+	j, k := len(want), len(read)
+	for j > i && k > i && want[j-1] == read[k-1] {
+		j--
+		k--
+	}
+	return j-i <= 1 && k-i <= 1
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+// TestRankTitles checks that text read off a label, misread letters & all, ranks the right title first
+func TestRankTitles(t *testing.T) {
+	titles := make(titleLookup)
+	titles.add("dat", map[uint32]string{
+		0x3274BDAF: "Super Mario 64 (USA)",
+		0x12345678: "Mario Kart 64 (USA)",
+		0x0000000A: "Mario Golf (USA)",
+		0x22222222: "Wave Race 64 (USA)",
+		0x33333333: "Legend of Zelda, The - Ocarina of Time (USA)",
+	})
+
+	tests := []struct {
+		text string
+		want uint32
+	}{
+		{"NINTENDO 64\nMARIO KART 64\nOfficial Nintendo Seal", 0x12345678},
+		{"SUPER MARI0 64", 0x3274BDAF},
+		{"THE LEGEND OF ZELDA\nOCARlNA OF TIME", 0x33333333},
+		{"MARIO\nGOLF", 0x0000000A},
+	}
+	for _, tt := range tests {
+		got := rankTitles(tt.text, titles)
+		if len(got) == 0 {
+			t.Errorf("%q: no candidates", tt.text)
+			continue
+		}
+		if got[0].Signature != tt.want {
+			t.Errorf("%q: best candidate is %08X (%s), want %08X", tt.text, got[0].Signature, got[0].Title.Title, tt.want)
+		}
+	}
+
+	if got := rankTitles("NINTENDO 64", titles); len(got) != 0 {
+		t.Errorf("the console's name alone matched %d titles", len(got))
+	}
+}
+
+// TestOCRMatch checks which misreadings are forgiven
+func TestOCRMatch(t *testing.T) {
+	tests := []struct {
+		want, read string
+		match      bool
+	}{
+		{"mario", "mario", true},
+		{"mario", "mari0", true},
+		{"ocarina", "ocarlna", true},
+		{"ocarina", "ocarna", true},
+		{"ocarina", "ocarinaa", true},
+		{"ocarina", "ocrlna", false},
+		{"golf", "g0lf", false},
+		{"kart", "karts", false},
+	}
+	for _, tt := range tests {
+		if got := ocrMatch(tt.want, tt.read); got != tt.match {
+			t.Errorf("ocrMatch(%q, %q) = %v, want %v", tt.want, tt.read, got, tt.match)
+		}
+	}
+}
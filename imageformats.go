@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	// Decoders for every format getImg accepts. imaging pulls in most of these anyway, but relying on that would be
+	// fragile.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// errUnsupportedFormat is returned for images that aren't in any of the formats getImg can decode
+var errUnsupportedFormat = errors.New("unsupported image format, expected PNG, JPEG, GIF, BMP, TIFF or WebP")
+
+// decodeError wraps an error from decoding src, replacing image.ErrFormat's bare "image: unknown format" with one that
+// says which formats are supported
+func decodeError(src string, err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("%s: %w", src, errUnsupportedFormat)
+	}
+	return fmt.Errorf("%s: %w", src, err)
+}
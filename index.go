@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// indexStateVersion is the version of the index.json format written by `index export`
+const indexStateVersion = 1
+
+// indexState is what `index export` writes: every signature in a database with the SHA-256 of its entry, padding &
+// all, which is also the entry's hash in the conversion store. It's a few hundred KB however much art there is.
+type indexState struct {
+	Version int               `json:"version"`
+	Entries []indexStateEntry `json:"entries"`
+}
+
+// indexStateEntry is one signature in an indexState
+type indexStateEntry struct {
+	Signature hexSig `json:"signature"`
+	SHA256    string `json:"sha256"`
+}
+
+// newIndexState returns the index state of db
+func newIndexState(db *labelsdb.DB) indexState {
+	s := indexState{Version: indexStateVersion, Entries: make([]indexStateEntry, 0, db.Len())}
+	for _, e := range db.Entries() {
+		s.Entries = append(s.Entries, indexStateEntry{hexSig(e.Signature), entryDigest(e.Data)})
+	}
+	return s
+}
+
+// write writes the state in its canonical form: one entry per line, in signature order, so that two exports of the same
+// database are byte for byte the same & the differences between two exports can be read with diff
+func (s indexState) write(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  \"version\": %d,\n  \"entries\": [", s.Version)
+	for i, e := range s.Entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "\n    {\"signature\": \"%08X\", \"sha256\": %q}", uint32(e.Signature), e.SHA256)
+	}
+	if len(s.Entries) > 0 {
+		buf.WriteString("\n  ")
+	}
+	buf.WriteString("]\n}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// loadIndexState reads an index.json written by `index export`
+func loadIndexState(path string) (indexState, error) {
+	var s indexState
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Version < 1 || s.Version > indexStateVersion {
+		return s, fmt.Errorf("%s: version %d index files aren't supported by this version", path, s.Version)
+	}
+	if len(s.Entries) > labelsdb.MaxEntries {
+		return s, fmt.Errorf("%s: %w: it has %s entries, but only %s fit", path, labelsdb.ErrFull,
+			formatCount(len(s.Entries)), formatCount(labelsdb.MaxEntries))
+	}
+	seen := make(map[hexSig]bool, len(s.Entries))
+	for i := range s.Entries {
+		e := &s.Entries[i]
+		e.SHA256 = strings.ToLower(e.SHA256)
+		if h, err := hex.DecodeString(e.SHA256); err != nil || len(h) != sha256.Size {
+			return s, fmt.Errorf("%s: %08X: %q isn't a SHA-256", path, uint32(e.Signature), e.SHA256)
+		}
+		if seen[e.Signature] {
+			return s, fmt.Errorf("%s: %08X is in the index more than once", path, uint32(e.Signature))
+		}
+		seen[e.Signature] = true
+	}
+	slices.SortFunc(s.Entries, func(a, b indexStateEntry) int { return cmp.Compare(a.Signature, b.Signature) })
+	return s, nil
+}
+
+// compare works out how the entries in db differ from the state, as if the state were the newer of the two
+func (s indexState) compare(db *labelsdb.DB) labelsdb.Diff {
+	var d labelsdb.Diff
+	want := make(map[uint32]string, len(s.Entries))
+	for _, e := range s.Entries {
+		want[uint32(e.Signature)] = e.SHA256
+		switch old, ok := db.Entry(uint32(e.Signature)); {
+		case !ok:
+			d.Added = append(d.Added, uint32(e.Signature))
+		case entryDigest(old) != e.SHA256:
+			d.Changed = append(d.Changed, uint32(e.Signature))
+		default:
+			d.Unchanged++
+		}
+	}
+	for _, sig := range db.Signatures() {
+		if _, ok := want[sig]; !ok {
+			d.Removed = append(d.Removed, sig)
+		}
+	}
+	return d
+}
+
+// indexCmd implements `index export` & `index import`
+func indexCmd(args []string) error {
+	usage := errors.New("usage: index export {labels.db} [-o index.json] [-store] | " +
+		"index import {index.json} {labels.db} [-check] [-from labels.db,...] [-partial] [-dry-run] [-backup]")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "export":
+		return indexExport(args[1:])
+	case "import":
+		return indexImport(args[1:])
+	}
+	return usage
+}
+
+// indexExport implements `index export {labels.db} [-o index.json]`, writing the signature & entry hash of everything
+// in a database without any of the image data. With -store, the entries are kept in the conversion store too, so that
+// the export can be put back with `index import` later.
+func indexExport(args []string) error {
+	fs := flag.NewFlagSet("index export", flag.ExitOnError)
+	out := fs.String("o", "", "the file to write the index to (default stdout)")
+	keep := fs.Bool("store", false, "keep every entry in the conversion store, so that import can put them back")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: index export {labels.db} [-o index.json] [-store]")
+	}
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+
+	if *keep {
+		dir, err := defaultStoreDir()
+		if err != nil {
+			return err
+		}
+		store, err := openStore(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range db.Entries() {
+			if _, err := store.Put(e.Data); err != nil {
+				return err
+			}
+		}
+		log.Printf("Kept %s entries in %s", formatCount(db.Len()), dir)
+	}
+
+	state := newIndexState(db)
+	if *out == "" {
+		return state.write(os.Stdout)
+	}
+	var buf bytes.Buffer
+	if err := state.write(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, buf.Bytes()); err != nil {
+		return err
+	}
+	log.Printf("Wrote the index of %s entries to %s", formatCount(db.Len()), *out)
+	return nil
+}
+
+// indexImport implements `index import {index.json} {labels.db}`. With -check it only reports whether the database
+// still matches the index, failing if it doesn't. Otherwise it makes the database match: entries the index doesn't
+// have are removed, & the rest are found by their hash in the database itself, the databases given with -from, & the
+// conversion store.
+func indexImport(args []string) error {
+	fs := flag.NewFlagSet("index import", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report whether labels.db matches the index, without changing anything")
+	from := fs.String("from", "", "a comma separated list of other databases to take entries from, such as backups")
+	partial := fs.Bool("partial", false, "leave the entries whose art can't be found as they are instead of failing")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: index import {index.json} {labels.db} [-check] [-from labels.db,...] [-partial] " +
+			"[-dry-run] [-backup]")
+	}
+	state, err := loadIndexState(args[0])
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		db, err := labelsdb.Open(args[1])
+		if err != nil {
+			return err
+		}
+		d := state.compare(db)
+		fmt.Printf("%s has %s entries, %s has %s. %s match.\n", args[1], formatCount(db.Len()), args[0],
+			formatCount(len(state.Entries)), formatCount(d.Unchanged))
+		printSigs("Only in the index", d.Added)
+		printSigs("Different", d.Changed)
+		printSigs("Only in labels.db", d.Removed)
+		if n := len(d.Added) + len(d.Changed) + len(d.Removed); n > 0 {
+			return fmt.Errorf("%s entries in %s don't match %s", formatCount(n), args[1], args[0])
+		}
+		return nil
+	}
+
+	// Starting from the existing file keeps its header as it is
+	orig, err := labelsdb.Open(args[1])
+	exists := err == nil
+	if errors.Is(err, os.ErrNotExist) {
+		orig = labelsdb.New()
+	} else if err != nil {
+		return err
+	}
+	d := state.compare(orig)
+	changing := make(map[uint32]bool)
+	for _, sig := range slices.Concat(d.Added, d.Changed) {
+		changing[sig] = true
+	}
+	needed := make(map[string]bool)
+	for _, e := range state.Entries {
+		if changing[uint32(e.Signature)] {
+			needed[e.SHA256] = true
+		}
+	}
+
+	// Art is often shared between signatures, so anything in the database itself is looked through as well
+	found := make(map[string][]byte)
+	sources := []*labelsdb.DB{orig}
+	if *from != "" {
+		for _, path := range strings.Split(*from, ",") {
+			src, err := labelsdb.Open(path)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, src)
+		}
+	}
+	for _, src := range sources {
+		for _, e := range src.Entries() {
+			if h := entryDigest(e.Data); needed[h] {
+				found[h] = e.Data
+			}
+		}
+	}
+	if len(found) < len(needed) {
+		if dir, err := defaultStoreDir(); err != nil {
+			log.Printf("Not looking in the conversion store: %v", err)
+		} else if store, err := openStore(dir); err != nil {
+			log.Printf("Not looking in the conversion store: %v", err)
+		} else {
+			for h := range needed {
+				if _, ok := found[h]; ok {
+					continue
+				}
+				if b, err := store.Get(h); err == nil {
+					found[h] = b
+				}
+			}
+		}
+	}
+
+	db := orig.Clone()
+	for _, sig := range d.Removed {
+		db.Remove(sig)
+	}
+	var missing []uint32
+	for _, e := range state.Entries {
+		if !changing[uint32(e.Signature)] {
+			continue
+		}
+		b, ok := found[e.SHA256]
+		if !ok {
+			missing = append(missing, uint32(e.Signature))
+			continue
+		}
+		if err := db.PutEntry(uint32(e.Signature), b); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		if !*partial {
+			printSigs("Art not found", missing)
+			return fmt.Errorf("the art for %s entries isn't in %s, -from, or the conversion store; -partial leaves "+
+				"them as they are", formatCount(len(missing)), args[1])
+		}
+		log.Printf("Leaving %s entries as they are, since their art couldn't be found", formatCount(len(missing)))
+	}
+
+	if *dryRun {
+		printDiff(labelsdb.Compare(orig, db), orig.Len(), db.Len())
+		printSigs("Art not found", missing)
+		fmt.Printf("\nDry run: %s was not modified.\n", args[1])
+		return nil
+	}
+	if *backup && exists {
+		if err := backupFile(args[1], args[1]+".bak"); err != nil {
+			return err
+		}
+	}
+	log.Printf("Writing %s images to %s", formatCount(db.Len()), args[1])
+	return saveDB(args[1], db)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// journalWrites is set by -journal to record what each write displaces in the database's undo journal
+var journalWrites bool
+
+// journalOp is a record in an undo journal: one write to the database & everything it displaced. The entries that were
+// replaced or removed follow the record in the journal, in that order.
+type journalOp struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Added    []hexSig  `json:"added,omitempty"`
+	Replaced []hexSig  `json:"replaced,omitempty"`
+	Removed  []hexSig  `json:"removed,omitempty"`
+	// After is the dbDigest of the database once the write was done, so a revert can tell if something else has
+	// changed it since
+	After string `json:"after"`
+
+	// offset is where the record starts in the journal & entries the displaced entries, by signature
+	offset  int64
+	entries map[uint32][]byte
+}
+
+// journalPath returns the undo journal kept alongside the database at path
+func journalPath(path string) string {
+	return path + ".undo"
+}
+
+// journalOn reports whether writes should be journaled: if -journal was given, or journal = "true" is in the config
+func journalOn() bool {
+	if journalWrites {
+		return true
+	}
+	cfg, err := loadConfig()
+	if err != nil || len(cfg["journal"]) == 0 {
+		return false
+	}
+	on, _ := strconv.ParseBool(cfg["journal"][0])
+	return on
+}
+
+// dbDigest returns a hash of every signature & entry in db. Unlike a hash of the file it ignores anything outside the
+// entries, such as leftover data in the index, so reverting a write gets back to the digest from before it.
+func dbDigest(db *labelsdb.DB) string {
+	h := sha256.New()
+	for _, e := range db.Entries() {
+		binary.Write(h, binary.LittleEndian, e.Signature)
+		h.Write(e.Data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// journalWrite appends a record of the change from oldDB to newDB to the undo journal for path. Nothing is written if
+// the entries are the same.
+func journalWrite(path string, oldDB, newDB *labelsdb.DB) error {
+	d := labelsdb.Compare(oldDB, newDB)
+	if len(d.Added)+len(d.Changed)+len(d.Removed) == 0 {
+		return nil
+	}
+	op := journalOp{Time: time.Now().UTC().Truncate(time.Second), Command: commandLine(),
+		After: dbDigest(newDB)}
+	toHex := func(sigs []uint32) []hexSig {
+		h := make([]hexSig, len(sigs))
+		for i, s := range sigs {
+			h[i] = hexSig(s)
+		}
+		return h
+	}
+	op.Added, op.Replaced, op.Removed = toHex(d.Added), toHex(d.Changed), toHex(d.Removed)
+
+	f, err := os.OpenFile(journalPath(path), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	// Anything after the last complete record is left from a crash, & would garble the record written after it
+	_, end, err := scanJournal(f, false)
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	b, err := json.Marshal(op)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.Write(append(b, '\n'))
+	for _, s := range append(d.Changed, d.Removed...) {
+		e, _ := oldDB.Entry(s)
+		w.Write(e)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	debugf("Journaled %d added, %d replaced & %d removed in %s", len(d.Added), len(d.Changed), len(d.Removed),
+		journalPath(path))
+	return f.Close()
+}
+
+// readJournal returns every record in the undo journal for path, oldest first
+func readJournal(path string) ([]journalOp, error) {
+	f, err := os.Open(journalPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ops, _, err := scanJournal(f, true)
+	return ops, err
+}
+
+// customisedSigs returns the signatures the undo journals for paths show being written & not removed again since: the
+// user's own labels, rather than stock art. It's nil if none of the paths has a journal.
+func customisedSigs(paths ...string) (map[uint32]bool, error) {
+	var sigs map[uint32]bool
+	for _, path := range paths {
+		f, err := os.Open(journalPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ops, _, err := scanJournal(f, false)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if sigs == nil {
+			sigs = make(map[uint32]bool)
+		}
+		for _, op := range ops {
+			for _, s := range slices.Concat(op.Added, op.Replaced) {
+				sigs[uint32(s)] = true
+			}
+			for _, s := range op.Removed {
+				delete(sigs, uint32(s))
+			}
+		}
+	}
+	return sigs, nil
+}
+
+// scanJournal reads the records in a journal, along with their entries if withEntries is set, & returns them with the
+// offset just past the last complete one. A record left incomplete at the end, by a crash partway through writing it,
+// is ignored.
+func scanJournal(f *os.File, withEntries bool) ([]journalOp, int64, error) {
+	ops := make([]journalOp, 0)
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("Ignoring an incomplete record at the end of %s", f.Name())
+			}
+			return ops, offset, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		var op journalOp
+		if err := json.Unmarshal(line, &op); err != nil {
+			return nil, 0, fmt.Errorf("%s: bad record at offset %d: %w", f.Name(), offset, err)
+		}
+		op.offset = offset
+
+		// The entries are skipped over with a seek rather than read when they aren't wanted
+		displaced := append(op.Replaced, op.Removed...)
+		start := offset + int64(len(line))
+		next := start + int64(len(displaced))*labelsdb.EntrySize
+		if fi, err := f.Stat(); err != nil {
+			return nil, 0, err
+		} else if next > fi.Size() {
+			log.Printf("Ignoring an incomplete record at the end of %s", f.Name())
+			return ops, offset, nil
+		}
+		if withEntries {
+			op.entries = make(map[uint32][]byte, len(displaced))
+			for i, s := range displaced {
+				e := make([]byte, labelsdb.EntrySize)
+				if _, err := f.ReadAt(e, start+int64(i)*labelsdb.EntrySize); err != nil {
+					return nil, 0, err
+				}
+				op.entries[uint32(s)] = e
+			}
+		}
+		if _, err := f.Seek(next, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		r.Reset(f)
+		ops = append(ops, op)
+		offset = next
+	}
+}
+
+// revert undoes op on db: the entries it added are removed & those it replaced or removed are put back
+func (op journalOp) revert(db *labelsdb.DB) error {
+	for _, s := range op.Added {
+		db.Remove(uint32(s))
+	}
+	for s, e := range op.entries {
+		if err := db.PutEntry(s, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summary describes op in a line
+func (op journalOp) summary() string {
+	return fmt.Sprintf("%s  +%d ~%d -%d  %s", op.Time.Local().Format("2006-01-02 15:04:05"), len(op.Added),
+		len(op.Replaced), len(op.Removed), op.Command)
+}
@@ -0,0 +1,82 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+	"golang.org/x/sys/unix"
+)
+
+// testXattr is set on the file being replaced to check that it's carried over. It's in the user namespace, the only
+// one an unprivileged process can write on Linux.
+const testXattr = "user.a3dlabels.test"
+
+// TestKeepAttributes checks that every way of replacing a file keeps its mode & extended attributes. The xattr check
+// is skipped where the filesystem doesn't support them.
+func TestKeepAttributes(t *testing.T) {
+	t.Setenv("A3DLABELS_CONFIG", filepath.Join(t.TempDir(), "config.toml"))
+	db := labelsdb.New()
+
+	writers := map[string]func(path string) error{
+		"writeDB":          func(path string) error { return writeDB(path, db) },
+		"writeFileAtomic":  func(path string) error { return writeFileAtomic(path, []byte("replaced")) },
+		"writeDBResumable": func(path string) error { return writeDBResumable(path, db) },
+	}
+	for name, write := range writers {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "labels.db")
+			if err := labelsdb.Create(path); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, 0o640); err != nil {
+				t.Fatal(err)
+			}
+			xattrs := setXattr(path, testXattr, []byte("kept")) == nil
+			if !xattrs {
+				t.Log("no xattr support here, only checking the mode")
+			}
+
+			if err := write(path); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0o640 {
+				t.Errorf("mode = %v, want -rw-r-----", fi.Mode().Perm())
+			}
+			if !xattrs {
+				return
+			}
+			value := make([]byte, 16)
+			n, err := unix.Getxattr(path, testXattr, value)
+			if err != nil {
+				t.Fatalf("%s wasn't kept: %v", testXattr, err)
+			}
+			if got := string(value[:n]); got != "kept" {
+				t.Errorf("%s = %q, want \"kept\"", testXattr, got)
+			}
+		})
+	}
+}
+
+// TestKeepAttributesNewFile checks that a file that didn't exist before is created readable by everyone, the same as
+// labelsdb.Create makes one
+func TestKeepAttributesNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.json")
+	if err := writeFileAtomic(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o644 {
+		t.Errorf("mode = %v, want -rw-r--r--", fi.Mode().Perm())
+	}
+}
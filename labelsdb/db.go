@@ -0,0 +1,350 @@
+// Package labelsdb reads & writes the labels.db file the Analogue 3D uses for its cartridge label art.
+//
+// A labels.db is a 0x100 byte header, an index of little endian CRC32 cartridge signatures running from 0x100 to
+// 0x4100 (sorted & terminated by 0xFFFFFFFF), then one entry per signature in the same order. Each entry is a 74x86
+// BGRA image followed by PaddingSize bytes of padding.
+//
+// # Compatibility
+//
+// The package's API is stable from v1.0.0 of the module & follows semantic versioning: nothing exported will be removed
+// or change its meaning before a v2, so a tool built against v1.x keeps building against any later v1 release. New
+// functions, methods, & Filter fields may be added in minor releases. The wording of error messages & Problem
+// descriptions isn't part of the API; match errors with errors.Is against the exported ones, such as ErrFull.
+package labelsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// DB is a labels.db held in memory. The zero value isn't usable; get one from New, Open or Read.
+type DB struct {
+	// header is the ImagesStart bytes before the first entry: the header proper followed by the raw index. Anything in
+	// the index after the EOF marker is kept as it was read, so unchanged files are written back byte for byte.
+	header []byte
+	sigs   []uint32
+	data   [][]byte
+}
+
+// Entry is a single signature & its raw BGRA + padding data
+type Entry struct {
+	Signature uint32
+	Data      []byte
+}
+
+// New returns an empty database: the stock header followed by an index containing only the EOF marker. The unused
+// index slots are filled with 0xFF.
+func New() *DB {
+	h := bytes.Repeat([]byte{0xFF}, ImagesStart)
+	copy(h, Header)
+	return &DB{header: h}
+}
+
+// Open reads the labels.db at path. See Read.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// readRetries is the number of times an entry that fails to read is tried again before giving up. Failing SD cards
+// often return an error or a short read once & then succeed on the next attempt.
+const readRetries = 3
+
+// Read reads the header, index & all of the entries from a labels.db. An entry that fails to read is retried a few
+// times before Read gives up.
+func Read(r io.ReadSeeker) (*DB, error) {
+	db, _, err := read(r, false)
+	return db, err
+}
+
+// Salvage is Read for damaged files, such as one on a failing SD card. Entries that still can't be read after retrying,
+// or that are missing because the file is truncated, are replaced with blank ones instead of failing the whole read.
+// The signatures of the entries that were blanked are returned so they can be reported. The header & index still have
+// to be readable.
+func Salvage(r io.ReadSeeker) (*DB, []uint32, error) {
+	return read(r, true)
+}
+
+// read implements Read & Salvage
+func read(r io.ReadSeeker, salvage bool) (*DB, []uint32, error) {
+	if err := Check(r); err != nil {
+		return nil, nil, err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	db := &DB{header: make([]byte, ImagesStart)}
+	if _, err := io.ReadFull(r, db.header); err != nil {
+		return nil, nil, err
+	}
+	db.sigs = parseIndex(db.header[IndexStart:])
+
+	var lost []uint32
+	db.data = make([][]byte, 0, len(db.sigs))
+	for i := range db.sigs {
+		px, err := readEntry(r, i, size)
+		if err != nil && salvage {
+			lost = append(lost, db.sigs[i])
+			px = Encode(image.NewNRGBA(image.Rect(0, 0, Width, Height)), DefaultPadding())
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("the index lists %d entries, but entry %d (%08X) can't be read: %w",
+				len(db.sigs), i, db.sigs[i], err)
+		}
+		db.data = append(db.data, px)
+	}
+
+	return db, lost, nil
+}
+
+// readEntry reads the i'th entry, seeking to it & trying again if the read fails. size is the size of the file: there's
+// no point retrying an entry that's past the end of it.
+func readEntry(r io.ReadSeeker, i int, size int64) ([]byte, error) {
+	off := ImagesStart + int64(i)*EntrySize
+	if off+EntrySize > size {
+		return nil, errors.New("file is truncated")
+	}
+	px := make([]byte, EntrySize)
+	var err error
+	for attempt := 0; attempt <= readRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if _, err = r.Seek(off, io.SeekStart); err != nil {
+			continue
+		}
+		if _, err = io.ReadFull(r, px); err == nil {
+			return px, nil
+		}
+	}
+	return nil, fmt.Errorf("%w (tried %d times)", err, readRetries+1)
+}
+
+// Clone returns a copy of the database that can be changed without affecting the original
+func (db *DB) Clone() *DB {
+	// The entries themselves are never modified in place, only replaced, so they can be shared
+	return &DB{header: slices.Clone(db.header), sigs: slices.Clone(db.sigs), data: slices.Clone(db.data)}
+}
+
+// Len returns the number of entries
+func (db *DB) Len() int {
+	return len(db.sigs)
+}
+
+// Signatures returns the signatures of every entry, in index order
+func (db *DB) Signatures() []uint32 {
+	return slices.Clone(db.sigs)
+}
+
+// Entries returns every entry, in index order. The data is shared with the database, so it mustn't be modified.
+func (db *DB) Entries() []Entry {
+	e := make([]Entry, len(db.sigs))
+	for i := range db.sigs {
+		e[i] = Entry{db.sigs[i], db.data[i]}
+	}
+	return e
+}
+
+// find returns the position of sig in the index & whether it's there
+func (db *DB) find(sig uint32) (int, bool) {
+	return slices.BinarySearch(db.sigs, sig)
+}
+
+// Contains reports whether there's an entry for sig
+func (db *DB) Contains(sig uint32) bool {
+	_, ok := db.find(sig)
+	return ok
+}
+
+// Entry returns the raw data for sig. The data is shared with the database, so it mustn't be modified.
+func (db *DB) Entry(sig uint32) ([]byte, bool) {
+	i, ok := db.find(sig)
+	if !ok {
+		return nil, false
+	}
+	return db.data[i], true
+}
+
+// Image returns the entry for sig as an image
+func (db *DB) Image(sig uint32) (*image.NRGBA, bool) {
+	e, ok := db.Entry(sig)
+	if !ok {
+		return nil, false
+	}
+	return Decode(e), true
+}
+
+// PutEntry adds the raw entry data for sig, replacing any existing entry. It returns ErrFull if sig is new & the index
+// has no free slots.
+func (db *DB) PutEntry(sig uint32, data []byte) error {
+	if len(data) != EntrySize {
+		return fmt.Errorf("entry for %08X is %d bytes, expected %d", sig, len(data), EntrySize)
+	}
+	if sig == IndexEOF {
+		return fmt.Errorf("%08X is the index EOF marker & can't be used as a signature", sig)
+	}
+	i, ok := db.find(sig)
+	if ok {
+		db.data[i] = data
+		return nil
+	}
+	if len(db.sigs) >= MaxEntries {
+		return fmt.Errorf("%w: can't add %08X, the index already holds %d entries", ErrFull, sig, MaxEntries)
+	}
+	db.sigs = slices.Insert(db.sigs, i, sig)
+	db.data = slices.Insert(db.data, i, data)
+	return nil
+}
+
+// Put converts img into an entry & adds it for sig, replacing any existing entry. Images that aren't 74x86 are resized
+// with a Lanczos filter. The padding matches that used by the existing entries.
+func (db *DB) Put(sig uint32, img image.Image) error {
+	var n *image.NRGBA
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		n = imaging.Resize(img, Width, Height, imaging.Lanczos)
+	} else {
+		n = imaging.Clone(img)
+	}
+	return db.PutEntry(sig, Encode(n, db.Padding()))
+}
+
+// Remove deletes the entry for sig, reporting whether there was one
+func (db *DB) Remove(sig uint32) bool {
+	i, ok := db.find(sig)
+	if !ok {
+		return false
+	}
+	db.sigs = slices.Delete(db.sigs, i, i+1)
+	db.data = slices.Delete(db.data, i, i+1)
+
+	// The EOF marker moves up a slot when the index is written, so blank the slot after it. Otherwise the old last
+	// signature would be left sitting after the marker.
+	if off := IndexStart + (len(db.sigs)+1)*4; off+4 <= ImagesStart {
+		binary.LittleEndian.PutUint32(db.header[off:], IndexEOF)
+	}
+	return true
+}
+
+// IndexJunk returns the number of index slots after the EOF marker that aren't blank (0xFFFFFFFF). They're never read,
+// since the index ends at the marker, but some databases in the wild have leftover data there.
+func (db *DB) IndexJunk() int {
+	return countJunk(db.header[IndexStart:], len(db.sigs))
+}
+
+// ClearIndexJunk blanks every index slot after the EOF marker, so that the index is written out the same way a fresh
+// database's would be
+func (db *DB) ClearIndexJunk() {
+	for off := IndexStart + (len(db.sigs)+1)*4; off+4 <= ImagesStart; off += 4 {
+		binary.LittleEndian.PutUint32(db.header[off:], IndexEOF)
+	}
+}
+
+// Padding returns the most common padding used by the existing entries, or DefaultPadding if there aren't any
+func (db *DB) Padding() []byte {
+	counts := make(map[string]int)
+	best, bestCount := "", 0
+	for _, e := range db.data {
+		p := string(PaddingOf(e))
+		counts[p]++
+		if counts[p] > bestCount {
+			best, bestCount = p, counts[p]
+		}
+	}
+	if bestCount == 0 {
+		return DefaultPadding()
+	}
+	return []byte(best)
+}
+
+// Size returns how many bytes WriteTo will write
+func (db *DB) Size() int64 {
+	return ImagesStart + int64(len(db.sigs))*EntrySize
+}
+
+// Validate checks that the database can be written out as a valid labels.db: the index must fit between IndexStart &
+// ImagesStart with room for the EOF marker, the signatures must be sorted & unique, every entry must be the right size,
+// & the file must fit within MaxFileSize.
+func (db *DB) Validate() error {
+	if len(db.sigs) > MaxEntries {
+		return fmt.Errorf("%w: %d entries, but the index only has room for %d", ErrFull, len(db.sigs), MaxEntries)
+	}
+	if len(db.sigs) != len(db.data) {
+		return fmt.Errorf("%d signatures but %d entries", len(db.sigs), len(db.data))
+	}
+	for i, s := range db.sigs {
+		if i > 0 && s <= db.sigs[i-1] {
+			return fmt.Errorf("signature %08X in slot %d is out of order or repeated", s, i)
+		}
+		if s == IndexEOF {
+			return fmt.Errorf("%08X is the index EOF marker & can't be used as a signature", s)
+		}
+		if len(db.data[i]) != EntrySize {
+			return fmt.Errorf("entry for %08X is %d bytes, expected %d", s, len(db.data[i]), EntrySize)
+		}
+	}
+	if size := db.Size(); size > MaxFileSize {
+		return fmt.Errorf("database would be %d bytes, over the %d byte limit", size, int64(MaxFileSize))
+	}
+	return nil
+}
+
+// writeChunkSize is how much of the image pool is gathered up before each write. Slow media like FAT32 SD cards cope
+// far better with a handful of large writes than with thousands of 25KiB ones. It's a whole number of entries & also a
+// multiple of 4KiB.
+const writeChunkSize = 160 * EntrySize
+
+// WriteTo writes the whole database to w. It's checked with Validate first, so nothing is written if it's invalid. The
+// header & index are written in a single call & the images in writeChunkSize batches to keep the number of syscalls
+// down.
+func (db *DB) WriteTo(w io.Writer) (int64, error) {
+	if err := db.Validate(); err != nil {
+		return 0, err
+	}
+
+	h := slices.Clone(db.header)
+	index := h[IndexStart:IndexStart]
+	for _, s := range db.sigs {
+		index = binary.LittleEndian.AppendUint32(index, s)
+	}
+	binary.LittleEndian.AppendUint32(index, IndexEOF)
+
+	var written int64
+	n, err := w.Write(h)
+	written += int64(n)
+	if err != nil {
+		return written, fmt.Errorf("writing index: %w", err)
+	}
+
+	buf := make([]byte, 0, min(writeChunkSize, len(db.data)*EntrySize))
+	first := 0
+	for i := range db.data {
+		buf = append(buf, db.data[i]...)
+		if len(buf)+EntrySize > writeChunkSize || i == len(db.data)-1 {
+			n, err := w.Write(buf)
+			written += int64(n)
+			if err != nil {
+				return written, fmt.Errorf("writing entries %08X-%08X: %w", db.sigs[first], db.sigs[i], err)
+			}
+			buf = buf[:0]
+			first = i + 1
+		}
+	}
+	return written, nil
+}
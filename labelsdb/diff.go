@@ -0,0 +1,43 @@
+package labelsdb
+
+import "bytes"
+
+// Diff describes how the entries in one labels.db differ from those in another
+type Diff struct {
+	// Added are signatures only present in the new db
+	Added []uint32
+	// Removed are signatures only present in the old db
+	Removed []uint32
+	// Changed are signatures present in both, but with different image data
+	Changed []uint32
+	// Unchanged is the number of signatures present in both with identical image data
+	Unchanged int
+}
+
+// Compare works out how the entries in newDB differ from those in oldDB
+func Compare(oldDB, newDB *DB) Diff {
+	var d Diff
+	i, j := 0, 0
+	for i < len(oldDB.sigs) && j < len(newDB.sigs) {
+		switch {
+		case oldDB.sigs[i] < newDB.sigs[j]:
+			d.Removed = append(d.Removed, oldDB.sigs[i])
+			i++
+		case oldDB.sigs[i] > newDB.sigs[j]:
+			d.Added = append(d.Added, newDB.sigs[j])
+			j++
+		default:
+			if bytes.Equal(oldDB.data[i], newDB.data[j]) {
+				d.Unchanged++
+			} else {
+				d.Changed = append(d.Changed, newDB.sigs[j])
+			}
+			i++
+			j++
+		}
+	}
+	d.Removed = append(d.Removed, oldDB.sigs[i:]...)
+	d.Added = append(d.Added, newDB.sigs[j:]...)
+
+	return d
+}
@@ -0,0 +1,62 @@
+package labelsdb
+
+import "image"
+
+// DefaultPadding returns the padding used by stock entries
+func DefaultPadding() []byte {
+	return RepeatPadding([]byte{0xFF})
+}
+
+// RepeatPadding repeats pattern to fill PaddingSize bytes
+func RepeatPadding(pattern []byte) []byte {
+	p := make([]byte, PaddingSize)
+	for i := range p {
+		p[i] = pattern[i%len(pattern)]
+	}
+	return p
+}
+
+// PaddingOf returns the padding at the end of an entry. It shares the entry's memory.
+func PaddingOf(entry []byte) []byte {
+	return entry[EntrySize-PaddingSize:]
+}
+
+// Encode converts a 74x86 image into an entry: the pixels as BGRA followed by padding, which must be PaddingSize
+// bytes. The image must already be the right size.
+func Encode(img *image.NRGBA, padding []byte) []byte {
+	e := make([]byte, 0, EntrySize)
+	for y := 0; y < Height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+Width*4]
+		for x := 0; x < len(row); x += 4 {
+			e = append(e, row[x+2], row[x+1], row[x], row[x+3])
+		}
+	}
+	return append(e, padding...)
+}
+
+// Decode converts the pixel data of an entry from BGRA back into an image. The padding is ignored.
+func Decode(entry []byte) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, Width, Height))
+	for i := 0; i < Width*Height*4; i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = entry[i+2], entry[i+1], entry[i], entry[i+3]
+	}
+	return img
+}
+
+// BlankTolerance is how far any channel may stray from the first pixel for an entry to still count as blank
+const BlankTolerance = 8
+
+// IsBlank reports whether the art in entry is a single flat colour: every channel of every pixel is within
+// BlankTolerance of the first one. It works on the raw data, so nothing is decoded.
+func IsBlank(entry []byte) bool {
+	first := entry[:4]
+	for i := 0; i < Width*Height*4; i += 4 {
+		for c := 0; c < 4; c++ {
+			d := int(entry[i+c]) - int(first[c])
+			if d > BlankTolerance || d < -BlankTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
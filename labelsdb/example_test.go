@@ -0,0 +1,142 @@
+package labelsdb_test
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// solid returns a label-sized image that's all one colour
+func solid(c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, labelsdb.Width, labelsdb.Height))
+	for y := range labelsdb.Height {
+		for x := range labelsdb.Width {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// Adding art for two cartridges to an empty database & listing what it holds
+func Example() {
+	db := labelsdb.New()
+	if err := db.Put(0x3274BDAF, solid(color.White)); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Put(0x12345678, solid(color.Black)); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, e := range db.Entries() {
+		fmt.Printf("%08X: %d bytes\n", e.Signature, len(e.Data))
+	}
+	// Output:
+	// 12345678: 25600 bytes
+	// 3274BDAF: 25600 bytes
+}
+
+// Writing a database out & opening it again
+func ExampleOpen() {
+	dir, err := os.MkdirTemp("", "labelsdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "labels.db")
+
+	db := labelsdb.New()
+	if err := db.Put(0x3274BDAF, solid(color.White)); err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.WriteTo(f); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err = labelsdb.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d entry, %d bytes: %08X\n", db.Len(), db.Size(), db.Signatures())
+	// Output:
+	// 1 entry, 42240 bytes: [3274BDAF]
+}
+
+// Replacing one entry & removing another, then comparing the result with the original
+func ExampleCompare() {
+	before := labelsdb.New()
+	before.Put(0x3274BDAF, solid(color.White))
+	before.Put(0x12345678, solid(color.White))
+
+	after := before.Clone()
+	after.Put(0x3274BDAF, solid(color.Black))
+	after.Remove(0x12345678)
+
+	d := labelsdb.Compare(before, after)
+	fmt.Printf("changed %08X, removed %08X\n", d.Changed, d.Removed)
+	// Output:
+	// changed [3274BDAF], removed [12345678]
+}
+
+// Extracting an entry's art as a PNG
+func ExampleDB_Image() {
+	db := labelsdb.New()
+	db.Put(0x3274BDAF, solid(color.NRGBA{R: 0xFF, A: 0xFF}))
+
+	img, ok := db.Image(0x3274BDAF)
+	if !ok {
+		log.Fatal("no entry for 3274BDAF")
+	}
+	fmt.Println(img.Bounds().Size(), img.NRGBAAt(0, 0))
+
+	f, err := os.CreateTemp("", "3274BDAF*.png")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// (74,86) {255 0 0 255}
+}
+
+// Serving each entry's art over HTTP, for a web frontend. A database is only read once & can then be shared by any
+// number of requests, as long as nothing changes it in the meantime.
+func Example_serve() {
+	db, err := labelsdb.Open("labels.db")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("GET /labels/{sig}", func(w http.ResponseWriter, r *http.Request) {
+		sig, err := strconv.ParseUint(r.PathValue("sig"), 16, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		img, ok := db.Image(uint32(sig))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+	})
+	log.Fatal(http.ListenAndServe("localhost:8080", nil))
+}
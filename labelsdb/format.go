@@ -0,0 +1,145 @@
+package labelsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	Height = 86
+	Width  = 74
+	// PaddingSize is the size in bytes of padding added to the end of every image entry to make it the correct size
+	PaddingSize = 0x90
+	// EntrySize is the size in bytes of each image entry: the BGRA pixels followed by the padding
+	EntrySize = (Height * Width * 4) + PaddingSize
+
+	// IndexStart is the location in the file where the index of cartridge signatures begins
+	IndexStart = 0x100
+	// IndexEOF is the word that indicates there are no more cartridges in the index
+	IndexEOF uint32 = 0xFFFFFFFF
+	// ImagesStart is the location in the file where the first image begins
+	ImagesStart = 0x4100
+	// MaxEntries is the number of signatures that fit in the index, leaving room for the EOF word
+	MaxEntries = (ImagesStart-IndexStart)/4 - 1
+
+	// MaxFileSize is the largest file FAT32, the filesystem the console's SD card uses, can hold. A full database is
+	// nowhere near it, so exceeding it means something has gone badly wrong.
+	MaxFileSize = 1<<32 - 1
+
+	// HeaderIDLength is the number of bytes at the start of the header that identify the file as a labels.db
+	HeaderIDLength = 0x40
+	// HeaderVersion is the location of the format version in the header
+	HeaderVersion = 0x42
+
+	// Header is what a labels.db starts with. It's written out when creating a new file, & the start of it is used to
+	// check that a file actually is a labels.db.
+	Header = "\aAnalogue-Co\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000Analogue-3D.labels\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0002\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000"
+)
+
+var (
+	// ErrNotLabelsDB is returned when a file doesn't have the structure of a labels.db
+	ErrNotLabelsDB = errors.New("not a labels.db")
+	// ErrFull is returned when adding an entry to a database whose index has no free slots
+	ErrFull = errors.New("database is full")
+)
+
+// Check makes sure r looks like a labels.db: it must start with the expected header & be at least big enough to hold
+// the header & index. Without this, pointing a tool at the wrong file would have it happily write an index into the
+// middle of it.
+func Check(r io.ReadSeeker) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size < ImagesStart {
+		return fmt.Errorf("%w: file is only %d bytes, but the header & index alone take %d", ErrNotLabelsDB, size, ImagesStart)
+	}
+
+	b := make([]byte, HeaderIDLength)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if !bytes.Equal(b, []byte(Header[:HeaderIDLength])) {
+		return fmt.Errorf("%w: header doesn't match", ErrNotLabelsDB)
+	}
+	return nil
+}
+
+// ReadIndex reads just the signatures from the index, stopping at the EOF marker. It's much cheaper than reading the
+// whole database when the images aren't needed.
+func ReadIndex(r io.ReadSeeker) ([]uint32, error) {
+	if err := Check(r); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(IndexStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	index := make([]byte, ImagesStart-IndexStart)
+	if _, err := io.ReadFull(r, index); err != nil {
+		return nil, err
+	}
+	return parseIndex(index), nil
+}
+
+// ReadIndexJunk reads the index & returns the number of slots after the EOF marker that aren't blank. See
+// DB.IndexJunk.
+func ReadIndexJunk(r io.ReadSeeker) (int, error) {
+	if err := Check(r); err != nil {
+		return 0, err
+	}
+	if _, err := r.Seek(IndexStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+	index := make([]byte, ImagesStart-IndexStart)
+	if _, err := io.ReadFull(r, index); err != nil {
+		return 0, err
+	}
+	return countJunk(index, len(parseIndex(index))), nil
+}
+
+// countJunk returns the number of slots after the EOF marker in a raw index block that aren't blank. n is the number
+// of signatures before the marker.
+func countJunk(index []byte, n int) int {
+	junk := 0
+	for i := (n + 1) * 4; i+4 <= len(index); i += 4 {
+		if binary.LittleEndian.Uint32(index[i:]) != IndexEOF {
+			junk++
+		}
+	}
+	return junk
+}
+
+// parseIndex returns the signatures in a raw index block, up to the EOF marker
+func parseIndex(index []byte) []uint32 {
+	sigs := make([]uint32, 0)
+	// 32 bit words, so the index size must be divided by 4 to give the number of possible entries
+	for i := 0; i+4 <= len(index); i += 4 {
+		sig := binary.LittleEndian.Uint32(index[i:])
+		if sig == IndexEOF {
+			break
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// Create writes a new, empty labels.db to path. It fails if path already exists.
+func Create(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := New().WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,320 @@
+package labelsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// testPadding is a non-stock padding pattern, so it's obvious when an entry's padding has been replaced
+var testPadding = RepeatPadding([]byte{0xA3, 0xD0})
+
+// entry returns an entry whose pixels are all the byte b, with the given padding
+func entry(b byte, padding []byte) []byte {
+	return append(bytes.Repeat([]byte{b}, EntrySize-PaddingSize), padding...)
+}
+
+// smallDB returns a database holding three entries, added out of order, that all use testPadding
+func smallDB(t *testing.T) *DB {
+	t.Helper()
+	db := New()
+	for _, s := range []uint32{0x3274BDAF, 0x00C0FFEE, 0x12345678} {
+		if err := db.PutEntry(s, entry(byte(s), testPadding)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// encode returns db as it would be written to disk
+func encode(t *testing.T, db *DB) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := db.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != db.Size() || int64(buf.Len()) != n {
+		t.Fatalf("WriteTo reported %d bytes & wrote %d, but Size is %d", n, buf.Len(), db.Size())
+	}
+	return buf.Bytes()
+}
+
+// index returns the raw index words of an encoded database
+func index(b []byte) []uint32 {
+	var words []uint32
+	for off := IndexStart; off < ImagesStart; off += 4 {
+		words = append(words, binary.LittleEndian.Uint32(b[off:]))
+	}
+	return words
+}
+
+// TestRoundTrip checks that a database read back from what WriteTo wrote has the same entries & writes out byte for
+// byte the same
+func TestRoundTrip(t *testing.T) {
+	db := smallDB(t)
+	b := encode(t, db)
+
+	got, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.Signatures(), db.Signatures()) {
+		t.Fatalf("signatures = %08X, want %08X", got.Signatures(), db.Signatures())
+	}
+	for _, e := range db.Entries() {
+		data, ok := got.Entry(e.Signature)
+		if !ok || !bytes.Equal(data, e.Data) {
+			t.Errorf("entry %08X didn't survive the round trip", e.Signature)
+		}
+	}
+	if !bytes.Equal(encode(t, got), b) {
+		t.Error("writing the database back out changed it")
+	}
+}
+
+// TestPutEntry checks that the index stays sorted whatever order entries are added in, that it ends with the EOF
+// marker, & that bad entries are turned away
+func TestPutEntry(t *testing.T) {
+	db := smallDB(t)
+	want := []uint32{0x00C0FFEE, 0x12345678, 0x3274BDAF}
+	if !slices.Equal(db.Signatures(), want) {
+		t.Fatalf("signatures = %08X, want %08X", db.Signatures(), want)
+	}
+
+	// Replacing an entry mustn't add a second copy of its signature
+	if err := db.PutEntry(0x12345678, entry(0x77, testPadding)); err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 3 {
+		t.Fatalf("replacing an entry left %d entries, want 3", db.Len())
+	}
+	if data, _ := db.Entry(0x12345678); data[0] != 0x77 {
+		t.Error("replacing an entry kept the old data")
+	}
+
+	words := index(encode(t, db))
+	if !slices.Equal(words[:3], want) {
+		t.Errorf("written index = %08X, want %08X", words[:3], want)
+	}
+	for i, w := range words[3:] {
+		if w != IndexEOF {
+			t.Fatalf("index slot %d = %08X, want the EOF marker & blank slots after the last signature", i+3, w)
+		}
+	}
+
+	if err := db.PutEntry(IndexEOF, entry(0, testPadding)); err == nil {
+		t.Error("the EOF marker was accepted as a signature")
+	}
+	if err := db.PutEntry(0x01, make([]byte, EntrySize-1)); err == nil {
+		t.Error("a short entry was accepted")
+	}
+}
+
+// TestPutEntryFull checks that the index refuses entries once every slot is used
+func TestPutEntryFull(t *testing.T) {
+	db := New()
+	e := entry(0, testPadding)
+	for s := range uint32(MaxEntries) {
+		if err := db.PutEntry(s, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutEntry(MaxEntries, e); !errors.Is(err, ErrFull) {
+		t.Errorf("adding to a full database returned %v, want ErrFull", err)
+	}
+	// Replacing an existing entry still works
+	if err := db.PutEntry(0, e); err != nil {
+		t.Errorf("replacing an entry in a full database failed: %v", err)
+	}
+}
+
+// TestRemove checks that removing an entry from a database read from disk moves the EOF marker up without leaving the
+// old last signature behind it
+func TestRemove(t *testing.T) {
+	db, err := Read(bytes.NewReader(encode(t, smallDB(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.Remove(0x3274BDAF) {
+		t.Fatal("Remove didn't find 3274BDAF")
+	}
+	if db.Remove(0x3274BDAF) {
+		t.Error("removing an entry twice reported success")
+	}
+	if db.Contains(0x3274BDAF) {
+		t.Error("the removed entry is still there")
+	}
+	if db.IndexJunk() != 0 {
+		t.Errorf("IndexJunk = %d after Remove, want 0", db.IndexJunk())
+	}
+
+	b := encode(t, db)
+	if int64(len(b)) != ImagesStart+2*EntrySize {
+		t.Errorf("file is %d bytes, want %d", len(b), ImagesStart+2*EntrySize)
+	}
+	words := index(b)
+	if want := []uint32{0x00C0FFEE, 0x12345678, IndexEOF, IndexEOF}; !slices.Equal(words[:4], want) {
+		t.Errorf("written index starts %08X, want %08X", words[:4], want)
+	}
+}
+
+// TestIndexJunk checks that anything after the EOF marker is ignored when reading but kept when writing, until it's
+// cleared
+func TestIndexJunk(t *testing.T) {
+	b := encode(t, smallDB(t))
+	binary.LittleEndian.PutUint32(b[IndexStart+5*4:], 0xDEADBEEF)
+
+	db, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 3 {
+		t.Errorf("read %d entries, want 3: the signature after the EOF marker should be ignored", db.Len())
+	}
+	if db.IndexJunk() != 1 {
+		t.Errorf("IndexJunk = %d, want 1", db.IndexJunk())
+	}
+	if !bytes.Equal(encode(t, db), b) {
+		t.Error("the junk after the EOF marker wasn't written back as it was")
+	}
+
+	db.ClearIndexJunk()
+	if n, err := ReadIndexJunk(bytes.NewReader(encode(t, db))); err != nil || n != 0 {
+		t.Errorf("ReadIndexJunk after ClearIndexJunk = %d, %v, want 0", n, err)
+	}
+}
+
+// TestTruncated checks that Read refuses a file that ends partway through an entry, while Salvage blanks the entry
+func TestTruncated(t *testing.T) {
+	b := encode(t, smallDB(t))
+	b = b[:len(b)-EntrySize/2]
+
+	if _, err := Read(bytes.NewReader(b)); err == nil {
+		t.Fatal("a truncated file was read without error")
+	} else if errors.Is(err, ErrNotLabelsDB) {
+		t.Errorf("a truncated file was reported as not being a labels.db: %v", err)
+	}
+
+	db, lost, err := Salvage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(lost, []uint32{0x3274BDAF}) {
+		t.Errorf("lost = %08X, want [3274BDAF]", lost)
+	}
+	if data, ok := db.Entry(0x3274BDAF); !ok || !IsBlank(data) {
+		t.Error("the truncated entry wasn't replaced with a blank one")
+	}
+	if data, _ := db.Entry(0x12345678); data[0] != 0x78 {
+		t.Error("an entry before the truncation was changed")
+	}
+}
+
+// TestSalvageCorruptIndex checks that Salvage copes with an index that lists more entries than the file holds, as
+// happens when the EOF marker is overwritten
+func TestSalvageCorruptIndex(t *testing.T) {
+	b := encode(t, smallDB(t))
+	binary.LittleEndian.PutUint32(b[IndexStart+3*4:], 0xDEADBEEF)
+
+	if _, err := Read(bytes.NewReader(b)); err == nil {
+		t.Fatal("Read accepted an index listing an entry that isn't in the file")
+	}
+	db, lost, err := Salvage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(lost, []uint32{0xDEADBEEF}) {
+		t.Errorf("lost = %08X, want [DEADBEEF]", lost)
+	}
+	if db.Len() != 4 {
+		t.Errorf("salvaged %d entries, want 4", db.Len())
+	}
+	if err := db.Validate(); err != nil {
+		t.Errorf("the salvaged database can't be written: %v", err)
+	}
+}
+
+// TestCheck checks that files too short to hold the index, or without the labels.db header, are turned away
+func TestCheck(t *testing.T) {
+	good := encode(t, New())
+	if err := Check(bytes.NewReader(good)); err != nil {
+		t.Fatalf("an empty database failed Check: %v", err)
+	}
+
+	notDB := bytes.Clone(good)
+	copy(notDB, "\x89PNG\r\n\x1a\n")
+	tests := map[string][]byte{
+		"empty":       nil,
+		"short":       good[:ImagesStart-1],
+		"header only": good[:IndexStart],
+		"wrong magic": notDB,
+	}
+	for name, b := range tests {
+		if err := Check(bytes.NewReader(b)); !errors.Is(err, ErrNotLabelsDB) {
+			t.Errorf("%s: Check returned %v, want ErrNotLabelsDB", name, err)
+		}
+		if _, err := Read(bytes.NewReader(b)); !errors.Is(err, ErrNotLabelsDB) {
+			t.Errorf("%s: Read returned %v, want ErrNotLabelsDB", name, err)
+		}
+	}
+}
+
+// TestPadding checks that each entry's padding is kept through a round trip & that images added with Put pick up the
+// padding the existing entries use rather than the stock one
+func TestPadding(t *testing.T) {
+	db := smallDB(t)
+	if err := db.PutEntry(0x01, entry(0x01, DefaultPadding())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(db.Padding(), testPadding) {
+		t.Fatalf("Padding = % X, want the most common one, % X", db.Padding()[:4], testPadding[:4])
+	}
+
+	white := image.NewNRGBA(image.Rect(0, 0, Width, Height))
+	for i := range white.Pix {
+		white.Pix[i] = 0xFF
+	}
+	white.Set(0, 0, color.Black)
+	if err := db.Put(0x02, white); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Read(bytes.NewReader(encode(t, db)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range got.Entries() {
+		want := testPadding
+		if e.Signature == 0x01 {
+			want = DefaultPadding()
+		}
+		if !bytes.Equal(PaddingOf(e.Data), want) {
+			t.Errorf("entry %08X has padding % X…, want % X…", e.Signature, PaddingOf(e.Data)[:4], want[:4])
+		}
+	}
+	img, _ := got.Image(0x02)
+	if img.NRGBAAt(0, 0) != (color.NRGBA{A: 0xFF}) || img.NRGBAAt(1, 0) != (color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Error("the image added with Put didn't survive the round trip")
+	}
+}
+
+// TestNew checks that a new database is just the header & an empty index
+func TestNew(t *testing.T) {
+	b := encode(t, New())
+	if len(b) != ImagesStart {
+		t.Fatalf("new database is %d bytes, want %d", len(b), ImagesStart)
+	}
+	if !bytes.Equal(b[:len(Header)], []byte(Header)) {
+		t.Error("new database doesn't start with the stock header")
+	}
+	for i, w := range index(b) {
+		if w != IndexEOF {
+			t.Fatalf("index slot %d = %08X, want it blank", i, w)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package labelsdb
+
+import (
+	"bytes"
+	"iter"
+	"strings"
+)
+
+// Filter selects the entries Query returns. Every field that's set has to match, so the zero Filter matches everything.
+type Filter struct {
+	// Region keeps entries whose game is from this region, ignoring case. It needs Meta.
+	Region string
+	// TitleSubstring keeps entries whose game's title contains this, ignoring case. It needs Meta.
+	TitleSubstring string
+	// CustomOnly keeps entries whose art isn't the same as Stock's for the signature, ignoring the padding. Without
+	// Stock, every entry counts as custom.
+	CustomOnly bool
+	// HasBlankArt keeps entries whose art is a single flat colour. See IsBlank.
+	HasBlankArt bool
+
+	// Meta looks up the title & region of the game with sig, which labels.db doesn't hold. Without it, a filter on
+	// Region or TitleSubstring matches nothing.
+	Meta func(sig uint32) (title, region string)
+	// Stock is the stock database CustomOnly compares against. May be nil.
+	Stock *DB
+}
+
+// Query returns an iterator over the entries matching f, in index order. Entries are only checked as the iteration
+// reaches them, cheapest test first, & the art is never decoded, so a frontend can stop after a page of results without
+// paying for the rest. The data is shared with the database, so it mustn't be modified, & the database mustn't be
+// changed while iterating.
+func (db *DB) Query(f Filter) iter.Seq[Entry] {
+	region, title := strings.ToLower(f.Region), strings.ToLower(f.TitleSubstring)
+	return func(yield func(Entry) bool) {
+		for i, sig := range db.sigs {
+			if region != "" || title != "" {
+				if f.Meta == nil {
+					return
+				}
+				t, r := f.Meta(sig)
+				if (region != "" && strings.ToLower(r) != region) || !strings.Contains(strings.ToLower(t), title) {
+					continue
+				}
+			}
+			data := db.data[i]
+			if f.CustomOnly && f.Stock != nil {
+				if s, ok := f.Stock.Entry(sig); ok && bytes.Equal(s[:EntrySize-PaddingSize], data[:EntrySize-PaddingSize]) {
+					continue
+				}
+			}
+			if f.HasBlankArt && !IsBlank(data) {
+				continue
+			}
+			if !yield(Entry{sig, data}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package labelsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Problem is something Verify found wrong with a labels.db
+type Problem struct {
+	// Fatal is set for problems that are likely to stop the console reading the file properly. The rest are oddities it
+	// should ignore, but that are worth knowing about.
+	Fatal bool
+	Msg   string
+}
+
+func (p Problem) String() string {
+	if p.Fatal {
+		return "error: " + p.Msg
+	}
+	return "warning: " + p.Msg
+}
+
+// Verify checks the structure of a labels.db without stopping at the first problem: the header, that the index is
+// sorted, unique & terminated, that there's exactly one entry per signature, & that the entries are padded
+// consistently. It returns the number of signatures in the index along with the problems found. An error is only
+// returned if r couldn't be read.
+func Verify(r io.ReadSeeker) (int, []Problem, error) {
+	var problems []Problem
+	fail := func(format string, a ...any) {
+		problems = append(problems, Problem{Fatal: true, Msg: fmt.Sprintf(format, a...)})
+	}
+	warn := func(format string, a ...any) {
+		problems = append(problems, Problem{Msg: fmt.Sprintf(format, a...)})
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size < ImagesStart {
+		fail("file is only %d bytes, but the header & index alone take %d", size, ImagesStart)
+		return 0, problems, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, nil, err
+	}
+	header := make([]byte, ImagesStart)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	if !bytes.Equal(header[:HeaderIDLength], []byte(Header[:HeaderIDLength])) {
+		fail("header doesn't match: this doesn't look like a labels.db")
+	}
+	if v := header[HeaderVersion]; v != Header[HeaderVersion] {
+		warn("format version is %d; this tool knows version %d", v, Header[HeaderVersion])
+	}
+
+	// Unlike parseIndex, this carries on past anything odd so that every problem is reported
+	index := header[IndexStart:]
+	n := -1
+	seen := make(map[uint32]int)
+	for i := 0; i+4 <= len(index); i += 4 {
+		sig := binary.LittleEndian.Uint32(index[i:])
+		if sig == IndexEOF {
+			n = i / 4
+			break
+		}
+		slot := i / 4
+		if first, ok := seen[sig]; ok {
+			fail("signature %08X is in slot %d & again in slot %d", sig, first, slot)
+		} else {
+			seen[sig] = slot
+		}
+		if slot > 0 {
+			if prev := binary.LittleEndian.Uint32(index[i-4:]); sig < prev {
+				fail("signature %08X in slot %d is out of order: it comes after %08X", sig, slot, prev)
+			}
+		}
+	}
+	if n < 0 {
+		fail("the index has no EOF marker")
+		n = len(index) / 4
+	} else if junk := countJunk(index, n); junk > 0 {
+		warn("%d index slots after the EOF marker aren't blank; they're never read, but can be cleared with -clean-index", junk)
+	}
+
+	if want := ImagesStart + int64(n)*EntrySize; size < want {
+		have := (size - ImagesStart) / EntrySize
+		fail("file is truncated: the index lists %d entries, but there's only room for %d (%d bytes short)", n, have, want-size)
+		n = int(have)
+	} else if size > want {
+		extra := size - want
+		if extra%EntrySize == 0 {
+			warn("there are %d more entries than the index lists; they'll never be shown", extra/EntrySize)
+		} else {
+			warn("there are %d bytes after the last entry", extra)
+		}
+	}
+
+	// Padding that differs between entries doesn't bother the console, but it's often a sign of an entry written by a
+	// different tool or a damaged one
+	paddings := make(map[string][]uint32)
+	entry := make([]byte, EntrySize)
+	for i := range n {
+		if _, err := io.ReadFull(r, entry); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return 0, nil, err
+		}
+		sig := binary.LittleEndian.Uint32(index[i*4:])
+		p := string(PaddingOf(entry))
+		paddings[p] = append(paddings[p], sig)
+	}
+	if len(paddings) > 1 {
+		common := ""
+		for p, sigs := range paddings {
+			if len(sigs) > len(paddings[common]) || len(sigs) == len(paddings[common]) && p < common {
+				common = p
+			}
+		}
+		for p, sigs := range paddings {
+			if p != common {
+				warn("%d entries are padded differently to the other %d: %08X", len(sigs), len(paddings[common]), sigs)
+			}
+		}
+	}
+
+	return n, problems, nil
+}
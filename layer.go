@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// dirFlag collects the art directories given with -dir, in the order they're layered. It can be repeated.
+type dirFlag []string
+
+func (d *dirFlag) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *dirFlag) Set(v string) error {
+	if fi, err := os.Stat(v); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s isn't a directory", v)
+	}
+	*d = append(*d, v)
+	return nil
+}
+
+// layerDirs gathers the images in each art directory, found the same way watch finds them, & layers them so that each
+// directory overrides the ones before it: a base pack, then regional overrides, then personal tweaks, say. Duplicates
+// within one directory are settled first, by manifest priority & then order, & the winners' priorities are then reset
+// so that a directory's place in the layering always decides between it & the others.
+func layerDirs(dirs []string) ([]Image, error) {
+	imgs := make([]Image, 0)
+	for _, dir := range dirs {
+		items, err := scanWatchDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		layer := make([]Image, len(items))
+		for i, it := range items {
+			layer[i] = it.Image
+		}
+		layer = dedupeImages(layer)
+		for i := range layer {
+			layer[i].Priority = 0
+		}
+		log.Printf("Layering %s images from %s", formatCount(len(layer)), dir)
+		imgs = append(imgs, layer...)
+	}
+	return imgs, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// listEntry is a signature printed by list -json
+type listEntry struct {
+	Slot       int    `json:"slot"`
+	Signature  hexSig `json:"signature"`
+	Offset     int    `json:"offset"`
+	Title      string `json:"title,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Confidence string `json:"confidence,omitempty"`
+}
+
+// list implements `list {labels.db} [-roms roms.idx] [-dat file] [-titles file] [-details] [-json]`, printing every signature
+// in the index along with where its entry is, & its title if one can be found. See loadTitles.
+func list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	details := fs.Bool("details", false, "also show where each title came from & how far it can be trusted")
+	asJSON := fs.Bool("json", false, "print the list as JSON")
+	var filter labelsdb.Filter
+	fs.StringVar(&filter.TitleSubstring, "title", "", "only list games whose title contains this")
+	fs.StringVar(&filter.Region, "region", "", "only list games from this region, such as Japan or Europe (needs -roms)")
+	stock := fs.String("custom", "", "only list entries whose art differs from this stock labels.db")
+	fs.BoolVar(&filter.HasBlankArt, "blank", false, "only list entries whose art is blank")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || (*dat != "" && *roms == "") || (filter.Region != "" && *roms == "") {
+		return errors.New("usage: list {labels.db} [-roms roms.idx [-dat file]] [-titles file] [-details] [-raw] [-json] " +
+			"[-title text] [-region name] [-custom stock.db] [-blank]")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	index, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		return err
+	}
+	sigs := make([]slotSig, len(index))
+	for i, sig := range index {
+		sigs[i] = slotSig{i, sig}
+	}
+
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+	// Filtering needs the entries themselves, so the whole database is only read when a filter is given
+	if filter.TitleSubstring != "" || filter.Region != "" || filter.HasBlankArt || *stock != "" {
+		if sigs, err = queryList(args[0], sigs, titles, *roms, *stock, filter); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		entries := make([]listEntry, len(sigs))
+		for i, s := range sigs {
+			t := titles[s.sig]
+			entries[i] = listEntry{Slot: s.slot, Signature: hexSig(s.sig),
+				Offset: labelsdb.ImagesStart + s.slot*labelsdb.EntrySize, Title: t.Title, Source: t.Source,
+				Confidence: t.Confidence}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	headers := []string{"Slot", "Signature", "Offset", "Size", "Title"}
+	if *details {
+		headers = append(headers, "Source", "Confidence")
+	}
+	t := newTable(os.Stdout, headers...)
+	for _, s := range sigs {
+		title := titles[s.sig]
+		row := []string{fmt.Sprint(s.slot), fmt.Sprintf("%08X", s.sig),
+			fmt.Sprintf("0x%06X", labelsdb.ImagesStart+s.slot*labelsdb.EntrySize), formatCount(labelsdb.EntrySize),
+			title.Title}
+		if *details {
+			row = append(row, title.Source, title.Confidence)
+		}
+		t.row(row...)
+	}
+	return t.flush()
+}
+
+// slotSig is a signature along with its slot in the index
+type slotSig struct {
+	slot int
+	sig  uint32
+}
+
+// queryList narrows sigs down to the entries in the database at path that match filter. The titles & the regions in
+// the ROM index at roms, if there is one, are what the title & region filters are checked against. If stock isn't
+// empty, only entries that differ from the ones in that database are kept.
+func queryList(path string, sigs []slotSig, titles titleLookup, roms, stock string, filter labelsdb.Filter) (
+	[]slotSig, error) {
+	db, err := labelsdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	regions := make(map[uint32]string)
+	if roms != "" {
+		idx, err := loadROMIndex(roms)
+		if err != nil {
+			return nil, err
+		}
+		regions = idx.Regions()
+	}
+	filter.Meta = func(sig uint32) (string, string) { return titles[sig].Title, regions[sig] }
+	if stock != "" {
+		filter.CustomOnly = true
+		if filter.Stock, err = labelsdb.Open(stock); err != nil {
+			return nil, err
+		}
+	}
+
+	slots := make(map[uint32]int, len(sigs))
+	for _, s := range sigs {
+		slots[s.sig] = s.slot
+	}
+	matched := make([]slotSig, 0)
+	for e := range db.Query(filter) {
+		matched = append(matched, slotSig{slots[e.Signature], e.Signature})
+	}
+	return matched, nil
+}
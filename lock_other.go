@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing on this platform. The store's files are still placed atomically, so the worst parallel runs
+// can do is convert the same image twice.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing on this platform
+func unlockFile(f *os.File) error {
+	return nil
+}
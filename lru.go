@@ -0,0 +1,50 @@
+package main
+
+// Imported under another name since list is also a command
+import linked "container/list"
+
+// lru is a fixed size cache that evicts the least recently used value once it's full. It isn't safe for concurrent use.
+type lru[K comparable, V any] struct {
+	size  int
+	order *linked.List
+	items map[K]*linked.Element
+}
+
+// lruItem is what's kept in each element of an lru's list
+type lruItem[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// newLRU returns an lru that holds up to size values. A size below 1 disables caching.
+func newLRU[K comparable, V any](size int) *lru[K, V] {
+	return &lru[K, V]{size: size, order: linked.New(), items: make(map[K]*linked.Element)}
+}
+
+// get returns the value for key & marks it as the most recently used
+func (c *lru[K, V]) get(key K) (V, bool) {
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(lruItem[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// put adds or replaces the value for key, evicting the least recently used value if the cache is full
+func (c *lru[K, V]) put(key K, value V) {
+	if c.size < 1 {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value = lruItem[K, V]{key, value}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(lruItem[K, V]{key, value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(lruItem[K, V]).key)
+	}
+}
@@ -3,15 +3,21 @@ package main
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
+	"io"
+	"io/fs"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 
-	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
 )
 
 // Image is a simple struct used to store the custom images being added
@@ -21,115 +27,406 @@ type Image struct {
 	// Signature is the cartridge signature. It can be found via the library or by running a CRC32 on the first 8KiB of a
 	// native encoding (big endian) .z64 ROM file.
 	Signature uint32
+	// Priority settles which image is used when more than one is given for the same signature. See dedupeImages.
+	Priority int
+	// Title is the title a manifest gives the image, or "" if it doesn't give one
+	Title string
+	// Sidecar is the JSON file of overrides for this image alone, or "" if it hasn't got one. See imageOverrides.
+	Sidecar string
 }
 
 const (
-	height = 86
-	width  = 74
-	// imgPadding is the size in bytes of padding added to the end of every image entry to make it the correct size
-	imgPadding = 0x90
-	entrySize  = (height * width * 4) + imgPadding
-
-	// indexStart is the location in the file where the index of cartridge signatures begins
-	indexStart = 0x100
-	// indexEOF is the word that indicates there are no more cartridges in the index
-	indexEOF uint32 = 0xFFFFFFFF
-	// imgsStart is the location in the file where the first image begins
-	imgsStart = 0x4100
-
-	// header is not currently used since I'm modifying the file in place. But it's here for potential future use
-	header = "\aAnalogue-Co\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000Analogue-3D.labels\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0002\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000"
+	// defaultMaxImageBytes & defaultMaxImageDimension are the default limits on source images. Label art is tiny, so
+	// anything beyond these is either a mistake or a decompression bomb.
+	defaultMaxImageBytes     = 64 << 20
+	defaultMaxImageDimension = 10000
 )
 
+var (
+	// maxImageBytes & maxImageDimension limit the size of the source images that will be decoded. 0 means no limit.
+	maxImageBytes     int64 = defaultMaxImageBytes
+	maxImageDimension       = defaultMaxImageDimension
+
+	errImageTooLarge = errors.New("image exceeds size limits")
+
+	// verbose is set by -verbose & turns on debugf
+	verbose bool
+)
+
+// debugf logs only when -verbose is set
+func debugf(format string, v ...any) {
+	if verbose {
+		log.Printf(format, v...)
+	}
+}
+
+// command is a subcommand: the function that runs it & a one line summary for the help
+type command struct {
+	run     func(args []string) error
+	summary string
+}
+
+// commands maps each subcommand to the function that runs it. Anything that isn't listed here is treated as arguments
+// to add, so the original `{labels.db} {image files}` invocation still works.
+var commands = map[string]command{
+	"add":            {add, "add or replace images in a labels.db"},
+	"alias":          {alias, "export, import & merge tables of signatures that share a label"},
+	"post-update":    {postUpdate, "report what a firmware update changed in labels.db"},
+	"apply":          {apply, "add a label pack by matching a ROM folder against an art folder"},
+	"diff":           {diff, "list the entries added, replaced & removed between two databases"},
+	"reapply":        {reapply, "copy custom entries into the labels.db a firmware update installed"},
+	"restore":        {restore, "revert journaled writes or put signatures back as they were"},
+	"merge":          {merge, "combine several databases into one"},
+	"mockup":         {mockup, "draw labels as cartridges in a mockup of the console's library"},
+	"migrate":        {migrate, "add a collection of art laid out for another frontend"},
+	"index-roms":     {indexROMsCmd, "index the signatures of a folder of ROMs"},
+	"dump":           {dump, "copy a raw byte range out of a database"},
+	"inject":         {inject, "overwrite a raw byte range in a database"},
+	"stats":          {stats, "summarise what a database holds"},
+	"sync-state":     {syncStateCmd, "make a database's custom entries match a directory of images"},
+	"verify":         {verify, "check a database's structure"},
+	"selftest":       {selftest, "run the tool against a synthetic database"},
+	"create":         {create, "make a new empty database"},
+	"remove":         {remove, "delete entries"},
+	"extract":        {extract, "write entries out as PNGs"},
+	"export-bundle":  {exportBundle, "write entries out as a PNG & manifest bundle"},
+	"import-bundle":  {importBundle, "add the entries from a bundle"},
+	"curate":         {curate, "step through suspect entries interactively"},
+	"deploy":         {deploy, "add images to the labels.db on a mounted SD card"},
+	"browse":         {browse, "look through a database in a terminal UI"},
+	"preview":        {preview, "render labels as a contact sheet"},
+	"print":          {printSheet, "lay labels out on a PDF at their printed size, with cut marks"},
+	"pack":           {pack, "check the licenses in a label pack & write its attribution, or apply one"},
+	"quick":          {quick, "fix the label for one game by answering three questions"},
+	"profile":        {runProfile, "write one collection of art to several image stores"},
+	"calibration":    {calibration, "write the calibration chart or save a colour profile"},
+	"spec":           {spec, "print the labels.db format description"},
+	"download":       {download, "download the images from a shared cloud folder"},
+	"fetch":          {fetchCmd, "fetch labels from libretro thumbnails"},
+	"find-similar":   {findSimilar, "find the entries whose art looks like an image"},
+	"identify":       {identify, "suggest the title & signature of a cartridge from a photo of its label"},
+	"match":          {match, "report whether signatures are in a database"},
+	"watch":          {watch, "add images from a directory whenever they change"},
+	"why":            {why, "explain how the console finds a ROM's label"},
+	"collisions":     {collisions, "list, find & import signatures shared by more than one game"},
+	"upgrade-pack":   {upgradePack, "rebuild a pack made for an old firmware's stock labels.db on top of the new one"},
+	"spot-check":     {spotCheck, "pick a few changed labels at random to check on the console"},
+	"export-sqlite":  {exportSQLite, "write every entry to an SQLite database to query & edit with SQL"},
+	"import-sqlite":  {importSQLite, "rebuild labels.db from an SQLite database written by export-sqlite"},
+	"conformance":    {conformance, "check this build converts the reference images byte for byte the same as every other"},
+	"framing":        {framing, "remember how each signature's art is fitted to the label, for later adds to reuse"},
+	"index":          {indexCmd, "export just the signatures & entry hashes, or check or restore a database from them"},
+	"contains":       {contains, "print yes or no for each signature"},
+	"list":           {list, "list every signature in a database"},
+	"sig":            {sig, "print the label signature of ROMs"},
+	"set-eof":        {setEOF, "write the EOF marker into an index slot"},
+	"truncate-index": {truncateIndex, "keep only the first entries of the index"},
+	"swap-entries":   {swapEntries, "swap two signatures in the index"},
+}
+
+// exitStatus can be returned by a command to exit with that status without printing anything, for commands whose exit
+// status is part of their output
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
 func main() {
-	args := os.Args[1:]
-	if len(args) < 2 {
-		log.Fatalf("usage: %s {labels.db} {image files}", os.Args[0])
+	// Errors get their own logger, so that they're still shown when -quiet discards the rest of the log
+	errLog := log.New(os.Stderr, "", log.LstdFlags)
+
+	// plain = "true" in the config file turns on -plain for every command, for anyone who always uses a screen reader
+	if cfg, err := loadConfig(); err == nil && len(cfg["plain"]) > 0 {
+		plainOutput, _ = strconv.ParseBool(cfg["plain"][0])
+	}
+
+	name, args := "add", os.Args[1:]
+	if len(args) == 0 || slices.Contains([]string{"help", "-h", "-help", "--help"}, args[0]) {
+		plainOutput = plainOutput || slices.Contains(args, "-plain") || slices.Contains(args, "--plain")
+		usage()
+		return
+	}
+	if _, ok := commands[args[0]]; ok {
+		name, args = args[0], args[1:]
+	}
+	handleSignals()
+	err := commands[name].run(args)
+	if stopProfiling != nil {
+		stopProfiling()
+	}
+	// Only finished writes are recorded, so the hooks still run for a command that wrote labels.db & then failed, such
+	// as add with some images that couldn't be loaded
+	if herr := runHooks(); herr != nil && err == nil {
+		err = herr
+	} else if herr != nil {
+		errLog.Print(herr)
 	}
-	labelsDB, err := filepath.Abs(os.Args[1])
 	if err != nil {
-		log.Fatal(err)
+		var status exitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		errLog.Print(err)
+		printRemedy(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of commands
+func usage() {
+	fmt.Printf("usage: %s {command} [flags] [args]\n", filepath.Base(os.Args[0]))
+	fmt.Printf("       %s [flags] {labels.db} {image files}  (the same as add)\n\nCommands:\n",
+		filepath.Base(os.Args[0]))
+	names := slices.Sorted(maps.Keys(commands))
+	for _, n := range names {
+		if plainOutput {
+			fmt.Printf("%s: %s\n", n, commands[n].summary)
+		} else {
+			fmt.Printf("  %-15s %s\n", n, commands[n].summary)
+		}
 	}
-	customImgs, err := generateListFromArgs(os.Args[2:])
+	fmt.Println("\nEvery command takes -help, -verbose, -quiet, -plain, -journal & -no-hooks. Run `{command} -help` for " +
+		"its flags.")
+}
+
+// addSettings controls how addImages converts & merges images
+type addSettings struct {
+	// Store is used to reuse earlier conversions. May be nil.
+	Store *entryStore
+	// Memo shares conversions with other writes in the same run. May be nil.
+	Memo *conversionMemo
+	// Padding is the padding for new entries. If nil, the padding used by the existing entries is copied.
+	Padding []byte
+	// PreservePadding keeps the padding of an entry being replaced instead of using Padding. Nothing is known to read
+	// the padding, but if the firmware does keep anything in it for some entries, replacing the art shouldn't lose it.
+	PreservePadding bool
+	// Colour is the calibration profile applied to every image
+	Colour colourProfile
+	// Scale controls how images are fitted to the label
+	Scale scaleOptions
+	// Framing overrides Scale for the signatures with framing of their own, remembered with the framing command
+	Framing map[uint32]scaleOptions
+	// Alpha controls the corners & transparency of every image
+	Alpha alphaOptions
+	// Stamps & Badges are drawn onto every image
+	Stamps []stamp
+	Badges []badge
+	// CleanIndex blanks anything left in the index after the EOF marker
+	CleanIndex bool
+	// DryRun prints what would change instead of writing it
+	DryRun bool
+	// ShadowDiff prints the byte ranges the write would change & asks before writing
+	ShadowDiff bool
+	// Salvage blanks entries that can't be read from labels.db instead of failing
+	Salvage bool
+	Policy  confirmPolicy
+	// Report records what happened to each image, for -json. May be nil.
+	Report *addReport
+	// QA checks each image against the thresholds in the config file before it's converted. May be nil.
+	QA *qaRun
+}
+
+// addImages adds or replaces the custom images in the labels.db at labelsDB. Images that can't be loaded are skipped &
+// counted rather than stopping the whole batch.
+func addImages(labelsDB string, customImgs []Image, settings addSettings) (int, error) {
+	db, err := openDB(labelsDB, settings.Salvage)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
+	customImgs = dedupeImages(customImgs)
 
-	f, err := os.OpenFile(labelsDB, os.O_RDWR, 777)
+	customImgs, err = checkReplacements(db, customImgs, &settings.Policy, settings.Report)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
+	}
+	warnCollisions(customImgs)
+	if err := checkCapacity(db, customImgs); err != nil {
+		return 0, err
 	}
-	defer f.Close()
 
-	sigs := make([]uint32, 0)
-	if _, err := f.Seek(indexStart, 0); err != nil {
-		log.Fatal(err)
+	opts := defaultConvertOptions()
+	opts.Colour = settings.Colour
+	opts.Scale = settings.Scale
+	opts.Alpha = settings.Alpha
+	opts.Stamps, opts.Badges = settings.Stamps, settings.Badges
+	opts.Padding = settings.Padding
+	if opts.Padding == nil {
+		opts.Padding = db.Padding()
 	}
 
-	// 32 bit words, so imgsStart - indexStart must be divided by 4 to give the number of possible entries
-	for i := 0; i < (imgsStart-indexStart)/4; i++ {
-		var sig uint32
-		if err := binary.Read(f, binary.LittleEndian, &sig); err != nil {
-			log.Fatal(err)
-		}
-		if sig == indexEOF {
-			break
+	orig := db.Clone()
+	skipped, err := buildNewDB(db, customImgs, settings, opts)
+	if err != nil {
+		return skipped, err
+	}
+	if settings.DryRun {
+		// With -json the report is the output, so the diff would only get in its way
+		if settings.Report != nil {
+			log.Printf("Dry run: %s was not modified.", labelsDB)
+			return skipped, nil
 		}
-		sigs = append(sigs, sig)
+		printDiff(labelsdb.Compare(orig, db), orig.Len(), db.Len())
+		fmt.Printf("\nDry run: %s was not modified.\n", labelsDB)
+		return skipped, nil
 	}
 
-	if _, err := f.Seek(imgsStart, 0); err != nil {
-		log.Fatal(err)
+	if n := db.IndexJunk(); n > 0 && settings.CleanIndex {
+		log.Printf("Blanking %s index slots after the EOF marker", formatCount(n))
+		db.ClearIndexJunk()
+	} else if n > 0 {
+		log.Printf("The index has %s non-blank slots after the EOF marker. They're ignored, but -clean-index will "+
+			"blank them.", formatCount(n))
 	}
-	imgs := make([][]byte, 0)
-	for range sigs {
-		px := make([]byte, entrySize)
-		if err := binary.Read(f, binary.BigEndian, &px); err != nil {
-			log.Fatal(err)
+
+	if settings.ShadowDiff {
+		// With -json the report is the output, so the diff goes to stderr along with the log
+		w := io.Writer(os.Stdout)
+		if settings.Report != nil {
+			w = os.Stderr
+		}
+		if ok, err := shadowDiff(labelsDB, db, &settings.Policy, w); !ok || err != nil {
+			return skipped, err
 		}
-		imgs = append(imgs, px)
 	}
 
-	sigs, imgs = buildNewDB(sigs, imgs, customImgs)
+	log.Printf("Writing %s images to %s", formatCount(db.Len()), labelsDB)
+	return skipped, saveDB(labelsDB, db)
+}
 
-	// Write out the new values in place
-	log.Printf("Writing %d images to %s", len(imgs), labelsDB)
-	if _, err := f.Seek(indexStart, 0); err != nil {
-		log.Fatal(err)
+// parseArgs parses args with fs, allowing flags to appear before, after, or in between the positional arguments.
+// The flag package normally stops at the first non-flag argument, which would make `cmd labels.db -flag` ignore the flag.
+//
+// It also adds the flags every command shares: -verbose, -quiet, -plain, -journal, -no-hooks & -profile, plus -output
+// as a longer name for -o wherever a command has one.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var quiet bool
+	if fs.Lookup("quiet") == nil {
+		fs.BoolVar(&quiet, "quiet", false, "only print errors & the command's own output")
 	}
-
-	if err := binary.Write(f, binary.LittleEndian, sigs); err != nil {
-		log.Fatal("sigs", err)
+	if fs.Lookup("verbose") == nil {
+		// Defaulting to the current value means a command run from inside another one doesn't reset it
+		fs.BoolVar(&verbose, "verbose", verbose, "log extra detail about what's being done")
 	}
-	if err := binary.Write(f, binary.LittleEndian, indexEOF); err != nil {
-		log.Fatal("eof", err)
+	if fs.Lookup("plain") == nil {
+		fs.BoolVar(&plainOutput, "plain", plainOutput,
+			"print reports one labelled fact per line, without columns or pictures, for screen readers")
 	}
-	if _, err := f.Seek(imgsStart, 0); err != nil {
-		log.Fatal(err)
+	if fs.Lookup("raw") == nil {
+		fs.BoolVar(&rawOutput, "raw", rawOutput,
+			"print counts & sizes in reports as plain numbers without separators or units")
 	}
-	for i := range imgs {
-		if err := binary.Write(f, binary.BigEndian, imgs[i]); err != nil {
-			log.Fatal(i, err)
+	if fs.Lookup("journal") == nil {
+		fs.BoolVar(&journalWrites, "journal", journalWrites,
+			"record the entries each write replaces or removes in labels.db.undo, for restore")
+	}
+	if fs.Lookup("no-hooks") == nil {
+		fs.BoolVar(&noHooks, "no-hooks", noHooks, "don't run the post_write hooks from the config file")
+	}
+	if fs.Lookup("profile") == nil {
+		fs.StringVar(&profileKind, "profile", profileKind,
+			"record a cpu, mem, or trace profile of the run for go tool pprof or go tool trace, to attach to a bug report")
+		fs.StringVar(&profileFile, "profile-file", profileFile,
+			"where to write the -profile (default a3dlabels.{kind}.pprof, or a3dlabels.trace)")
+	}
+	if fs.Lookup("timeout") == nil {
+		fs.DurationVar(&timeout, "timeout", timeout,
+			"cancel safely if the command is still running after this long, such as 10m (default no limit)")
+	}
+	if o := fs.Lookup("o"); o != nil && fs.Lookup("output") == nil {
+		fs.Var(o.Value, "output", "the same as -o")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s:\n", filepath.Base(os.Args[0]), fs.Name())
+		fs.PrintDefaults()
+	}
+
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
 		}
+		args = fs.Args()
+		if len(args) == 0 {
+			if quiet {
+				log.SetOutput(io.Discard)
+			}
+			startTimer()
+			return positional, startProfiling()
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
 }
 
-// generateListFromArgs takes the command line list of args & turns them into a slice of Image objects. It does not check
-// if the files exist.
+// generateListFromArgs takes the command line list of args & turns them into a slice of Image objects. Each image's
+// signature comes from one of four places:
+//   - a ROM file immediately before it, e.g. `game.z64 art.png`, in which case the signature is calculated from the ROM
+//   - the image's filename, which should be the signature in hex
+//   - if the arg is a directory, each ROM in it is paired with the image alongside it that has the same name
+//   - if the arg is a .csv or .json manifest, the signature listed alongside each image in it
+//
+// It does not check if the image files exist.
 func generateListFromArgs(args []string) ([]Image, error) {
 	imgs := make([]Image, 0)
-	for _, arg := range args {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if isRemote(arg) {
+			remote, err := remoteImages(arg)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, remote...)
+			continue
+		}
 		file, err := filepath.Abs(arg)
 		if err != nil {
 			return nil, err
 		}
+
+		if isManifest(arg) {
+			listed, err := loadManifest(file)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, listed...)
+			continue
+		}
+
+		if fi, err := os.Stat(file); err == nil && fi.IsDir() {
+			pairs, err := pairROMDir(file)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, pairs...)
+			continue
+		}
+
 		img := Image{
 			Filepath: file,
 		}
 
+		if isROMFile(arg) {
+			if i+1 >= len(args) || isROMFile(args[i+1]) {
+				return nil, fmt.Errorf("ROM %s must be followed by the image to use for it", arg)
+			}
+			hdr, err := readROMHeader(file)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			if img.Filepath, err = filepath.Abs(args[i]); err != nil {
+				return nil, err
+			}
+			img.Signature = hdr.Signature
+			log.Printf("Using %08X from %s for %s", hdr.Signature, filepath.Base(arg), filepath.Base(args[i]))
+			imgs = append(imgs, img)
+			continue
+		}
+
 		sig, err := HexStringTransform(strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg)))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: filename isn't a signature & no ROM was given for it: %w", arg, err)
 		}
 		img.Signature = sig
 		imgs = append(imgs, img)
@@ -138,103 +435,222 @@ func generateListFromArgs(args []string) ([]Image, error) {
 	return imgs, nil
 }
 
-// buildNewDB takes the old sigs & images, as well as the new custom images to add, and creates the correct set of arrays
-// that can then be written back to the labels.db file
-func buildNewDB(sigs []uint32, imgs [][]byte, customImgs []Image) ([]uint32, [][]byte) {
-	slices.SortFunc(customImgs, func(a, b Image) int {
-		if a.Signature < b.Signature {
-			return -1
-		} else if a.Signature > b.Signature {
-			return 1
+// dedupeImages keeps one image for each signature, so the outcome doesn't depend on the order they're converted in.
+// The image with the highest Priority wins, & among equals, the last one given: the later argument on the command
+// line, the later line in a manifest, & within a directory, the later file in lexical order. The winner takes the place
+// of the first image given for its signature.
+func dedupeImages(imgs []Image) []Image {
+	winner := make(map[uint32]int, len(imgs))
+	for i, img := range imgs {
+		if w, ok := winner[img.Signature]; !ok || img.Priority >= imgs[w].Priority {
+			winner[img.Signature] = i
 		}
-		return 0
-	})
+	}
+	if len(winner) == len(imgs) {
+		return imgs
+	}
 
-	newSigs := make([]uint32, 0)
-	newImgs := make([][]byte, 0)
-	i := 0
-	j := 0
+	deduped := make([]Image, 0, len(winner))
+	placed := make(map[uint32]bool, len(winner))
+	for i, img := range imgs {
+		w := winner[img.Signature]
+		if w != i {
+			log.Printf("%08X is given more than once: using %s rather than %s", img.Signature, imgs[w].Filepath,
+				img.Filepath)
+		}
+		if !placed[img.Signature] {
+			placed[img.Signature] = true
+			deduped = append(deduped, imgs[w])
+		}
+	}
+	return deduped
+}
 
-	for i < len(sigs) && j < len(customImgs) {
-		if sigs[i] < customImgs[j].Signature {
-			newSigs = append(newSigs, sigs[i])
-			newImgs = append(newImgs, imgs[i])
-			i++
-		} else if sigs[i] > customImgs[j].Signature {
-			newSigs = append(newSigs, customImgs[j].Signature)
-			b, err := loadImage(customImgs[j].Filepath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			newImgs = append(newImgs, b)
-			j++
-		} else { // If the signature is equal, replace the old image with the new one
-			newSigs = append(newSigs, customImgs[j].Signature)
-			b, err := loadImage(customImgs[j].Filepath)
-			if err != nil {
-				log.Fatal(err)
+// pairROMDir looks for ROMs in dir & its subdirectories, pairing each one with an image in the same directory that has
+// the same name, e.g. `Super Mario 64 (USA).z64` & `Super Mario 64 (USA).png`. ROMs without an image are logged &
+// ignored.
+func pairROMDir(dir string) ([]Image, error) {
+	imgs := make([]Image, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isROMFile(path) {
+			return nil
+		}
+
+		img, ok := companionImage(path)
+		if !ok {
+			log.Printf("No image found for %s", path)
+			return nil
+		}
+		hdr, err := readROMHeader(path)
+		if err != nil {
+			log.Printf("Skipping %v", err)
+			return nil
+		}
+		imgs = append(imgs, Image{Filepath: img, Signature: hdr.Signature})
+		return nil
+	})
+	return imgs, err
+}
+
+// companionImage returns the image next to the ROM at path with the same name, if there is one
+func companionImage(path string) (string, bool) {
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range imageExts {
+		for _, candidate := range []string{stem + ext, stem + strings.ToUpper(ext)} {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, true
 			}
-			newImgs = append(newImgs, b)
-			i++
-			j++
 		}
 	}
+	return "", false
+}
 
-	if i < len(sigs) {
-		newSigs = append(newSigs, sigs[i:]...)
-		newImgs = append(newImgs, imgs[i:]...)
-	} else {
-		for j = j; j < len(customImgs); j++ {
-			newSigs = append(newSigs, customImgs[j].Signature)
-			b, err := loadImage(customImgs[j].Filepath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			newImgs = append(newImgs, b)
+// checkReplacements works out which of the custom images would replace an entry already in the index & applies the
+// policy to them: either dropping them from the list, or confirming the replacement with the user. Anything dropped is
+// recorded in report, which may be nil.
+func checkReplacements(db *labelsdb.DB, customImgs []Image, policy *confirmPolicy, report *addReport) ([]Image, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	kept := make([]Image, 0, len(customImgs))
+	replacing := make(map[uint32]bool)
+	for _, c := range customImgs {
+		exists := db.Contains(c.Signature)
+		if reason := policy.skipReason(exists); reason != "" {
+			log.Printf("Skipping %08X: %s", c.Signature, reason)
+			report.skip(c, reason)
+			continue
+		}
+		if exists {
+			replacing[c.Signature] = true
+		}
+		kept = append(kept, c)
+	}
+
+	return kept, policy.confirmReplace(len(replacing))
+}
+
+// checkCapacity makes sure there's room in the index for every new signature in customImgs, so that a batch that can't
+// fit fails straight away rather than after converting everything
+func checkCapacity(db *labelsdb.DB, customImgs []Image) error {
+	added := make(map[uint32]bool)
+	for _, c := range customImgs {
+		if !db.Contains(c.Signature) {
+			added[c.Signature] = true
 		}
 	}
+	if free := labelsdb.MaxEntries - db.Len(); len(added) > free {
+		return fmt.Errorf("%w: %s new entries won't fit, only %s of the index's %s slots are free", labelsdb.ErrFull,
+			formatCount(len(added)), formatCount(free), formatCount(labelsdb.MaxEntries))
+	}
+	return nil
+}
 
-	return newSigs, newImgs
+// buildNewDB converts the custom images & adds them to db, replacing any existing entries with the same signature. If
+// settings.Store is not nil, previous conversions of the same images are reused from it, as are conversions already
+// made for settings.Memo. Images that fail to load are logged & skipped, with the number skipped being returned. Images
+// that fail QA are left out too, but only recorded in settings.QA.
+func buildNewDB(db *labelsdb.DB, customImgs []Image, settings addSettings, opts convertOptions) (int, error) {
+	skipped := 0
+	for _, c := range customImgs {
+		if failures := settings.QA.check(c); len(failures) > 0 {
+			reason := "failed QA: " + strings.Join(failures, "; ")
+			log.Printf("Skipping %08X: %s", c.Signature, reason)
+			settings.Report.skip(c, reason)
+			continue
+		}
+		o := opts
+		o.Scale = settings.scaleFor(c.Signature)
+		o, err := withSidecar(c, o)
+		if err != nil {
+			log.Printf("Skipping %08X: %v", c.Signature, err)
+			settings.Report.fail(c, err)
+			skipped++
+			continue
+		}
+		b, err := settings.Memo.load(settings.Store, c.Filepath, o)
+		if err != nil {
+			log.Printf("Skipping %08X: %v", c.Signature, err)
+			settings.Report.fail(c, err)
+			skipped++
+			continue
+		}
+		old, exists := db.Entry(c.Signature)
+		if exists && settings.PreservePadding {
+			b = keepPadding(b, old)
+		}
+		if err := db.PutEntry(c.Signature, b); err != nil {
+			return skipped, err
+		}
+		settings.Report.put(c, exists)
+	}
+	return skipped, nil
 }
 
-// loadImage takes a filename, loads the file from disk using getImg, resizes it to the correct dimensions, and returns a byte array
-// of the BGRA representation of the image
-func loadImage(filename string) ([]byte, error) {
+// loadImage takes a filename, loads the file from disk using getImg, scales it to the correct dimensions, and returns the
+// entry: the BGRA representation of the image followed by the padding from opts
+func loadImage(filename string, opts convertOptions) ([]byte, error) {
 	log.Printf("Loading %s\n", filename)
 	i, err := getImg(filename)
 	if err != nil {
 		return nil, err
 	}
-	img := imaging.Resize(i, width, height, imaging.Lanczos)
-
-	bgra := make([]byte, 0)
-	// Since it's one row at a time, outer loop should be Y & inner loop should be X
-	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
-		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-			c := img.NRGBAAt(x, y)
-			bgra = append(bgra, c.B, c.G, c.R, c.A)
+	if c := opts.Scale.Crop; !c.Empty() {
+		// The crop is relative to the image's own top left, wherever its bounds start
+		r := c.Add(i.Bounds().Min).Intersect(i.Bounds())
+		if r.Empty() {
+			return nil, fmt.Errorf("the crop %dx%d at %d,%d is outside the %dx%d image", c.Dx(), c.Dy(), c.Min.X,
+				c.Min.Y, i.Bounds().Dx(), i.Bounds().Dy())
 		}
+		i = subImage(i, r)
 	}
-
-	// Add the 144 bytes of padding
-	for i := 0; i < imgPadding; i++ {
-		bgra = append(bgra, 0xFF)
+	if r := edgeBounds(i); r != i.Bounds() && opts.Scale.TrimEdges {
+		log.Printf("Trimmed the edge around %s to %dx%d", filename, r.Dx(), r.Dy())
+		i = subImage(i, r)
+	} else if r != i.Bounds() {
+		log.Printf("%s has a thin transparent or white edge that may show as a border; -auto-trim-edges removes it",
+			filename)
 	}
-
-	return bgra, nil
+	img := opts.Alpha.apply(annotate(scaleImage(i, opts.Scale), opts.Stamps, opts.Badges))
+	return labelsdb.Encode(opts.Colour.apply(img), opts.Padding), nil
 }
 
-// getImg loads an image from disk. I copied this from an old project and can't recall why I'm using it rather than
-// imaging.Open. I think image.Decode might handle a greater number of file formats?
+// getImg loads an image from disk or a URL. I copied this from an old project and can't recall why I'm using it
+// rather than imaging.Open. I think image.Decode might handle a greater number of file formats?
+//
+// Before decoding, the file size & the dimensions in the image header are checked against maxImageBytes &
+// maxImageDimension so that a decompression bomb is rejected before it gets a chance to allocate anything.
 func getImg(src string) (img image.Image, err error) {
-	f, err := os.Open(src)
+	f, size, err := openSource(src)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
+	if maxImageBytes > 0 && size > maxImageBytes {
+		return nil, fmt.Errorf("%s: %w: file is %d bytes, limit is %d", src, errImageTooLarge, size, maxImageBytes)
+	}
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, decodeError(src, err)
+	}
+	if maxImageDimension > 0 && (cfg.Width > maxImageDimension || cfg.Height > maxImageDimension) {
+		return nil, fmt.Errorf("%s: %w: image is %dx%d, limit is %dx%d", src, errImageTooLarge, cfg.Width, cfg.Height,
+			maxImageDimension, maxImageDimension)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
 	i, _, err := image.Decode(f)
-	return i, err
+	if err != nil {
+		return nil, decodeError(src, err)
+	}
+	return i, nil
 }
 
 // HexStringTransform takes a string, validates that it is a 32 bit hex string, and returns the uint32 representation of it
@@ -249,19 +665,19 @@ func HexStringTransform(s string) (uint32, error) {
 	// take care of the many different ways a user might input this
 	s = strings.TrimPrefix(strings.ToLower(s), "0x")
 	if s == "" {
-		return 0, fmt.Errorf("invalid string provided: %s", s)
+		return 0, withCode(codeBadSignature, fmt.Errorf("invalid string provided: %s", s))
 	}
 
 	// String should be exactly 32 bits. We can pad it out if too short, but can't handle too long.
 	if len(s) > 8 {
-		return 0, fmt.Errorf("hex string too long: %s", s)
+		return 0, withCode(codeBadSignature, fmt.Errorf("hex string too long: %s", s))
 	} else if len(s) < 8 {
 		s = fmt.Sprintf("%08s", s) // binary.BigEndian.Uint32 fails if not padded out to 32 bits
 	}
 
 	h, err := hex.DecodeString(s)
 	if err != nil {
-		return 0, err
+		return 0, withCode(codeBadSignature, err)
 	}
 
 	return binary.BigEndian.Uint32(h), nil
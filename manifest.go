@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// manifestEntry is one line of a manifest: an image, the signature to add it under, & optionally the game's title &
+// where the art came from. The provenance is only used by pack audit.
+type manifestEntry struct {
+	ImagePath string `json:"image_path"`
+	Signature hexSig `json:"signature"`
+	Title     string `json:"title,omitempty"`
+	Author    string `json:"author,omitempty"`
+	// License is the art's license, ideally as an SPDX identifier such as CC-BY-4.0
+	License string `json:"license,omitempty"`
+	// Source is where the art was found, usually a URL
+	Source string `json:"source,omitempty"`
+	// Priority picks between entries for the same signature, in this or another manifest. See dedupeImages.
+	Priority int `json:"priority,omitempty"`
+}
+
+// isManifest returns true if path looks like a manifest rather than an image or ROM
+func isManifest(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".csv" || ext == ".json"
+}
+
+// loadManifest reads a CSV or JSON manifest & returns the images it lists. Image paths are relative to the directory
+// the manifest is in, so a pack of images & its manifest can be moved around together.
+//
+// A CSV manifest has the columns image_path, signature, & optionally title, author, license, source & priority, with an
+// optional header row. A JSON manifest is an array of objects with the same keys.
+func loadManifest(filename string) ([]Image, error) {
+	entries, err := readManifest(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(filename)
+	imgs := make([]Image, 0, len(entries))
+	for _, e := range entries {
+		if e.ImagePath == "" {
+			return nil, fmt.Errorf("%s: entry for %08X has no image_path", filename, uint32(e.Signature))
+		}
+		p := e.ImagePath
+		if !isRemote(p) {
+			if p = filepath.FromSlash(p); !filepath.IsAbs(p) {
+				p = filepath.Join(dir, p)
+			}
+		}
+		if e.Title != "" {
+			log.Printf("Using %08X (%s) for %s", uint32(e.Signature), e.Title, e.ImagePath)
+		}
+		imgs = append(imgs, Image{Filepath: p, Signature: uint32(e.Signature), Priority: e.Priority, Title: e.Title})
+	}
+	return imgs, nil
+}
+
+// readManifest reads the entries in a CSV or JSON manifest as they are
+func readManifest(filename string) ([]manifestEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []manifestEntry
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.NewDecoder(f).Decode(&entries)
+	} else {
+		entries, err = readCSVManifest(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return entries, nil
+}
+
+// readCSVManifest reads the rows of a CSV manifest. A first row starting with image_path is taken to be a header.
+func readCSVManifest(r io.Reader) ([]manifestEntry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	entries := make([]manifestEntry, 0)
+	for first := true; ; first = false {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(row[0], "image_path") {
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		if len(row) < 2 || len(row) > 7 {
+			return nil, fmt.Errorf("line %d: expected image_path,signature[,title[,author[,license[,source[,priority]]]]]",
+				line)
+		}
+		sig, err := HexStringTransform(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		e := manifestEntry{ImagePath: row[0], Signature: hexSig(sig)}
+		for i, field := range []*string{&e.Title, &e.Author, &e.License, &e.Source} {
+			if len(row) > i+2 {
+				*field = row[i+2]
+			}
+		}
+		if len(row) > 6 && strings.TrimSpace(row[6]) != "" {
+			if e.Priority, err = strconv.Atoi(strings.TrimSpace(row[6])); err != nil {
+				return nil, fmt.Errorf("line %d: priority: %w", line, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+}
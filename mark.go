@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// markXattr is the extended attribute the customized mark is also kept in, where the filesystem supports them
+const markXattr = "user.a3dlabels.customized"
+
+// packs are the label packs the command being run is adding from, such as manifests, bundles, & art directories, for
+// the customized mark
+var packs []string
+
+// appliedPacks are the named packs, with their versions, that the command being run is applying, for the customized
+// mark
+var appliedPacks = make(map[string]string)
+
+// customMark records that a database has been changed by this tool, so it can be told from a stock one at a glance
+// months later. It's kept in a sidecar file next to the database, & in an extended attribute on it where possible.
+type customMark struct {
+	Tool    string    `json:"tool"`
+	Version string    `json:"version"`
+	First   time.Time `json:"first_modified"`
+	Last    time.Time `json:"last_modified"`
+	Command string    `json:"command"`
+	// Packs are every pack that's been added from, oldest first
+	Packs []string `json:"packs,omitempty"`
+	// Applied is the version of every pack that declared its name in a pack.json that's been applied, so that packs
+	// built on top of it can check it's there
+	Applied map[string]string `json:"applied_packs,omitempty"`
+}
+
+// markPath returns the sidecar the customized mark for the database at path is kept in
+func markPath(path string) string {
+	return path + ".a3dlabels.json"
+}
+
+// notePack adds p to the packs recorded in the customized mark
+func notePack(p string) {
+	if !slices.Contains(packs, p) {
+		packs = append(packs, p)
+	}
+}
+
+// secretFlags are the flags whose values are credentials, & so mustn't be recorded. Webhook URLs usually carry a token
+// in their path.
+var secretFlags = []string{"google-api-key", "webhook"}
+
+// commandLine returns the command being run, for the customized mark & the undo journal. Both are kept next to the
+// database & can end up shared along with it, so the values of secretFlags & any credentials in URLs are replaced.
+func commandLine() string {
+	args := slices.Clone(os.Args[1:])
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || !slices.Contains(secretFlags, name) {
+			args[i] = redactURL(args[i])
+			continue
+		}
+		if hasValue {
+			args[i] = "-" + name + "=REDACTED"
+		} else if i+1 < len(args) {
+			i++
+			args[i] = "REDACTED"
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// toolVersion returns the version of the tool, as recorded by the Go toolchain when it was built
+func toolVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "unknown"
+}
+
+// readMark returns the customized mark for the database at path, or nil if it hasn't got one
+func readMark(path string) *customMark {
+	b, err := os.ReadFile(markPath(path))
+	if err != nil {
+		return nil
+	}
+	var m customMark
+	if json.Unmarshal(b, &m) != nil {
+		return nil
+	}
+	return &m
+}
+
+// markCustomized updates the customized mark for the database at path after a write. Any failure is only logged in
+// verbose mode, since the write itself has already succeeded.
+func markCustomized(path string) {
+	now := time.Now().UTC().Truncate(time.Second)
+	m := readMark(path)
+	if m == nil {
+		m = &customMark{First: now}
+	}
+	m.Tool, m.Version, m.Last = "a3dlabels", toolVersion(), now
+	m.Command = commandLine()
+	for _, p := range packs {
+		if !slices.Contains(m.Packs, p) {
+			m.Packs = append(m.Packs, p)
+		}
+	}
+	if len(appliedPacks) > 0 && m.Applied == nil {
+		m.Applied = make(map[string]string)
+	}
+	maps.Copy(m.Applied, appliedPacks)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		debugf("Not marking %s as customized: %v", path, err)
+		return
+	}
+	if err := writeFileAtomic(markPath(path), append(b, '\n')); err != nil {
+		debugf("Not marking %s as customized: %v", path, err)
+	}
+	// The attribute is a single line so that tools such as xattr & getfattr show it readably
+	if b, err = json.Marshal(m); err == nil {
+		if err := setXattr(path, markXattr, b); err != nil {
+			debugf("Not setting %s on %s: %v", markXattr, path, err)
+		}
+	}
+}
+
+// String describes the mark in a line
+func (m *customMark) String() string {
+	s := fmt.Sprintf("by %s %s on %s", m.Tool, m.Version, m.Last.Local().Format("2006-01-02 15:04"))
+	if !m.First.Equal(m.Last) {
+		s += fmt.Sprintf(", first on %s", m.First.Local().Format("2006-01-02"))
+	}
+	if len(m.Packs) > 0 {
+		s += ", from " + strings.Join(m.Packs, ", ")
+	}
+	return s
+}
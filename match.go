@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// match implements `match {labels.db} {signature...} [-roms roms.idx] [-dat file] [-titles file]`. For each signature it
+// reports whether the db has an entry for it, the game's title if one can be found, & the signatures closest to it. It's
+// intended for working out why a label isn't showing up for a cartridge. See loadTitles.
+func match(args []string) error {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || (*dat != "" && *roms == "") {
+		return errors.New("usage: match {labels.db} {signature...} [-roms roms.idx [-dat file]] [-titles file]")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sigs, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		return err
+	}
+
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+	describe := func(sig uint32) string {
+		if t, ok := titles[sig]; ok {
+			return fmt.Sprintf("%08X (%s)", sig, t.Title)
+		}
+		return fmt.Sprintf("%08X", sig)
+	}
+
+	for _, arg := range args[1:] {
+		sig, err := HexStringTransform(arg)
+		if err != nil {
+			return err
+		}
+
+		i, found := slices.BinarySearch(sigs, sig)
+		if found {
+			fmt.Printf("%s: found, index slot %d\n", describe(sig), i)
+			continue
+		}
+		fmt.Printf("%s: not found\n", describe(sig))
+
+		if i > 0 {
+			fmt.Printf("  next lowest:  %s\n", describe(sigs[i-1]))
+		}
+		if i < len(sigs) {
+			fmt.Printf("  next highest: %s\n", describe(sigs[i]))
+		}
+		// A signature that's one hex digit off is most likely a typo or misread
+		for _, s := range sigs {
+			if hexDigitsDiffer(s, sig) == 1 {
+				fmt.Printf("  one digit off: %s\n", describe(s))
+			}
+		}
+	}
+	return nil
+}
+
+// hexDigitsDiffer returns the number of hex digits that differ between a & b
+func hexDigitsDiffer(a, b uint32) int {
+	x := a ^ b
+	n := 0
+	for ; x != 0; x >>= 4 {
+		if x&0xF != 0 {
+			n++
+		}
+	}
+	return n
+}
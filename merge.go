@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// merge implements `merge {base labels.db} {overlay labels.db...} -o {merged labels.db}`. Entries in each overlay
+// replace the matching ones in the base & new signatures are inserted in order. Later overlays win over earlier ones.
+// None of the inputs are modified, unless -o names one of them.
+func merge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "", "the merged labels.db to write")
+	preservePadding := fs.Bool("preserve-padding", true,
+		"keep the base's padding for entries an overlay replaces, rather than copying the overlay's")
+	cleanIndex := fs.Bool("clean-index", false, "blank any leftover data in the index after the EOF marker")
+	salvage := fs.Bool("salvage", false, "replace entries that can't be read with blank ones instead of failing")
+	dryRun := fs.Bool("dry-run", false, "show what the merged database would contain, without writing anything")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || *out == "" {
+		return errors.New("usage: merge {base labels.db} {overlay labels.db...} -o {merged labels.db} [flags]")
+	}
+
+	db, err := openDB(args[0], *salvage)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", args[0], err)
+	}
+	orig := db.Clone()
+	for _, path := range args[1:] {
+		overlay, err := openDB(path, *salvage)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := mergeDB(db, overlay, *preservePadding, &policy); err != nil {
+			return fmt.Errorf("merging %s: %w", path, err)
+		}
+	}
+
+	if *dryRun {
+		printDiff(labelsdb.Compare(orig, db), orig.Len(), db.Len())
+		fmt.Printf("\nDry run: %s was not written.\n", *out)
+		return nil
+	}
+	if n := db.IndexJunk(); n > 0 && *cleanIndex {
+		log.Printf("Blanking %s index slots after the EOF marker", formatCount(n))
+		db.ClearIndexJunk()
+	}
+
+	log.Printf("Writing %s images to %s", formatCount(db.Len()), *out)
+	return saveDB(*out, db)
+}
+
+// mergeDB copies every entry in overlay into db. Entries that are identical in both are left alone, so only the ones
+// that would actually change count towards the policy's confirmation threshold. If preservePadding is set, replaced
+// entries keep the padding they had in db.
+func mergeDB(db, overlay *labelsdb.DB, preservePadding bool, policy *confirmPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	d := labelsdb.Compare(db, overlay)
+	if policy.skipReason(false) != "" {
+		if len(d.Added) > 0 {
+			log.Printf("Skipping %d entries not in the base: -replace-only is set", len(d.Added))
+		}
+		d.Added = nil
+	}
+	if free := labelsdb.MaxEntries - db.Len(); len(d.Added) > free {
+		return fmt.Errorf("%w: %s new entries won't fit, only %s of the index's %s slots are free", labelsdb.ErrFull,
+			formatCount(len(d.Added)), formatCount(free), formatCount(labelsdb.MaxEntries))
+	}
+
+	sigs := d.Added
+	if policy.skipReason(true) != "" {
+		if len(d.Changed) > 0 {
+			log.Printf("Skipping %d entries already in the base: -no-overwrite is set", len(d.Changed))
+		}
+	} else {
+		if err := policy.confirmReplace(len(d.Changed)); err != nil {
+			return err
+		}
+		sigs = append(sigs, d.Changed...)
+	}
+
+	for _, s := range sigs {
+		entry, _ := overlay.Entry(s)
+		if old, ok := db.Entry(s); ok && preservePadding {
+			entry = keepPadding(entry, old)
+		}
+		if err := db.PutEntry(s, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
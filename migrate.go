@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// migrator finds the images in a directory laid out by another tool & works out which signature each belongs to
+type migrator func(dir string, idx *romIndex, titleFile, dat string) ([]Image, error)
+
+// migrators are the layouts migrate understands, by the name passed to -from
+var migrators = map[string]migrator{
+	"libretro": migrateLibretro,
+}
+
+// migrate implements `migrate {labels.db} {art dir} -from {layout} -roms roms.idx`, adding a collection of art
+// organised the way another tool organises it in one step
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "the layout the art is in: "+strings.Join(migratorNames(), ", "))
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to match the art to signatures")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	m, ok := migrators[*from]
+	if len(args) != 2 || !ok || *roms == "" {
+		return fmt.Errorf("usage: migrate {labels.db} {art dir} -from {%s} -roms roms.idx [-dat file] [-titles file] "+
+			"[flags]", strings.Join(migratorNames(), "|"))
+	}
+
+	idx, err := loadROMIndex(*roms)
+	if err != nil {
+		return err
+	}
+	notePack(args[1])
+	imgs, err := m(args[1], idx, *titleFile, *dat)
+	if err != nil {
+		return err
+	}
+	if len(imgs) == 0 {
+		return errors.New("none of the art could be matched to a ROM")
+	}
+
+	settings := addSettings{PreservePadding: true, DryRun: *dryRun, Policy: policy}
+	if storeDir, err := defaultStoreDir(); err == nil {
+		settings.Store, _ = openStore(storeDir)
+	}
+	if settings.Colour, err = loadColourProfile(); err != nil {
+		return err
+	}
+	skipped, err := addImages(args[0], imgs, settings)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+	}
+	return nil
+}
+
+// migratorNames returns the names of the migrators, sorted
+func migratorNames() []string {
+	names := make([]string, 0, len(migrators))
+	for n := range migrators {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// migrateLibretro handles art named the way libretro-thumbnails & RetroArch name it: the game's No-Intro name with
+// unsafe characters replaced, in any folder under dir. Where there are several folders, such as Named_Boxarts &
+// Named_Snaps, the first one alphabetically wins.
+func migrateLibretro(dir string, idx *romIndex, titleFile, dat string) ([]Image, error) {
+	titles, err := thumbnailTitles(idx, titleFile, dat)
+	if err != nil {
+		return nil, err
+	}
+	// Several ROMs, such as the same game in different formats, can share a name
+	sigs := make(map[string][]uint32)
+	for s, t := range titles {
+		name := strings.ToLower(libretroName(t))
+		sigs[name] = append(sigs[name], s)
+	}
+
+	imgs := make([]Image, 0)
+	found := make(map[uint32]bool)
+	unmatched := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isImageFile(path) {
+			return err
+		}
+		base := filepath.Base(path)
+		matches := sigs[strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)))]
+		if len(matches) == 0 {
+			unmatched++
+			return nil
+		}
+		for _, s := range matches {
+			if !found[s] {
+				found[s] = true
+				imgs = append(imgs, Image{Filepath: path, Signature: s})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(imgs, func(a, b Image) int { return int(int64(a.Signature) - int64(b.Signature)) })
+	if unmatched > 0 {
+		log.Printf("%s images didn't match any ROM in the index & were ignored", formatCount(unmatched))
+	}
+	return imgs, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// mockupW & mockupH are the size the mockup is drawn at. It's doubled when saved, giving a 1080p image with the
+	// chunky text & pixel doubled labels of a real capture.
+	mockupW, mockupH = 960, 540
+	// mockupColumns is the number of cartridges in each row of the library grid
+	mockupColumns = 7
+	// cartBorder is the plastic around the label on each side of a cartridge, & cartTop the extra above it
+	cartBorder, cartTop = 8, 14
+)
+
+var (
+	mockupBackground = color.NRGBA{0x12, 0x12, 0x14, 0xFF}
+	mockupBar        = color.NRGBA{0x1C, 0x1C, 0x20, 0xFF}
+	mockupCart       = color.NRGBA{0x3A, 0x3A, 0x3E, 0xFF}
+	mockupRidge      = color.NRGBA{0x30, 0x30, 0x34, 0xFF}
+	mockupSelected   = color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	mockupText       = color.NRGBA{0xE8, 0xE8, 0xE8, 0xFF}
+	mockupDim        = color.NRGBA{0x80, 0x80, 0x86, 0xFF}
+)
+
+// mockup implements `mockup {labels.db} -o {mockup.png} [signature...]`, drawing the labels as cartridges in a grid
+// like the console's library, with one of them selected & its title shown. It's meant for showing off a pack, so the
+// layout is an approximation of the console's rather than a pixel perfect copy.
+func mockup(args []string) error {
+	fs := flag.NewFlagSet("mockup", flag.ExitOnError)
+	out := fs.String("o", "mockup.png", "the PNG to write")
+	sigList := fs.String("sigs", "", "a comma separated list of signatures to show, as well as any given as arguments")
+	selected := fs.Int("select", 1, "which cartridge to show as selected, counting from 1")
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || *selected < 1 || (*dat != "" && *roms == "") {
+		return errors.New("usage: mockup {labels.db} [signature...] [-sigs sig,sig...] [-o mockup.png] [-select n] " +
+			"[-roms roms.idx [-dat file]] [-titles file]")
+	}
+
+	want, err := parseSignatures(args[1:], *sigList)
+	if err != nil {
+		return err
+	}
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+	// The listed signatures are shown in the order given, so the author can choose what's on screen
+	entries := make([]labelsdb.Entry, 0, db.Len())
+	if len(want) == 0 {
+		entries = db.Entries()
+	}
+	for _, sig := range want {
+		if e, ok := db.Entry(sig); ok {
+			entries = append(entries, labelsdb.Entry{Signature: sig, Data: e})
+		} else {
+			log.Printf("%08X is not in %s", sig, args[0])
+		}
+	}
+	if len(entries) == 0 {
+		return errors.New("nothing to show")
+	}
+
+	img := libraryMockup(entries, titles, min(*selected, len(entries))-1)
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := encodeSRGB(f, imaging.Resize(img, 2*mockupW, 2*mockupH, imaging.NearestNeighbor)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Wrote a mockup of %s cartridges to %s", formatCount(len(entries)), *out)
+	return nil
+}
+
+// libraryMockup draws the library screen with the entries as cartridges, scrolled so that the selected one is on
+// screen
+func libraryMockup(entries []labelsdb.Entry, titles titleLookup, selected int) *image.NRGBA {
+	face := basicfont.Face7x13
+	img := image.NewNRGBA(image.Rect(0, 0, mockupW, mockupH))
+	fill(img, img.Bounds(), mockupBackground)
+
+	// Header & footer bars
+	const barH = 36
+	fill(img, image.Rect(0, 0, mockupW, barH), mockupBar)
+	fill(img, image.Rect(0, mockupH-barH, mockupW, mockupH), mockupBar)
+	drawText(img, face, 24, barH/2+face.Ascent/2, "Library", mockupText)
+	count := fmt.Sprintf("%s games", formatCount(len(entries)))
+	drawText(img, face, mockupW-24-len(count)*face.Advance, barH/2+face.Ascent/2, count, mockupDim)
+	drawText(img, face, 24, mockupH-barH/2+face.Ascent/2, "(A) Play   (B) Back   (Y) Sort", mockupDim)
+
+	cartW, cartH := labelsdb.Width+2*cartBorder, labelsdb.Height+cartBorder+cartTop
+	gapX := (mockupW - mockupColumns*cartW) / (mockupColumns + 1)
+	gapY := 2*face.Height + 12
+	rowH := cartH + gapY
+	visible := (mockupH - 2*barH - 16) / rowH
+	firstRow := max(0, selected/mockupColumns-visible+1)
+
+	for i, e := range entries[firstRow*mockupColumns:] {
+		row, col := i/mockupColumns, i%mockupColumns
+		if row >= visible {
+			break
+		}
+		at := image.Pt(gapX+col*(cartW+gapX), barH+16+row*rowH)
+		cart := image.Rectangle{at, at.Add(image.Pt(cartW, cartH))}
+		if firstRow*mockupColumns+i == selected {
+			fill(img, cart.Inset(-3), mockupSelected)
+			title := titles[e.Signature].Title
+			if title == "" {
+				title = fmt.Sprintf("%08X", e.Signature)
+			}
+			title = truncate(title, (cartW+gapX)*2/face.Advance)
+			x := min(max(8, cart.Min.X+cartW/2-len(title)*face.Advance/2), mockupW-8-len(title)*face.Advance)
+			drawText(img, face, x, cart.Max.Y+8+face.Ascent, title, mockupText)
+		}
+		fill(img, cart, mockupCart)
+		// The ridges along the top of an N64 cartridge
+		for x := cart.Min.X + 6; x+4 <= cart.Max.X-6; x += 8 {
+			fill(img, image.Rect(x, cart.Min.Y+3, x+4, cart.Min.Y+cartTop-3), mockupRidge)
+		}
+		label := labelsdb.Decode(e.Data)
+		lp := at.Add(image.Pt(cartBorder, cartTop))
+		draw.Draw(img, image.Rectangle{lp, lp.Add(label.Bounds().Size())}, label, image.Point{}, draw.Over)
+	}
+	return img
+}
+
+// fill paints r in a solid colour
+func fill(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// drawText draws s with its baseline starting at x, y
+func drawText(img draw.Image, face *basicfont.Face, x, y int, s string, c color.Color) {
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// keepOwner does nothing on this platform, where files don't have a Unix owner to keep
+func keepOwner(f *os.File, orig os.FileInfo) {}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// keepOwner gives f the same owner & group as orig. Only root can give a file away, so this mostly matters when
+// running as root, e.g. in a container, where the replacement labels.db would otherwise end up owned by root. Failures
+// are ignored: the file is still usable, just owned by whoever wrote it.
+func keepOwner(f *os.File, orig os.FileInfo) {
+	if st, ok := orig.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(st.Uid), int(st.Gid))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// unknownLicenses are the values of a manifest's license that say nobody knows what it is
+var unknownLicenses = []string{"", "unknown", "?", "none", "noassertion"}
+
+// licenseProblem is something pack audit found wrong with an entry's provenance
+type licenseProblem struct {
+	manifestEntry
+	Problem string
+	// Blocking is set for problems that should stop the pack being published, rather than just needing a look
+	Blocking bool
+}
+
+// pack implements `pack audit` & `pack apply`, for the maintainers & users of published label packs
+func pack(args []string) error {
+	usage := errors.New("usage: pack audit {manifest | bundle dir} [-allow license,license...] [-o attribution file] | " +
+		"pack apply {labels.db} {bundle dir} [flags]")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "audit":
+		return packAudit(args[1:])
+	case "apply":
+		return importBundle(args[1:])
+	}
+	return usage
+}
+
+// packAudit implements `pack audit {manifest | bundle dir}`, which checks the author, license & source recorded for
+// every entry in a pack's manifest before it's published, & can write the attribution file the licenses call for.
+// Entries whose license is unknown, or isn't one of those allowed, make it fail so it can guard a release in CI.
+func packAudit(args []string) error {
+	fs := flag.NewFlagSet("pack audit", flag.ExitOnError)
+	allow := fs.String("allow", "", "a comma separated list of the licenses the pack may include (default any known one)")
+	out := fs.String("o", "", "write an attribution file crediting every entry, grouped by license")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: pack audit {manifest | bundle dir} [-allow license,license...] [-o attribution file]")
+	}
+	path := args[0]
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, bundleManifest)
+	}
+	entries, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+	var allowed []string
+	if *allow != "" {
+		for _, l := range strings.Split(*allow, ",") {
+			allowed = append(allowed, strings.ToLower(strings.TrimSpace(l)))
+		}
+	}
+
+	problems := make([]licenseProblem, 0)
+	licenses := make(map[string]int)
+	for _, e := range entries {
+		lic := strings.TrimSpace(e.License)
+		known := !slices.Contains(unknownLicenses, strings.ToLower(lic))
+		if known {
+			licenses[lic]++
+		} else {
+			licenses["unknown"]++
+		}
+		switch {
+		case !known:
+			problems = append(problems, licenseProblem{e, "unknown license", true})
+		case allowed != nil && !slices.Contains(allowed, strings.ToLower(lic)):
+			problems = append(problems, licenseProblem{e, fmt.Sprintf("%s isn't an allowed license", lic), true})
+		case strings.HasPrefix(strings.ToUpper(lic), "CC-BY") && e.Author == "":
+			problems = append(problems, licenseProblem{e, "the license needs the author credited, but there isn't one",
+				true})
+		}
+		if e.Source == "" {
+			problems = append(problems, licenseProblem{e, "no source recorded", false})
+		}
+	}
+
+	if len(problems) > 0 {
+		t := newTable(os.Stdout, "Signature", "Title", "License", "Problem")
+		for _, p := range problems {
+			t.row(fmt.Sprintf("%08X", uint32(p.Signature)), p.Title, p.License, p.Problem)
+		}
+		if err := t.flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%s entries in %s\n", formatCount(len(entries)), path)
+	for _, lic := range slices.Sorted(maps.Keys(licenses)) {
+		fmt.Printf("  %s: %s\n", lic, formatCount(licenses[lic]))
+	}
+
+	if *out != "" {
+		if err := writeFileAtomic(*out, []byte(attribution(entries))); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote the attribution to %s\n", *out)
+	}
+
+	blocking := 0
+	for _, p := range problems {
+		if p.Blocking {
+			blocking++
+		}
+	}
+	if blocking > 0 {
+		return fmt.Errorf("%d problems need fixing before %s is published", blocking, path)
+	}
+	return nil
+}
+
+// attribution returns a Markdown file crediting each entry's author & source, grouped by license. Entries with an
+// unknown license are listed last so they stand out.
+func attribution(entries []manifestEntry) string {
+	byLicense := make(map[string][]manifestEntry)
+	for _, e := range entries {
+		lic := strings.TrimSpace(e.License)
+		if slices.Contains(unknownLicenses, strings.ToLower(lic)) {
+			lic = ""
+		}
+		byLicense[lic] = append(byLicense[lic], e)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Attribution\n")
+	for _, lic := range slices.Sorted(maps.Keys(byLicense)) {
+		if lic == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", lic)
+		for _, e := range byLicense[lic] {
+			b.WriteString(creditLine(e))
+		}
+	}
+	if unknown := byLicense[""]; len(unknown) > 0 {
+		b.WriteString("\n## Unknown license\n\n")
+		for _, e := range unknown {
+			b.WriteString(creditLine(e))
+		}
+	}
+	return b.String()
+}
+
+// creditLine returns the line of the attribution file for e
+func creditLine(e manifestEntry) string {
+	s := fmt.Sprintf("- %08X", uint32(e.Signature))
+	if e.Title != "" {
+		s = fmt.Sprintf("- %s (%08X)", e.Title, uint32(e.Signature))
+	}
+	if e.Author != "" {
+		s += " by " + e.Author
+	}
+	if e.Source != "" {
+		s += ", from " + e.Source
+	}
+	return s + "\n"
+}
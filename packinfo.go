@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// packInfoFile is the name of the file in a bundle directory declaring what the pack is & what it needs
+const packInfoFile = "pack.json"
+
+// packInfo is what a pack declares about itself, so that applying it where it doesn't belong is refused with an
+// explanation rather than leaving a mess to be untangled. Everything but the name is optional.
+type packInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// MinToolVersion is the oldest a3dlabels that can apply the pack
+	MinToolVersion string `json:"min_tool_version,omitempty"`
+	// DBVersion is the labels.db format version the pack was made for, from the header of the database it was exported
+	// from
+	DBVersion int `json:"db_version,omitempty"`
+	// Requires are the packs that have to have been applied first, such as the base pack an add-on pack builds on
+	Requires []packRef `json:"requires,omitempty"`
+}
+
+// packRef names a pack, & optionally the oldest version of it that will do
+type packRef struct {
+	Name       string `json:"name"`
+	MinVersion string `json:"min_version,omitempty"`
+}
+
+// requiresFlag collects the -requires flags, each given as name[@min version]. It can be repeated.
+type requiresFlag []packRef
+
+func (r *requiresFlag) String() string {
+	s := make([]string, len(*r))
+	for i, p := range *r {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func (r *requiresFlag) Set(v string) error {
+	name, version, _ := strings.Cut(v, "@")
+	if strings.TrimSpace(name) == "" {
+		return errors.New("expected name[@min version]")
+	}
+	*r = append(*r, packRef{strings.TrimSpace(name), strings.TrimSpace(version)})
+	return nil
+}
+
+// String describes the reference the way it's given with -requires
+func (p packRef) String() string {
+	if p.MinVersion == "" {
+		return p.Name
+	}
+	return p.Name + "@" + p.MinVersion
+}
+
+// loadPackInfo reads the pack.json in the bundle at dir, returning nil if it hasn't got one
+func loadPackInfo(dir string) (*packInfo, error) {
+	path := filepath.Join(dir, packInfoFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var info packInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if info.Name == "" {
+		return nil, fmt.Errorf("%s: the pack has no name", path)
+	}
+	return &info, nil
+}
+
+// save writes the info to the bundle at dir
+func (p *packInfo) save(dir string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, packInfoFile), append(b, '\n'))
+}
+
+// String names the pack & its version
+func (p *packInfo) String() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + " " + p.Version
+}
+
+// compareVersions compares two dotted version numbers such as 1.4 or v2.0.1, as cmp.Compare does. Anything after a - or
+// + is ignored. ok is false if either isn't a version number, such as the "(devel)" of a development build.
+func compareVersions(a, b string) (c int, ok bool) {
+	parse := func(v string) ([]int, bool) {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+		if i := strings.IndexAny(v, "-+"); i >= 0 {
+			v = v[:i]
+		}
+		var parts []int
+		for _, f := range strings.Split(v, ".") {
+			n, err := strconv.Atoi(f)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			parts = append(parts, n)
+		}
+		return parts, true
+	}
+	pa, okA := parse(a)
+	pb, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range max(len(pa), len(pb)) {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// formatVersion returns the format version in the header of the labels.db at path. One that doesn't exist yet will be
+// created with the stock header.
+func formatVersion(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return int(labelsdb.Header[labelsdb.HeaderVersion]), nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, labelsdb.HeaderVersion); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, labelsdb.ErrNotLabelsDB)
+	}
+	return int(b[0]), nil
+}
+
+// check explains everything about the database at path, & this tool, that the pack says it can't be applied to
+func (p *packInfo) check(path string) ([]string, error) {
+	var problems []string
+	if p.MinToolVersion != "" {
+		// A build from an untagged checkout gets a v0.0.0 pseudo-version, which says nothing about how new it is
+		switch c, ok := compareVersions(toolVersion(), p.MinToolVersion); {
+		case !ok || strings.HasPrefix(toolVersion(), "v0.0.0-"):
+			log.Printf("Not checking that this is a3dlabels %s or later, since it's a development build", p.MinToolVersion)
+		case c < 0:
+			problems = append(problems, fmt.Sprintf("it needs a3dlabels %s or later, but this is %s; update the tool "+
+				"first", p.MinToolVersion, toolVersion()))
+		}
+	}
+	if p.DBVersion != 0 {
+		v, err := formatVersion(path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case v < p.DBVersion:
+			problems = append(problems, fmt.Sprintf("it was made for version %d of the labels.db format, but %s is "+
+				"version %d; update the console's firmware, or get a version of the pack made for version %d",
+				p.DBVersion, path, v, v))
+		case v > p.DBVersion:
+			problems = append(problems, fmt.Sprintf("it was made for version %d of the labels.db format, but %s is "+
+				"version %d; a firmware update has changed the format since, so get a version of the pack made for it",
+				p.DBVersion, path, v))
+		}
+	}
+	var applied map[string]string
+	if m := readMark(path); m != nil {
+		applied = m.Applied
+	}
+	for _, r := range p.Requires {
+		v, ok := applied[r.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("it goes on top of %s, which hasn't been applied to %s; apply "+
+				"that first", r, path))
+			continue
+		}
+		if r.MinVersion == "" {
+			continue
+		}
+		if c, ok := compareVersions(v, r.MinVersion); ok && c < 0 {
+			problems = append(problems, fmt.Sprintf("it needs %s %s or later, but %s has %s %s; apply the newer one "+
+				"first", r.Name, r.MinVersion, path, r.Name, v))
+		}
+	}
+	return problems, nil
+}
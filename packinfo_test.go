@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"1.4", "1.4.0", 0, true},
+		{"v1.10.0", "1.9", 1, true},
+		{"1.2", "v1.3", -1, true},
+		{"v2.0.0-rc1", "2", 0, true},
+		{"(devel)", "1.0", 0, false},
+		{"unknown", "1.0", 0, false},
+	} {
+		if got, ok := compareVersions(tc.a, tc.b); got != tc.want || ok != tc.ok {
+			t.Errorf("compareVersions(%q, %q) = %d, %t, want %d, %t", tc.a, tc.b, got, ok, tc.want, tc.ok)
+		}
+	}
+}
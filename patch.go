@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"os"
+)
+
+// Only the parts of each patch format that romhacks are distributed with are understood: IPS with the truncation
+// extension, BPS without caring about its metadata, & VCDIFF (xdelta) with the default code table & no secondary
+// compression, which is what xdelta3 & Delta Patcher write unless told otherwise.
+
+var (
+	// errPatchSource is returned when a patch's own checksums say it was made for a different ROM
+	errPatchSource = errors.New("the patch was made for a different ROM")
+	// errPatchCorrupt is returned when a patch runs out or points outside the ROM
+	errPatchCorrupt = errors.New("the patch is corrupt or truncated")
+)
+
+// applyPatch applies an IPS, BPS, or VCDIFF patch to rom, detecting the format from the patch's magic number, & returns
+// the patched ROM. rom isn't modified.
+func applyPatch(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		return applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		return applyBPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte{0xD6, 0xC3, 0xC4, 0x00}):
+		return applyVCDIFF(rom, patch)
+	}
+	return nil, errors.New("not an IPS, BPS, or xdelta patch")
+}
+
+// patchedROMHeader reads the base ROM at romPath, applies the patch at patchPath to it in memory, & returns the header
+// of the patched ROM, so a hack's signature can be worked out without the patched ROM ever being written out.
+// -verify-dat checks the base ROM, since that's what a DAT lists. Patches are nearly always made against the big endian
+// .z64 order, & an IPS patch has no checksums to say otherwise, so a byte-swapped or little endian base ROM is converted
+// to .z64 before any patch is applied. If a BPS or xdelta patch says it doesn't fit the converted ROM, it's tried again
+// on the ROM as it was read, for the odd patch made against a .v64.
+func patchedROMHeader(romPath, patchPath string) (romHeader, error) {
+	rom, err := os.ReadFile(romPath)
+	if err != nil {
+		return romHeader{}, err
+	}
+	if len(rom) < sigLength {
+		return romHeader{}, fmt.Errorf("%s: too short to be an N64 ROM", romPath)
+	}
+	patch, err := os.ReadFile(patchPath)
+	if err != nil {
+		return romHeader{}, err
+	}
+	if romDAT != nil {
+		if err := romDAT.check(romPath); err != nil {
+			return romHeader{}, err
+		}
+	}
+
+	z64, swapped := rom, false
+	if magic := binary.BigEndian.Uint32(rom); magic != magicZ64 {
+		z64 = bytes.Clone(rom)
+		swapped = swapROM(z64, magic) == nil
+		if !swapped {
+			z64 = rom
+		}
+	}
+	patched, err := applyPatch(z64, patch)
+	if errors.Is(err, errPatchSource) && swapped {
+		patched, err = applyPatch(rom, patch)
+	}
+	if err != nil {
+		return romHeader{}, fmt.Errorf("%s: %w", patchPath, err)
+	}
+	if len(patched) < sigLength {
+		return romHeader{}, fmt.Errorf("%s: the patched ROM is too short to be an N64 ROM", patchPath)
+	}
+
+	b := patched[:sigLength]
+	if err := normalizeROM(b); err != nil {
+		return romHeader{}, fmt.Errorf("%s patched with %s: %w", romPath, patchPath, err)
+	}
+	return romHeader{
+		Signature: crc32.ChecksumIEEE(b),
+		Name:      romName(b),
+		Region:    regionName(b[romRegion]),
+	}, nil
+}
+
+// applyIPS applies an IPS patch: a list of records, each overwriting the bytes at a 24-bit offset, ended by "EOF" &
+// optionally followed by the length to truncate the ROM to
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	out := bytes.Clone(rom)
+	p := patch[len("PATCH"):]
+	for {
+		if len(p) < 3 {
+			return nil, errPatchCorrupt
+		}
+		off := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		p = p[3:]
+		if off == 0x454F46 { // "EOF"
+			break
+		}
+		if len(p) < 2 {
+			return nil, errPatchCorrupt
+		}
+		size := int(binary.BigEndian.Uint16(p))
+		p = p[2:]
+		var data []byte
+		if size == 0 { // run-length encoded
+			if len(p) < 3 {
+				return nil, errPatchCorrupt
+			}
+			data = bytes.Repeat(p[2:3], int(binary.BigEndian.Uint16(p)))
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, errPatchCorrupt
+			}
+			data, p = p[:size], p[size:]
+		}
+		if end := off + len(data); end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[off:], data)
+	}
+	if len(p) >= 3 {
+		if size := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// bpsReader reads the variable length numbers BPS patches use
+type bpsReader struct {
+	p   []byte
+	err error
+}
+
+func (r *bpsReader) number() int {
+	var n, shift uint64 = 0, 1
+	for {
+		if len(r.p) == 0 || shift > 1<<56 {
+			r.err = errPatchCorrupt
+			return 0
+		}
+		x := r.p[0]
+		r.p = r.p[1:]
+		n += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		n += shift
+	}
+	if n > 1<<31 {
+		r.err = errPatchCorrupt
+		return 0
+	}
+	return int(n)
+}
+
+// applyBPS applies a BPS patch, checking the CRC32s of the ROM, the result, & the patch itself that end it
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < len("BPS1")+12 {
+		return nil, errPatchCorrupt
+	}
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, errPatchCorrupt
+	}
+	if crc32.ChecksumIEEE(rom) != binary.LittleEndian.Uint32(footer) {
+		return nil, errPatchSource
+	}
+
+	r := &bpsReader{p: patch[len("BPS1") : len(patch)-12]}
+	sourceSize, targetSize, metaSize := r.number(), r.number(), r.number()
+	if r.err != nil || sourceSize != len(rom) || metaSize > len(r.p) {
+		return nil, errPatchCorrupt
+	}
+	r.p = r.p[metaSize:]
+
+	out := make([]byte, 0, targetSize)
+	var sourceRel, targetRel int
+	for len(r.p) > 0 {
+		action := r.number()
+		cmd, n := action&3, action>>2+1
+		switch cmd {
+		case 0: // SourceRead
+			if len(out)+n > len(rom) {
+				return nil, errPatchCorrupt
+			}
+			out = append(out, rom[len(out):len(out)+n]...)
+		case 1: // TargetRead
+			if n > len(r.p) {
+				return nil, errPatchCorrupt
+			}
+			out = append(out, r.p[:n]...)
+			r.p = r.p[n:]
+		case 2, 3: // SourceCopy & TargetCopy
+			d := r.number()
+			delta := d >> 1
+			if d&1 != 0 {
+				delta = -delta
+			}
+			if cmd == 2 {
+				sourceRel += delta
+				if sourceRel < 0 || sourceRel+n > len(rom) {
+					return nil, errPatchCorrupt
+				}
+				out = append(out, rom[sourceRel:sourceRel+n]...)
+				sourceRel += n
+				break
+			}
+			targetRel += delta
+			if targetRel < 0 || targetRel >= len(out) {
+				return nil, errPatchCorrupt
+			}
+			// The copy can overlap what it's writing, so it has to go a byte at a time
+			for range n {
+				out = append(out, out[targetRel])
+				targetRel++
+			}
+		}
+		if r.err != nil || len(out) > targetSize {
+			return nil, errPatchCorrupt
+		}
+	}
+	if len(out) != targetSize || crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(footer[4:]) {
+		return nil, errPatchCorrupt
+	}
+	return out, nil
+}
+
+// VCDIFF instruction types
+const (
+	vcdNoop = iota
+	vcdAdd
+	vcdRun
+	vcdCopy
+)
+
+// vcdInst is one half of an entry in a VCDIFF code table. A size of 0 means the size follows in the instructions.
+type vcdInst struct {
+	Type, Size, Mode byte
+}
+
+// vcdCodeTable is the default code table from RFC 3284 section 5.6, which maps each instruction byte to up to two
+// instructions
+var vcdCodeTable = func() (t [256][2]vcdInst) {
+	i := 0
+	next := func(a, b vcdInst) {
+		t[i] = [2]vcdInst{a, b}
+		i++
+	}
+	next(vcdInst{vcdRun, 0, 0}, vcdInst{})
+	for size := range byte(18) {
+		next(vcdInst{vcdAdd, size, 0}, vcdInst{})
+	}
+	for mode := range byte(9) {
+		next(vcdInst{vcdCopy, 0, mode}, vcdInst{})
+		for size := byte(4); size <= 18; size++ {
+			next(vcdInst{vcdCopy, size, mode}, vcdInst{})
+		}
+	}
+	for mode := range byte(9) {
+		for add := byte(1); add <= 4; add++ {
+			// The near modes pair with copies of 4 to 6 bytes & the same modes only with copies of 4
+			last := byte(6)
+			if mode >= 6 {
+				last = 4
+			}
+			for size := byte(4); size <= last; size++ {
+				next(vcdInst{vcdAdd, add, 0}, vcdInst{vcdCopy, size, mode})
+			}
+		}
+	}
+	for mode := range byte(9) {
+		next(vcdInst{vcdCopy, 4, mode}, vcdInst{vcdAdd, 1, 0})
+	}
+	return t
+}()
+
+// vcdReader reads the big endian base 128 numbers VCDIFF uses from one section of a window
+type vcdReader struct {
+	p   []byte
+	err error
+}
+
+func (r *vcdReader) byte() byte {
+	if len(r.p) == 0 {
+		r.err = errPatchCorrupt
+		return 0
+	}
+	b := r.p[0]
+	r.p = r.p[1:]
+	return b
+}
+
+func (r *vcdReader) number() int {
+	var n uint64
+	for range 5 {
+		b := r.byte()
+		n = n<<7 | uint64(b&0x7F)
+		if b&0x80 == 0 {
+			if n > 1<<31 {
+				r.err = errPatchCorrupt
+			}
+			return int(n)
+		}
+	}
+	r.err = errPatchCorrupt
+	return 0
+}
+
+func (r *vcdReader) bytes(n int) []byte {
+	if n > len(r.p) {
+		r.err = errPatchCorrupt
+		return nil
+	}
+	b := r.p[:n]
+	r.p = r.p[n:]
+	return b
+}
+
+// applyVCDIFF applies a VCDIFF patch, as written by xdelta3. xdelta3's Adler-32 checksum of each window is checked when
+// it's there.
+func applyVCDIFF(rom, patch []byte) ([]byte, error) {
+	r := &vcdReader{p: patch[4:]}
+	hdr := r.byte()
+	if hdr&0x01 != 0 {
+		return nil, errors.New("xdelta patches with secondary compression aren't supported; recreate it with -S none")
+	}
+	if hdr&0x02 != 0 {
+		return nil, errors.New("xdelta patches with a custom code table aren't supported")
+	}
+	if hdr&0x04 != 0 { // xdelta3's application header, naming the files the patch was made from
+		r.bytes(r.number())
+	}
+
+	out := make([]byte, 0, len(rom))
+	for r.err == nil && len(r.p) > 0 {
+		win := r.byte()
+		var source []byte
+		if win&0x03 != 0 {
+			size, pos := r.number(), r.number()
+			from := rom
+			if win&0x02 != 0 {
+				from = out
+			}
+			if r.err != nil || pos+size > len(from) {
+				return nil, errPatchCorrupt
+			}
+			source = from[pos : pos+size]
+		}
+		r.number() // the length of the rest of the window
+		targetSize := r.number()
+		if r.byte() != 0 {
+			return nil, errors.New("xdelta patches with secondary compression aren't supported; recreate it with -S none")
+		}
+		dataSize, instSize, addrSize := r.number(), r.number(), r.number()
+		var sum []byte
+		if win&0x04 != 0 {
+			sum = r.bytes(4)
+		}
+		data := &vcdReader{p: r.bytes(dataSize)}
+		inst := &vcdReader{p: r.bytes(instSize)}
+		addr := &vcdReader{p: r.bytes(addrSize)}
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		// The address caches start afresh in each window
+		var near [4]int
+		var same [3 * 256]int
+		nextNear := 0
+		target := make([]byte, 0, targetSize)
+		for len(inst.p) > 0 && inst.err == nil {
+			for _, in := range vcdCodeTable[inst.byte()] {
+				if in.Type == vcdNoop {
+					continue
+				}
+				size := int(in.Size)
+				if size == 0 {
+					size = inst.number()
+				}
+				if len(target)+size > targetSize {
+					return nil, errPatchCorrupt
+				}
+				switch in.Type {
+				case vcdAdd:
+					target = append(target, data.bytes(size)...)
+				case vcdRun:
+					target = append(target, bytes.Repeat([]byte{data.byte()}, size)...)
+				case vcdCopy:
+					here := len(source) + len(target)
+					var a int
+					switch m := int(in.Mode); {
+					case m == 0:
+						a = addr.number()
+					case m == 1:
+						a = here - addr.number()
+					case m < 6:
+						a = near[m-2] + addr.number()
+					default:
+						a = same[(m-6)*256+int(addr.byte())]
+					}
+					near[nextNear] = a
+					nextNear = (nextNear + 1) % len(near)
+					same[a%len(same)] = a
+					if a < 0 || a >= here {
+						return nil, errPatchCorrupt
+					}
+					// Addresses past the source segment are in the target window, & a copy from there can overlap
+					// what it's writing
+					for i := range size {
+						if a+i < len(source) {
+							target = append(target, source[a+i])
+						} else {
+							target = append(target, target[a+i-len(source)])
+						}
+					}
+				}
+				if data.err != nil || addr.err != nil {
+					return nil, errPatchCorrupt
+				}
+			}
+		}
+		if inst.err != nil || len(target) != targetSize {
+			return nil, errPatchCorrupt
+		}
+		if sum != nil && adler32.Checksum(target) != binary.BigEndian.Uint32(sum) {
+			return nil, errPatchSource
+		}
+		out = append(out, target...)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return out, nil
+}
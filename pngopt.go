@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// optimizedEncoder is used for -optimize, trading encoding time for the smallest output zlib can manage
+var optimizedEncoder = png.Encoder{CompressionLevel: png.BestCompression}
+
+// encodeOptimized is encodeSRGB for published art, where every byte saved is saved on each download. The image is
+// encoded as 8 bit grayscale if it's opaque & grey, & as a palette if it has 256 colours or fewer, as well as in full
+// colour, & whichever comes out smallest is kept. A reduced form is only used if it decodes back to exactly the same
+// pixels, so the result is always lossless.
+func encodeOptimized(w io.Writer, img *image.NRGBA) error {
+	candidates := []image.Image{img}
+	if g := grayVersion(img); g != nil {
+		candidates = append(candidates, g)
+	}
+	if p := palettedVersion(img); p != nil {
+		candidates = append(candidates, p)
+	}
+
+	var best []byte
+	for _, c := range candidates {
+		var buf bytes.Buffer
+		if err := optimizedEncoder.Encode(&buf, c); err != nil {
+			return err
+		}
+		if best != nil && buf.Len() >= len(best) {
+			continue
+		}
+		if c != image.Image(img) && !decodesTo(buf.Bytes(), img) {
+			continue
+		}
+		best = buf.Bytes()
+	}
+	_, err := w.Write(tagSRGB(best))
+	return err
+}
+
+// grayVersion returns img as a grayscale image, or nil if any of its pixels has colour or transparency
+func grayVersion(img *image.NRGBA) *image.Gray {
+	b := img.Bounds()
+	g := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A != 0xFF || c.R != c.G || c.G != c.B {
+				return nil
+			}
+			g.SetGray(x, y, color.Gray{Y: c.R})
+		}
+	}
+	return g
+}
+
+// palettedVersion returns img with a palette of its colours, or nil if it has more than 256
+func palettedVersion(img *image.NRGBA) *image.Paletted {
+	b := img.Bounds()
+	index := make(map[color.NRGBA]uint8)
+	var palette color.Palette
+	p := image.NewPaletted(b, nil)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			i, ok := index[c]
+			if !ok {
+				if len(palette) == 256 {
+					return nil
+				}
+				i = uint8(len(palette))
+				index[c] = i
+				palette = append(palette, c)
+			}
+			p.SetColorIndex(x, y, i)
+		}
+	}
+	p.Palette = palette
+	return p
+}
+
+// decodesTo reports whether the PNG in b decodes to exactly the pixels of img. PNG palettes are stored with straight
+// alpha, but Go converts them by way of premultiplied alpha, which can shift the colour of a translucent pixel slightly.
+func decodesTo(b []byte, img *image.NRGBA) bool {
+	d, err := png.Decode(bytes.NewReader(b))
+	if err != nil || d.Bounds() != img.Bounds() {
+		return false
+	}
+	r := img.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBAModel.Convert(d.At(x, y)) != img.NRGBAAt(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
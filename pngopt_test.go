@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// TestEncodeOptimized checks that optimised PNGs decode to exactly the original pixels & are never bigger than the
+// plain encoding, whichever form suits the image
+func TestEncodeOptimized(t *testing.T) {
+	r := image.Rect(0, 0, labelsdb.Width, labelsdb.Height)
+	images := map[string]func(x, y int) color.NRGBA{
+		"gray":        func(x, y int) color.NRGBA { v := uint8(x * 3); return color.NRGBA{v, v, v, 0xFF} },
+		"few colours": func(x, y int) color.NRGBA { return color.NRGBA{uint8(x / 8 * 20), 0x40, uint8(y / 8 * 20), 0xFF} },
+		"translucent": func(x, y int) color.NRGBA { return color.NRGBA{0xC0, 0x30, 0x11, uint8(y)} },
+		"full colour": func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 3), uint8(y * 3), uint8(x ^ y), 0xFF} },
+	}
+	for name, at := range images {
+		img := image.NewNRGBA(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.SetNRGBA(x, y, at(x, y))
+			}
+		}
+
+		var plain, opt bytes.Buffer
+		if err := encodeSRGB(&plain, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := encodeOptimized(&opt, img); err != nil {
+			t.Fatal(err)
+		}
+		if opt.Len() > plain.Len() {
+			t.Errorf("%s: optimised PNG is %d bytes, more than the plain %d", name, opt.Len(), plain.Len())
+		}
+		if !decodesTo(opt.Bytes(), img) {
+			t.Errorf("%s: optimised PNG doesn't decode to the original pixels", name)
+		}
+		if _, err := png.Decode(&opt); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// postUpdate compares the labels.db installed by a firmware update against the copy the user had beforehand & reports
+// what the update added, what it changed, & which of the user's entries no longer exist. Where either file has an undo
+// journal, the changed & removed entries are split into the user's labels & stock art. With -reapply, the user's labels
+// are then copied back in.
+func postUpdate(args []string) error {
+	fs := flag.NewFlagSet("post-update", flag.ExitOnError)
+	previous := fs.String("previous", "", "the labels.db from before the firmware update")
+	reapplyNow := fs.Bool("reapply", false, "copy your labels back in: the entries the update removed or changed "+
+		"that the undo journal shows you wrote, or without one every removed entry")
+	backup := fs.Bool("backup", false, "keep a copy of the new labels.db as labels.db.bak before modifying it")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *previous == "" {
+		return errors.New("usage: post-update {labels.db} -previous {old labels.db} [-reapply] [-backup]")
+	}
+
+	oldDB, err := labelsdb.Open(*previous)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *previous, err)
+	}
+	newDB, err := labelsdb.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", args[0], err)
+	}
+
+	d := labelsdb.Compare(oldDB, newDB)
+	custom, err := customisedSigs(args[0], *previous)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s entries before the update, %s after. %s unchanged.\n", formatCount(oldDB.Len()),
+		formatCount(newDB.Len()), formatCount(d.Unchanged))
+	printSigs("New stock entries added by the update", d.Added)
+	var overwritten []uint32
+	if custom == nil {
+		// There's no way of knowing from the files alone whether a changed entry was stock art the update improved or a
+		// custom label the update overwrote, so report them together & let the user decide.
+		printSigs("Entries changed by the update (updated stock art, or your custom labels overwritten)", d.Changed)
+	} else {
+		var stock []uint32
+		for _, s := range d.Changed {
+			if custom[s] {
+				overwritten = append(overwritten, s)
+			} else {
+				stock = append(stock, s)
+			}
+		}
+		printSigs("Your custom labels overwritten by the update", overwritten)
+		printSigs("Stock art updated by the update", stock)
+	}
+	mine := d.Removed
+	if custom == nil {
+		printSigs("Entries removed by the update (most likely labels you added yourself)", d.Removed)
+	} else {
+		var stock []uint32
+		mine = nil
+		for _, s := range d.Removed {
+			if custom[s] {
+				mine = append(mine, s)
+			} else {
+				stock = append(stock, s)
+			}
+		}
+		printSigs("Your custom labels removed by the update", mine)
+		printSigs("Stock entries removed by the update", stock)
+	}
+
+	restore := slices.Sorted(slices.Values(slices.Concat(mine, overwritten)))
+	if !*reapplyNow {
+		switch {
+		case len(restore) > 0:
+			fmt.Println("\nTo restore your labels, rerun with -reapply, or run reapply with the same arguments.")
+		case len(d.Changed) > 0 || len(d.Removed) > 0:
+			fmt.Println("\nTo restore your labels, run reapply with the same arguments & -changed or -removed.")
+		}
+		return nil
+	}
+	if len(restore) == 0 {
+		log.Printf("Nothing to re-apply to %s", args[0])
+		return nil
+	}
+	if *backup {
+		if err := backupFile(args[0], args[0]+".bak"); err != nil {
+			return err
+		}
+	}
+	return reapplyEntries(args[0], oldDB, newDB, restore)
+}
+
+// printDiff prints a summary of the differences between two databases with before & after entries
+func printDiff(d labelsdb.Diff, before, after int) {
+	fmt.Printf("%s entries before, %s after. %s unchanged.\n", formatCount(before), formatCount(after),
+		formatCount(d.Unchanged))
+	printSigs("Added", d.Added)
+	printSigs("Replaced", d.Changed)
+	printSigs("Removed", d.Removed)
+}
+
+// printSigs prints a heading followed by one signature per line. Nothing is printed if sigs is empty.
+func printSigs(heading string, sigs []uint32) {
+	if len(sigs) == 0 {
+		return
+	}
+	fmt.Printf("\n%s (%s):\n", heading, formatCount(len(sigs)))
+	for _, s := range sigs {
+		fmt.Printf("  %08X\n", s)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+)
+
+// profileKinds are what -profile can record
+var profileKinds = []string{"cpu", "mem", "trace"}
+
+var (
+	// profileKind & profileFile are set by -profile & -profile-file
+	profileKind, profileFile string
+	// stopProfiling finishes the profile being recorded & writes it out. It's nil when nothing is being recorded.
+	stopProfiling func()
+)
+
+// startProfiling starts recording the profile asked for with -profile, if any. It's only started once, so a command
+// run from inside another one doesn't restart it.
+func startProfiling() error {
+	if profileKind == "" || stopProfiling != nil {
+		return nil
+	}
+	if !slices.Contains(profileKinds, profileKind) {
+		return fmt.Errorf("unknown profile %q, expected one of cpu, mem, or trace", profileKind)
+	}
+	path := profileFile
+	if path == "" {
+		path = "a3dlabels." + profileKind + ".pprof"
+		if profileKind == "trace" {
+			path = "a3dlabels.trace"
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch profileKind {
+	case "cpu":
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+	case "trace":
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			return err
+		}
+	case "mem":
+		// Every allocation is sampled rather than one every 512KiB, so that the many small ones made while converting
+		// images show up. It slows things down, but only while profiling.
+		runtime.MemProfileRate = 1
+	}
+	stopProfiling = func() {
+		switch profileKind {
+		case "cpu":
+			pprof.StopCPUProfile()
+		case "trace":
+			trace.Stop()
+		case "mem":
+			// The allocs profile covers everything allocated over the whole run, not just what's still live at the end
+			if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+				log.Printf("Writing the memory profile: %v", err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			log.Printf("Writing the %s profile: %v", profileKind, err)
+			return
+		}
+		log.Printf("Wrote the %s profile to %s", profileKind, path)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// presets are named bundles of the scaling, alpha, & sharpening flags, tuned for the common kinds of art so that a
+// good result doesn't need every flag learned first. Flags given explicitly override the preset's.
+var presets = map[string]map[string]string{
+	// Box art is usually wider than the label & has a bad crop or scanner edge, so it's letterboxed whole rather than
+	// trimmed, & sharpened a little since it's shrunk a long way
+	"boxart": {
+		"scale-mode":      "fit",
+		"filter":          "lanczos",
+		"pad-color":       "000000",
+		"auto-trim-edges": "true",
+		"sharpen":         "0.6",
+	},
+	// Cartridge label scans are already close to the label's shape, so they fill it & get the cartridge's corners
+	"cart-label": {
+		"scale-mode":      "fill",
+		"filter":          "lanczos",
+		"auto-trim-edges": "true",
+		"rounded-corners": "true",
+		"sharpen":         "0.4",
+	},
+	// Logos are mostly transparent with hard edges, so they're fitted without sharpening, which would give them halos,
+	// & flattened onto black
+	"minimal-logo": {
+		"scale-mode": "fit",
+		"filter":     "catmullrom",
+		"pad-color":  "000000",
+		"alpha":      "flatten",
+		"background": "000000",
+	},
+}
+
+// registerPreset adds the -preset flag to fs
+func registerPreset(fs *flag.FlagSet) *string {
+	return fs.String("preset", "", "start from a preset for the kind of art: "+
+		strings.Join(slices.Sorted(maps.Keys(presets)), ", ")+"; flags given as well override it")
+}
+
+// applyPreset sets the flags in fs that the named preset covers, apart from any that were given explicitly. It's called
+// after the flags are parsed.
+func applyPreset(fs *flag.FlagSet, name string) error {
+	if name == "" {
+		return nil
+	}
+	p, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, expected one of %s", name,
+			strings.Join(slices.Sorted(maps.Keys(presets)), ", "))
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, flagName := range slices.Sorted(maps.Keys(p)) {
+		if explicit[flagName] || fs.Lookup(flagName) == nil {
+			continue
+		}
+		if err := fs.Set(flagName, p[flagName]); err != nil {
+			return fmt.Errorf("preset %s: -%s: %w", name, flagName, err)
+		}
+	}
+	return nil
+}
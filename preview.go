@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// sheetGap is the space in pixels between the cells of a contact sheet & around its edge
+const sheetGap = 8
+
+var (
+	sheetBackground = color.NRGBA{0x20, 0x20, 0x20, 0xFF}
+	sheetSignature  = color.NRGBA{0xE0, 0xE0, 0xE0, 0xFF}
+	sheetTitle      = color.NRGBA{0xA0, 0xA0, 0xA0, 0xFF}
+)
+
+// preview implements `preview {labels.db} -o {sheet.png} [signature...]`, rendering the labels into a single contact
+// sheet captioned with their signatures & titles. It's much quicker to check a whole pack this way than by copying it
+// to the SD card & rebooting the console.
+func preview(args []string) error {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	out := fs.String("o", "preview.png", "the PNG to write the contact sheet to")
+	columns := fs.Int("columns", 10, "the number of labels in each row")
+	scale := fs.Int("scale", 2, "how many times bigger than their real size to draw the labels")
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || *columns < 1 || *scale < 1 || (*dat != "" && *roms == "") {
+		return errors.New("usage: preview {labels.db} [signature...] [-o preview.png] [-columns n] [-scale n] " +
+			"[-roms roms.idx [-dat file]] [-titles file]")
+	}
+
+	want := make([]uint32, 0, len(args)-1)
+	for _, a := range args[1:] {
+		sig, err := HexStringTransform(a)
+		if err != nil {
+			return err
+		}
+		want = append(want, sig)
+	}
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]labelsdb.Entry, 0, db.Len())
+	for _, e := range db.Entries() {
+		if len(want) == 0 || slices.Contains(want, e.Signature) {
+			entries = append(entries, e)
+		}
+	}
+	for _, sig := range want {
+		if !db.Contains(sig) {
+			log.Printf("%08X is not in %s", sig, args[0])
+		}
+	}
+	if len(entries) == 0 {
+		return errors.New("nothing to preview")
+	}
+
+	sheet := contactSheet(entries, titles, min(*columns, len(entries)), *scale)
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := encodeSRGB(f, sheet); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Wrote a contact sheet of %s labels to %s", formatCount(len(entries)), *out)
+	return nil
+}
+
+// contactSheet lays the entries out in a grid, each label drawn scale times its real size with its signature & title
+// underneath
+func contactSheet(entries []labelsdb.Entry, titles titleLookup, columns, scale int) *image.NRGBA {
+	face := basicfont.Face7x13
+	labelW, labelH := labelsdb.Width*scale, labelsdb.Height*scale
+	cellW, cellH := labelW+sheetGap, labelH+2*face.Height+sheetGap
+	rows := (len(entries) + columns - 1) / columns
+	sheet := image.NewNRGBA(image.Rect(0, 0, columns*cellW+sheetGap, rows*cellH+sheetGap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(sheetBackground), image.Point{}, draw.Src)
+
+	chars := labelW / face.Advance
+	for i, e := range entries {
+		at := image.Pt(sheetGap+(i%columns)*cellW, sheetGap+(i/columns)*cellH)
+		// Nearest neighbour, so that the preview shows exactly the pixels the console will
+		label := imaging.Resize(labelsdb.Decode(e.Data), labelW, labelH, imaging.NearestNeighbor)
+		draw.Draw(sheet, image.Rectangle{at, at.Add(label.Bounds().Size())}, label, image.Point{}, draw.Over)
+
+		captions := []struct {
+			text string
+			c    color.NRGBA
+		}{
+			{fmt.Sprintf("%08X", e.Signature), sheetSignature},
+			{truncate(titles[e.Signature].Title, chars), sheetTitle},
+		}
+		for n, caption := range captions {
+			d := &font.Drawer{Dst: sheet, Src: image.NewUniform(caption.c), Face: face}
+			d.Dot = fixed.P(at.X, at.Y+labelH+n*face.Height+face.Ascent)
+			d.DrawString(caption.text)
+		}
+	}
+	return sheet
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// pointsPerMM converts millimetres to PDF points
+const pointsPerMM = 72 / 25.4
+
+// paperSizes are the page sizes print knows, in millimetres
+var paperSizes = map[string][2]float64{
+	"a4":     {210, 297},
+	"letter": {215.9, 279.4},
+}
+
+// sheetLayout is how labels are placed on the pages of a printed sheet. Every measurement is in millimetres.
+type sheetLayout struct {
+	PageW, PageH   float64
+	LabelW, LabelH float64
+	// Margin is kept clear around the edge of the page, & Gap between labels. The cut marks are drawn in the gap.
+	Margin, Gap float64
+}
+
+// perPage returns the number of columns & rows of labels that fit on a page
+func (l sheetLayout) perPage() (int, int) {
+	cols := int((l.PageW - 2*l.Margin + l.Gap) / (l.LabelW + l.Gap))
+	rows := int((l.PageH - 2*l.Margin + l.Gap) / (l.LabelH + l.Gap))
+	return cols, rows
+}
+
+// printSheet implements `print {labels.db} -o {sheet.pdf} [signature...]`, laying the labels out at their physical size
+// with cut marks, for printing replacement stickers that match the art on the console
+func printSheet(args []string) error {
+	fs := flag.NewFlagSet("print", flag.ExitOnError)
+	out := fs.String("o", "labels.pdf", "the PDF to write")
+	sigList := fs.String("sigs", "", "a comma separated list of signatures to print, as well as any given as arguments")
+	paper := fs.String("paper", "a4", "the page size: a4 or letter")
+	// A millimetre per pixel is close to the size of an N64 cartridge's front label
+	width := fs.Float64("width", labelsdb.Width, "the printed width of each label in millimetres")
+	height := fs.Float64("height", labelsdb.Height, "the printed height of each label in millimetres")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	size, ok := paperSizes[strings.ToLower(*paper)]
+	if len(args) < 1 || !ok || *width <= 0 || *height <= 0 {
+		return errors.New("usage: print {labels.db} [signature...] [-sigs sig,sig...] [-o labels.pdf] " +
+			"[-paper a4|letter] [-width mm] [-height mm]")
+	}
+	layout := sheetLayout{PageW: size[0], PageH: size[1], LabelW: *width, LabelH: *height, Margin: 10, Gap: 6}
+	if cols, rows := layout.perPage(); cols < 1 || rows < 1 {
+		return fmt.Errorf("a %gx%gmm label doesn't fit on %s paper", *width, *height, *paper)
+	}
+
+	want, err := parseSignatures(args[1:], *sigList)
+	if err != nil {
+		return err
+	}
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	entries := make([]labelsdb.Entry, 0, db.Len())
+	for _, e := range db.Entries() {
+		if len(want) == 0 || slices.Contains(want, e.Signature) {
+			entries = append(entries, e)
+		}
+	}
+	for _, sig := range want {
+		if !db.Contains(sig) {
+			log.Printf("%08X is not in %s", sig, args[0])
+		}
+	}
+	if len(entries) == 0 {
+		return errors.New("nothing to print")
+	}
+
+	var buf bytes.Buffer
+	pages, err := writeLabelPDF(&buf, entries, layout)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, buf.Bytes()); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s labels on %s pages to %s", formatCount(len(entries)), formatCount(pages), *out)
+	return nil
+}
+
+// parseSignatures returns the signatures given as arguments followed by those in a comma separated list
+func parseSignatures(args []string, list string) ([]uint32, error) {
+	if list != "" {
+		args = append(args, strings.Split(list, ",")...)
+	}
+	sigs := make([]uint32, 0, len(args))
+	for _, a := range args {
+		sig, err := HexStringTransform(a)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// pdfWriter writes the numbered objects of a PDF, keeping track of where each one starts for the xref table
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+// object writes object n. If stream isn't nil it's appended as the object's stream & its length added to dict.
+func (p *pdfWriter) object(n int, dict string, stream []byte) {
+	p.offsets[n] = p.buf.Len()
+	if stream == nil {
+		fmt.Fprintf(&p.buf, "%d 0 obj\n%s\nendobj\n", n, dict)
+		return
+	}
+	fmt.Fprintf(&p.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(stream))
+	p.buf.Write(stream)
+	p.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// writeLabelPDF writes the entries to w as a PDF laid out by layout, returning the number of pages. Each label is
+// embedded once as an image drawn without smoothing, composited onto white since paper has no alpha channel.
+func writeLabelPDF(w io.Writer, entries []labelsdb.Entry, layout sheetLayout) (int, error) {
+	cols, rows := layout.perPage()
+	pages := (len(entries) + cols*rows - 1) / (cols * rows)
+
+	// Objects 1 & 2 are the catalog & page tree, then one per image, then a page & its contents for each page
+	p := &pdfWriter{offsets: make(map[int]int)}
+	p.buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	firstImage, firstPage := 3, 3+len(entries)
+
+	for i, e := range entries {
+		data, err := labelRGB(e.Data)
+		if err != nil {
+			return 0, err
+		}
+		p.object(firstImage+i, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB "+
+			"/BitsPerComponent 8 /Interpolate false /Filter /FlateDecode", labelsdb.Width, labelsdb.Height), data)
+	}
+
+	mm := func(v float64) string { return fmt.Sprintf("%.2f", v*pointsPerMM) }
+	kids := make([]string, pages)
+	for page := range pages {
+		var content strings.Builder
+		content.WriteString("0.25 w 0 G\n")
+		for slot := range cols * rows {
+			i := page*cols*rows + slot
+			if i >= len(entries) {
+				break
+			}
+			// PDF coordinates start at the bottom left, but the labels are laid out from the top
+			x := layout.Margin + float64(slot%cols)*(layout.LabelW+layout.Gap)
+			y := layout.PageH - layout.Margin - float64(slot/cols+1)*layout.LabelH - float64(slot/cols)*layout.Gap
+			fmt.Fprintf(&content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", mm(layout.LabelW), mm(layout.LabelH), mm(x),
+				mm(y), i)
+			writeCutMarks(&content, x, y, layout.LabelW, layout.LabelH, layout.Gap/2, mm)
+		}
+
+		images := make([]string, 0, cols*rows)
+		for i := page * cols * rows; i < min(len(entries), (page+1)*cols*rows); i++ {
+			images = append(images, fmt.Sprintf("/Im%d %d 0 R", i, firstImage+i))
+		}
+		pageObj, contentObj := firstPage+2*page, firstPage+2*page+1
+		p.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R "+
+			"/Resources << /XObject << %s >> >> >>", mm(layout.PageW), mm(layout.PageH), contentObj,
+			strings.Join(images, " ")), nil)
+		p.object(contentObj, "", []byte(content.String()))
+		kids[page] = fmt.Sprintf("%d 0 R", pageObj)
+	}
+	p.object(1, "<< /Type /Catalog /Pages 2 0 R >>", nil)
+	p.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages), nil)
+
+	xref := p.buf.Len()
+	count := firstPage + 2*pages
+	fmt.Fprintf(&p.buf, "xref\n0 %d\n0000000000 65535 f \n", count)
+	for n := 1; n < count; n++ {
+		fmt.Fprintf(&p.buf, "%010d 00000 n \n", p.offsets[n])
+	}
+	fmt.Fprintf(&p.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", count, xref)
+
+	_, err := p.buf.WriteTo(w)
+	return pages, err
+}
+
+// writeCutMarks draws a short line out from each corner of the label at x, y, in both directions, starting a
+// millimetre clear of the label so the marks don't show if the cut is slightly off. length is how far they reach.
+func writeCutMarks(w io.Writer, x, y, width, height, length float64, mm func(float64) string) {
+	for _, cx := range []float64{x, x + width} {
+		for _, cy := range []float64{y, y + height} {
+			dx, dy := 1.0, 1.0
+			if cx == x {
+				dx = -1
+			}
+			if cy == y {
+				dy = -1
+			}
+			fmt.Fprintf(w, "%s %s m %s %s l S\n", mm(cx+dx), mm(cy), mm(cx+dx*length), mm(cy))
+			fmt.Fprintf(w, "%s %s m %s %s l S\n", mm(cx), mm(cy+dy), mm(cx), mm(cy+dy*length))
+		}
+	}
+}
+
+// labelRGB converts an entry to deflated RGB rows, blending any transparency onto white
+func labelRGB(entry []byte) ([]byte, error) {
+	img := labelsdb.Decode(entry)
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	row := make([]byte, 0, labelsdb.Width*3)
+	for y := range labelsdb.Height {
+		row = row[:0]
+		for x := range labelsdb.Width {
+			c := img.NRGBAAt(x, y)
+			for _, v := range []uint8{c.R, c.G, c.B} {
+				row = append(row, uint8((int(v)*int(c.A)+0xFF*(0xFF-int(c.A)))/0xFF))
+			}
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
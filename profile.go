@@ -0,0 +1,288 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// profile is a file declaring a collection of art once & every image store it should be written to, each with its own
+// processing. Only labels.db is a store today, but the other image stores Analogue devices keep are meant to become
+// further target kinds, so that the same curated collection can drive them all.
+type profile struct {
+	// Images are the sources shared by every target, given the same way as to add: images named after their
+	// signature, manifests, directories of ROMs & images, or URLs. Relative paths are relative to the profile.
+	Images  []string        `json:"images"`
+	Targets []profileTarget `json:"targets"`
+}
+
+// profileTarget is a store a profile is written to, along with how to process the images for it. Every processing
+// setting is optional & defaults to the same as the flag for add.
+type profileTarget struct {
+	// Name identifies the target for -only & in the log. Defaults to the path.
+	Name string `json:"name"`
+	// Kind is one of targetKinds. Defaults to labels.
+	Kind string `json:"kind"`
+	// Path is where the store is. Relative paths are relative to the profile.
+	Path string `json:"path"`
+	// Images are added to this target only, after the shared ones
+	Images         []string `json:"images"`
+	ScaleMode      string   `json:"scale_mode"`
+	Filter         string   `json:"filter"`
+	PadColor       string   `json:"pad_color"`
+	AutoTrimEdges  bool     `json:"auto_trim_edges"`
+	Alpha          string   `json:"alpha"`
+	Background     string   `json:"background"`
+	RoundedCorners bool     `json:"rounded_corners"`
+	Padding        string   `json:"padding"`
+	NoCalibration  bool     `json:"no_calibration"`
+}
+
+// targetKinds are the kinds of store a profile can target, & how images are written to each
+var targetKinds = map[string]func(path string, imgs []Image, settings addSettings) error{
+	"labels": func(path string, imgs []Image, settings addSettings) error {
+		skipped, err := addImages(path, imgs, settings)
+		if err == nil && skipped > 0 {
+			err = fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+		}
+		return err
+	},
+}
+
+// loadProfile reads a profile & makes its paths absolute. Keys it doesn't know are ignored, so that a profile written
+// for a newer version still works with the targets this one does know.
+func loadProfile(path string) (*profile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p profile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(p.Targets) == 0 {
+		return nil, fmt.Errorf("%s has no targets", path)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if isRemote(p) || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, filepath.FromSlash(p))
+	}
+	for i, src := range p.Images {
+		p.Images[i] = resolve(src)
+	}
+	for i := range p.Targets {
+		t := &p.Targets[i]
+		if t.Path == "" {
+			return nil, fmt.Errorf("%s: target %d has no path", path, i+1)
+		}
+		t.Path = resolve(t.Path)
+		if t.Name == "" {
+			t.Name = t.Path
+		}
+		if t.Kind == "" {
+			t.Kind = "labels"
+		}
+		for j, src := range t.Images {
+			t.Images[j] = resolve(src)
+		}
+	}
+	return &p, nil
+}
+
+// settings returns the addSettings for writing to the target, filling in the defaults for anything it leaves out
+func (t profileTarget) settings() (addSettings, error) {
+	s := addSettings{PreservePadding: true}
+	s.Scale = scaleOptions{Mode: t.ScaleMode, Filter: t.Filter, TrimEdges: t.AutoTrimEdges}
+	if s.Scale.Mode == "" {
+		s.Scale.Mode = "stretch"
+	}
+	if s.Scale.Filter == "" {
+		s.Scale.Filter = "lanczos"
+	}
+	if err := s.Scale.validate(); err != nil {
+		return s, err
+	}
+	var err error
+	if s.Scale.PadColor, err = parseColor(cmp.Or(t.PadColor, "000000")); err != nil {
+		return s, err
+	}
+	s.Alpha = alphaOptions{Mode: cmp.Or(t.Alpha, "keep"), RoundedCorners: t.RoundedCorners}
+	if s.Alpha.Background, err = parseColor(cmp.Or(t.Background, "000000")); err != nil {
+		return s, err
+	}
+	if err := s.Alpha.validate(); err != nil {
+		return s, err
+	}
+	if t.Padding != "" && t.Padding != "auto" {
+		if s.Padding, err = parsePadding(t.Padding); err != nil {
+			return s, err
+		}
+	}
+	if !t.NoCalibration {
+		if s.Colour, err = loadColourProfile(); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// runProfile implements `profile {profile.json}`, which writes the collection a profile declares to all of its targets
+// at once, converting each image only once for the targets that process it the same way. A target that fails is
+// reported without stopping the others, so an unplugged or flaky card doesn't hold up the rest, & the outcome for each
+// target is summarised at the end.
+func runProfile(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	only := fs.String("only", "", "a comma separated list of the targets to write, by name (default all of them)")
+	dryRun := fs.Bool("dry-run", false, "convert everything & show what would change, without writing anything")
+	noCache := fs.Bool("no-cache", false, "convert every image from scratch instead of reusing earlier conversions")
+	sequential := fs.Bool("sequential", false, "write the targets one after another rather than at the same time")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: profile {profile.json} [-only name,name...] [-dry-run] [-sequential] [flags]")
+	}
+	p, err := loadProfile(args[0])
+	if err != nil {
+		return err
+	}
+	notePack(args[0])
+
+	var names []string
+	if *only != "" {
+		names = strings.Split(*only, ",")
+		for _, n := range names {
+			if !slices.ContainsFunc(p.Targets, func(t profileTarget) bool { return t.Name == n }) {
+				return fmt.Errorf("%s has no target named %q", args[0], n)
+			}
+		}
+	}
+	var store *entryStore
+	if !*noCache {
+		if dir, err := defaultStoreDir(); err != nil {
+			log.Printf("Not caching conversions: %v", err)
+		} else if store, err = openStore(dir); err != nil {
+			log.Printf("Not caching conversions: %v", err)
+		}
+	}
+	shared, err := generateListFromArgs(p.Images)
+	if err != nil {
+		return err
+	}
+
+	var selected []profileTarget
+	for _, t := range p.Targets {
+		if names == nil || slices.Contains(names, t.Name) {
+			selected = append(selected, t)
+		}
+	}
+	// Targets are written at the same time, apart from ones that share a path, which are written in order so they
+	// don't overwrite each other. A dry run goes one at a time so its diffs don't get mixed up.
+	var batches [][]int
+	batchOf := make(map[string]int)
+	for i, t := range selected {
+		key := t.Path
+		if *sequential || *dryRun {
+			key = ""
+		}
+		b, ok := batchOf[key]
+		if !ok {
+			b = len(batches)
+			batchOf[key] = b
+			batches = append(batches, nil)
+		}
+		batches[b] = append(batches[b], i)
+	}
+	memo := newConversionMemo()
+	results := make([]error, len(selected))
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Go(func() {
+			for _, i := range batch {
+				results[i] = runTarget(selected[i], shared, store, memo, policy, *dryRun)
+			}
+		})
+	}
+	wg.Wait()
+
+	failed := 0
+	table := newTable(os.Stdout, "Target", "Result")
+	for i, t := range selected {
+		var skip *skippedTarget
+		switch err := results[i]; {
+		case errors.As(err, &skip):
+			table.row(t.Name, "skipped: "+err.Error())
+		case err != nil:
+			failed++
+			table.row(t.Name, "failed: "+err.Error())
+		case *dryRun:
+			table.row(t.Name, "checked")
+		default:
+			table.row(t.Name, "written")
+		}
+	}
+	if err := table.flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed", failed, len(selected))
+	}
+	return nil
+}
+
+// skippedTarget is returned for a target this version can't write
+type skippedTarget struct {
+	kind string
+}
+
+func (e *skippedTarget) Error() string {
+	return fmt.Sprintf("%q targets aren't supported by this version", e.kind)
+}
+
+// runTarget writes to t, logging the outcome. Targets of a kind that isn't in targetKinds are skipped.
+func runTarget(t profileTarget, shared []Image, store *entryStore, memo *conversionMemo, policy confirmPolicy,
+	dryRun bool) error {
+	write, ok := targetKinds[t.Kind]
+	if !ok {
+		err := &skippedTarget{t.Kind}
+		log.Printf("Skipping %s: %v", t.Name, err)
+		return err
+	}
+	log.Printf("Writing to %s", t.Name)
+	policy.Target = t.Name
+	err := writeTarget(t, write, shared, store, memo, policy, dryRun)
+	if err != nil {
+		log.Printf("%s: %v", t.Name, err)
+	}
+	return err
+}
+
+// writeTarget writes the shared images & the target's own to t
+func writeTarget(t profileTarget, write func(string, []Image, addSettings) error, shared []Image, store *entryStore,
+	memo *conversionMemo, policy confirmPolicy, dryRun bool) error {
+	settings, err := t.settings()
+	if err != nil {
+		return err
+	}
+	settings.Store, settings.Memo, settings.Policy, settings.DryRun = store, memo, policy, dryRun
+	own, err := generateListFromArgs(t.Images)
+	if err != nil {
+		return err
+	}
+	return write(t.Path, append(slices.Clone(shared), own...), settings)
+}
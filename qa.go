@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// qaThresholds are the quality checks every source image has to pass before it's added, set in the config file. A zero
+// threshold isn't checked.
+type qaThresholds struct {
+	// MinWidth & MinHeight are the smallest source image allowed, from qa_min_resolution
+	MinWidth  int `json:"min_width,omitempty"`
+	MinHeight int `json:"min_height,omitempty"`
+	// MaxAspectDeviation is how far the source's aspect ratio may be from the label's, as a percentage
+	MaxAspectDeviation float64 `json:"max_aspect_deviation,omitempty"`
+	// MinSharpness is the lowest detail score allowed for the image at label size, as reported by curate
+	MinSharpness float64 `json:"min_sharpness,omitempty"`
+}
+
+// isZero returns true if no thresholds are set
+func (t qaThresholds) isZero() bool {
+	return t == qaThresholds{}
+}
+
+// loadQAThresholds reads the thresholds from the config file's qa_min_resolution, qa_max_aspect_deviation, &
+// qa_min_sharpness
+func loadQAThresholds() (qaThresholds, error) {
+	var t qaThresholds
+	cfg, err := loadConfig()
+	if err != nil {
+		return t, err
+	}
+	if v := cfg["qa_min_resolution"]; len(v) > 0 {
+		w, h, ok := strings.Cut(v[0], "x")
+		if t.MinWidth, err = strconv.Atoi(w); err != nil || !ok {
+			return t, fmt.Errorf("qa_min_resolution: expected WIDTHxHEIGHT, got %q", v[0])
+		}
+		if t.MinHeight, err = strconv.Atoi(h); err != nil {
+			return t, fmt.Errorf("qa_min_resolution: expected WIDTHxHEIGHT, got %q", v[0])
+		}
+	}
+	if v := cfg["qa_max_aspect_deviation"]; len(v) > 0 {
+		if t.MaxAspectDeviation, err = strconv.ParseFloat(strings.TrimSuffix(v[0], "%"), 64); err != nil {
+			return t, fmt.Errorf("qa_max_aspect_deviation: %w", err)
+		}
+	}
+	if v := cfg["qa_min_sharpness"]; len(v) > 0 {
+		if t.MinSharpness, err = strconv.ParseFloat(v[0], 64); err != nil {
+			return t, fmt.Errorf("qa_min_sharpness: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// qaResult is the measurements taken of one source image & the thresholds it failed, if any
+type qaResult struct {
+	Signature       hexSig   `json:"signature"`
+	File            string   `json:"file"`
+	Width           int      `json:"width"`
+	Height          int      `json:"height"`
+	AspectDeviation float64  `json:"aspect_deviation"`
+	Sharpness       float64  `json:"sharpness"`
+	Passed          bool     `json:"passed"`
+	Failures        []string `json:"failures,omitempty"`
+}
+
+// qaRun checks images against the thresholds as they're added & collects the results for the QA report
+type qaRun struct {
+	Database   string       `json:"database"`
+	Time       time.Time    `json:"time"`
+	Thresholds qaThresholds `json:"thresholds"`
+	Checked    int          `json:"checked"`
+	Failed     int          `json:"failed"`
+	Images     []qaResult   `json:"images"`
+}
+
+// newQARun returns a run checking against t, or nil if no thresholds are set so nothing needs checking
+func newQARun(labelsDB string, t qaThresholds) *qaRun {
+	if t.isZero() {
+		return nil
+	}
+	return &qaRun{Database: labelsDB, Time: time.Now().UTC().Truncate(time.Second), Thresholds: t,
+		Images: []qaResult{}}
+}
+
+// check measures img's source & records the result, returning the thresholds it failed. An image that can't be read
+// isn't recorded, since it will fail to load anyway. Like the addReport methods, it does nothing on a nil run.
+func (q *qaRun) check(img Image) []string {
+	if q == nil {
+		return nil
+	}
+	src, err := getImg(img.Filepath)
+	if err != nil {
+		return nil
+	}
+	b := src.Bounds()
+	r := qaResult{Signature: hexSig(img.Signature), File: img.Filepath, Width: b.Dx(), Height: b.Dy()}
+	label := float64(labelsdb.Width) / float64(labelsdb.Height)
+	r.AspectDeviation = math.Round(math.Abs(float64(b.Dx())/float64(b.Dy())/label-1)*1000) / 10
+	r.Sharpness = math.Round(detailScore(imaging.Resize(src, labelsdb.Width, labelsdb.Height, imaging.Lanczos))*10) / 10
+
+	t := q.Thresholds
+	if (t.MinWidth > 0 && r.Width < t.MinWidth) || (t.MinHeight > 0 && r.Height < t.MinHeight) {
+		r.Failures = append(r.Failures, fmt.Sprintf("%dx%d is smaller than %dx%d", r.Width, r.Height, t.MinWidth,
+			t.MinHeight))
+	}
+	if t.MaxAspectDeviation > 0 && r.AspectDeviation > t.MaxAspectDeviation {
+		r.Failures = append(r.Failures, fmt.Sprintf("aspect ratio is %.1f%% off the label's, more than %g%%",
+			r.AspectDeviation, t.MaxAspectDeviation))
+	}
+	if t.MinSharpness > 0 && r.Sharpness < t.MinSharpness {
+		r.Failures = append(r.Failures, fmt.Sprintf("sharpness %.1f is below %g", r.Sharpness, t.MinSharpness))
+	}
+	r.Passed = len(r.Failures) == 0
+	q.Checked++
+	if !r.Passed {
+		q.Failed++
+	}
+	q.Images = append(q.Images, r)
+	return r.Failures
+}
+
+// write saves the QA report to path as JSON
+func (q *qaRun) write(path string) error {
+	b, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
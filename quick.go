@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// quick implements `quick`, which asks for a ROM or signature, an image, & a labels.db, then adds the one label with a
+// backup & checks the result. It's for someone who's just bought a game & wants its label fixed without learning the
+// rest of the tool.
+func quick(args []string) error {
+	fs := flag.NewFlagSet("quick", flag.ExitOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: quick")
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	ask := func(prompt string) (string, error) {
+		fmt.Print(prompt)
+		s, err := in.ReadString('\n')
+		if errors.Is(err, io.EOF) && s == "" {
+			return "", errors.New("cancelled, nothing was written")
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimSpace(s), nil
+	}
+
+	fmt.Println("Fix the label for one game. Files can be dragged into the terminal instead of typing their path.")
+	fmt.Println()
+
+	var sig uint32
+	for {
+		answer, err := ask("1. The game's ROM file, or its signature if you know it: ")
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			continue
+		}
+		if s, err := HexStringTransform(answer); err == nil && len(strings.TrimPrefix(answer, "0x")) == 8 {
+			sig = s
+			break
+		}
+		hdr, err := readROMHeader(typedPath(answer))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		sig = hdr.Signature
+		fmt.Printf("   %s has the signature %08X\n", hdr.Name, sig)
+		break
+	}
+
+	opts := defaultConvertOptions()
+	if opts.Colour, err = loadColourProfile(); err != nil {
+		return err
+	}
+	var art string
+	var entry []byte
+	for {
+		answer, err := ask("2. The image to use as its label: ")
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			continue
+		}
+		art = typedPath(answer)
+		if entry, err = loadImage(art, opts); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if !plainOutput {
+			printPreview(os.Stdout, labelsdb.Decode(entry))
+		}
+		break
+	}
+
+	card, cardErr := findCardDB("")
+	var path string
+	onCard := false
+	for {
+		prompt := "3. Where labels.db is, or the SD card: "
+		if cardErr == nil {
+			prompt = fmt.Sprintf("3. Where labels.db is, or the SD card [%s]: ", card)
+		}
+		answer, err := ask(prompt)
+		if err != nil {
+			return err
+		}
+		if answer == "" && cardErr == nil {
+			path, onCard = card, true
+			break
+		} else if answer == "" {
+			continue
+		}
+		path = typedPath(answer)
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			if path, err = findCardDB(path); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			onCard = true
+		}
+		if _, err := labelsdb.Open(path); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		break
+	}
+
+	db, err := labelsdb.Open(path)
+	if err != nil {
+		return err
+	}
+	action := "Add"
+	if db.Contains(sig) {
+		action = "Replace the existing label for"
+	}
+	answer, err := ask(fmt.Sprintf("\n%s %08X using %s in %s? [Y/n] ", action, sig, filepath.Base(art), path))
+	if err != nil {
+		return err
+	}
+	if a := strings.ToLower(answer); a != "" && a != "y" && a != "yes" {
+		fmt.Println("Nothing was written")
+		return nil
+	}
+
+	if err := backupFile(path, path+".bak"); err != nil {
+		return err
+	}
+	// A card is a slow target that's easily pulled out partway through
+	resumableWrites = onCard
+	skipped, err := addImages(path, []Image{{Filepath: art, Signature: sig}},
+		addSettings{PreservePadding: true, Colour: opts.Colour, Policy: confirmPolicy{Yes: true, Threshold: -1}})
+	if err == nil && skipped > 0 {
+		err = fmt.Errorf("%s could not be loaded", art)
+	}
+	if err != nil {
+		return err
+	}
+	if err := verifyDeployed(path); err != nil {
+		return err
+	}
+	fmt.Printf("Done. If anything looks wrong, %s.bak is a copy of labels.db from before.\n", path)
+	return nil
+}
+
+// typedPath cleans up a path typed or dragged into a terminal, which may be quoted or have its spaces escaped
+func typedPath(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	if runtime.GOOS != "windows" {
+		s = strings.ReplaceAll(s, `\ `, " ")
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// dump implements `dump {labels.db} -offset N -length N [-o file]`, copying a raw byte range out of the db for
+// inspection. Offsets & lengths can be given in hex with a 0x prefix.
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	offset := fs.Int64("offset", 0, "the offset to start reading from")
+	length := fs.Int64("length", 0, "the number of bytes to read")
+	out := fs.String("o", "", "the file to write the bytes to (default stdout)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *length <= 0 {
+		return errors.New("usage: dump {labels.db} -offset N -length N [-o file]")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := checkRange(f, *offset, *length); err != nil {
+		return err
+	}
+	b := make([]byte, *length)
+	if _, err := f.ReadAt(b, *offset); err != nil {
+		return err
+	}
+	logRange(f, *offset, *length)
+
+	if *out == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(*out, b, 0o644)
+}
+
+// inject implements `inject {labels.db} -offset N -i file`, the inverse of dump. It overwrites bytes in place & will
+// never change the size of the db. Writing over the header needs -allow-header.
+func inject(args []string) error {
+	fs := flag.NewFlagSet("inject", flag.ExitOnError)
+	offset := fs.Int64("offset", 0, "the offset to start writing at")
+	in := fs.String("i", "", "the file containing the bytes to write")
+	allowHeader := fs.Bool("allow-header", false, "allow writing over the file header")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *in == "" {
+		return errors.New("usage: inject {labels.db} -offset N -i file [-allow-header] [-backup]")
+	}
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("%s is empty", *in)
+	}
+	if *offset < labelsdb.IndexStart && !*allowHeader {
+		return fmt.Errorf("offset 0x%X is within the header; use -allow-header if you really mean to overwrite it", *offset)
+	}
+
+	f, err := os.OpenFile(args[0], os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The offsets only mean anything in a labels.db, so refuse to write into some other file given by mistake
+	if err := labelsdb.Check(f); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if err := checkRange(f, *offset, int64(len(b))); err != nil {
+		return err
+	}
+	if *backup {
+		if err := backupFile(args[0], args[0]+".bak"); err != nil {
+			return err
+		}
+	}
+
+	logRange(f, *offset, int64(len(b)))
+	defer beginWrite()()
+	if _, err := f.WriteAt(b, *offset); err != nil {
+		return err
+	}
+	recordWrite(args[0])
+	log.Printf("Wrote %s at 0x%X", formatBytes(int64(len(b))), *offset)
+	return nil
+}
+
+// checkRange makes sure the byte range lies entirely within f
+func checkRange(f *os.File, offset, length int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if offset < 0 || length < 0 || offset+length > fi.Size() {
+		return fmt.Errorf("range 0x%X-0x%X is outside the file, which is 0x%X bytes", offset, offset+length, fi.Size())
+	}
+	return nil
+}
+
+// logRange logs which parts of the db a byte range covers, so it's obvious when a range straddles two entries
+func logRange(f io.ReadSeeker, offset, length int64) {
+	sigs, _ := labelsdb.ReadIndex(f)
+	first := describeOffset(sigs, offset)
+	last := describeOffset(sigs, offset+length-1)
+	if first == last {
+		log.Printf("0x%X-0x%X: %s", offset, offset+length, first)
+	} else {
+		log.Printf("0x%X-0x%X: from %s to %s", offset, offset+length, first, last)
+	}
+}
+
+// describeOffset returns a human-readable description of what lives at offset in a db with the given index
+func describeOffset(sigs []uint32, offset int64) string {
+	switch {
+	case offset < labelsdb.IndexStart:
+		return "header"
+	case offset < labelsdb.ImagesStart:
+		n := (offset - labelsdb.IndexStart) / 4
+		if n < int64(len(sigs)) {
+			return fmt.Sprintf("index slot %d (%08X)", n, sigs[n])
+		} else if n == int64(len(sigs)) {
+			return fmt.Sprintf("index slot %d (EOF marker)", n)
+		}
+		return fmt.Sprintf("index slot %d (unused)", n)
+	}
+
+	n := (offset - labelsdb.ImagesStart) / labelsdb.EntrySize
+	within := (offset - labelsdb.ImagesStart) % labelsdb.EntrySize
+	part := "pixels"
+	if within >= labelsdb.EntrySize-labelsdb.PaddingSize {
+		part = "padding"
+	}
+	if n < int64(len(sigs)) {
+		return fmt.Sprintf("entry %d (%08X) %s +0x%X", n, sigs[n], part, within)
+	}
+	return fmt.Sprintf("beyond last entry (entry slot %d) +0x%X", n, within)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// reapply copies the user's entries from the labels.db they had before a firmware update into the fresh stock one
+// the update installed. Entries the update changed or removed are copied if the undo journal for either file shows the
+// user wrote them. Changed entries the journal doesn't know about are only copied with -changed, since they may be stock
+// art the update improved rather than custom labels, & removed ones only with -removed, since they may be stock entries
+// the update dropped on purpose. Without a journal there's no telling, so removed entries are all copied, as they're
+// most likely the user's own.
+func reapply(args []string) error {
+	fs := flag.NewFlagSet("reapply", flag.ExitOnError)
+	previous := fs.String("previous", "", "the labels.db from before the firmware update")
+	changed := fs.Bool("changed", false,
+		"also restore the entries the update changed that the undo journal doesn't show you writing")
+	removed := fs.Bool("removed", false,
+		"also restore the entries the update removed that the undo journal doesn't show you writing")
+	backup := fs.Bool("backup", false, "keep a copy of the new labels.db as labels.db.bak before modifying it")
+	var policy confirmPolicy
+	policy.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *previous == "" {
+		return errors.New("usage: reapply {labels.db} -previous {old labels.db} [flags]")
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	labelsDB := args[0]
+
+	oldDB, err := labelsdb.Open(*previous)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *previous, err)
+	}
+
+	if *backup {
+		if err := backupFile(labelsDB, labelsDB+".bak"); err != nil {
+			return err
+		}
+	}
+
+	db, err := labelsdb.Open(labelsDB)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", labelsDB, err)
+	}
+
+	d := labelsdb.Compare(oldDB, db)
+	custom, err := customisedSigs(labelsDB, *previous)
+	if err != nil {
+		return err
+	}
+
+	// The entries the undo journal shows the user writing are their own labels, so they're restored without -removed
+	// or -changed
+	restore := d.Removed
+	if !*removed && custom != nil {
+		restore = slices.DeleteFunc(slices.Clone(d.Removed), func(s uint32) bool { return !custom[s] })
+		if n := len(d.Removed) - len(restore); n > 0 {
+			log.Printf("Leaving %d removed entries that aren't in the undo journal; -removed restores them too", n)
+		}
+	}
+	if len(restore) > 0 && policy.skipReason(false) != "" {
+		log.Printf("Not restoring %d entries the update removed: -replace-only is set", len(restore))
+		restore = nil
+	}
+
+	overwritten := d.Changed
+	if !*changed {
+		overwritten = slices.DeleteFunc(slices.Clone(d.Changed), func(s uint32) bool { return !custom[s] })
+		switch n := len(d.Changed) - len(overwritten); {
+		case n > 0 && custom == nil:
+			log.Printf("Leaving %d changed entries, as there's no undo journal to tell your labels from updated stock "+
+				"art; -changed restores them too", n)
+		case n > 0:
+			log.Printf("Leaving %d changed entries that aren't in the undo journal; -changed restores them too", n)
+		}
+	}
+	if len(overwritten) > 0 && policy.skipReason(true) != "" {
+		log.Printf("Not restoring %d changed entries: -no-overwrite is set", len(overwritten))
+	} else if len(overwritten) > 0 {
+		if err := policy.confirmReplace(len(overwritten)); err != nil {
+			return err
+		}
+		restore = slices.Sorted(slices.Values(slices.Concat(restore, overwritten)))
+	}
+	if len(restore) == 0 {
+		log.Printf("Nothing to re-apply to %s", labelsDB)
+		return nil
+	}
+
+	return reapplyEntries(labelsDB, oldDB, db, restore)
+}
+
+// reapplyEntries copies the entries for sigs from oldDB into db & saves it to path, journaling the write like any other
+func reapplyEntries(path string, oldDB, db *labelsdb.DB, sigs []uint32) error {
+	for _, s := range sigs {
+		entry, _ := oldDB.Entry(s)
+		if err := db.PutEntry(s, entry); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Re-applying %d entries, writing %d images to %s", len(sigs), db.Len(), path)
+	return saveDB(path, db)
+}
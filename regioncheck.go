@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// tagZones maps the region tags in No-Intro style titles, as split off by fuzzyName, to the market the game was
+// released for. Art is only ever wrong for a dump when they're for different markets: German art on a European dump is
+// fine, but Japanese art on a USA dump isn't.
+var tagZones = map[string]string{
+	"usa": "USA", "canada": "USA", "brazil": "USA",
+	"japan": "Japan", "asia": "Japan", "korea": "Japan",
+	"europe": "Europe", "germany": "Europe", "france": "Europe", "spain": "Europe", "italy": "Europe",
+	"netherlands": "Europe", "scandinavia": "Europe", "sweden": "Europe", "uk": "Europe", "australia": "Europe",
+	"china": "China",
+}
+
+// headerZones maps the regions in ROM headers, as given by regionName, to their market, for ROMs without a DAT title
+var headerZones = map[string]string{
+	"North America": "USA", "Canada": "USA", "Brazil": "USA", "Gateway 64 (NTSC)": "USA",
+	"Japan": "Japan", "Asia": "Japan", "Korea": "Japan",
+	"Europe": "Europe", "Germany": "Europe", "France": "Europe", "Spain": "Europe", "Italy": "Europe",
+	"Netherlands": "Europe", "Scandinavia": "Europe", "Australia": "Europe", "Gateway 64 (PAL)": "Europe",
+	"China": "China",
+}
+
+// titleZones returns the markets named by the region tags in title, & whether one of them is World
+func titleZones(title string) (map[string]bool, bool) {
+	_, tags := fuzzyName(title)
+	zones := make(map[string]bool)
+	for _, t := range tags {
+		if t == "world" {
+			return zones, true
+		}
+		if z, ok := tagZones[t]; ok {
+			zones[z] = true
+		}
+	}
+	return zones, false
+}
+
+// regionCheck cross-checks the titles that manifests give art against the dumps with the same signature, catching a
+// pack that's put one region's art on another region's dump
+type regionCheck struct {
+	// titles are the ROMs' No-Intro titles, from a DAT or the ROM index
+	titles map[uint32]string
+	// regions are the regions in the ROMs' headers, for those without a title that says
+	regions map[uint32]string
+}
+
+// newRegionCheck returns a regionCheck for the ROMs in the index at roms, named using dat if it isn't ""
+func newRegionCheck(roms, dat string) (*regionCheck, error) {
+	idx, err := loadROMIndex(roms)
+	if err != nil {
+		return nil, err
+	}
+	rc := &regionCheck{titles: idx.Titles(), regions: idx.Regions()}
+	if dat != "" {
+		t, err := datTitles(dat, idx)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(rc.titles, t)
+	}
+	return rc, nil
+}
+
+// mismatch returns a description of the dump with the image's signature if the image's title is for a different
+// market, or "" if it isn't, or there's nothing to tell either way
+func (rc *regionCheck) mismatch(img Image) string {
+	art, world := titleZones(img.Title)
+	if world || len(art) == 0 {
+		return ""
+	}
+	dump, world := titleZones(rc.titles[img.Signature])
+	if world {
+		return ""
+	}
+	for z := range art {
+		if dump[z] {
+			return ""
+		}
+	}
+	if len(dump) > 0 {
+		return fmt.Sprintf("%s, a %s dump", rc.titles[img.Signature], strings.Join(slices.Sorted(maps.Keys(dump)), "/"))
+	}
+	// Without a region in its title, the dump's header says where it's from
+	if z, ok := headerZones[rc.regions[img.Signature]]; ok && !art[z] {
+		return fmt.Sprintf("a %s dump", rc.regions[img.Signature])
+	}
+	return ""
+}
+
+// warn logs every image whose title is for a different market than its dump
+func (rc *regionCheck) warn(imgs []Image) {
+	for _, img := range imgs {
+		if dump := rc.mismatch(img); dump != "" {
+			log.Printf("Warning: %s is titled %q, but %08X is %s; check the pack hasn't put one region's art on "+
+				"another's dump", img.Filepath, img.Title, img.Signature, dump)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// registryMaxAge is how old a downloaded title registry can get before it's fetched again
+const registryMaxAge = 7 * 24 * time.Hour
+
+// registryTitles returns the titles from the registries listed as title_registry in the config file. See
+// loadRegistries.
+func registryTitles() (map[uint32]string, error) {
+	cfg, err := loadConfig()
+	if err != nil || len(cfg["title_registry"]) == 0 {
+		return nil, err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return loadRegistries(cfg["title_registry"], filepath.Join(dir, "a3dlabels", "titles")), nil
+}
+
+// loadRegistries returns the titles from the registries at urls. Each is a title file in the same format as -titles,
+// served over HTTP. Where two registries name the same signature, the first one listed wins.
+//
+// Registries are kept in cache & only downloaded again once the copy there is older than registryMaxAge, so titles can
+// be looked up offline & the server isn't asked on every run. A registry that can't be downloaded is used from the
+// cache however old it is, & skipped with a warning if it was never downloaded, since titles are only ever a nicety.
+func loadRegistries(urls []string, cache string) map[uint32]string {
+	titles := make(map[uint32]string)
+	for _, u := range urls {
+		path, err := cachedRegistry(u, cache)
+		if err != nil {
+			log.Printf("Skipping title registry %s: %v", redactURL(u), err)
+			continue
+		}
+		t, err := loadTitleFile(path)
+		if err != nil {
+			log.Printf("Skipping title registry %s: %v", redactURL(u), err)
+			continue
+		}
+		for sig, title := range t {
+			if _, ok := titles[sig]; !ok {
+				titles[sig] = title
+			}
+		}
+	}
+	return titles
+}
+
+// cachedRegistry returns the path of the cached copy of the registry at u, downloading it first if it's missing or
+// stale. The new copy is downloaded alongside & renamed over the old one, so a failed download leaves the old one be.
+func cachedRegistry(u, cache string) (string, error) {
+	sum := sha256.Sum256([]byte(u))
+	path := filepath.Join(cache, hex.EncodeToString(sum[:8])+".txt")
+	fi, statErr := os.Stat(path)
+	if statErr == nil && time.Since(fi.ModTime()) < registryMaxAge {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(cache, 0o755); err != nil {
+		return "", err
+	}
+	tmp := path + ".download"
+	err := fetchFile(u, tmp)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		if statErr != nil {
+			return "", err
+		}
+		log.Printf("Using the cached copy of title registry %s from %s, since it can't be updated: %v", redactURL(u),
+			fi.ModTime().Format(time.DateOnly), err)
+	}
+	return path, nil
+}
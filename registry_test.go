@@ -0,0 +1,74 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLoadRegistries checks that registries are cached, fetched again once stale, used from the cache when they can't
+// be, & that the first registry listed wins
+func TestLoadRegistries(t *testing.T) {
+	requests := 0
+	body := "3274BDAF,Super Mario 64\n# a comment\n12345678\tWave Race 64\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/a.txt":
+			w.Write([]byte(body))
+		case "/b.txt":
+			w.Write([]byte("3274BDAF,Mario 64\nAAAAAAAA,Pilotwings 64\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cache := t.TempDir()
+	urls := []string{srv.URL + "/a.txt", srv.URL + "/b.txt", srv.URL + "/missing.txt"}
+
+	want := map[uint32]string{0x3274BDAF: "Super Mario 64", 0x12345678: "Wave Race 64", 0xAAAAAAAA: "Pilotwings 64"}
+	if got := loadRegistries(urls, cache); !maps.Equal(got, want) {
+		t.Fatalf("titles = %v, want %v", got, want)
+	}
+	if requests != 3 {
+		t.Errorf("%d requests on the first run, want 3", requests)
+	}
+
+	// A fresh copy is used without asking the server again. The missing registry was never cached, so it's retried.
+	requests = 0
+	body = "3274BDAF,Changed\n"
+	loadRegistries(urls, cache)
+	if requests != 1 {
+		t.Errorf("%d requests with a fresh cache, want 1", requests)
+	}
+
+	// Once the copy is stale it's downloaded again
+	stale := time.Now().Add(-registryMaxAge - time.Hour)
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := os.Chtimes(filepath.Join(cache, e.Name()), stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := loadRegistries(urls[:1], cache); got[0x3274BDAF] != "Changed" {
+		t.Errorf("stale registry wasn't updated: got %q", got[0x3274BDAF])
+	}
+
+	// A stale copy is still used if the server can't be reached
+	for _, e := range entries {
+		if err := os.Chtimes(filepath.Join(cache, e.Name()), stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.Close()
+	if got := loadRegistries(urls[:2], cache); got[0x3274BDAF] != "Changed" || got[0xAAAAAAAA] != "Pilotwings 64" {
+		t.Errorf("titles with the server down = %v, want the cached copies", got)
+	}
+}
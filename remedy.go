@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// errCode identifies a kind of failure that has a known fix in remedies. The codes are printed with the fix so they can
+// be searched for & quoted in bug reports, so they mustn't change once added.
+type errCode string
+
+const (
+	codeDBNotFound        errCode = "db-not-found"
+	codeNoCard            errCode = "no-card"
+	codeNotLabelsDB       errCode = "not-labels-db"
+	codeFull              errCode = "db-full"
+	codeBadSignature      errCode = "bad-signature"
+	codeImageQA           errCode = "image-failed-qa"
+	codeImageTooLarge     errCode = "image-too-large"
+	codeUnsupportedFormat errCode = "unsupported-format"
+	codePermission        errCode = "permission-denied"
+)
+
+// codedError attaches an errCode to an error whose message says what went wrong well enough already, for failures
+// that don't have a sentinel error of their own
+type codedError struct {
+	code errCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode returns err with code attached
+func withCode(code errCode, err error) error {
+	return &codedError{code, err}
+}
+
+// remedy is what to suggest for a kind of failure: what it usually means, & the steps that fix it. Steps starting with
+// "a3dlabels" are commands to run.
+type remedy struct {
+	Cause string
+	Steps []string
+}
+
+// remedies are the suggestions printed after an error, by errCode
+var remedies = map[errCode]remedy{
+	codeDBNotFound: {
+		Cause: "There's no labels.db at that path. On the SD card it's a few directories down, such as " +
+			"Library/Images/labels.db, & it's only created once the console has shown a label.",
+		Steps: []string{
+			"Check the SD card is mounted & the path is right, or let deploy find the card by itself:",
+			"a3dlabels deploy {images}",
+			"To start a new database from scratch instead:",
+			"a3dlabels create labels.db",
+		},
+	},
+	codeNoCard: {
+		Cause: "No mounted volume has an Analogue 3D's System directory & a labels.db.",
+		Steps: []string{
+			"Put the SD card in & make sure it's mounted, then run the command again.",
+			"If it's mounted somewhere unusual, say where:",
+			"a3dlabels deploy -card /path/to/card {images}",
+		},
+	},
+	codeNotLabelsDB: {
+		Cause: "The file doesn't start with a labels.db header, so it's either the wrong file or badly damaged.",
+		Steps: []string{
+			"Check the path points at labels.db & not an image or another file.",
+			"To see what's wrong with it & whether it can be repaired:",
+			"a3dlabels verify labels.db",
+			"To put back a copy from before it was damaged:",
+			"a3dlabels restore labels.db",
+		},
+	},
+	codeFull: {
+		Cause: fmt.Sprintf("The index only has room for %d entries.", labelsdb.MaxEntries),
+		Steps: []string{
+			"See how full it is & where the space is going:",
+			"a3dlabels stats labels.db",
+			"Go through blank, low resolution, & duplicate entries & remove the ones that can go:",
+			"a3dlabels curate labels.db",
+			"Or remove particular ones:",
+			"a3dlabels remove labels.db {signatures}",
+		},
+	},
+	codeBadSignature: {
+		Cause: "A signature is 8 hex digits (0-9 & A-F), such as 3274BDAF, & images are named after theirs.",
+		Steps: []string{
+			"Rename the file to its signature, or work the signature out from the ROM:",
+			"a3dlabels sig {rom}",
+			"Or let the tool rename images next to their ROMs for you:",
+			"a3dlabels sig -rename {roms}",
+		},
+	},
+	codeImageQA: {
+		Cause: "Some images are below the quality thresholds set in config.toml, usually for being too small.",
+		Steps: []string{
+			"The QA report next to labels.db (labels.db.qa.json) says which images failed & why.",
+			"Replace them with bigger or sharper art, or add them anyway:",
+			"a3dlabels add -no-qa labels.db {images}",
+		},
+	},
+	codeImageTooLarge: {
+		Cause: "The image is bigger than the limits that guard against decompression bombs.",
+		Steps: []string{
+			"If the image is genuine, raise or remove the limits:",
+			"a3dlabels add -max-image-bytes 0 -max-image-dimension 0 labels.db {images}",
+		},
+	},
+	codeUnsupportedFormat: {
+		Cause: "The file isn't an image in a format the tool can read, or has the wrong extension for what it is.",
+		Steps: []string{
+			"Convert it to PNG with an image editor, then add it again.",
+		},
+	},
+	codePermission: {
+		Cause: "The file or its directory can't be written, often because the SD card is write protected or " +
+			"mounted read-only.",
+		Steps: []string{
+			"Check the card's lock switch & remount it read-write, or check the file's permissions.",
+		},
+	},
+}
+
+// errorCode works out which errCode err has, from a code attached with withCode or from the sentinel errors it wraps.
+// It returns "" for failures without a known fix.
+func errorCode(err error) errCode {
+	var coded *codedError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, labelsdb.ErrFull):
+		return codeFull
+	case errors.Is(err, labelsdb.ErrNotLabelsDB):
+		return codeNotLabelsDB
+	case errors.Is(err, errImageTooLarge):
+		return codeImageTooLarge
+	case errors.Is(err, errUnsupportedFormat):
+		return codeUnsupportedFormat
+	case errors.Is(err, fs.ErrPermission):
+		return codePermission
+	case errors.Is(err, fs.ErrNotExist) && errors.As(err, &pathErr) &&
+		strings.EqualFold(filepath.Ext(pathErr.Path), ".db"):
+		return codeDBNotFound
+	}
+	return ""
+}
+
+// printRemedy writes the suggestions for err to w, if it's a failure with a known fix
+func printRemedy(w io.Writer, err error) {
+	code := errorCode(err)
+	r, ok := remedies[code]
+	if !ok {
+		return
+	}
+	fmt.Fprintf(w, "\n%s (%s)\n", r.Cause, code)
+	for _, s := range r.Steps {
+		if strings.HasPrefix(s, "a3dlabels ") {
+			fmt.Fprintf(w, "    %s\n", s)
+		} else {
+			fmt.Fprintf(w, "  %s\n", s)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// isRemote returns true if src is a URL rather than a local path. s3:// URLs are only used for listing; the objects
+// themselves are fetched over HTTPS.
+func isRemote(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "s3://")
+}
+
+// nopSeekCloser adds a no-op Close to a bytes.Reader
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// openSource opens an image source, which is either a local file or an http(s) URL, & returns it along with its size.
+// Remote images are read into memory rather than mirrored to disk; label art is small enough for that not to matter.
+func openSource(src string) (io.ReadSeekCloser, int64, error) {
+	if !isRemote(src) {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, fi.Size(), nil
+	}
+	b, err := fetchRemote(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nopSeekCloser{bytes.NewReader(b)}, int64(len(b)), nil
+}
+
+// lastRemote holds the most recently fetched remote image. A store lookup hashes the source & then, on a miss, the
+// image is decoded, so without this every new remote image would be downloaded twice.
+var lastRemote struct {
+	sync.Mutex
+	url  string
+	data []byte
+}
+
+// fetchRemote downloads a remote image, refusing anything over maxImageBytes
+func fetchRemote(src string) ([]byte, error) {
+	lastRemote.Lock()
+	defer lastRemote.Unlock()
+	if lastRemote.url == src {
+		return lastRemote.data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := fetch(src, &buf); err != nil {
+		return nil, err
+	}
+	if maxImageBytes > 0 && int64(buf.Len()) > maxImageBytes {
+		return nil, fmt.Errorf("%s: %w: file is %d bytes, limit is %d", src, errImageTooLarge, buf.Len(), maxImageBytes)
+	}
+	lastRemote.url, lastRemote.data = src, buf.Bytes()
+	return lastRemote.data, nil
+}
+
+// listRemote returns the URL of every image in a remote directory: either an S3 bucket or prefix, given as
+// s3://bucket/prefix, or a web server's directory listing. A URL that points straight at an image is returned as is.
+func listRemote(src string) ([]string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "s3" {
+		return listS3(u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+	if isImageFile(u.Path) {
+		return []string{src}, nil
+	}
+	return listHTTPDir(u)
+}
+
+// hrefPattern finds the links in an HTML directory listing
+var hrefPattern = regexp.MustCompile(`(?i)href\s*=\s*["']([^"'#?]+)["']`)
+
+// listHTTPDir finds the images linked to from a directory listing, such as the ones Apache, nginx, & most static file
+// servers generate. Only links to files in the directory itself are followed, not subdirectories or other sites.
+func listHTTPDir(u *url.URL) ([]string, error) {
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	var sb strings.Builder
+	if err := fetch(u.String(), &sb); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	urls := make([]string, 0)
+	for _, m := range hrefPattern.FindAllStringSubmatch(sb.String(), -1) {
+		link, err := u.Parse(m[1])
+		if err != nil || link.Host != u.Host || path.Dir(link.Path)+"/" != u.Path || !isImageFile(link.Path) {
+			continue
+		}
+		if s := link.String(); !seen[s] {
+			seen[s] = true
+			urls = append(urls, s)
+		}
+	}
+	return urls, nil
+}
+
+// s3Endpoint returns the base URL for a bucket. AWS_ENDPOINT_URL can point it at any S3-compatible service, which are
+// addressed path style.
+func s3Endpoint(bucket string) string {
+	if e := os.Getenv("AWS_ENDPOINT_URL"); e != "" {
+		return strings.TrimSuffix(e, "/") + "/" + url.PathEscape(bucket)
+	}
+	return "https://" + bucket + ".s3.amazonaws.com"
+}
+
+// listS3 lists the images in a publicly readable S3 bucket under prefix. Requests aren't signed, so private buckets
+// aren't supported.
+func listS3(bucket, prefix string) ([]string, error) {
+	base := s3Endpoint(bucket)
+	urls := make([]string, 0)
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		var list struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		var sb strings.Builder
+		if err := fetch(base+"/?"+q.Encode(), &sb); err != nil {
+			return nil, err
+		}
+		if err := xml.Unmarshal([]byte(sb.String()), &list); err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+
+		for _, c := range list.Contents {
+			if isImageFile(c.Key) {
+				urls = append(urls, base+"/"+(&url.URL{Path: c.Key}).EscapedPath())
+			}
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return urls, nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// remoteImages lists a remote directory & returns the images in it whose names are signatures. The rest are logged
+// & skipped.
+func remoteImages(src string) ([]Image, error) {
+	urls, err := listRemote(src)
+	if err != nil {
+		return nil, err
+	}
+	imgs := make([]Image, 0, len(urls))
+	for _, u := range urls {
+		name, _ := url.PathUnescape(path.Base(u))
+		sig, err := HexStringTransform(strings.TrimSuffix(name, path.Ext(name)))
+		if err != nil {
+			log.Printf("Skipping %s: the filename isn't a signature", u)
+			continue
+		}
+		imgs = append(imgs, Image{Filepath: u, Signature: sig})
+	}
+	log.Printf("Found %s images at %s", formatCount(len(imgs)), src)
+	return imgs, nil
+}
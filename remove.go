@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// remove implements `remove {labels.db} {signature...}`, deleting the given entries. The remaining entries are moved up
+// to fill the gaps & the file is truncated to its new size. Signatures that aren't in the database are warned about but
+// don't stop the others from being removed.
+func remove(args []string) error {
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	dryRun := fs.Bool("dry-run", false, "list the entries that would be removed, without writing anything")
+	var policy confirmPolicy
+	policy.registerRemove(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return errors.New("usage: remove {labels.db} {signature...} [-dry-run] [-backup] [-yes] [-force] " +
+			"[-confirm-threshold N]")
+	}
+
+	sigs := make([]uint32, 0, len(args)-1)
+	for _, a := range args[1:] {
+		sig, err := HexStringTransform(a)
+		if err != nil {
+			return err
+		}
+		sigs = append(sigs, sig)
+	}
+
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+
+	var removed []uint32
+	for _, sig := range sigs {
+		if !db.Remove(sig) {
+			log.Printf("%08X is not in %s", sig, args[0])
+			continue
+		}
+		removed = append(removed, sig)
+	}
+	if len(removed) == 0 {
+		log.Printf("Nothing to remove from %s", args[0])
+		return nil
+	}
+	if *dryRun {
+		printSigs("Would be removed", removed)
+		fmt.Printf("\nDry run: %s was not modified.\n", args[0])
+		return nil
+	}
+	if err := policy.confirmRemove(len(removed)); err != nil {
+		return err
+	}
+
+	if *backup {
+		if err := backupFile(args[0], args[0]+".bak"); err != nil {
+			return err
+		}
+	}
+	log.Printf("Removed %s entries, writing %s images to %s", formatCount(len(removed)), formatCount(db.Len()), args[0])
+	return saveDB(args[0], db)
+}
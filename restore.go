@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// restore implements `restore {labels.db}`, which uses the undo journal kept by -journal to revert the last writes, or
+// to put specific signatures back the way they were before anything in the journal touched them
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	count := fs.Int("n", 1, "the number of writes to revert, most recent first")
+	sigList := fs.String("sigs", "", "a comma separated list of signatures to restore, as well as any given as arguments")
+	showList := fs.Bool("list", false, "list the writes in the journal instead of reverting anything")
+	force := fs.Bool("force", false, "revert even if labels.db has been changed by something that wasn't journaled")
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || *count < 1 {
+		return errors.New("usage: restore {labels.db} [-n 1] [-force] | {signature...} [-sigs sig,sig...] | -list")
+	}
+	path := args[0]
+	sigs, err := parseSignatures(args[1:], *sigList)
+	if err != nil {
+		return err
+	}
+
+	ops, err := readJournal(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s has no undo journal; writes are only journaled with -journal or journal = \"true\" in "+
+			"the config file", path)
+	} else if err != nil {
+		return err
+	}
+	if *showList {
+		for i := len(ops) - 1; i >= 0; i-- {
+			if plainOutput {
+				fmt.Printf("%d: %s\n", len(ops)-i, ops[i].summary())
+			} else {
+				fmt.Printf("%3d  %s\n", len(ops)-i, ops[i].summary())
+			}
+		}
+		return nil
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("the undo journal for %s is empty", path)
+	}
+
+	db, err := labelsdb.Open(path)
+	if err != nil {
+		return err
+	}
+	orig := db.Clone()
+	if len(sigs) > 0 {
+		if err := restoreSigs(db, ops, sigs); err != nil {
+			return err
+		}
+	} else {
+		if *count > len(ops) {
+			return fmt.Errorf("the undo journal only has %d writes", len(ops))
+		}
+		if dbDigest(db) != ops[len(ops)-1].After && !*force {
+			return fmt.Errorf("%s has been changed since the last journaled write, so reverting could undo the "+
+				"wrong thing; rerun with -force if you're sure", path)
+		}
+		for i := len(ops) - 1; i >= len(ops)-*count; i-- {
+			log.Printf("Reverting %s", ops[i].summary())
+			if err := ops[i].revert(db); err != nil {
+				return err
+			}
+		}
+	}
+
+	d := labelsdb.Compare(orig, db)
+	if *dryRun {
+		printDiff(d, orig.Len(), db.Len())
+		fmt.Printf("\nDry run: %s was not modified.\n", path)
+		return nil
+	}
+	if len(d.Added)+len(d.Changed)+len(d.Removed) == 0 && len(sigs) > 0 {
+		log.Printf("Nothing to restore in %s", path)
+		return nil
+	}
+	if *backup {
+		if err := backupFile(path, path+".bak"); err != nil {
+			return err
+		}
+	}
+
+	// Restoring signatures is an ordinary change that's journaled like any other, even without -journal, so that the
+	// journal still matches the database. Reverting writes takes them off the journal instead.
+	if len(sigs) > 0 {
+		journalWrites = true
+		log.Printf("Restored %s signatures, writing %s images to %s", formatCount(len(d.Added)+len(d.Changed)+
+			len(d.Removed)), formatCount(db.Len()), path)
+		return saveDB(path, db)
+	}
+	if err := writeDB(path, db); err != nil {
+		return err
+	}
+	if err := os.Truncate(journalPath(path), ops[len(ops)-*count].offset); err != nil {
+		return fmt.Errorf("%s was reverted, but the undo journal couldn't be updated: %w", path, err)
+	}
+	log.Printf("Reverted %s writes, %s images are left in %s", formatCount(*count), formatCount(db.Len()), path)
+	return nil
+}
+
+// restoreSigs puts each signature back the way it was before the first write in the journal that changed it: the entry
+// it displaced, or no entry at all if the signature was added
+func restoreSigs(db *labelsdb.DB, ops []journalOp, sigs []uint32) error {
+	for _, sig := range sigs {
+		found := false
+		for _, op := range ops {
+			if e, ok := op.entries[sig]; ok {
+				if err := db.PutEntry(sig, e); err != nil {
+					return err
+				}
+				found = true
+			} else if slices.Contains(op.Added, hexSig(sig)) {
+				db.Remove(sig)
+				found = true
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			log.Printf("%08X hasn't been changed by anything in the undo journal", sig)
+		}
+	}
+	return nil
+}
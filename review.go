@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ambiguousMatch is a ROM that several images matched equally well, queued for the user to choose between
+type ambiguousMatch struct {
+	ROM        romEntry
+	Candidates []string
+}
+
+// matchDecisions is the image chosen for each ROM that was ambiguous, keyed by the ROM's filename. The image is kept
+// by its filename alone so that a decision still holds after the art is moved; "" means the ROM was skipped.
+type matchDecisions map[string]string
+
+// matchDecisionsPath returns where the decisions are kept, in the user's config directory
+func matchDecisionsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "match-decisions.json"), nil
+}
+
+// loadMatchDecisions returns the saved decisions, or an empty table if none have been saved
+func loadMatchDecisions() (matchDecisions, error) {
+	m := make(matchDecisions)
+	path, err := matchDecisionsPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// save writes the table to match-decisions.json
+func (m matchDecisions) save() error {
+	path, err := matchDecisionsPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// decided returns the image previously chosen for a ROM from its candidates, "" if it was skipped, & whether there's a
+// decision that still applies. One naming an image that's no longer a candidate doesn't.
+func (m matchDecisions) decided(a ambiguousMatch) (string, bool) {
+	name, ok := m[filepath.Base(filepath.FromSlash(a.ROM.Path))]
+	if !ok || name == "" {
+		return "", ok
+	}
+	for _, c := range a.Candidates {
+		if filepath.Base(c) == name {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// reviewMatches resolves each of the queued ambiguous matches, returning the image chosen for each ROM, or "" where it
+// was skipped. Earlier decisions are reused, & the rest are asked one after another at the end of the run, rather
+// than interrupting the matching, with the answers saved for next time. Without a terminal to ask on, or with ask
+// false, the first candidate is used & nothing is saved.
+func reviewMatches(queue []ambiguousMatch, ask bool) ([]string, error) {
+	chosen := make([]string, len(queue))
+	decisions, err := loadMatchDecisions()
+	if err != nil {
+		return nil, err
+	}
+	var pending []int
+	for i, a := range queue {
+		if c, ok := decisions.decided(a); ok {
+			chosen[i] = c
+		} else {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return chosen, nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		ask = false
+	}
+	if !ask {
+		log.Printf("Using the first of the equally close images for %s ROMs; review them from a terminal without "+
+			"-yes to choose", formatCount(len(pending)))
+		for _, i := range pending {
+			chosen[i] = queue[i].Candidates[0]
+		}
+		return chosen, nil
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	fmt.Fprintf(os.Stderr, "\n%s ROMs matched more than one image equally well.\n", formatCount(len(pending)))
+	for _, i := range pending {
+		a := queue[i]
+		rom := filepath.Base(filepath.FromSlash(a.ROM.Path))
+		fmt.Fprintf(os.Stderr, "\n%s (%08X) could be:\n", rom, uint32(a.ROM.Signature))
+		for n, c := range a.Candidates {
+			fmt.Fprintf(os.Stderr, "  %d. %s\n", n+1, c)
+		}
+		for {
+			fmt.Fprintf(os.Stderr, "Choose 1-%d, or s to skip it [1]: ", len(a.Candidates))
+			answer, readErr := in.ReadString('\n')
+			if readErr != nil && !errors.Is(readErr, io.EOF) {
+				return nil, readErr
+			}
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer == "s" || answer == "skip" {
+				decisions[rom] = ""
+				break
+			}
+			n, err := 1, error(nil)
+			if answer != "" {
+				n, err = strconv.Atoi(answer)
+			}
+			if err == nil && n >= 1 && n <= len(a.Candidates) {
+				chosen[i] = a.Candidates[n-1]
+				decisions[rom] = filepath.Base(chosen[i])
+				break
+			}
+			if readErr != nil {
+				return nil, errors.New("cancelled, nothing was written")
+			}
+		}
+	}
+	return chosen, decisions.save()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// sigLength is the number of bytes at the start of a ROM that the signature is calculated from
+	sigLength = 0x2000
+	// romNameStart & romNameEnd delimit the internal name in the ROM header
+	romNameStart = 0x20
+	romNameEnd   = 0x34
+	// romRegion is the location of the destination/region code in the ROM header
+	romRegion = 0x3E
+
+	// The first word of a ROM in each of the three common byte orders
+	magicZ64 uint32 = 0x80371240 // native big endian
+	magicV64 uint32 = 0x37804012 // byte-swapped
+	magicN64 uint32 = 0x40123780 // little endian
+)
+
+// romExts are the file extensions treated as N64 ROMs when scanning a directory
+var romExts = []string{".z64", ".n64", ".v64"}
+
+// regions maps the region codes used in ROM headers to readable names
+var regions = map[byte]string{
+	'7': "Beta",
+	'A': "Asia",
+	'B': "Brazil",
+	'C': "China",
+	'D': "Germany",
+	'E': "North America",
+	'F': "France",
+	'G': "Gateway 64 (NTSC)",
+	'H': "Netherlands",
+	'I': "Italy",
+	'J': "Japan",
+	'K': "Korea",
+	'L': "Gateway 64 (PAL)",
+	'N': "Canada",
+	'P': "Europe",
+	'S': "Spain",
+	'U': "Australia",
+	'W': "Scandinavia",
+	'X': "Europe",
+	'Y': "Europe",
+}
+
+// romHeader is the information pulled out of the start of a ROM
+type romHeader struct {
+	Signature uint32
+	Name      string
+	Region    string
+}
+
+// isROMFile returns true if path has one of the ROM file extensions
+func isROMFile(path string) bool {
+	for _, ext := range romExts {
+		if strings.EqualFold(ext, path[max(0, len(path)-len(ext)):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readROMHeader reads the start of the ROM at path, converts it to native big endian order if it's byte-swapped or
+// little endian, & returns the signature (the CRC32 of the first 8KiB) along with the internal name & region from the
+// header.
+func readROMHeader(path string) (romHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return romHeader{}, err
+	}
+	defer f.Close()
+
+	b := make([]byte, sigLength)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return romHeader{}, fmt.Errorf("%s: too short to be an N64 ROM: %w", path, err)
+	}
+	if err := normalizeROM(b); err != nil {
+		return romHeader{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if romDAT != nil {
+		if err := romDAT.check(path); err != nil {
+			return romHeader{}, err
+		}
+	}
+	return romHeader{
+		Signature: crc32.ChecksumIEEE(b),
+		Name:      romName(b),
+		Region:    regionName(b[romRegion]),
+	}, nil
+}
+
+// normalizeROM converts b, the start of a ROM in any of the three byte orders, to native big endian in place. The byte
+// order is detected from the first word rather than the file extension, since ROMs are frequently misnamed.
+func normalizeROM(b []byte) error {
+	return swapROM(b, binary.BigEndian.Uint32(b))
+}
+
+// swapROM converts b, part of a ROM whose first word is magic, to native big endian in place. b must start on a word
+// boundary within the ROM.
+func swapROM(b []byte, magic uint32) error {
+	switch magic {
+	case magicZ64:
+	case magicV64:
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	case magicN64:
+		for i := 0; i+3 < len(b); i += 4 {
+			b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+		}
+	default:
+		return fmt.Errorf("unrecognised ROM header %08X", magic)
+	}
+	return nil
+}
+
+// romName returns the internal name from a normalized ROM header with the padding removed
+func romName(b []byte) string {
+	return strings.TrimRight(string(b[romNameStart:romNameEnd]), " \x00")
+}
+
+// regionName returns the readable name for a header region code
+func regionName(code byte) string {
+	if r, ok := regions[code]; ok {
+		return r
+	}
+	return fmt.Sprintf("Unknown (0x%02X)", code)
+}
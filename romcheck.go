@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// romDAT is set by -verify-dat to check every ROM against a No-Intro DAT before its signature is used
+var romDAT *noIntroDAT
+
+// noIntroDAT is what's needed from a No-Intro DAT to check ROMs: every dump it lists, by the CRC32 of the whole ROM in
+// big endian (.z64) order, which is the order No-Intro's checksums are for
+type noIntroDAT struct {
+	byCRC  map[uint32]datROM
+	byName map[string]datROM
+	// sizes are the sizes of every ROM in the DAT, smallest first, for spotting overdumps
+	sizes []int64
+}
+
+type datROM struct {
+	Game string
+	Size int64
+	CRC  uint32
+	// Status is No-Intro's status for the dump, such as baddump, or "" for a good one
+	Status string
+}
+
+// registerROMCheck adds -verify-dat to fs
+func registerROMCheck(fs *flag.FlagSet) {
+	fs.Func("verify-dat", "a No-Intro DAT to check ROMs against, warning about bad & overdumped ones",
+		func(s string) (err error) {
+			romDAT, err = loadNoIntroDAT(s)
+			return err
+		})
+}
+
+// loadNoIntroDAT reads the checksums from a No-Intro DAT
+func loadNoIntroDAT(filename string) (*noIntroDAT, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var dat struct {
+		Games []struct {
+			Name string `xml:"name,attr"`
+			ROMs []struct {
+				Name   string `xml:"name,attr"`
+				Size   int64  `xml:"size,attr"`
+				CRC    string `xml:"crc,attr"`
+				Status string `xml:"status,attr"`
+			} `xml:"rom"`
+		} `xml:"game"`
+	}
+	if err := xml.Unmarshal(b, &dat); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	d := &noIntroDAT{byCRC: make(map[uint32]datROM), byName: make(map[string]datROM)}
+	for _, g := range dat.Games {
+		for _, r := range g.ROMs {
+			crc, err := strconv.ParseUint(r.CRC, 16, 32)
+			if err != nil {
+				continue
+			}
+			dr := datROM{Game: g.Name, Size: r.Size, CRC: uint32(crc), Status: r.Status}
+			d.byCRC[dr.CRC] = dr
+			d.byName[strings.ToLower(r.Name)] = dr
+			if !slices.Contains(d.sizes, r.Size) {
+				d.sizes = append(d.sizes, r.Size)
+			}
+		}
+	}
+	if len(d.byCRC) == 0 {
+		return nil, fmt.Errorf("%s has no ROM checksums in it", filename)
+	}
+	slices.Sort(d.sizes)
+	return d, nil
+}
+
+// check verifies the whole of the ROM at path against the DAT, logging a warning if it's a known bad dump, an overdump
+// of a good one, or not in the DAT at all. A bad dump's signature may well not match the real cartridge, so its label
+// would never be shown.
+func (d *noIntroDAT) check(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The CRC is taken at each size in the DAT smaller than the file too, since an overdump is a good dump with junk
+	// on the end
+	stops := make([]int64, 0)
+	for _, s := range d.sizes {
+		if s < fi.Size() {
+			stops = append(stops, s)
+		}
+	}
+	stops = append(stops, fi.Size())
+	prefixes := make(map[int64]uint32, len(stops))
+
+	var crc, magic uint32
+	buf := make([]byte, 1<<20)
+	var off int64
+	for _, stop := range stops {
+		for off < stop {
+			n := int(min(int64(len(buf)), stop-off))
+			if _, err := io.ReadFull(f, buf[:n]); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if off == 0 {
+				if n < 4 {
+					return fmt.Errorf("%s: too short to be an N64 ROM", path)
+				}
+				magic = binary.BigEndian.Uint32(buf)
+			}
+			if err := swapROM(buf[:n], magic); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			crc = crc32.Update(crc, crc32.IEEETable, buf[:n])
+			off += int64(n)
+		}
+		prefixes[stop] = crc
+	}
+
+	name := filepath.Base(path)
+	if r, ok := d.byCRC[crc]; ok && r.Size == fi.Size() {
+		if r.Status == "baddump" {
+			log.Printf("Warning: %s is a known bad dump of %s; its signature may not match the real cartridge", name,
+				r.Game)
+		} else {
+			debugf("%s is a good dump of %s", name, r.Game)
+		}
+		return nil
+	}
+	for _, s := range stops[:len(stops)-1] {
+		if r, ok := d.byCRC[prefixes[s]]; ok && r.Size == s {
+			log.Printf("Warning: %s is an overdump of %s, with %s extra on the end. The signature is unaffected, but "+
+				"the file should be trimmed to %s.", name, r.Game, formatBytes(fi.Size()-s), formatBytes(s))
+			return nil
+		}
+	}
+	if r, ok := d.byName[strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name))+".z64")]; ok {
+		log.Printf("Warning: %s doesn't match No-Intro's checksum for %s (CRC32 %08X, expected %08X). It's likely a "+
+			"bad dump, & its signature may not match the real cartridge.", name, r.Game, crc, r.CRC)
+		return nil
+	}
+	log.Printf("Warning: %s (CRC32 %08X) isn't in the DAT. It may be a hack or translation, or a bad dump whose "+
+		"signature won't match the real cartridge.", name, crc)
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// romIndexVersion is the version of the ROM index file format. Older indexes are rebuilt from scratch.
+const romIndexVersion = 2
+
+// romIndex is a cache of the signature & name of every ROM in a collection, so they don't need to be hashed again on
+// every run. Entries are only trusted while the ROM's size & modification time still match.
+type romIndex struct {
+	Version int `json:"version"`
+	// Root is the absolute path of the directory that was indexed. ROM paths are relative to it & use forward slashes.
+	Root string     `json:"root"`
+	ROMs []romEntry `json:"roms"`
+}
+
+type romEntry struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Signature hexSig    `json:"signature"`
+	Title     string    `json:"title"`
+	Region    string    `json:"region"`
+}
+
+// hexSig is a signature that is stored as an 8 character hex string in JSON, matching how they appear everywhere else
+type hexSig uint32
+
+func (h hexSig) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%08X", uint32(h))), nil
+}
+
+func (h *hexSig) UnmarshalText(b []byte) error {
+	s, err := HexStringTransform(string(b))
+	*h = hexSig(s)
+	return err
+}
+
+// loadROMIndex reads a ROM index file
+func loadROMIndex(path string) (*romIndex, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx romIndex
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if idx.Version != romIndexVersion {
+		return nil, fmt.Errorf("%s: unsupported ROM index version %d", path, idx.Version)
+	}
+	return &idx, nil
+}
+
+// Titles returns a map of signature to title for every ROM in the index that has one
+func (idx *romIndex) Titles() map[uint32]string {
+	titles := make(map[uint32]string)
+	for _, r := range idx.ROMs {
+		if r.Title != "" {
+			titles[uint32(r.Signature)] = r.Title
+		}
+	}
+	return titles
+}
+
+// Regions returns a map of signature to region for every ROM in the index
+func (idx *romIndex) Regions() map[uint32]string {
+	regions := make(map[uint32]string)
+	for _, r := range idx.ROMs {
+		regions[uint32(r.Signature)] = r.Region
+	}
+	return regions
+}
+
+// indexROMs walks root looking for ROMs & returns an index of them. Any ROM already in prev with the same size &
+// modification time is reused rather than being read again. prev may be nil.
+func indexROMs(root string, prev *romIndex) (*romIndex, int, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cached := make(map[string]romEntry)
+	if prev != nil && prev.Root == root {
+		for _, r := range prev.ROMs {
+			cached[r.Path] = r
+		}
+	}
+
+	idx := &romIndex{Version: romIndexVersion, Root: root, ROMs: make([]romEntry, 0)}
+	hashed := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isROMFile(path) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if r, ok := cached[rel]; ok && r.Size == fi.Size() && r.ModTime.Equal(fi.ModTime()) {
+			idx.ROMs = append(idx.ROMs, r)
+			return nil
+		}
+
+		hdr, err := readROMHeader(path)
+		if err != nil {
+			log.Printf("Skipping %v", err)
+			return nil
+		}
+		hashed++
+		idx.ROMs = append(idx.ROMs, romEntry{
+			Path:      rel,
+			Size:      fi.Size(),
+			ModTime:   fi.ModTime(),
+			Signature: hexSig(hdr.Signature),
+			Title:     hdr.Name,
+			Region:    hdr.Region,
+		})
+		return nil
+	})
+
+	return idx, hashed, err
+}
+
+// indexROMsCmd implements `index-roms {dir} -o {index file}`
+func indexROMsCmd(args []string) error {
+	fs := flag.NewFlagSet("index-roms", flag.ExitOnError)
+	out := fs.String("o", "roms.idx", "the index file to create or update")
+	registerROMCheck(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: index-roms {rom directory} [-o roms.idx]")
+	}
+
+	prev, err := loadROMIndex(*out)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Rebuilding index from scratch: %v", err)
+	}
+
+	idx, hashed, err := indexROMs(args[0], prev)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, append(b, '\n')); err != nil {
+		return err
+	}
+	log.Printf("Indexed %d ROMs (%d read, %d unchanged) to %s", len(idx.ROMs), hashed, len(idx.ROMs)-hashed, *out)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// addInSandbox runs addImages against a temporary copy of labelsDB rather than the real thing, then prints a summary of
+// what changed. The copy only replaces the original if commit is set; otherwise it's left in the temp directory so it
+// can be inspected or tried on the console.
+func addInSandbox(labelsDB string, customImgs []Image, settings addSettings, commit bool) (int, error) {
+	dir, err := os.MkdirTemp("", "a3dlabels-sandbox")
+	if err != nil {
+		return 0, err
+	}
+	sandboxDB := filepath.Join(dir, filepath.Base(labelsDB))
+	if err := copyFile(labelsDB, sandboxDB); err != nil {
+		return 0, err
+	}
+	log.Printf("Working on a sandbox copy at %s", sandboxDB)
+
+	skipped, err := addImages(sandboxDB, customImgs, settings)
+	// Only the real labels.db is of any interest to the post_write hooks
+	forgetWrite(sandboxDB)
+	if err != nil {
+		return skipped, err
+	}
+
+	oldDB, err := openDB(labelsDB, settings.Salvage)
+	if err != nil {
+		return skipped, err
+	}
+	newDB, err := labelsdb.Open(sandboxDB)
+	if err != nil {
+		return skipped, err
+	}
+	// With -json the report already says what changed
+	if settings.Report == nil {
+		printDiff(labelsdb.Compare(oldDB, newDB), oldDB.Len(), newDB.Len())
+	}
+
+	if !commit {
+		msg := fmt.Sprintf("%s was not modified. The sandbox copy is at %s; rerun with -commit to apply the changes.",
+			labelsDB, sandboxDB)
+		if settings.Report != nil {
+			log.Print(msg)
+		} else {
+			fmt.Printf("\n%s\n", msg)
+		}
+		return skipped, nil
+	}
+
+	if err := saveDB(labelsDB, newDB); err != nil {
+		return skipped, err
+	}
+	log.Printf("Committed the sandbox changes to %s", labelsDB)
+	return skipped, os.RemoveAll(dir)
+}
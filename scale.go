@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// scaleModes are the ways an image can be fitted to the label's 74x86
+var scaleModes = []string{"stretch", "fit", "fill", "crop"}
+
+// filters are the resampling kernels that can be used for scaling
+var filters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"catmullrom": imaging.CatmullRom,
+	"mitchell":   imaging.MitchellNetravali,
+	"lanczos":    imaging.Lanczos,
+}
+
+// scaleOptions control how a source image is made to fit the label. The zero value stretches the image with a Lanczos
+// filter, which is what the tool has always done.
+type scaleOptions struct {
+	// Mode is one of scaleModes:
+	//   - stretch scales the whole image to 74x86, ignoring its aspect ratio
+	//   - fit scales it to fit inside 74x86 & fills the space left over with PadColor
+	//   - fill scales it to cover 74x86 & trims whatever overhangs, keeping the centre
+	//   - crop doesn't scale at all, taking the centre 74x86 & padding with PadColor if the image is smaller
+	Mode string
+	// Filter is one of the keys of filters
+	Filter   string
+	PadColor color.NRGBA
+	// TrimEdges removes a thin transparent or white edge left around the art by a bad crop before it's fitted, so it
+	// doesn't show as a hairline border on the console
+	TrimEdges bool
+	// Crop is the part of the source image to use, in its own pixels, or empty for all of it. It's only set by sidecars.
+	Crop image.Rectangle
+	// Sharpen is the sigma of the unsharp mask applied to the art once it's scaled, or 0 for none
+	Sharpen float64
+}
+
+// isDefault returns true if the options stretch with Lanczos, so that store keys made before these options existed
+// still match
+func (o scaleOptions) isDefault() bool {
+	return (o.Mode == "" || o.Mode == "stretch") && (o.Filter == "" || o.Filter == "lanczos") && !o.TrimEdges &&
+		o.Crop.Empty() && o.Sharpen == 0
+}
+
+// String describes the options, for store keys
+func (o scaleOptions) String() string {
+	s := fmt.Sprintf("scale=%s filter=%s pad=%02X%02X%02X%02X", o.Mode, o.Filter, o.PadColor.R, o.PadColor.G,
+		o.PadColor.B, o.PadColor.A)
+	// Left out when unset so that keys & state recorded before it existed still match
+	if o.TrimEdges {
+		s += " trim-edges"
+	}
+	if !o.Crop.Empty() {
+		s += fmt.Sprintf(" crop=%d,%d,%d,%d", o.Crop.Min.X, o.Crop.Min.Y, o.Crop.Dx(), o.Crop.Dy())
+	}
+	if o.Sharpen > 0 {
+		s += fmt.Sprintf(" sharpen=%g", o.Sharpen)
+	}
+	return s
+}
+
+// validate checks the mode & filter are known ones & the sharpening isn't negative
+func (o scaleOptions) validate() error {
+	if o.Sharpen < 0 {
+		return fmt.Errorf("-sharpen can't be negative, got %g", o.Sharpen)
+	}
+	if o.Mode != "" && !slices.Contains(scaleModes, o.Mode) {
+		return fmt.Errorf("unknown scale mode %q, expected one of %s", o.Mode, strings.Join(scaleModes, ", "))
+	}
+	if _, ok := filters[o.Filter]; o.Filter != "" && !ok {
+		names := make([]string, 0, len(filters))
+		for f := range filters {
+			names = append(names, f)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("unknown filter %q, expected one of %s", o.Filter, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// parseColor parses a colour given as hex RRGGBB or RRGGBBAA, with or without a leading #
+func parseColor(s string) (color.NRGBA, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if err != nil || (len(b) != 3 && len(b) != 4) {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q, expected RRGGBB or RRGGBBAA", s)
+	}
+	c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 0xFF}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
+
+// scaleImage makes src fit the label according to opts. 16 bit images scaled with Lanczos go through resize16 to keep
+// their precision until the end; everything else is converted to 8 bits as it's scaled.
+func scaleImage(src image.Image, opts scaleOptions) *image.NRGBA {
+	const w, h = labelsdb.Width, labelsdb.Height
+	b := src.Bounds()
+	sw, sh := float64(b.Dx()), float64(b.Dy())
+
+	// crop is the part of src that's used & size is how big it ends up on the label
+	crop := b
+	size := image.Pt(w, h)
+	switch opts.Mode {
+	case "fit":
+		scale := min(w/sw, h/sh)
+		size = image.Pt(max(int(math.Round(sw*scale)), 1), max(int(math.Round(sh*scale)), 1))
+	case "fill":
+		scale := max(w/sw, h/sh)
+		crop = centre(b, int(math.Round(w/scale)), int(math.Round(h/scale)))
+	case "crop":
+		crop = centre(b, w, h)
+		size = crop.Size()
+	}
+
+	if crop != b {
+		src = subImage(src, crop)
+	}
+
+	var img *image.NRGBA
+	filter, ok := filters[opts.Filter]
+	if !ok {
+		filter = imaging.Lanczos
+	}
+	if is16Bit(src) && (opts.Filter == "" || opts.Filter == "lanczos") {
+		img = resize16(src, size.X, size.Y)
+	} else {
+		// imaging converts everything else, including grayscale & paletted images, to 8 bit RGBA as it resizes
+		img = imaging.Resize(src, size.X, size.Y, filter)
+	}
+	// Only the art is sharpened, so its edge doesn't bleed into the padding
+	if opts.Sharpen > 0 {
+		img = imaging.Sharpen(img, opts.Sharpen)
+	}
+	if size == image.Pt(w, h) {
+		return img
+	}
+	return imaging.PasteCenter(imaging.New(w, h, opts.PadColor), img)
+}
+
+// centre returns a w x h rectangle in the middle of r, or r itself in whichever direction it's smaller than that
+func centre(r image.Rectangle, w, h int) image.Rectangle {
+	w, h = min(w, r.Dx()), min(h, r.Dy())
+	x := r.Min.X + (r.Dx()-w)/2
+	y := r.Min.Y + (r.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// subImage returns the part of src within r, sharing its pixels where the image type allows it
+func subImage(src image.Image, r image.Rectangle) image.Image {
+	if s, ok := src.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return imaging.Crop(src, r)
+}
+
+// edgeBounds returns the bounds of src without any thin transparent or white edge along its sides. Up to 2 pixels at
+// the label's size are trimmed from each side, so art with a deliberate border is left alone. If there's no such edge
+// src.Bounds() is returned.
+func edgeBounds(src image.Image) image.Rectangle {
+	b := src.Bounds()
+	blank := func(x, y int) bool {
+		c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+		return c.A < 0x10 || (c.R > 0xF0 && c.G > 0xF0 && c.B > 0xF0)
+	}
+	row := func(y int) bool {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !blank(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	col := func(x int) bool {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			if !blank(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	maxX := max(2, 2*b.Dx()/labelsdb.Width)
+	maxY := max(2, 2*b.Dy()/labelsdb.Height)
+	r := b
+	for n := 0; n < maxY && r.Dy() > 1 && row(r.Min.Y); n++ {
+		r.Min.Y++
+	}
+	for n := 0; n < maxY && r.Dy() > 1 && row(r.Max.Y-1); n++ {
+		r.Max.Y--
+	}
+	for n := 0; n < maxX && r.Dx() > 1 && col(r.Min.X); n++ {
+		r.Min.X++
+	}
+	for n := 0; n < maxX && r.Dx() > 1 && col(r.Max.X-1); n++ {
+		r.Max.X--
+	}
+	return r
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// selftest implements `selftest`, which runs the tool's operations against a synthetic labels.db in a temporary
+// directory & checks the results byte for byte. It's meant as a quick sanity check after building or installing on a
+// new machine, & doesn't touch any real databases or the conversion store.
+func selftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	keep := fs.Bool("keep", false, "don't delete the temporary directory afterwards")
+	fs.BoolVar(&verbose, "verbose", false, "show the log output of each step")
+	fs.BoolVar(&verbose, "v", false, "the same as -verbose")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	// The databases written are all throwaway ones
+	noHooks = true
+
+	dir, err := os.MkdirTemp("", "a3dlabels-selftest")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Printf("Working in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	if !verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	st := &selfTester{dir: dir, db: filepath.Join(dir, "labels.db")}
+	st.run("create an empty database", st.create)
+	st.run("add new entries", st.add)
+	st.run("replace an existing entry", st.replace)
+	st.run("insert an entry in sorted order", st.insert)
+	st.run("pass a custom padding pattern", st.padding)
+	st.run("keep the padding of a replaced entry", st.preservePadding)
+	st.run("extract an entry & add it back unchanged", st.extract)
+	st.run("remove entries", st.remove)
+	st.run("merge another database in", st.merge)
+
+	if st.failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", st.failed, st.total)
+	}
+	fmt.Printf("All %d checks passed\n", st.total)
+	return nil
+}
+
+// selfTester holds the state shared by the selftest steps. Each step builds on the database left by the previous one.
+type selfTester struct {
+	dir           string
+	db            string
+	total, failed int
+}
+
+// run runs a single step & reports the result
+func (st *selfTester) run(name string, step func() error) {
+	st.total++
+	if err := step(); err != nil {
+		st.failed++
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+		return
+	}
+	fmt.Printf("ok    %s\n", name)
+}
+
+// image writes a solid colour PNG named after sig & returns it as an Image
+func (st *selfTester) image(sig uint32, c color.NRGBA) (Image, error) {
+	path := filepath.Join(st.dir, fmt.Sprintf("%08X.png", sig))
+	img := image.NewNRGBA(image.Rect(0, 0, labelsdb.Width*2, labelsdb.Height*2))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return Image{}, err
+	}
+	defer f.Close()
+	return Image{Filepath: path, Signature: sig}, png.Encode(f, img)
+}
+
+// addColours writes solid colour images for the given signatures & adds them to the database
+func (st *selfTester) addColours(settings addSettings, entries map[uint32]color.NRGBA) error {
+	imgs := make([]Image, 0, len(entries))
+	for sig, c := range entries {
+		img, err := st.image(sig, c)
+		if err != nil {
+			return err
+		}
+		imgs = append(imgs, img)
+	}
+	settings.Policy = confirmPolicy{Threshold: -1}
+	skipped, err := addImages(st.db, imgs, settings)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images were skipped", skipped)
+	}
+	return nil
+}
+
+// entries reads the database back & returns the raw data of each entry, in index order
+func (st *selfTester) entries() ([]uint32, [][]byte, error) {
+	db, err := labelsdb.Open(st.db)
+	if err != nil {
+		return nil, nil, err
+	}
+	imgs := make([][]byte, 0, db.Len())
+	for _, e := range db.Entries() {
+		imgs = append(imgs, e.Data)
+	}
+	return db.Signatures(), imgs, nil
+}
+
+// check reads the database back & verifies the index & the size of the file
+func (st *selfTester) check(want []uint32) ([][]byte, error) {
+	sigs, imgs, err := st.entries()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(sigs, want) {
+		return nil, fmt.Errorf("index is %08X, expected %08X", sigs, want)
+	}
+	fi, err := os.Stat(st.db)
+	if err != nil {
+		return nil, err
+	}
+	if size := int64(labelsdb.ImagesStart + len(want)*labelsdb.EntrySize); fi.Size() != size {
+		return nil, fmt.Errorf("file is %d bytes, expected %d", fi.Size(), size)
+	}
+	return imgs, nil
+}
+
+// checkEntry verifies that an entry is entirely one colour & ends with the given padding
+func checkEntry(entry []byte, c color.NRGBA, padding []byte) error {
+	want := bytes.Repeat([]byte{c.B, c.G, c.R, c.A}, labelsdb.Width*labelsdb.Height)
+	if !bytes.Equal(entry[:len(want)], want) {
+		return fmt.Errorf("pixels don't match %v", c)
+	}
+	if !bytes.Equal(entry[len(want):], padding) {
+		return fmt.Errorf("padding is % X, expected % X", entry[len(want):], padding)
+	}
+	return nil
+}
+
+var (
+	red   = color.NRGBA{R: 0xFF, A: 0xFF}
+	green = color.NRGBA{G: 0xFF, A: 0xFF}
+	blue  = color.NRGBA{B: 0xFF, A: 0xFF}
+	grey  = color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF}
+)
+
+func (st *selfTester) create() error {
+	if err := labelsdb.Create(st.db); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(st.db)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b[:labelsdb.IndexStart], []byte(labelsdb.Header)) {
+		return errors.New("header doesn't match")
+	}
+	_, err = st.check([]uint32{})
+	return err
+}
+
+func (st *selfTester) add() error {
+	if err := st.addColours(addSettings{}, map[uint32]color.NRGBA{0x3274BDAF: red, 0x10000000: green}); err != nil {
+		return err
+	}
+	imgs, err := st.check([]uint32{0x10000000, 0x3274BDAF})
+	if err != nil {
+		return err
+	}
+	ff := labelsdb.RepeatPadding([]byte{0xFF})
+	if err := checkEntry(imgs[0], green, ff); err != nil {
+		return err
+	}
+	return checkEntry(imgs[1], red, ff)
+}
+
+func (st *selfTester) replace() error {
+	_, before, err := st.entries()
+	if err != nil {
+		return err
+	}
+	if err := st.addColours(addSettings{}, map[uint32]color.NRGBA{0x3274BDAF: blue}); err != nil {
+		return err
+	}
+	imgs, err := st.check([]uint32{0x10000000, 0x3274BDAF})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(imgs[0], before[0]) {
+		return errors.New("untouched entry was modified")
+	}
+	return checkEntry(imgs[1], blue, labelsdb.RepeatPadding([]byte{0xFF}))
+}
+
+func (st *selfTester) insert() error {
+	_, before, err := st.entries()
+	if err != nil {
+		return err
+	}
+	if err := st.addColours(addSettings{}, map[uint32]color.NRGBA{0x00000001: grey, 0x20000000: red}); err != nil {
+		return err
+	}
+	imgs, err := st.check([]uint32{0x00000001, 0x10000000, 0x20000000, 0x3274BDAF})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(imgs[1], before[0]) || !bytes.Equal(imgs[3], before[1]) {
+		return errors.New("existing entries were modified")
+	}
+	if err := checkEntry(imgs[0], grey, labelsdb.RepeatPadding([]byte{0xFF})); err != nil {
+		return err
+	}
+	return checkEntry(imgs[2], red, labelsdb.RepeatPadding([]byte{0xFF}))
+}
+
+func (st *selfTester) padding() error {
+	pad := labelsdb.RepeatPadding([]byte{0x00, 0xAB})
+	if err := st.addColours(addSettings{Padding: pad}, map[uint32]color.NRGBA{0x40000000: green}); err != nil {
+		return err
+	}
+	imgs, err := st.check([]uint32{0x00000001, 0x10000000, 0x20000000, 0x3274BDAF, 0x40000000})
+	if err != nil {
+		return err
+	}
+	return checkEntry(imgs[4], green, pad)
+}
+
+func (st *selfTester) preservePadding() error {
+	sigs := []uint32{0x00000001, 0x10000000, 0x20000000, 0x3274BDAF, 0x40000000}
+	ff := labelsdb.RepeatPadding([]byte{0xFF})
+	settings := addSettings{Padding: ff, PreservePadding: true}
+	if err := st.addColours(settings, map[uint32]color.NRGBA{0x40000000: red}); err != nil {
+		return err
+	}
+	imgs, err := st.check(sigs)
+	if err != nil {
+		return err
+	}
+	if err := checkEntry(imgs[4], red, labelsdb.RepeatPadding([]byte{0x00, 0xAB})); err != nil {
+		return err
+	}
+
+	settings.PreservePadding = false
+	if err := st.addColours(settings, map[uint32]color.NRGBA{0x40000000: green}); err != nil {
+		return err
+	}
+	if imgs, err = st.check(sigs); err != nil {
+		return err
+	}
+	return checkEntry(imgs[4], green, ff)
+}
+
+func (st *selfTester) extract() error {
+	out := filepath.Join(st.dir, "extracted")
+	if err := extract([]string{st.db, out, "3274BDAF"}); err != nil {
+		return err
+	}
+	// Re-add the extracted image under a new signature. Since it's already the right size it shouldn't be resampled,
+	// so the new entry should be identical to the original.
+	src := filepath.Join(out, "3274BDAF.png")
+	dst := filepath.Join(out, "50000000.png")
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	skipped, err := addImages(st.db, []Image{{Filepath: dst, Signature: 0x50000000}},
+		addSettings{Padding: labelsdb.RepeatPadding([]byte{0xFF}), Policy: confirmPolicy{Threshold: -1}})
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return errors.New("extracted image couldn't be loaded")
+	}
+
+	imgs, err := st.check([]uint32{0x00000001, 0x10000000, 0x20000000, 0x3274BDAF, 0x40000000, 0x50000000})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(imgs[3], imgs[5]) {
+		return errors.New("round-tripped entry doesn't match the original")
+	}
+	return nil
+}
+
+func (st *selfTester) remove() error {
+	_, before, err := st.entries()
+	if err != nil {
+		return err
+	}
+	// 12345678 isn't in the database & should only be warned about
+	if err := remove([]string{st.db, "10000000", "3274BDAF", "12345678"}); err != nil {
+		return err
+	}
+	imgs, err := st.check([]uint32{0x00000001, 0x20000000, 0x40000000, 0x50000000})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(imgs[0], before[0]) || !bytes.Equal(imgs[1], before[2]) || !bytes.Equal(imgs[2], before[4]) ||
+		!bytes.Equal(imgs[3], before[5]) {
+		return errors.New("remaining entries were modified")
+	}
+
+	// The slots freed at the end of the index must not still hold the old signatures
+	b, err := os.ReadFile(st.db)
+	if err != nil {
+		return err
+	}
+	index := b[labelsdb.IndexStart+len(imgs)*4 : labelsdb.IndexStart+len(before)*4+4]
+	if !bytes.Equal(index, bytes.Repeat([]byte{0xFF}, len(index))) {
+		return fmt.Errorf("index after the EOF marker is % X, expected all FF", index)
+	}
+	return nil
+}
+
+func (st *selfTester) merge() error {
+	_, before, err := st.entries()
+	if err != nil {
+		return err
+	}
+	overlay := labelsdb.New()
+	for sig, c := range map[uint32]color.NRGBA{0x20000000: blue, 0x60000000: green} {
+		img := image.NewNRGBA(image.Rect(0, 0, labelsdb.Width, labelsdb.Height))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		if err := overlay.Put(sig, img); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(st.dir, "overlay.db")
+	if err := saveDB(path, overlay); err != nil {
+		return err
+	}
+
+	if err := merge([]string{st.db, path, "-o", st.db}); err != nil {
+		return err
+	}
+	imgs, err := st.check([]uint32{0x00000001, 0x20000000, 0x40000000, 0x50000000, 0x60000000})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(imgs[0], before[0]) || !bytes.Equal(imgs[2], before[2]) || !bytes.Equal(imgs[3], before[3]) {
+		return errors.New("entries not in the overlay were modified")
+	}
+	ff := labelsdb.RepeatPadding([]byte{0xFF})
+	if err := checkEntry(imgs[1], blue, ff); err != nil {
+		return err
+	}
+	return checkEntry(imgs[4], green, ff)
+}
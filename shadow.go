@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// byteRange is a run of bytes that differ between two versions of a file
+type byteRange struct {
+	Offset, Length int64
+}
+
+// diffBytes returns every run of bytes in b that differs from a. If b is longer, the extra bytes are one last run; if
+// it's shorter, the run covers what's been cut off.
+func diffBytes(a, b []byte) []byteRange {
+	ranges := make([]byteRange, 0)
+	n := min(len(a), len(b))
+	for i := 0; i < n; {
+		if a[i] == b[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < n && a[i] != b[i] {
+			i++
+		}
+		ranges = append(ranges, byteRange{int64(start), int64(i - start)})
+	}
+	if len(a) != len(b) {
+		ranges = append(ranges, byteRange{int64(n), int64(max(len(a), len(b)) - n)})
+	}
+	return ranges
+}
+
+// errNotWritten is returned when the user declines to write the changes shown by -shadow-diff, so that nothing that
+// depends on them having been written, such as -since-state, is recorded
+var errNotWritten = errors.New("cancelled, nothing was written")
+
+// shadowDiff writes db to memory rather than to labelsDB, prints every byte range that would change in the file, &
+// asks whether to go ahead. It returns true if db should be written, & false with no error if it would be written the
+// same as it is, so that it already holds the changes. Declining returns errNotWritten. A -yes policy goes ahead without
+// asking; otherwise stdin needs to be a terminal.
+func shadowDiff(labelsDB string, db *labelsdb.DB, policy *confirmPolicy, w io.Writer) (bool, error) {
+	old, err := os.ReadFile(labelsDB)
+	if err != nil {
+		return false, err
+	}
+	var shadow bytes.Buffer
+	if _, err := db.WriteTo(&shadow); err != nil {
+		return false, err
+	}
+
+	ranges := diffBytes(old, shadow.Bytes())
+	if len(ranges) == 0 {
+		fmt.Fprintf(w, "%s would be written byte for byte the same.\n", labelsDB)
+		return false, nil
+	}
+	sigs := db.Signatures()
+	t := newTable(w, "Offset", "Length", "Part")
+	var changed int64
+	for _, r := range ranges {
+		// The end is only named when it's in a different part of the file from the start
+		part := describeOffset(sigs, r.Offset)
+		end := describeOffset(sigs, r.Offset+r.Length-1)
+		if p, _, _ := strings.Cut(part, " +"); !strings.HasPrefix(end, p+" +") {
+			part += " to " + end
+		}
+		t.row(fmt.Sprintf("0x%06X", r.Offset), formatCount(int(r.Length)), part)
+		changed += r.Length
+	}
+	if err := t.flush(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(w, "\n%s bytes in %s ranges would change. %s is %s now & would be %s.\n", formatCount(int(changed)),
+		formatCount(len(ranges)), labelsDB, formatBytes(int64(len(old))), formatBytes(int64(shadow.Len())))
+
+	if policy.Yes || policy.Force {
+		return true, nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false, errors.New("-shadow-diff needs a terminal to ask before writing; rerun with -yes to write anyway")
+	}
+	fmt.Fprintf(os.Stderr, "Write these changes to %s? [y/N] ", labelsDB)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	log.Printf("%s was not modified", labelsDB)
+	return false, errNotWritten
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imageOverrides are the settings in an image's sidecar: a JSON file named after its signature next to it, such as
+// 635A2BFF.json beside 635A2BFF.png, which keeps per-game tweaks with the art itself. The keys are named after the flags
+// they override, & anything left out is taken from the flags as usual.
+type imageOverrides struct {
+	// Crop is the part of the source image to use, in its own pixels, before it's fitted to the label
+	Crop           *cropBox `json:"crop"`
+	ScaleMode      *string  `json:"scale-mode"`
+	Filter         *string  `json:"filter"`
+	PadColor       *string  `json:"pad-color"`
+	AutoTrimEdges  *bool    `json:"auto-trim-edges"`
+	Sharpen        *float64 `json:"sharpen"`
+	Alpha          *string  `json:"alpha"`
+	Background     *string  `json:"background"`
+	RoundedCorners *bool    `json:"rounded-corners"`
+}
+
+// cropBox is a sidecar's crop: the top left corner & the size, in the source image's pixels
+type cropBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// isSidecar reports whether path is an image's sidecar rather than a manifest, which is decided by its name alone: a
+// sidecar's is a full 8 digit signature
+func isSidecar(path string) bool {
+	name := filepath.Base(path)
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	if !strings.EqualFold(filepath.Ext(name), ".json") || len(stem) != 8 {
+		return false
+	}
+	_, err := HexStringTransform(stem)
+	return err == nil
+}
+
+// findSidecar returns the sidecar for the image at path, or "" if it hasn't got one
+func findSidecar(path string) string {
+	sidecar := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	if !isSidecar(sidecar) {
+		return ""
+	}
+	if fi, err := os.Stat(sidecar); err != nil || fi.IsDir() {
+		return ""
+	}
+	return sidecar
+}
+
+// loadOverrides reads the sidecar at path
+func loadOverrides(path string) (imageOverrides, error) {
+	var ov imageOverrides
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ov, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&ov); err != nil {
+		return ov, fmt.Errorf("%s: %w", path, err)
+	}
+	return ov, nil
+}
+
+// apply returns opts with the overrides made to it
+func (ov imageOverrides) apply(opts convertOptions) (convertOptions, error) {
+	var err error
+	s, a := &opts.Scale, &opts.Alpha
+	if ov.Crop != nil {
+		if ov.Crop.Width <= 0 || ov.Crop.Height <= 0 {
+			return opts, errors.New("crop needs a width & height")
+		}
+		s.Crop = image.Rect(ov.Crop.X, ov.Crop.Y, ov.Crop.X+ov.Crop.Width, ov.Crop.Y+ov.Crop.Height)
+	}
+	if ov.ScaleMode != nil {
+		s.Mode = *ov.ScaleMode
+	}
+	if ov.Filter != nil {
+		s.Filter = *ov.Filter
+	}
+	if ov.PadColor != nil {
+		if s.PadColor, err = parseColor(*ov.PadColor); err != nil {
+			return opts, err
+		}
+	}
+	if ov.AutoTrimEdges != nil {
+		s.TrimEdges = *ov.AutoTrimEdges
+	}
+	if ov.Sharpen != nil {
+		s.Sharpen = *ov.Sharpen
+	}
+	if ov.Alpha != nil {
+		a.Mode = *ov.Alpha
+	}
+	if ov.Background != nil {
+		if a.Background, err = parseColor(*ov.Background); err != nil {
+			return opts, err
+		}
+	}
+	if ov.RoundedCorners != nil {
+		a.RoundedCorners = *ov.RoundedCorners
+	}
+	if err := s.validate(); err != nil {
+		return opts, err
+	}
+	return opts, a.validate()
+}
+
+// withSidecar returns the options to convert img with: opts, with any overrides in its sidecar made to them
+func withSidecar(img Image, opts convertOptions) (convertOptions, error) {
+	if img.Sidecar == "" {
+		return opts, nil
+	}
+	ov, err := loadOverrides(img.Sidecar)
+	if err != nil {
+		return opts, err
+	}
+	if opts, err = ov.apply(opts); err != nil {
+		return opts, fmt.Errorf("%s: %w", img.Sidecar, err)
+	}
+	return opts, nil
+}
+
+// stampImage returns the fileStamp of img's file, taking in its sidecar if it has one, so that editing the sidecar
+// counts as changing the image
+func stampImage(img Image) (fileStamp, error) {
+	st, err := stampFile(img.Filepath)
+	if err != nil || img.Sidecar == "" {
+		return st, err
+	}
+	sc, err := stampFile(img.Sidecar)
+	if err != nil {
+		// A sidecar that's been deleted changes the stamp too
+		return st, nil
+	}
+	st.Size += sc.Size
+	if sc.ModTime.After(st.ModTime) {
+		st.ModTime = sc.ModTime
+	}
+	return st, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sig implements `sig {rom...} [-rename]`, printing the label signature of each ROM whatever its byte order. With
+// -rename, the image alongside each ROM with the same name is renamed to the signature, ready to be added. With
+// -patch, the signature is that of the ROM once the patch has been applied to it, & the image renamed is the one
+// alongside the patch.
+func sig(args []string) error {
+	fs := flag.NewFlagSet("sig", flag.ExitOnError)
+	rename := fs.Bool("rename", false, "rename the image with the same name as each ROM to its signature")
+	registerROMCheck(fs)
+	dryRun := fs.Bool("dry-run", false, "with -rename, show what would be renamed without renaming anything")
+	patch := fs.String("patch", "", "an IPS, BPS, or xdelta patch to apply to the ROM, for the signature of a romhack")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || (*patch != "" && len(args) != 1) {
+		return errors.New("usage: sig {rom...} [-rename [-dry-run]] | sig {base rom} -patch {patch} [-rename [-dry-run]]")
+	}
+
+	failed := 0
+	for _, path := range args {
+		var hdr romHeader
+		if *patch != "" {
+			hdr, err = patchedROMHeader(path, *patch)
+			path = *patch
+		} else {
+			hdr, err = readROMHeader(path)
+		}
+		if err != nil {
+			log.Print(err)
+			failed++
+			continue
+		}
+		fmt.Printf("%08X  %s\n", hdr.Signature, path)
+		if !*rename {
+			continue
+		}
+
+		img, ok := companionImage(path)
+		if !ok {
+			log.Printf("No image found for %s", path)
+			continue
+		}
+		ext := filepath.Ext(img)
+		dest := filepath.Join(filepath.Dir(img), fmt.Sprintf("%08X%s", hdr.Signature, strings.ToLower(ext)))
+		if strings.EqualFold(img, dest) {
+			continue
+		} else if _, err := os.Stat(dest); err == nil {
+			log.Printf("Not renaming %s: %s already exists", img, dest)
+			continue
+		}
+		if *dryRun {
+			log.Printf("Would rename %s to %s", img, dest)
+			continue
+		}
+		if err := os.Rename(img, dest); err != nil {
+			return err
+		}
+		log.Printf("Renamed %s to %s", img, dest)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files couldn't be read as ROMs", failed, len(args))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"slices"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// similarHashBits is the number of bits in an imageHash, & so the largest possible distance between two
+const similarHashBits = 64
+
+// imageHash is a perceptual hash of an image: close images have hashes that differ in only a few bits, so a label can be
+// found again after being resized, recompressed, or colour corrected
+type imageHash uint64
+
+// distance returns the number of bits that differ between two hashes
+func (h imageHash) distance(o imageHash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// similarMatch is an entry found by find-similar
+type similarMatch struct {
+	Slot      int
+	Signature uint32
+	Distance  int
+}
+
+// findSimilar implements `find-similar {labels.db} {image}`, listing the entries whose art looks most like the image.
+// It's for tracking down which signature holds a label that's showing up on the wrong game.
+func findSimilar(args []string) error {
+	fs := flag.NewFlagSet("find-similar", flag.ExitOnError)
+	count := fs.Int("n", 5, "the number of matches to list")
+	maxDistance := fs.Int("max-distance", 16, fmt.Sprintf("leave out entries whose hashes differ in more than this "+
+		"many of the %d bits", similarHashBits))
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	var scale scaleOptions
+	fs.StringVar(&scale.Mode, "scale-mode", "stretch",
+		"how the image was fitted to the label when it was added: stretch, fit, fill, or crop")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 || *count < 1 || (*dat != "" && *roms == "") {
+		return errors.New("usage: find-similar {labels.db} {image} [-n 5] [-max-distance 16] [-scale-mode mode] " +
+			"[-roms roms.idx [-dat file]] [-titles file]")
+	}
+	scale.Filter = "lanczos"
+	if err := scale.validate(); err != nil {
+		return err
+	}
+
+	src, err := getImg(args[1])
+	if err != nil {
+		return err
+	}
+	// The query goes through the same scaling as an image being added, so it lines up with the entry made from it
+	query := hashImage(scaleImage(src, scale))
+
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+
+	matches := make([]similarMatch, 0, db.Len())
+	for i, e := range db.Entries() {
+		if d := query.distance(hashImage(labelsdb.Decode(e.Data))); d <= *maxDistance {
+			matches = append(matches, similarMatch{i, e.Signature, d})
+		}
+	}
+	if len(matches) == 0 {
+		fmt.Printf("Nothing in %s looks like %s\n", args[0], args[1])
+		return nil
+	}
+	slices.SortStableFunc(matches, func(a, b similarMatch) int { return a.Distance - b.Distance })
+	matches = matches[:min(len(matches), *count)]
+
+	t := newTable(os.Stdout, "Signature", "Slot", "Distance", "Similarity", "Title")
+	for _, m := range matches {
+		t.row(fmt.Sprintf("%08X", m.Signature), fmt.Sprint(m.Slot), fmt.Sprint(m.Distance),
+			formatPercent(float64(similarHashBits-m.Distance)*100/similarHashBits), titles[m.Signature].Title)
+	}
+	return t.flush()
+}
+
+// hashImage returns the difference hash of img: it's shrunk to 9x8 greyscale & each bit records whether a pixel is
+// brighter than the one to its right. Transparent pixels count as black.
+func hashImage(img image.Image) imageHash {
+	small := imaging.Resize(img, 9, 8, imaging.Box)
+	var h imageHash
+	for y := range 8 {
+		for x := range 8 {
+			h <<= 1
+			if luma(small, x, y) > luma(small, x+1, y) {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// luma returns the brightness of the pixel at x, y, darkened by its transparency
+func luma(img *image.NRGBA, x, y int) int {
+	c := img.NRGBAAt(x, y)
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) * int(c.A) / 0xFF
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// artSource is one of the places fetch looks for a game's art. They're tried in the order given, & the first that has
+// art for a game is used.
+type artSource struct {
+	// Kind is dir, cache, or libretro
+	Kind string
+	// Arg is the directory for dir, & the thumbnail type for cache & libretro
+	Arg string
+	// Regions limits the source to games whose ROM header is for one of these regions. Empty means every game.
+	Regions []string
+	// MinWidth & MinHeight reject art smaller than this, so that a later source can supply something better
+	MinWidth, MinHeight int
+
+	// spec is the source as it was given, for messages
+	spec string
+	// art is the images in a dir source, indexed the first time they're needed
+	art *artIndex
+}
+
+// parseArtSource parses a source given as kind[:arg][,filter...], where the filters are region=Name|Name &
+// min-size=WxH. The kinds are dir:{directory}, cache[:type], & libretro[:type], with the type defaulting to kind.
+func parseArtSource(v, kind string) (artSource, error) {
+	parts := strings.Split(v, ",")
+	src := artSource{spec: v}
+	src.Kind, src.Arg, _ = strings.Cut(strings.TrimSpace(parts[0]), ":")
+	switch src.Kind {
+	case "dir":
+		if src.Arg == "" {
+			return src, fmt.Errorf("source %q: dir needs a directory, as dir:path", v)
+		}
+	case "cache", "libretro":
+		if src.Arg == "" {
+			src.Arg = kind
+		}
+		if _, ok := thumbnailTypes[src.Arg]; !ok {
+			return src, fmt.Errorf("source %q: %q isn't a thumbnail type; use boxart, title, snap, or logo", v, src.Arg)
+		}
+	case "screenscraper":
+		return src, fmt.Errorf("source %q: ScreenScraper isn't supported, as it needs a registered developer account", v)
+	default:
+		return src, fmt.Errorf("source %q: unknown source %q; use dir:path, cache, or libretro", v, src.Kind)
+	}
+
+	for _, f := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(f), "=")
+		switch key {
+		case "region":
+			src.Regions = strings.Split(value, "|")
+		case "min-size":
+			if _, err := fmt.Sscanf(value, "%dx%d", &src.MinWidth, &src.MinHeight); err != nil {
+				return src, fmt.Errorf("source %q: min-size should be WxH, e.g. 200x200", v)
+			}
+		default:
+			return src, fmt.Errorf("source %q: unknown filter %q; use region=Name|Name or min-size=WxH", v, key)
+		}
+	}
+	return src, nil
+}
+
+// sourceFlag collects the -source flags, in the order they're tried. It can be repeated.
+type sourceFlag []string
+
+func (s *sourceFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *sourceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// artSources returns the sources given with -source, or failing that the fetch_sources in the config file, or failing
+// that just libretro. kind is the -type, used by the sources that don't give their own.
+func artSources(flags []string, kind string) ([]artSource, error) {
+	specs := flags
+	if len(specs) == 0 {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		specs = cfg["fetch_sources"]
+	}
+	if len(specs) == 0 {
+		specs = []string{"libretro"}
+	}
+	sources := make([]artSource, len(specs))
+	for i, spec := range specs {
+		var err error
+		if sources[i], err = parseArtSource(spec, kind); err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+// thumbnailFetcher is what the cache & libretro sources need to find a thumbnail
+type thumbnailFetcher struct {
+	// Cache is the directory downloaded thumbnails are kept in, with a folder for each type
+	Cache string
+	// Template is the -url to download them from
+	Template string
+}
+
+// allows reports whether the source may be used for a game from region
+func (src *artSource) allows(region string) bool {
+	return len(src.Regions) == 0 || slices.ContainsFunc(src.Regions, func(r string) bool {
+		return strings.EqualFold(strings.TrimSpace(r), region)
+	})
+}
+
+// find returns the source's art for the game with signature sig & the given title, & whether it had to be downloaded
+func (src *artSource) find(sig uint32, title string, tf thumbnailFetcher) (string, bool, error) {
+	switch src.Kind {
+	case "dir":
+		if src.art == nil {
+			art, err := findArt(src.Arg)
+			if err != nil {
+				return "", false, err
+			}
+			src.art = art
+		}
+		// Art named after the signature is the surest match, then art named after the game
+		if path, ok := src.art.exact[strings.ToLower(fmt.Sprintf("%08X", sig))]; ok {
+			return path, false, nil
+		}
+		if paths, _ := src.art.match(title); len(paths) > 0 {
+			return paths[0], false, nil
+		}
+		return "", false, errors.New("no image with that signature or title")
+	}
+
+	folder := thumbnailTypes[src.Arg]
+	name := libretroName(title)
+	dst := filepath.Join(tf.Cache, folder, name+".png")
+	if _, err := os.Stat(dst); err == nil {
+		return dst, false, nil
+	} else if src.Kind == "cache" {
+		return "", false, errors.New("not downloaded yet")
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", false, err
+	}
+	u := strings.NewReplacer("{type}", url.PathEscape(folder), "{title}", url.PathEscape(name)).Replace(tf.Template)
+	if err := fetchFile(u, dst); err != nil {
+		return "", false, err
+	}
+	return dst, true, nil
+}
+
+// bigEnough checks the image at path against the source's min-size
+func (src *artSource) bigEnough(path string) error {
+	if src.MinWidth == 0 && src.MinHeight == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return decodeError(path, err)
+	}
+	if cfg.Width < src.MinWidth || cfg.Height < src.MinHeight {
+		return fmt.Errorf("%s is only %dx%d", filepath.Base(path), cfg.Width, cfg.Height)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// formatSpec describes the labels.db format as this tool understands it. It's built from the same constants the rest
+// of the code uses, so the published description can't drift from what the tool actually does.
+type formatSpec struct {
+	Header struct {
+		Offset        int    `json:"offset"`
+		Size          int    `json:"size"`
+		IDLength      int    `json:"id_length"`
+		VersionOffset int    `json:"version_offset"`
+		Version       int    `json:"version"`
+		Magic         string `json:"magic"`
+	} `json:"header"`
+	Index struct {
+		Offset     int    `json:"offset"`
+		Size       int    `json:"size"`
+		WordSize   int    `json:"word_size"`
+		Endianness string `json:"endianness"`
+		EOFMarker  string `json:"eof_marker"`
+		MaxEntries int    `json:"max_entries"`
+		Order      string `json:"order"`
+	} `json:"index"`
+	Entries struct {
+		Offset         int    `json:"offset"`
+		Size           int    `json:"size"`
+		Width          int    `json:"width"`
+		Height         int    `json:"height"`
+		PixelFormat    string `json:"pixel_format"`
+		RowOrder       string `json:"row_order"`
+		PaddingSize    int    `json:"padding_size"`
+		DefaultPadding string `json:"default_padding"`
+		Order          string `json:"order"`
+	} `json:"entries"`
+	Signature struct {
+		Algorithm string `json:"algorithm"`
+		Length    int    `json:"length"`
+		ByteOrder string `json:"byte_order"`
+	} `json:"signature"`
+}
+
+// buildSpec fills in a formatSpec from the format constants
+func buildSpec() formatSpec {
+	var s formatSpec
+	s.Header.Offset = 0
+	s.Header.Size = labelsdb.IndexStart
+	s.Header.IDLength = labelsdb.HeaderIDLength
+	s.Header.VersionOffset = labelsdb.HeaderVersion
+	s.Header.Version = int(labelsdb.Header[labelsdb.HeaderVersion])
+	s.Header.Magic = fmt.Sprintf("%X", labelsdb.Header[:labelsdb.HeaderIDLength])
+
+	s.Index.Offset = labelsdb.IndexStart
+	s.Index.Size = labelsdb.ImagesStart - labelsdb.IndexStart
+	s.Index.WordSize = 4
+	s.Index.Endianness = "little"
+	s.Index.EOFMarker = fmt.Sprintf("0x%08X", labelsdb.IndexEOF)
+	s.Index.MaxEntries = labelsdb.MaxEntries
+	s.Index.Order = "ascending signature"
+
+	s.Entries.Offset = labelsdb.ImagesStart
+	s.Entries.Size = labelsdb.EntrySize
+	s.Entries.Width = labelsdb.Width
+	s.Entries.Height = labelsdb.Height
+	s.Entries.PixelFormat = "BGRA8888, straight alpha"
+	s.Entries.RowOrder = "top to bottom"
+	s.Entries.PaddingSize = labelsdb.PaddingSize
+	s.Entries.DefaultPadding = fmt.Sprintf("0x%02X", defaultConvertOptions().Padding[0])
+	s.Entries.Order = "same as index"
+
+	s.Signature.Algorithm = "CRC-32 (IEEE)"
+	s.Signature.Length = sigLength
+	s.Signature.ByteOrder = "big endian (.z64)"
+	return s
+}
+
+// spec implements `spec [-json]`, printing the format description
+func spec(args []string) error {
+	fs := flag.NewFlagSet("spec", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the spec as JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	s := buildSpec()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	fmt.Printf("Header     0x%04X-0x%04X  format version %d at 0x%X, first 0x%X bytes identify the file\n",
+		s.Header.Offset, s.Header.Offset+s.Header.Size, s.Header.Version, s.Header.VersionOffset, s.Header.IDLength)
+	fmt.Printf("Index      0x%04X-0x%04X  %d-byte %s endian signatures in %s order, ended by %s, max %d entries\n",
+		s.Index.Offset, s.Index.Offset+s.Index.Size, s.Index.WordSize, s.Index.Endianness, s.Index.Order,
+		s.Index.EOFMarker, s.Index.MaxEntries)
+	fmt.Printf("Entries    0x%04X+        0x%X bytes each in index order: %dx%d %s, rows %s, then %d bytes of padding (%s)\n",
+		s.Entries.Offset, s.Entries.Size, s.Entries.Width, s.Entries.Height, s.Entries.PixelFormat, s.Entries.RowOrder,
+		s.Entries.PaddingSize, s.Entries.DefaultPadding)
+	fmt.Printf("Signature  %s of the first 0x%X bytes of the ROM in %s order\n", s.Signature.Algorithm,
+		s.Signature.Length, s.Signature.ByteOrder)
+	return nil
+}
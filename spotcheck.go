@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// spotCheck implements `spot-check {labels.db} [-previous {old labels.db}] [-n 10]`, which picks a random sample of the
+// entries that changed since the previous copy & prints them as a checklist, so the user can check a few on the console
+// after syncing instead of scrolling through all of them. The seed is printed so the same sample can be drawn again.
+func spotCheck(args []string) error {
+	fs := flag.NewFlagSet("spot-check", flag.ExitOnError)
+	previous := fs.String("previous", "", "the labels.db to compare against (default labels.db.bak next to labels.db)")
+	n := fs.Int("n", 10, "how many entries to pick")
+	seed := fs.Uint64("seed", 0, "pick the same sample as an earlier run that printed this seed")
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *n < 1 || (*dat != "" && *roms == "") {
+		return errors.New("usage: spot-check {labels.db} [-previous {old labels.db}] [-n 10] [-seed N] " +
+			"[-roms roms.idx [-dat file]] [-titles file]")
+	}
+	if *previous == "" {
+		*previous = args[0] + ".bak"
+		if _, err := os.Stat(*previous); err != nil {
+			return fmt.Errorf("no -previous given & there's no %s to compare against", *previous)
+		}
+	}
+
+	oldDB, err := labelsdb.Open(*previous)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *previous, err)
+	}
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", args[0], err)
+	}
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+
+	d := labelsdb.Compare(oldDB, db)
+	changed := append(slices.Clone(d.Added), d.Changed...)
+	slices.Sort(changed)
+	if len(changed) == 0 {
+		fmt.Printf("No labels changed between %s & %s\n", *previous, args[0])
+		return nil
+	}
+
+	if *seed == 0 {
+		*seed = uint64(time.Now().UnixNano())
+	}
+	r := rand.New(rand.NewPCG(*seed, 0))
+	r.Shuffle(len(changed), func(i, j int) { changed[i], changed[j] = changed[j], changed[i] })
+	pick := changed[:min(*n, len(changed))]
+	slices.Sort(pick)
+
+	fmt.Printf("Check these %d of the %s changed labels on the console (seed %d):\n\n", len(pick),
+		formatCount(len(changed)), *seed)
+	for _, sig := range pick {
+		line := fmt.Sprintf("[ ] %08X", sig)
+		if t := titles[sig].Title; t != "" {
+			line += "  " + t
+		}
+		if slices.Contains(d.Added, sig) {
+			line += "  (new)"
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("\nIf one looks wrong, report it with its signature. `why` explains where an entry's art came from.")
+	return nil
+}
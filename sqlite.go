@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// sqliteSchema is the CREATE TABLE statement for the entries table export-sqlite writes. The slot is the entry's place
+// in the index; import-sqlite ignores it & orders the entries by signature, as labels.db has to.
+const sqliteSchema = `CREATE TABLE entries (
+	slot INTEGER PRIMARY KEY,
+	signature TEXT NOT NULL,
+	title TEXT,
+	title_source TEXT,
+	author TEXT,
+	license TEXT,
+	source TEXT,
+	stock INTEGER,
+	sha256 TEXT,
+	pixels BLOB NOT NULL,
+	padding BLOB
+)`
+
+// exportSQLite implements `export-sqlite {labels.db} {labels.sqlite}`, which writes every entry to an SQLite database
+// along with its title, provenance, & a hash of its art, so the collection can be queried & edited with SQL &
+// import-sqlite can turn it back into a labels.db.
+func exportSQLite(args []string) error {
+	fs := flag.NewFlagSet("export-sqlite", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up titles")
+	dat := fs.String("dat", "", "a No-Intro DAT used to name the ROMs in the ROM index (needs -roms)")
+	titleFile := fs.String("titles", "", "a file of signature, title lines")
+	manifest := fs.String("manifest", "", "a manifest whose author, license, & source to record for each entry")
+	stock := fs.String("stock", "", "a stock labels.db, to record which entries are stock art")
+	out := fs.String("o", "", "the SQLite database to write, instead of giving it after labels.db")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *out != "" && len(args) == 1 {
+		args = append(args, *out)
+	}
+	if len(args) != 2 || (*out != "" && args[1] != *out) || (*dat != "" && *roms == "") {
+		return errors.New("usage: export-sqlite {labels.db} {labels.sqlite | -o labels.sqlite} [-roms roms.idx " +
+			"[-dat file]] [-titles file] [-manifest file] [-stock labels.db]")
+	}
+
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	titles, err := loadTitles(*titleFile, *dat, *roms)
+	if err != nil {
+		return err
+	}
+	provenance := make(map[hexSig]manifestEntry)
+	if *manifest != "" {
+		entries, err := readManifest(*manifest)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			provenance[e.Signature] = e
+		}
+	}
+	var stockDB *labelsdb.DB
+	if *stock != "" {
+		if stockDB, err = labelsdb.Open(*stock); err != nil {
+			return err
+		}
+	}
+
+	// Empty strings are stored as NULL, so that IS NULL finds everything unknown
+	orNull := func(s string) any {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	rows := make([]sqliteRow, 0, db.Len())
+	for i, e := range db.Entries() {
+		pixels := e.Data[:labelsdb.EntrySize-labelsdb.PaddingSize]
+		var isStock any
+		if stockDB != nil {
+			isStock = int64(0)
+			if s, ok := stockDB.Entry(e.Signature); ok && slices.Equal(s[:len(pixels)], pixels) {
+				isStock = int64(1)
+			}
+		}
+		sum := sha256.Sum256(pixels)
+		t, p := titles[e.Signature], provenance[hexSig(e.Signature)]
+		rows = append(rows, sqliteRow{int64(i), []any{nil, fmt.Sprintf("%08X", e.Signature), orNull(t.Title),
+			orNull(t.Source), orNull(p.Author), orNull(p.License), orNull(p.Source), isStock, hex.EncodeToString(sum[:]),
+			pixels, labelsdb.PaddingOf(e.Data)}})
+	}
+
+	info := []sqliteRow{
+		{1, []any{"database", args[0]}},
+		{2, []any{"exported", time.Now().UTC().Format(time.RFC3339)}},
+		{3, []any{"tool_version", toolVersion()}},
+		{4, []any{"pixel_format", fmt.Sprintf("%dx%d BGRA", labelsdb.Width, labelsdb.Height)}},
+	}
+	if m := readMark(args[0]); m != nil {
+		info = append(info, sqliteRow{5, []any{"customized", m.String()}})
+	}
+
+	data := writeSQLite([]sqliteTable{
+		{"entries", sqliteSchema, rows},
+		{"info", "CREATE TABLE info (key TEXT, value TEXT)", info},
+	})
+	if err := writeFileAtomic(args[1], data); err != nil {
+		return err
+	}
+	log.Printf("Exported %s entries to %s", formatCount(len(rows)), args[1])
+	return nil
+}
+
+// importSQLite implements `import-sqlite {labels.sqlite} {labels.db}`, which makes labels.db hold exactly the rows of
+// the entries table in an SQLite database written by export-sqlite, however it's been edited since. Only the signature,
+// pixels, & padding columns are used; a NULL padding gets the stock padding.
+func importSQLite(args []string) error {
+	fs := flag.NewFlagSet("import-sqlite", flag.ExitOnError)
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: import-sqlite {labels.sqlite} {labels.db} [-backup] [-dry-run]")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	f, err := openSQLite(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	root, cols, err := f.table("entries")
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	sigCol, pixCol, padCol := slices.Index(cols, "signature"), slices.Index(cols, "pixels"), slices.Index(cols, "padding")
+	if sigCol < 0 || pixCol < 0 {
+		return fmt.Errorf("%s: the entries table needs signature & pixels columns", args[0])
+	}
+
+	entries := make(map[uint32][]byte)
+	err = f.rows(root, func(rowid int64, v []any) error {
+		// Columns added after a row was written are missing from it
+		col := func(i int) any {
+			if i < 0 || i >= len(v) {
+				return nil
+			}
+			return v[i]
+		}
+		var sig uint32
+		switch s := col(sigCol).(type) {
+		case string:
+			if sig, err = HexStringTransform(s); err != nil {
+				return fmt.Errorf("row %d: %w", rowid, err)
+			}
+		case int64:
+			sig = uint32(s)
+		default:
+			return fmt.Errorf("row %d: the signature should be hex text, not %v", rowid, s)
+		}
+		pixels, _ := col(pixCol).([]byte)
+		if len(pixels) != labelsdb.EntrySize-labelsdb.PaddingSize {
+			return fmt.Errorf("row %d (%08X): pixels should be a %d byte blob", rowid, sig,
+				labelsdb.EntrySize-labelsdb.PaddingSize)
+		}
+		padding, _ := col(padCol).([]byte)
+		if padding == nil {
+			padding = labelsdb.DefaultPadding()
+		} else if len(padding) != labelsdb.PaddingSize {
+			return fmt.Errorf("row %d (%08X): padding should be a %d byte blob or NULL", rowid, sig,
+				labelsdb.PaddingSize)
+		}
+		if _, ok := entries[sig]; ok {
+			return fmt.Errorf("row %d: %08X is in the table more than once", rowid, sig)
+		}
+		entries[sig] = append(slices.Clone(pixels), padding...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if len(entries) > labelsdb.MaxEntries {
+		return fmt.Errorf("%w: the table has %s entries, but only %s fit", labelsdb.ErrFull, formatCount(len(entries)),
+			formatCount(labelsdb.MaxEntries))
+	}
+
+	// Starting from the existing file keeps its header as it is
+	orig, err := labelsdb.Open(args[1])
+	if errors.Is(err, os.ErrNotExist) {
+		orig = labelsdb.New()
+	} else if err != nil {
+		return err
+	}
+	db := orig.Clone()
+	for _, sig := range db.Signatures() {
+		if _, ok := entries[sig]; !ok {
+			db.Remove(sig)
+		}
+	}
+	for sig, e := range entries {
+		if err := db.PutEntry(sig, e); err != nil {
+			return err
+		}
+	}
+
+	if *dryRun {
+		printDiff(labelsdb.Compare(orig, db), orig.Len(), db.Len())
+		fmt.Printf("\nDry run: %s was not modified.\n", args[1])
+		return nil
+	}
+	if *backup {
+		if err := backupFile(args[1], args[1]+".bak"); err != nil {
+			return err
+		}
+	}
+	log.Printf("Writing %s images to %s", formatCount(db.Len()), args[1])
+	return saveDB(args[1], db)
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Only as much of the SQLite file format as export-sqlite & import-sqlite need is understood: writing a database of
+// plain rowid tables, & reading the rows of one table back, from a file SQLite may have changed since in any way it
+// likes. Indexes, WITHOUT ROWID tables, & UTF-16 databases aren't supported. See https://sqlite.org/fileformat2.html.
+
+const (
+	// sqlitePageSize is the page size export-sqlite writes with
+	sqlitePageSize = 4096
+	// sqliteMagic is what every SQLite database starts with
+	sqliteMagic = "SQLite format 3\x00"
+
+	sqliteLeafTable     = 0x0D
+	sqliteInteriorTable = 0x05
+)
+
+var errNotSQLite = errors.New("not an SQLite database")
+
+// sqliteTable is a table to be written: its name, its CREATE TABLE statement, & its rows, each keyed by its rowid. A
+// column declared INTEGER PRIMARY KEY must be nil in the row, as SQLite keeps its value as the rowid.
+type sqliteTable struct {
+	Name string
+	SQL  string
+	Rows []sqliteRow
+}
+
+// sqliteRow is a row of a table. Values may be nil, int64, float64, string, or []byte.
+type sqliteRow struct {
+	RowID  int64
+	Values []any
+}
+
+// putVarint appends v to b as an SQLite varint: big endian, 7 bits a byte, with a 9th byte holding a full 8 bits
+func putVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7F) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7F)
+		n++
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := buf[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// readVarint returns the varint at the start of b & its length, which is 0 if b is too short
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// encodeRecord returns values in the record format
+func encodeRecord(values []any) []byte {
+	types := make([]byte, 0, len(values))
+	var body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = putVarint(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = putVarint(types, 8)
+			case v == 1:
+				types = putVarint(types, 9)
+			default:
+				for i, n := range []int{1, 2, 3, 4, 6, 8} {
+					if n == 8 || (v >= -1<<(8*n-1) && v < 1<<(8*n-1)) {
+						types = putVarint(types, uint64(i+1))
+						var buf [8]byte
+						binary.BigEndian.PutUint64(buf[:], uint64(v))
+						body = append(body, buf[8-n:]...)
+						break
+					}
+				}
+			}
+		case float64:
+			types = putVarint(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = putVarint(types, uint64(len(v))*2+13)
+			body = append(body, v...)
+		case []byte:
+			types = putVarint(types, uint64(len(v))*2+12)
+			body = append(body, v...)
+		default:
+			panic(fmt.Sprintf("can't store a %T in SQLite", v))
+		}
+	}
+	// The header's size includes the varint giving it, which is nearly always 1 byte
+	size := len(types) + 1
+	if size > 0x7F {
+		size = len(putVarint(nil, uint64(size+1))) + len(types)
+	}
+	rec := putVarint(nil, uint64(size))
+	rec = append(rec, types...)
+	return append(rec, body...)
+}
+
+// decodeRecord returns the values in a record
+func decodeRecord(rec []byte) ([]any, error) {
+	size, n := readVarint(rec)
+	if n == 0 || size > uint64(len(rec)) {
+		return nil, errors.New("record header is truncated")
+	}
+	header, body := rec[n:size], rec[size:]
+	values := make([]any, 0)
+	for len(header) > 0 {
+		t, n := readVarint(header)
+		if n == 0 {
+			return nil, errors.New("record header is truncated")
+		}
+		header = header[n:]
+
+		var length uint64
+		switch {
+		case t == 0 || t == 8 || t == 9:
+		case t <= 4:
+			length = t
+		case t == 5:
+			length = 6
+		case t == 6 || t == 7:
+			length = 8
+		case t >= 12:
+			length = (t - 12) / 2
+		default:
+			return nil, fmt.Errorf("unknown serial type %d", t)
+		}
+		if length > uint64(len(body)) {
+			return nil, errors.New("record is truncated")
+		}
+		b := body[:length]
+		body = body[length:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8 || t == 9:
+			values = append(values, int64(t-8))
+		case t <= 6:
+			v := int64(int8(b[0]))
+			for _, c := range b[1:] {
+				v = v<<8 | int64(c)
+			}
+			values = append(values, v)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case t%2 == 0:
+			values = append(values, b)
+		default:
+			values = append(values, string(b))
+		}
+	}
+	return values, nil
+}
+
+// sqliteLocal returns how much of a payload of p bytes is kept on a table leaf page with u usable bytes, the rest going
+// to overflow pages
+func sqliteLocal(p, u int) int {
+	x := u - 35
+	if p <= x {
+		return p
+	}
+	m := (u-12)*32/255 - 23
+	if k := m + (p-m)%(u-4); k <= x {
+		return k
+	}
+	return m
+}
+
+// sqliteBuilder lays out the pages of a new database
+type sqliteBuilder struct {
+	pages [][]byte
+}
+
+// alloc adds a page, returning its number. Pages are numbered from 1.
+func (b *sqliteBuilder) alloc() int {
+	b.pages = append(b.pages, make([]byte, sqlitePageSize))
+	return len(b.pages)
+}
+
+// leafCell returns the cell for a row, spilling what doesn't fit onto newly allocated overflow pages
+func (b *sqliteBuilder) leafCell(row sqliteRow) []byte {
+	payload := encodeRecord(row.Values)
+	cell := putVarint(nil, uint64(len(payload)))
+	cell = putVarint(cell, uint64(row.RowID))
+	local := sqliteLocal(len(payload), sqlitePageSize)
+	cell = append(cell, payload[:local]...)
+	if rest := payload[local:]; len(rest) > 0 {
+		first := b.alloc()
+		cell = binary.BigEndian.AppendUint32(cell, uint32(first))
+		for page := first; len(rest) > 0; {
+			n := copy(b.pages[page-1][4:], rest)
+			if rest = rest[n:]; len(rest) > 0 {
+				next := b.alloc()
+				binary.BigEndian.PutUint32(b.pages[page-1], uint32(next))
+				page = next
+			}
+		}
+	}
+	return cell
+}
+
+// btreePage is a page of a b-tree being built, along with the largest rowid under it
+type btreePage struct {
+	num    int
+	maxKey int64
+}
+
+// fill writes a b-tree page of the given type holding cells. start is where the page header goes: 100 on page 1, after
+// the database header, & 0 everywhere else. right is the right-most child of an interior page.
+func (b *sqliteBuilder) fill(num, start int, kind byte, cells [][]byte, right int) {
+	p := b.pages[num-1]
+	hdr := 8
+	if kind == sqliteInteriorTable {
+		hdr = 12
+		binary.BigEndian.PutUint32(p[start+8:], uint32(right))
+	}
+	p[start] = kind
+	binary.BigEndian.PutUint16(p[start+3:], uint16(len(cells)))
+	end := len(p)
+	for i, c := range cells {
+		end -= len(c)
+		copy(p[end:], c)
+		binary.BigEndian.PutUint16(p[start+hdr+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(p[start+5:], uint16(end))
+}
+
+// fits reports whether cells, plus one more of size extra, fit on a leaf page
+func fits(cells [][]byte, extra int) bool {
+	used := 8 + 2*(len(cells)+1) + extra
+	for _, c := range cells {
+		used += len(c)
+	}
+	return used <= sqlitePageSize
+}
+
+// table writes the rows of a table, which must be in rowid order, returning its root page
+func (b *sqliteBuilder) table(rows []sqliteRow) int {
+	var level []btreePage
+	var cells [][]byte
+	var lastKey int64
+	flush := func() {
+		num := b.alloc()
+		b.fill(num, 0, sqliteLeafTable, cells, 0)
+		level = append(level, btreePage{num, lastKey})
+		cells = nil
+	}
+	for _, r := range rows {
+		c := b.leafCell(r)
+		if len(cells) > 0 && !fits(cells, len(c)) {
+			flush()
+		}
+		cells = append(cells, c)
+		lastKey = r.RowID
+	}
+	if len(cells) > 0 || len(level) == 0 {
+		flush()
+	}
+
+	// Each interior page points at up to perPage pages of the level below, the last as its right-most child. An
+	// interior page needs at least one cell, so the last page never gets a single child.
+	const perPage = (sqlitePageSize - 12) / (2 + 4 + 9)
+	for len(level) > 1 {
+		var next []btreePage
+		for i := 0; i < len(level); {
+			n := min(perPage, len(level)-i)
+			if len(level)-i-n == 1 {
+				n--
+			}
+			group := level[i : i+n]
+			cells := make([][]byte, 0, n-1)
+			for _, c := range group[:n-1] {
+				cells = append(cells, putVarint(binary.BigEndian.AppendUint32(nil, uint32(c.num)), uint64(c.maxKey)))
+			}
+			num := b.alloc()
+			b.fill(num, 0, sqliteInteriorTable, cells, group[n-1].num)
+			next = append(next, btreePage{num, group[n-1].maxKey})
+			i += n
+		}
+		level = next
+	}
+	return level[0].num
+}
+
+// writeSQLite returns a database file holding tables
+func writeSQLite(tables []sqliteTable) []byte {
+	b := &sqliteBuilder{}
+	b.alloc()
+	master := make([][]byte, 0, len(tables))
+	for i, t := range tables {
+		root := b.table(t.Rows)
+		row := sqliteRow{int64(i + 1), []any{"table", t.Name, t.Name, int64(root), t.SQL}}
+		master = append(master, b.leafCell(row))
+	}
+	b.fill(1, 100, sqliteLeafTable, master, 0)
+
+	h := b.pages[0]
+	copy(h, sqliteMagic)
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1                                      // legacy (rollback journal) read & write versions
+	h[21], h[22], h[23] = 64, 32, 32                         // payload fractions, which must be these values
+	binary.BigEndian.PutUint32(h[24:], 1)                    // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(b.pages))) // size in pages
+	binary.BigEndian.PutUint32(h[40:], 1)                    // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4)                    // schema format
+	binary.BigEndian.PutUint32(h[56:], 1)                    // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1)                    // the change counter the size is valid for
+	binary.BigEndian.PutUint32(h[96:], 3040001)              // the SQLite version this mimics
+
+	out := make([]byte, 0, len(b.pages)*sqlitePageSize)
+	for _, p := range b.pages {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// sqliteFile is a database file being read
+type sqliteFile struct {
+	data           []byte
+	pageSize, used int
+}
+
+// openSQLite checks data is an SQLite database this can read
+func openSQLite(data []byte) (*sqliteFile, error) {
+	if len(data) < 100 || string(data[:16]) != sqliteMagic {
+		return nil, errNotSQLite
+	}
+	f := &sqliteFile{data: data, pageSize: int(binary.BigEndian.Uint16(data[16:]))}
+	if f.pageSize == 1 {
+		f.pageSize = 65536
+	}
+	f.used = f.pageSize - int(data[20])
+	if data[18] == 2 || data[19] == 2 {
+		return nil, errors.New("the database is in WAL mode; run PRAGMA journal_mode=DELETE on it first")
+	}
+	if enc := binary.BigEndian.Uint32(data[56:]); enc > 1 {
+		return nil, errors.New("only UTF-8 databases are supported")
+	}
+	return f, nil
+}
+
+// page returns page num
+func (f *sqliteFile) page(num uint32) ([]byte, error) {
+	start := int64(num-1) * int64(f.pageSize)
+	if num == 0 || start+int64(f.pageSize) > int64(len(f.data)) {
+		return nil, fmt.Errorf("page %d is past the end of the file", num)
+	}
+	return f.data[start : start+int64(f.pageSize)], nil
+}
+
+// rows calls fn with every row of the table whose b-tree starts at root, in rowid order
+func (f *sqliteFile) rows(root uint32, fn func(rowid int64, values []any) error) error {
+	return f.walk(root, 0, fn)
+}
+
+// walk implements rows, with depth guarding against a corrupt file that loops
+func (f *sqliteFile) walk(num uint32, depth int, fn func(int64, []any) error) error {
+	if depth > 20 {
+		return errors.New("the table's b-tree is too deep; the file may be corrupt")
+	}
+	p, err := f.page(num)
+	if err != nil {
+		return err
+	}
+	start := 0
+	if num == 1 {
+		start = 100
+	}
+	kind := p[start]
+	n := int(binary.BigEndian.Uint16(p[start+3:]))
+	hdr := 8
+	if kind == sqliteInteriorTable {
+		hdr = 12
+	} else if kind != sqliteLeafTable {
+		return fmt.Errorf("page %d isn't part of a rowid table (type 0x%02X)", num, kind)
+	}
+
+	for i := 0; i < n; i++ {
+		off := int(binary.BigEndian.Uint16(p[start+hdr+2*i:]))
+		if off >= len(p) {
+			return fmt.Errorf("page %d has a cell past its end", num)
+		}
+		cell := p[off:]
+		if kind == sqliteInteriorTable {
+			if err := f.walk(binary.BigEndian.Uint32(cell), depth+1, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		size, a := readVarint(cell)
+		rowid, b := readVarint(cell[a:])
+		if a == 0 || b == 0 {
+			return fmt.Errorf("page %d has a truncated cell", num)
+		}
+		payload, err := f.payload(cell[a+b:], int(size))
+		if err != nil {
+			return fmt.Errorf("page %d: %w", num, err)
+		}
+		values, err := decodeRecord(payload)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", int64(rowid), err)
+		}
+		if err := fn(int64(rowid), values); err != nil {
+			return err
+		}
+	}
+	if kind == sqliteInteriorTable {
+		return f.walk(binary.BigEndian.Uint32(p[start+8:]), depth+1, fn)
+	}
+	return nil
+}
+
+// payload returns a cell's payload of size bytes, gathering whatever overflowed from the overflow pages
+func (f *sqliteFile) payload(cell []byte, size int) ([]byte, error) {
+	local := sqliteLocal(size, f.used)
+	if local > len(cell) {
+		return nil, errors.New("truncated cell")
+	}
+	if local == size {
+		return cell[:size], nil
+	}
+	out := append(make([]byte, 0, size), cell[:local]...)
+	next := binary.BigEndian.Uint32(cell[local:])
+	for len(out) < size {
+		p, err := f.page(next)
+		if err != nil {
+			return nil, err
+		}
+		n := min(size-len(out), f.used-4)
+		out = append(out, p[4:4+n]...)
+		next = binary.BigEndian.Uint32(p)
+	}
+	return out, nil
+}
+
+// table returns the root page of the table called name & the names of its columns, in order
+func (f *sqliteFile) table(name string) (uint32, []string, error) {
+	var root uint32
+	var sql string
+	err := f.rows(1, func(_ int64, v []any) error {
+		if len(v) >= 5 && v[0] == "table" && strings.EqualFold(fmt.Sprint(v[1]), name) {
+			r, _ := v[3].(int64)
+			root, sql = uint32(r), fmt.Sprint(v[4])
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if root == 0 {
+		return 0, nil, fmt.Errorf("there's no %s table", name)
+	}
+	return root, sqliteColumns(sql), nil
+}
+
+// sqliteColumns returns the names of the columns in a CREATE TABLE statement, lower cased. Table constraints are left
+// out.
+func sqliteColumns(sql string) []string {
+	open, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil
+	}
+	var defs []string
+	depth, from := 0, open+1
+	for i := open + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[from:i])
+				from = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[from:end])
+
+	cols := make([]string, 0, len(defs))
+	for _, d := range defs {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(strings.Trim(fields[0], "\"`[]'"))
+		switch name {
+		case "primary", "unique", "check", "foreign", "constraint":
+			continue
+		}
+		cols = append(cols, name)
+	}
+	return cols
+}
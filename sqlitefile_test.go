@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// TestSQLiteRoundTrip writes tables with what export-sqlite needs, including enough rows to need interior pages & blobs
+// big enough to need overflow pages, & checks that reading them back gives every value as it was written
+func TestSQLiteRoundTrip(t *testing.T) {
+	const schema = "CREATE TABLE entries (slot INTEGER PRIMARY KEY, name TEXT, n INTEGER, f REAL, data BLOB)"
+	ints := []int64{0, 1, -1, 127, -128, 1 << 20, -(1 << 40), math.MaxInt64, math.MinInt64}
+	var rows []sqliteRow
+	for i := range 3000 {
+		var data any
+		switch i % 3 {
+		case 1:
+			data = bytes.Repeat([]byte{byte(i)}, i%50)
+		case 2:
+			// A whole entry's pixels, which overflow onto several pages
+			data = bytes.Repeat([]byte{byte(i), 0xA3}, 74*86*2)
+		}
+		var name any = fmt.Sprintf("game %d", i)
+		if i%7 == 0 {
+			name = nil
+		}
+		rows = append(rows, sqliteRow{int64(i), []any{nil, name, ints[i%len(ints)], float64(i) / 4, data}})
+	}
+	info := []sqliteRow{{1, []any{"key", "value"}}, {2, []any{"empty", ""}}}
+
+	f, err := openSQLite(writeSQLite([]sqliteTable{
+		{"entries", schema, rows},
+		{"info", "CREATE TABLE info (key TEXT, value TEXT)", info},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		cols []string
+		rows []sqliteRow
+	}{
+		{"entries", []string{"slot", "name", "n", "f", "data"}, rows},
+		{"info", []string{"key", "value"}, info},
+	} {
+		root, cols, err := f.table(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(cols, tc.cols) {
+			t.Errorf("%s has the columns %v, want %v", tc.name, cols, tc.cols)
+		}
+		var got []sqliteRow
+		err = f.rows(root, func(rowid int64, values []any) error {
+			got = append(got, sqliteRow{rowid, values})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.rows) {
+			t.Fatalf("read %d rows of %s, want %d", len(got), tc.name, len(tc.rows))
+		}
+		for i, r := range got {
+			if !reflect.DeepEqual(r, tc.rows[i]) {
+				t.Errorf("%s row %d: read %v, want %v", tc.name, i, abbreviate(r), abbreviate(tc.rows[i]))
+			}
+		}
+	}
+
+	if _, _, err := f.table("missing"); err == nil {
+		t.Error("reading a table that isn't there succeeded")
+	}
+}
+
+// abbreviate shortens the blobs in a row, for messages
+func abbreviate(r sqliteRow) string {
+	s := make([]string, len(r.Values))
+	for i, v := range r.Values {
+		if b, ok := v.([]byte); ok {
+			s[i] = fmt.Sprintf("%d byte blob", len(b))
+		} else {
+			s[i] = fmt.Sprintf("%#v", v)
+		}
+	}
+	return fmt.Sprintf("%d %v", r.RowID, s)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runStateVersion is the version of the -since-state file format. A state file with any other version is ignored &
+// everything is added again.
+const runStateVersion = 1
+
+// runState is what -since-state records after a successful run, so that the next one can skip any input that hasn't
+// changed. It's meant for unattended runs, such as a nightly sync of a shared art folder.
+type runState struct {
+	Version int `json:"version"`
+	// DB is the labels.db as the run left it. If it's changed since, such as by a firmware update replacing it,
+	// everything is added again.
+	DB fileStamp `json:"db"`
+	// Settings describes the conversion settings. Changing them also means adding everything again.
+	Settings string `json:"settings"`
+	// Inputs are the images that were added, by absolute path
+	Inputs map[string]inputStamp `json:"inputs"`
+}
+
+// fileStamp is what's used to tell whether a file has changed
+type fileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type inputStamp struct {
+	fileStamp
+	Signature hexSig `json:"signature"`
+}
+
+// stampFile returns the fileStamp of the file at path
+func stampFile(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{fi.Size(), fi.ModTime()}, nil
+}
+
+// loadRunState reads a state file. A missing or outdated one gives an empty state, so that everything is added.
+func loadRunState(path string) (*runState, error) {
+	st := &runState{Version: runStateVersion, Inputs: make(map[string]inputStamp)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var prev runState
+	if err := json.Unmarshal(b, &prev); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if prev.Version != runStateVersion || prev.Inputs == nil {
+		return st, nil
+	}
+	return &prev, nil
+}
+
+// changed returns the images that need adding: all of them if labelsDB or the settings have changed since the state
+// was recorded, otherwise only those that are new or whose file has changed
+func (st *runState) changed(labelsDB string, imgs []Image, settings string) []Image {
+	if db, err := stampFile(labelsDB); err != nil || !db.ModTime.Equal(st.DB.ModTime) || db.Size != st.DB.Size ||
+		settings != st.Settings {
+		return imgs
+	}
+	changed := make([]Image, 0)
+	for _, img := range imgs {
+		path, err := filepath.Abs(img.Filepath)
+		if err != nil {
+			changed = append(changed, img)
+			continue
+		}
+		prev, ok := st.Inputs[path]
+		fs, err := stampImage(img)
+		if !ok || err != nil || uint32(prev.Signature) != img.Signature || fs.Size != prev.Size ||
+			!fs.ModTime.Equal(prev.ModTime) {
+			changed = append(changed, img)
+		}
+	}
+	return changed
+}
+
+// save records a successful run that added imgs to labelsDB. Only the images passed in are recorded, so an input that's
+// been removed since the last run is forgotten.
+func (st *runState) save(path, labelsDB string, imgs []Image, settings string) error {
+	db, err := stampFile(labelsDB)
+	if err != nil {
+		return err
+	}
+	st.DB, st.Settings = db, settings
+	st.Inputs = make(map[string]inputStamp, len(imgs))
+	for _, img := range imgs {
+		// There's no cheap way to tell whether a remote image has changed, so they're always added again
+		if isRemote(img.Filepath) {
+			continue
+		}
+		abs, err := filepath.Abs(img.Filepath)
+		if err != nil {
+			return err
+		}
+		fs, err := stampImage(img)
+		if err != nil {
+			return err
+		}
+		st.Inputs[abs] = inputStamp{fs, hexSig(img.Signature)}
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// stateKey describes everything in the settings that affects the entries written, for runState
+func (s addSettings) stateKey() string {
+	padding := "auto"
+	if s.Padding != nil {
+		padding = fmt.Sprintf("%X", s.Padding)
+	}
+	k := fmt.Sprintf("padding=%s preserve=%t clean=%t %s marks=%s %s", padding, s.PreservePadding, s.CleanIndex,
+		s.Scale, markKey(s.Stamps, s.Badges), s.Colour)
+	// Left out when unset so that state recorded before the alpha options existed still matches
+	if !s.Alpha.isDefault() {
+		k += " " + s.Alpha.String()
+	}
+	if len(s.Framing) > 0 {
+		k += " framing=" + framingKey(s.Framing)
+	}
+	return k
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/bits"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// dbStats is the summary printed by the stats command
+type dbStats struct {
+	Entries  int   `json:"entries"`
+	Capacity int   `json:"capacity"`
+	FileSize int64 `json:"file_size"`
+	// IndexJunk is the number of non-blank index slots after the EOF marker
+	IndexJunk int `json:"index_junk"`
+	// Regions counts entries by the region of the matching ROM. Only filled in with -per-region.
+	Regions map[string]int `json:"regions,omitempty"`
+	// Years & Publishers count entries by the release year & manufacturer the DAT gives for the matching ROM. Only
+	// filled in with -per-year & -per-publisher.
+	Years      map[string]int `json:"years,omitempty"`
+	Publishers map[string]int `json:"publishers,omitempty"`
+	// Lookup is only filled in with -lookup
+	Lookup *lookupStats `json:"lookup,omitempty"`
+	// Customized is the mark left by the last write made with this tool, if there's been one
+	Customized *customMark `json:"customized,omitempty"`
+}
+
+// lookupStats describes how the signatures are spread through the index & how much work finding one is. How the
+// console searches the index isn't known, so the cost is given for both a linear & a binary search.
+type lookupStats struct {
+	// ByFirstDigit counts the signatures by their first hex digit. CRC32s are close to random, so these should be
+	// roughly even.
+	ByFirstDigit [16]int `json:"by_first_digit"`
+	// MaxSkew is the furthest any signature is, in slots, from where a perfectly even spread would put it. It matters
+	// if the console estimates a signature's position rather than searching for it.
+	MaxSkew     int    `json:"max_skew"`
+	MaxSkewSlot int    `json:"max_skew_slot"`
+	MaxSkewSig  hexSig `json:"max_skew_signature"`
+	// LinearWorst is the most slots a linear search reads, which is for a signature that isn't there as it has to go
+	// all the way to the EOF marker. LinearAverage is the average for signatures that are there.
+	LinearWorst   int     `json:"linear_worst"`
+	LinearAverage float64 `json:"linear_average"`
+	// BinaryWorst is the most slots a binary search reads
+	BinaryWorst int `json:"binary_worst"`
+}
+
+// indexLookup works out the lookupStats for a sorted index
+func indexLookup(sigs []uint32) *lookupStats {
+	n := len(sigs)
+	l := &lookupStats{LinearWorst: n + 1, LinearAverage: float64(n+1) / 2, BinaryWorst: bits.Len(uint(n))}
+	for i, s := range sigs {
+		l.ByFirstDigit[s>>28]++
+		expected := int(uint64(s) * uint64(n) >> 32)
+		if skew := max(i-expected, expected-i); skew > l.MaxSkew {
+			l.MaxSkew, l.MaxSkewSlot, l.MaxSkewSig = skew, i, hexSig(s)
+		}
+	}
+	return l
+}
+
+// stats implements `stats {labels.db} [-roms roms.idx] [-dat file] [-per-region] [-per-year] [-per-publisher] [-json]`
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to look up regions")
+	dat := fs.String("dat", "", "a DAT giving each game's year & publisher (needs -roms)")
+	perRegion := fs.Bool("per-region", false, "break the entries down by region (needs -roms)")
+	perYear := fs.Bool("per-year", false, "break the entries down by release year (needs -roms & -dat)")
+	perPublisher := fs.Bool("per-publisher", false, "break the entries down by publisher (needs -roms & -dat)")
+	asJSON := fs.Bool("json", false, "print the stats as JSON")
+	lookup := fs.Bool("lookup", false, "show how the signatures are spread through the index & how costly lookups are")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || (*perRegion && *roms == "") || (*dat != "" && *roms == "") ||
+		((*perYear || *perPublisher) && *dat == "") {
+		return errors.New("usage: stats {labels.db} [-roms roms.idx [-per-region] [-dat file [-per-year] " +
+			"[-per-publisher]]] [-lookup] [-json] [-raw]")
+	}
+
+	fi, err := os.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sigs, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		return err
+	}
+
+	junk, err := labelsdb.ReadIndexJunk(f)
+	if err != nil {
+		return err
+	}
+
+	st := dbStats{Entries: len(sigs), Capacity: labelsdb.MaxEntries, FileSize: fi.Size(), IndexJunk: junk,
+		Customized: readMark(args[0])}
+	if *lookup {
+		st.Lookup = indexLookup(sigs)
+	}
+	if *perRegion || *perYear || *perPublisher {
+		idx, err := loadROMIndex(*roms)
+		if err != nil {
+			return err
+		}
+		if *perRegion {
+			regions := idx.Regions()
+			st.Regions = make(map[string]int)
+			for _, s := range sigs {
+				r, ok := regions[s]
+				if !ok {
+					r = "Not in ROM index"
+				}
+				st.Regions[r]++
+			}
+		}
+		if *perYear || *perPublisher {
+			games, err := datGames(*dat, idx)
+			if err != nil {
+				return err
+			}
+			if *perYear {
+				st.Years = countByGame(sigs, games, func(g datGame) string { return g.Year })
+			}
+			if *perPublisher {
+				st.Publishers = countByGame(sigs, games, func(g datGame) string { return g.Manufacturer })
+			}
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	fmt.Printf("Entries:   %s of %s (%s)\n", formatCount(st.Entries), formatCount(st.Capacity),
+		formatPercent(float64(st.Entries)*100/float64(st.Capacity)))
+	fmt.Printf("File size: %s\n", formatBytes(st.FileSize))
+	if st.Customized != nil {
+		fmt.Printf("Modified:  %s\n", st.Customized)
+	}
+	if st.IndexJunk > 0 {
+		fmt.Printf("Index junk: %s non-blank slots after the EOF marker\n", formatCount(st.IndexJunk))
+	}
+	printBreakdown("By region", st.Regions)
+	printBreakdown("By year", st.Years)
+	printBreakdown("By publisher", st.Publishers)
+	if l := st.Lookup; l != nil {
+		fmt.Println("\nBy first digit:")
+		most := slices.Max(l.ByFirstDigit[:])
+		for d, c := range l.ByFirstDigit {
+			if plainOutput {
+				fmt.Printf("%Xxxxxxxx: %s\n", d, formatCount(c))
+				continue
+			}
+			bar := 0
+			if most > 0 {
+				bar = c * 40 / most
+			}
+			fmt.Printf("  %Xxxxxxxx %6s %s\n", d, formatCount(c), strings.Repeat("#", bar))
+		}
+		fmt.Println("\nLookup:")
+		if st.Entries > 0 {
+			fmt.Printf("  Furthest from an even spread: %08X in slot %s, %s slots out\n", uint32(l.MaxSkewSig),
+				formatCount(l.MaxSkewSlot), formatCount(l.MaxSkew))
+		}
+		fmt.Printf("  Linear search: up to %s slots read, %.1f on average for a signature that's there\n",
+			formatCount(l.LinearWorst), l.LinearAverage)
+		fmt.Printf("  Binary search: up to %s slots read\n", formatCount(l.BinaryWorst))
+	}
+	return nil
+}
+
+// countByGame counts sigs by a field of the DAT game each one matches. Signatures with no matching game, & games that
+// leave the field out, are counted separately.
+func countByGame(sigs []uint32, games map[uint32]datGame, field func(datGame) string) map[string]int {
+	counts := make(map[string]int)
+	for _, s := range sigs {
+		g, ok := games[s]
+		switch v := field(g); {
+		case !ok:
+			counts["Not in DAT"]++
+		case v == "":
+			counts["Not given"]++
+		default:
+			counts[v]++
+		}
+	}
+	return counts
+}
+
+// printBreakdown prints the counts under a heading, largest first. Nothing is printed for a nil map.
+func printBreakdown(heading string, counts map[string]int) {
+	if counts == nil {
+		return
+	}
+	fmt.Printf("\n%s:\n", heading)
+	names := make([]string, 0, len(counts))
+	for r := range counts {
+		names = append(names, r)
+	}
+	// Largest first, then alphabetically
+	slices.SortFunc(names, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	for _, r := range names {
+		if plainOutput {
+			fmt.Printf("%s: %s\n", r, formatCount(counts[r]))
+		} else {
+			fmt.Printf("  %-20s %s\n", r, formatCount(counts[r]))
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// storeVersion is mixed into every source key. Bump it whenever the image conversion changes in a way that would make
+// previously stored entries wrong, so that stale conversions are never reused.
+const storeVersion = 2
+
+// entryStore is a content-addressed store of converted entries on disk. Each entry is saved once under the SHA-256 of
+// its bytes in objects/, while refs/ maps the hash of a source image (plus whatever settings were used to convert it)
+// to the object it produced. Anything that needs entries can hold onto the object hash rather than a copy of the data.
+//
+// Several runs can share a store at once. Every file is written to a temporary name & renamed into place, so readers
+// never see a partial one, & conversions take a lock in locks/ so that parallel runs given the same image wait for each
+// other rather than both converting it.
+type entryStore struct {
+	dir string
+}
+
+// defaultStoreDir returns the location of the store within the user's cache directory
+func defaultStoreDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "a3dlabels", "store"), nil
+}
+
+// openStore returns a store rooted at dir, creating the directory structure if needed
+func openStore(dir string) (*entryStore, error) {
+	for _, sub := range []string{"objects", "refs", "locks"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &entryStore{dir: dir}, nil
+}
+
+// Put saves entry to the store if it isn't already there & returns its hash
+func (s *entryStore) Put(entry []byte) (string, error) {
+	sum := sha256.Sum256(entry)
+	hash := hex.EncodeToString(sum[:])
+
+	path := s.objectPath(hash)
+	// An object that's already there is only trusted if it's intact. One left damaged by a crash gets replaced.
+	if _, err := s.Get(hash); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, entry); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get returns the entry stored under hash
+func (s *entryStore) Get(hash string) ([]byte, error) {
+	b, err := os.ReadFile(s.objectPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	// Double-check the contents so a damaged object can never end up in a labels.db
+	sum := sha256.Sum256(b)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("store object %s is corrupt", hash)
+	}
+	return b, nil
+}
+
+// Lookup returns the entry previously converted from the source identified by key, or os.ErrNotExist if there isn't one
+func (s *entryStore) Lookup(key string) ([]byte, error) {
+	ref, err := os.ReadFile(filepath.Join(s.dir, "refs", key))
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(strings.TrimSpace(string(ref)))
+}
+
+// Link records that the source identified by key converts to the object with the given hash
+func (s *entryStore) Link(key, hash string) error {
+	return writeFileAtomic(filepath.Join(s.dir, "refs", key), []byte(hash+"\n"))
+}
+
+// lock takes an exclusive lock covering key, blocking until any other run holding it is done. Keys are spread over 256
+// lock files by their first byte, which keeps the number of files fixed while making it unlikely that unrelated
+// conversions wait on each other. The returned function releases the lock.
+func (s *entryStore) lock(key string) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, "locks", key[:2]), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// objectPath returns where an object lives. Objects are fanned out by the first byte of the hash to keep directory
+// sizes reasonable.
+func (s *entryStore) objectPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash[:2], hash[2:])
+}
+
+// sourceKey hashes the contents of the source image at path, along with any settings that affect its conversion.
+func sourceKey(path string, settings ...string) (string, error) {
+	f, _, err := openSource(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	fmt.Fprintf(h, "v%d\x00%dx%d\x00", storeVersion, labelsdb.Width, labelsdb.Height)
+	for _, s := range settings {
+		fmt.Fprintf(h, "%s\x00", s)
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadImageStored is loadImage backed by the store: if this exact source has been converted before with the same
+// options, the stored entry is returned instead of decoding & resizing it again. A nil store disables this.
+func loadImageStored(s *entryStore, filename string, opts convertOptions) ([]byte, error) {
+	if s == nil {
+		return loadImage(filename, opts)
+	}
+
+	key, err := sourceKey(filename, opts.key())
+	if err != nil {
+		return nil, err
+	}
+	debugf("Store key for %s is %s", filename, key)
+	if b, err := s.Lookup(key); err == nil {
+		log.Printf("Loading %s (cached)\n", filename)
+		return b, nil
+	}
+
+	unlock, err := s.lock(key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// Check again now the lock is held, in case another run converted it while this one was waiting
+	if b, err := s.Lookup(key); err == nil {
+		log.Printf("Loading %s (cached)\n", filename)
+		return b, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		// A broken store shouldn't stop anything, so just convert the image as normal
+		log.Printf("Ignoring stored conversion of %s: %v", filename, err)
+	}
+
+	b, err := loadImage(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.Put(b)
+	if err != nil {
+		return nil, err
+	}
+	debugf("Stored the conversion of %s as %s", filename, hash)
+	return b, s.Link(key, hash)
+}
+
+// conversionMemo shares conversions between the targets of a profile being written at the same time, so that an image
+// several targets process the same way is only converted once, even when there's no store to find it in
+type conversionMemo struct {
+	mu    sync.Mutex
+	calls map[string]*memoCall
+}
+
+// memoCall is one conversion in a conversionMemo, made by whichever target asks for it first
+type memoCall struct {
+	once  sync.Once
+	entry []byte
+	err   error
+}
+
+func newConversionMemo() *conversionMemo {
+	return &conversionMemo{calls: make(map[string]*memoCall)}
+}
+
+// load is loadImageStored, but converts each source with the same options only once. Anyone else asking for it waits
+// for that conversion & gets their own copy of the entry. A nil memo just calls loadImageStored.
+func (m *conversionMemo) load(s *entryStore, filename string, opts convertOptions) ([]byte, error) {
+	if m == nil {
+		return loadImageStored(s, filename, opts)
+	}
+	key := filename + "\x00" + opts.key()
+	m.mu.Lock()
+	c, ok := m.calls[key]
+	if !ok {
+		c = new(memoCall)
+		m.calls[key] = c
+	}
+	m.mu.Unlock()
+	c.once.Do(func() { c.entry, c.err = loadImageStored(s, filename, opts) })
+	return slices.Clone(c.entry), c.err
+}
+
+// writeFileAtomic writes data to a temporary file alongside path & renames it into place, so nothing reading path ever
+// sees a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	defer beginWrite()()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := keepAttributes(tmp, path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Without a sync, a crash soon after the rename can leave an empty file in place of the old one on some filesystems
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// The commands in this file edit the index directly, without any of the usual checks. They exist to recover databases
+// that have been corrupted in unusual ways, so each one needs -i-know-what-im-doing before it will do anything.
+
+// surgeryFlags adds the flags shared by all of the index surgery commands
+func surgeryFlags(name string) (*flag.FlagSet, *bool, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	sure := fs.Bool("i-know-what-im-doing", false, "confirm that you understand this can corrupt the database")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	return fs, sure, backup
+}
+
+// openForSurgery checks the confirmation flag, opens the db for writing, checks it is a labels.db, & makes the backup if
+// asked. The surgery commands write at fixed offsets, so pointed at the wrong file they'd corrupt it.
+func openForSurgery(path string, sure, backup bool) (*os.File, error) {
+	if !sure {
+		return nil, errors.New("this command edits the index directly & can easily corrupt the database; " +
+			"rerun with -i-know-what-im-doing if you're sure")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := labelsdb.Check(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if backup {
+		if err := backupFile(path, path+".bak"); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// parseSlot parses an index slot number & checks it fits in the index
+func parseSlot(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index slot %q: %w", s, err)
+	}
+	if n < 0 || n > labelsdb.MaxEntries {
+		return 0, fmt.Errorf("index slot %d is outside the index (0-%d)", n, labelsdb.MaxEntries)
+	}
+	return n, nil
+}
+
+// setEOF implements `set-eof {labels.db} {slot}`, writing the EOF marker into the given index slot. Nothing else in the
+// file is changed, so any signatures after the slot are hidden rather than removed, & can be recovered by moving the
+// marker back.
+func setEOF(args []string) error {
+	fs, sure, backup := surgeryFlags("set-eof")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: set-eof {labels.db} {slot} -i-know-what-im-doing [-backup]")
+	}
+	slot, err := parseSlot(args[1])
+	if err != nil {
+		return err
+	}
+
+	f, err := openForSurgery(args[0], *sure, *backup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	defer beginWrite()()
+	if err := writeSlot(f, slot, labelsdb.IndexEOF); err != nil {
+		return err
+	}
+	recordWrite(args[0])
+	log.Printf("Wrote EOF marker to index slot %d", slot)
+	return nil
+}
+
+// truncateIndex implements `truncate-index {labels.db} {count}`, keeping only the first count entries. The EOF marker is
+// moved & the file is cut off after the last remaining image.
+func truncateIndex(args []string) error {
+	fs, sure, backup := surgeryFlags("truncate-index")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: truncate-index {labels.db} {count} -i-know-what-im-doing [-backup]")
+	}
+	n, err := parseSlot(args[1])
+	if err != nil {
+		return err
+	}
+
+	f, err := openForSurgery(args[0], *sure, *backup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sigs, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		return err
+	}
+	if n > len(sigs) {
+		return fmt.Errorf("the index only has %d entries", len(sigs))
+	}
+
+	// Moving the marker & cutting off the images are one write unit, so a cancellation can't leave one without the other
+	defer beginWrite()()
+	if err := writeSlot(f, n, labelsdb.IndexEOF); err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(labelsdb.ImagesStart + n*labelsdb.EntrySize)); err != nil {
+		return err
+	}
+	recordWrite(args[0])
+	log.Printf("Truncated the index from %d to %d entries", len(sigs), n)
+	return nil
+}
+
+// swapEntries implements `swap-entries {labels.db} {slot} {slot}`, swapping two signatures in the index. With -images
+// their images are swapped too, which just moves the pair without changing which image belongs to which signature.
+func swapEntries(args []string) error {
+	fs, sure, backup := surgeryFlags("swap-entries")
+	images := fs.Bool("images", false, "swap the images along with the signatures")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return errors.New("usage: swap-entries {labels.db} {slot} {slot} -i-know-what-im-doing [-images] [-backup]")
+	}
+	a, err := parseSlot(args[1])
+	if err != nil {
+		return err
+	}
+	b, err := parseSlot(args[2])
+	if err != nil {
+		return err
+	}
+
+	f, err := openForSurgery(args[0], *sure, *backup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sigs, err := labelsdb.ReadIndex(f)
+	if err != nil {
+		return err
+	}
+	if a >= len(sigs) || b >= len(sigs) {
+		return fmt.Errorf("the index only has %d entries", len(sigs))
+	}
+
+	var imgA, imgB []byte
+	if *images {
+		imgA = make([]byte, labelsdb.EntrySize)
+		imgB = make([]byte, labelsdb.EntrySize)
+		if _, err := f.ReadAt(imgA, int64(labelsdb.ImagesStart+a*labelsdb.EntrySize)); err != nil {
+			return err
+		}
+		if _, err := f.ReadAt(imgB, int64(labelsdb.ImagesStart+b*labelsdb.EntrySize)); err != nil {
+			return err
+		}
+	}
+
+	// Both signatures & both images are one write unit, so a cancellation can't leave the pair half swapped
+	defer beginWrite()()
+	if err := writeSlot(f, a, sigs[b]); err != nil {
+		return err
+	}
+	if err := writeSlot(f, b, sigs[a]); err != nil {
+		return err
+	}
+	if *images {
+		if _, err := f.WriteAt(imgB, int64(labelsdb.ImagesStart+a*labelsdb.EntrySize)); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(imgA, int64(labelsdb.ImagesStart+b*labelsdb.EntrySize)); err != nil {
+			return err
+		}
+	}
+	recordWrite(args[0])
+
+	log.Printf("Swapped index slots %d (%08X) & %d (%08X)", a, sigs[a], b, sigs[b])
+	return nil
+}
+
+// writeSlot writes a single word into the index
+func writeSlot(f *os.File, slot int, v uint32) error {
+	b := binary.LittleEndian.AppendUint32(nil, v)
+	_, err := f.WriteAt(b, int64(labelsdb.IndexStart+slot*4))
+	return err
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// syncStateVersion is the version of the sync-state file format. A state file with any other version is an error
+// rather than being ignored, since forgetting which entries came from the source directory would mean never removing
+// them.
+const syncStateVersion = 1
+
+// syncState is what sync-state keeps next to the database: every entry it's written from the source directory, so that
+// it can tell its own entries from stock ones & knows what to put back when an image is deleted
+type syncState struct {
+	Version int    `json:"version"`
+	Source  string `json:"source"`
+	// Settings describes the conversion settings. Changing them converts every image again.
+	Settings string                 `json:"settings"`
+	Entries  map[hexSig]syncedEntry `json:"entries"`
+}
+
+// syncedEntry is an entry written from the source directory
+type syncedEntry struct {
+	File  string    `json:"file"`
+	Stamp fileStamp `json:"stamp"`
+	// Written is the SHA-256 of the entry as it was written, to tell whether something else has changed it since
+	Written string `json:"written"`
+	// Shadowed is the entry that was there before, if any, which is put back once the image is deleted
+	Shadowed []byte `json:"shadowed,omitempty"`
+}
+
+// syncStatePath returns where the sync state for the database at path is kept
+func syncStatePath(path string) string {
+	return path + ".sync"
+}
+
+// loadSyncState reads a sync state file. A missing one gives an empty state, as for a database that's never been synced.
+func loadSyncState(path string) (*syncState, error) {
+	st := &syncState{Version: syncStateVersion, Entries: make(map[hexSig]syncedEntry)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if st.Version != syncStateVersion {
+		return nil, fmt.Errorf("%s is version %d, but only version %d is understood", path, st.Version,
+			syncStateVersion)
+	}
+	if st.Entries == nil {
+		st.Entries = make(map[hexSig]syncedEntry)
+	}
+	return st, nil
+}
+
+// save writes the state to path
+func (st *syncState) save(path string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// entryDigest returns the SHA-256 of an entry as hex, for syncedEntry.Written. It's also what the conversion store
+// names the entry by.
+func entryDigest(e []byte) string {
+	sum := sha256.Sum256(e)
+	return hex.EncodeToString(sum[:])
+}
+
+// syncStateCmd implements `sync-state {labels.db} -source {dir}`, which makes the custom entries in the database exactly
+// match a directory of images: new images are added, changed ones replaced, & the entries for deleted ones removed, or
+// set back to the stock art they replaced. Stock entries without an image in the directory are never touched.
+//
+// Which entries came from the directory is kept in labels.db.sync, so only entries this command wrote are ever removed.
+// An entry that's been changed by something else since, such as a firmware update putting the stock art back, is
+// treated as the new stock art.
+func syncStateCmd(args []string) error {
+	fs := flag.NewFlagSet("sync-state", flag.ExitOnError)
+	source := fs.String("source", "", "the directory of images & manifests the database should match")
+	dryRun := fs.Bool("dry-run", false, "show what would change, without writing anything")
+	backup := fs.Bool("backup", false, "keep a copy of the original labels.db as labels.db.bak before modifying it")
+	noCache := fs.Bool("no-cache", false, "convert every image from scratch instead of reusing earlier conversions")
+	noProfile := fs.Bool("no-profile", false, "don't apply the calibration profile saved with the calibration command")
+	var scale scaleOptions
+	fs.StringVar(&scale.Mode, "scale-mode", "stretch",
+		"how to fit images to the label: stretch, fit (letterbox), fill (scale & trim), or crop (no scaling)")
+	fs.StringVar(&scale.Filter, "filter", "lanczos",
+		"resampling filter: nearest, box, linear, catmullrom, mitchell, or lanczos")
+	padColor := fs.String("pad-color", "000000", "colour for the space around fitted or cropped images, as RRGGBB[AA]")
+	fs.BoolVar(&scale.TrimEdges, "auto-trim-edges", false,
+		"trim a thin transparent or white edge left by a bad crop, so it doesn't show as a border")
+	fs.Float64Var(&scale.Sharpen, "sharpen", 0,
+		"sharpen the art once it's scaled, as the sigma of an unsharp mask such as 0.5 (0 for none)")
+	preset := registerPreset(fs)
+	var alpha alphaOptions
+	alpha.register(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := applyPreset(fs, *preset); err != nil {
+		return err
+	}
+	if len(args) != 1 || *source == "" {
+		return errors.New("usage: sync-state {labels.db} -source {dir} [-dry-run] [-backup] [flags]")
+	}
+	if err := scale.validate(); err != nil {
+		return err
+	}
+	if err := alpha.validate(); err != nil {
+		return err
+	}
+	if scale.PadColor, err = parseColor(*padColor); err != nil {
+		return err
+	}
+	path := filepath.Clean(args[0])
+	src, err := filepath.Abs(*source)
+	if err != nil {
+		return err
+	}
+
+	settings := addSettings{PreservePadding: true, Scale: scale, Alpha: alpha}
+	if !*noProfile {
+		if settings.Colour, err = loadColourProfile(); err != nil {
+			return err
+		}
+	}
+	if !*noCache {
+		if dir, err := defaultStoreDir(); err != nil {
+			log.Printf("Not caching conversions: %v", err)
+		} else if settings.Store, err = openStore(dir); err != nil {
+			log.Printf("Not caching conversions: %v", err)
+		}
+	}
+
+	notePack(src)
+	items, err := scanWatchDir(src)
+	if err != nil {
+		return err
+	}
+	want := make(map[uint32]watchItem, len(items))
+	for _, it := range items {
+		if prev, ok := want[it.Signature]; ok && prev.Filepath != it.Filepath {
+			return fmt.Errorf("%08X is in %s twice: %s & %s", it.Signature, src, prev.Filepath, it.Filepath)
+		}
+		want[it.Signature] = it
+	}
+
+	st, err := loadSyncState(syncStatePath(path))
+	if err != nil {
+		return err
+	}
+	// A different directory has nothing in common with the last one, but the entries already written from it are still
+	// this command's to remove
+	if st.Source != "" && st.Source != src {
+		log.Printf("%s was last synced from %s; syncing it from %s instead", path, st.Source, src)
+	}
+	settingsChanged := st.Settings != settings.stateKey()
+	st.Source, st.Settings = src, settings.stateKey()
+
+	db, err := labelsdb.Open(path)
+	if err != nil {
+		return err
+	}
+	orig := db.Clone()
+
+	// Entries the state doesn't match any more were changed by something else, which is now what they fall back to
+	for sig, se := range st.Entries {
+		cur, ok := db.Entry(uint32(sig))
+		if ok && entryDigest(cur) == se.Written {
+			continue
+		}
+		debugf("%08X has been changed since it was synced", uint32(sig))
+		se.Shadowed, se.Written = nil, ""
+		if ok {
+			se.Shadowed = slices.Clone(cur)
+		}
+		st.Entries[sig] = se
+	}
+
+	// Images that have been deleted go first, freeing up their slots for the new ones
+	for _, sig := range slices.Sorted(maps.Keys(st.Entries)) {
+		if _, ok := want[uint32(sig)]; ok {
+			continue
+		}
+		se := st.Entries[sig]
+		switch {
+		case se.Written == "":
+			// Not ours any more, so it's left as whatever changed it
+		case se.Shadowed != nil:
+			if err := db.PutEntry(uint32(sig), se.Shadowed); err != nil {
+				return err
+			}
+		default:
+			db.Remove(uint32(sig))
+		}
+		delete(st.Entries, sig)
+	}
+
+	opts := defaultConvertOptions()
+	opts.Colour, opts.Scale, opts.Alpha = settings.Colour, settings.Scale, settings.Alpha
+	opts.Padding = db.Padding()
+
+	changed := make([]watchItem, 0)
+	for _, sig := range slices.Sorted(maps.Keys(want)) {
+		it := want[sig]
+		se, ok := st.Entries[hexSig(sig)]
+		stamp, err := syncStamp(it)
+		if ok && se.Written != "" && !settingsChanged && err == nil && se.File == it.Filepath &&
+			se.Stamp.Size == stamp.Size && se.Stamp.ModTime.Equal(stamp.ModTime) {
+			continue
+		}
+		changed = append(changed, it)
+	}
+	imgs := make([]Image, len(changed))
+	for i, it := range changed {
+		imgs[i] = it.Image
+	}
+	if err := checkCapacity(db, imgs); err != nil {
+		return err
+	}
+
+	skipped := 0
+	for _, it := range changed {
+		img := it.Image
+		o, err := withSidecar(img, opts)
+		if err != nil {
+			log.Printf("Skipping %08X: %v", img.Signature, err)
+			skipped++
+			continue
+		}
+		b, err := loadImageStored(settings.Store, img.Filepath, o)
+		if err != nil {
+			log.Printf("Skipping %08X: %v", img.Signature, err)
+			skipped++
+			continue
+		}
+		se, ok := st.Entries[hexSig(img.Signature)]
+		old, exists := db.Entry(img.Signature)
+		if !ok && exists {
+			// The first time an image shadows an entry, the entry is kept to be put back if the image is deleted
+			se.Shadowed = slices.Clone(old)
+		}
+		if exists {
+			b = keepPadding(b, old)
+		}
+		if err := db.PutEntry(img.Signature, b); err != nil {
+			return err
+		}
+		se.File, se.Written = img.Filepath, entryDigest(b)
+		if se.Stamp, err = syncStamp(it); err != nil {
+			return err
+		}
+		st.Entries[hexSig(img.Signature)] = se
+	}
+
+	d := labelsdb.Compare(orig, db)
+	if *dryRun {
+		printDiff(d, orig.Len(), db.Len())
+		fmt.Printf("\nDry run: %s was not modified.\n", path)
+		return nil
+	}
+	if len(d.Added)+len(d.Changed)+len(d.Removed) == 0 {
+		log.Printf("%s already matches %s", path, src)
+	} else {
+		if *backup {
+			if err := backupFile(path, path+".bak"); err != nil {
+				return err
+			}
+		}
+		log.Printf("Added %s, replaced %s & removed %s entries, writing %s images to %s", formatCount(len(d.Added)),
+			formatCount(len(d.Changed)), formatCount(len(d.Removed)), formatCount(db.Len()), path)
+		if err := saveDB(path, db); err != nil {
+			return err
+		}
+	}
+	// The state is saved even when nothing changed, since it may have picked up entries changed by something else
+	if err := st.save(syncStatePath(path)); err != nil {
+		return err
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d images could not be loaded & were skipped", skipped)
+	}
+	return nil
+}
+
+// syncStamp returns the stamp used to tell whether img has changed: its own file, or for a remote image, the manifest
+// listing it
+func syncStamp(it watchItem) (fileStamp, error) {
+	if isRemote(it.Filepath) {
+		return stampFile(it.Source)
+	}
+	return stampImage(it.Image)
+}
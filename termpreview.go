@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// printPreview draws img in the terminal at half size using 24-bit colour half-block characters, so each character
+// cell shows two pixels stacked on top of each other
+func printPreview(w io.Writer, img image.Image) {
+	for _, line := range previewLines(img) {
+		io.WriteString(w, line+"\n")
+	}
+}
+
+// previewLines renders img the same way as printPreview, returning each line of the preview without a line ending so
+// that it can be positioned anywhere on the screen
+func previewLines(img image.Image) []string {
+	small := imaging.Resize(img, labelsdb.Width/2, labelsdb.Height/2, imaging.Box)
+	b := small.Bounds()
+
+	lines := make([]string, 0, (b.Dy()+1)/2)
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		var sb strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := small.NRGBAAt(x, y)
+			bottom := top
+			if y+1 < b.Max.Y {
+				bottom = small.NRGBAAt(x, y+1)
+			}
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		sb.WriteString("\x1b[0m")
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// gameTitle is a title along with where it came from & how far it can be trusted
+type gameTitle struct {
+	Title      string
+	Source     string
+	Confidence string
+}
+
+// sourceConfidence is how far a title from each source can be trusted. A title file is written by hand & a DAT is
+// matched to the exact ROM, so both are high. A registry is keyed by signature but written by someone else, so it's
+// medium, as is a ROM's filename. The internal names in ROM headers are low: they're cut off at 20 characters, often
+// abbreviated, & shared between a game's regional releases.
+var sourceConfidence = map[string]string{
+	"titles":   "high",
+	"dat":      "high",
+	"registry": "medium",
+	"file":     "medium",
+	"rom":      "low",
+}
+
+// titleLookup maps signatures to titles, gathered from several sources. Sources are added in order of preference: a
+// signature that already has a title keeps it, so a later, less trustworthy source only fills in the gaps.
+type titleLookup map[uint32]gameTitle
+
+// add adds the titles from one source
+func (t titleLookup) add(source string, titles map[uint32]string) {
+	for sig, title := range titles {
+		if _, ok := t[sig]; !ok && title != "" {
+			t[sig] = gameTitle{title, source, sourceConfidence[source]}
+		}
+	}
+}
+
+// loadTitles gathers titles from whichever of a title file, a No-Intro DAT, the title registries in the config file, & a
+// ROM index are given, in that order of preference. Any of them may be empty. The DAT is only usable along with a ROM
+// index.
+func loadTitles(titleFile, dat, roms string) (titleLookup, error) {
+	titles := make(titleLookup)
+	if titleFile != "" {
+		t, err := loadTitleFile(titleFile)
+		if err != nil {
+			return nil, err
+		}
+		titles.add("titles", t)
+	}
+	var idx *romIndex
+	if roms != "" {
+		var err error
+		if idx, err = loadROMIndex(roms); err != nil {
+			return nil, err
+		}
+		if dat != "" {
+			t, err := datTitles(dat, idx)
+			if err != nil {
+				return nil, err
+			}
+			titles.add("dat", t)
+		}
+	}
+	t, err := registryTitles()
+	if err != nil {
+		return nil, err
+	}
+	titles.add("registry", t)
+	if idx != nil {
+		titles.add("rom", idx.Titles())
+	}
+	return titles, nil
+}
+
+// loadTitleFile reads a file mapping signatures to titles. Each line is a signature followed by a comma, tab, or space
+// & then the title. Blank lines & lines starting with # are ignored.
+func loadTitleFile(filename string) (map[uint32]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	titles := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, ",\t ")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected a signature followed by a title", filename, n)
+		}
+		sig, err := HexStringTransform(line[:i])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+		titles[sig] = strings.TrimSpace(line[i+1:])
+	}
+	return titles, scanner.Err()
+}
+
+// datFile is the part of a No-Intro (Logiqx XML) DAT that's needed to name games. Year & manufacturer are optional in
+// the format: No-Intro's own DATs leave them out, but some other sets' DATs fill them in.
+type datFile struct {
+	Games []struct {
+		Name         string `xml:"name,attr"`
+		Year         string `xml:"year"`
+		Manufacturer string `xml:"manufacturer"`
+		ROMs         []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"rom"`
+	} `xml:"game"`
+}
+
+// datGame is what a DAT says about a single game
+type datGame struct {
+	Name         string
+	Year         string
+	Manufacturer string
+}
+
+// datGames matches the ROMs in idx to the games in a No-Intro DAT. The DAT's checksums cover the whole ROM rather than
+// the 8KiB the signature is made from, so ROMs are matched on their filename instead. This works for any set named to
+// No-Intro's conventions, which is how most sets are named.
+func datGames(filename string, idx *romIndex) (map[uint32]datGame, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var dat datFile
+	if err := xml.Unmarshal(b, &dat); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	games := make(map[string]datGame)
+	for _, g := range dat.Games {
+		for _, r := range g.ROMs {
+			games[strings.ToLower(r.Name)] = datGame{g.Name, strings.TrimSpace(g.Year), strings.TrimSpace(g.Manufacturer)}
+		}
+	}
+
+	matched := make(map[uint32]datGame)
+	for _, r := range idx.ROMs {
+		if g, ok := games[strings.ToLower(path.Base(r.Path))]; ok {
+			matched[uint32(r.Signature)] = g
+		}
+	}
+	return matched, nil
+}
+
+// datTitles names the ROMs in idx using a No-Intro DAT. See datGames.
+func datTitles(filename string, idx *romIndex) (map[uint32]string, error) {
+	games, err := datGames(filename, idx)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[uint32]string, len(games))
+	for sig, g := range games {
+		titles[sig] = g.Name
+	}
+	return titles, nil
+}
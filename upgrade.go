@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// stockPolicies are what upgrade-pack can do with a pack entry the new stock database now has different art for
+var stockPolicies = []string{"keep", "drop", "ask"}
+
+// upgradePack implements `upgrade-pack {old pack} -old-stock {labels.db} -new-stock {labels.db} -o {new pack}`, which
+// rebuilds a pack made against one firmware's stock database on top of the next one's. The pack's entries are the ones
+// that differ from the old stock database. Each is copied onto the new one, except that:
+//   - an entry for a stock signature the new firmware dropped moves to the signatures of the same game it added instead,
+//     using the alias table
+//   - an entry the new stock database has different art for is kept, dropped in favour of the stock art, or asked about,
+//     depending on -stock-improved
+//   - an entry the pack removed from the old stock database is removed from the new one too
+func upgradePack(args []string) error {
+	fs := flag.NewFlagSet("upgrade-pack", flag.ExitOnError)
+	oldStock := fs.String("old-stock", "", "the stock labels.db the pack was built against")
+	newStock := fs.String("new-stock", "", "the stock labels.db from the new firmware")
+	out := fs.String("o", "", "the upgraded pack to write")
+	improved := fs.String("stock-improved", "keep", "what to do with pack entries the new stock labels.db has "+
+		"different art for: keep the pack's, drop them for the stock art, or ask about each")
+	aliasFile := fs.String("aliases", "", "a file of alias groups used to find a game's new signatures "+
+		"(default the table saved with alias import)")
+	noAliases := fs.Bool("no-aliases", false, "don't use the saved alias table")
+	dryRun := fs.Bool("dry-run", false, "show what the upgraded pack would contain, without writing anything")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *oldStock == "" || *newStock == "" || *out == "" {
+		return errors.New("usage: upgrade-pack {old pack labels.db} -old-stock {labels.db} -new-stock {labels.db} " +
+			"-o {new pack labels.db} [-stock-improved keep|drop|ask] [flags]")
+	}
+	if !slices.Contains(stockPolicies, *improved) {
+		return fmt.Errorf("unknown -stock-improved %q, expected one of keep, drop, or ask", *improved)
+	}
+	notePack(args[0])
+
+	dbs := make([]*labelsdb.DB, 3)
+	for i, path := range []string{args[0], *oldStock, *newStock} {
+		if dbs[i], err = labelsdb.Open(path); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	pack, before, after := dbs[0], dbs[1], dbs[2]
+	aliases := make(aliasTable)
+	if *aliasFile != "" {
+		aliases, err = loadAliases(*aliasFile)
+	} else if !*noAliases {
+		aliases, err = loadSavedAliases()
+	}
+	if err != nil {
+		return err
+	}
+
+	custom := labelsdb.Compare(before, pack)
+	stock := labelsdb.Compare(before, after)
+	log.Printf("%s has %s custom entries & removes %s stock ones; the new firmware adds %s stock entries, changes %s, "+
+		"& removes %s", args[0], formatCount(len(custom.Added)+len(custom.Changed)), formatCount(len(custom.Removed)),
+		formatCount(len(stock.Added)), formatCount(len(stock.Changed)), formatCount(len(stock.Removed)))
+
+	sigs := append(slices.Clone(custom.Added), custom.Changed...)
+	slices.Sort(sigs)
+	explicit := make(map[uint32]bool, len(sigs))
+	for _, s := range sigs {
+		explicit[s] = true
+	}
+
+	db := after.Clone()
+	var moved, kept, dropped int
+	for _, s := range sigs {
+		entry, _ := pack.Entry(s)
+		oldStockEntry, hadStock := before.Entry(s)
+		targets := []uint32{s}
+		if slices.Contains(stock.Removed, s) {
+			if to := resolveAlias(aliases, s, stock.Added, explicit); len(to) > 0 {
+				log.Printf("%08X is no longer in the stock database, moving its entry to %s", s,
+					(&aliasGroup{Sigs: to}).sigs())
+				targets = to
+				moved++
+			} else {
+				debugf("%08X is no longer in the stock database & has no new alias, keeping it as is", s)
+			}
+		}
+
+		for _, t := range targets {
+			// The stock art only counts as improved if it's not what the old firmware had for the game, so an entry that
+			// has just moved to a new signature isn't mistaken for one
+			if stockEntry, ok := after.Entry(t); ok && !(hadStock && bytes.Equal(stockEntry, oldStockEntry)) {
+				drop, err := dropForStock(*improved, t, entry, stockEntry)
+				if err != nil {
+					return err
+				}
+				if drop {
+					log.Printf("Dropping %08X for the new stock art", t)
+					dropped++
+					continue
+				}
+				kept++
+			}
+			if err := db.PutEntry(t, entry); err != nil {
+				return fmt.Errorf("%08X: %w", t, err)
+			}
+		}
+	}
+	for _, s := range custom.Removed {
+		if db.Remove(s) {
+			debugf("Removing %08X, which the pack removed from the old stock database", s)
+		}
+	}
+	if kept > 0 {
+		log.Printf("Kept the pack's art for %s entries the new stock database changed", formatCount(kept))
+	}
+
+	if *dryRun {
+		printDiff(labelsdb.Compare(after, db), after.Len(), db.Len())
+		fmt.Printf("\nDry run: %s was not written.\n", *out)
+		return nil
+	}
+	log.Printf("Writing %s images to %s (%s moved to new signatures, %s dropped for stock art)", formatCount(db.Len()),
+		*out, formatCount(moved), formatCount(dropped))
+	return saveDB(*out, db)
+}
+
+// resolveAlias returns the signatures in added that are aliases of sig, leaving out any the pack has its own entry for
+func resolveAlias(aliases aliasTable, sig uint32, added []uint32, explicit map[uint32]bool) []uint32 {
+	g, ok := aliases[sig]
+	if !ok {
+		return nil
+	}
+	var to []uint32
+	for _, s := range g.Sigs {
+		if s != sig && !explicit[s] && slices.Contains(added, s) {
+			to = append(to, s)
+		}
+	}
+	return to
+}
+
+// dropForStock decides whether the pack's entry for sig should give way to the new stock art, following policy. With
+// ask, both are shown & the user is asked, which needs stdin to be a terminal.
+func dropForStock(policy string, sig uint32, packEntry, stockEntry []byte) (bool, error) {
+	switch policy {
+	case "keep":
+		return false, nil
+	case "drop":
+		return true, nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false, errors.New("-stock-improved ask needs a terminal; use keep or drop instead")
+	}
+	if !plainOutput {
+		fmt.Fprintln(os.Stderr, "\nThe pack's art:")
+		printPreview(os.Stderr, labelsdb.Decode(packEntry))
+		fmt.Fprintln(os.Stderr, "The new stock art:")
+		printPreview(os.Stderr, labelsdb.Decode(stockEntry))
+	}
+	fmt.Fprintf(os.Stderr, "The new stock database has different art for %08X. Keep the pack's? [Y/n] ", sig)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "n", "no":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// verifyFailed is the exit status used by verify when any database has a fatal problem
+const verifyFailed exitStatus = 1
+
+// verifyResult is a database checked by verify -json
+type verifyResult struct {
+	Path     string          `json:"path"`
+	OK       bool            `json:"ok"`
+	Entries  int             `json:"entries"`
+	Problems []verifyProblem `json:"problems"`
+}
+
+// verifyProblem is a labelsdb.Problem as written by verify -json
+type verifyProblem struct {
+	Fatal   bool   `json:"fatal"`
+	Message string `json:"message"`
+}
+
+// verify implements `verify [-json] {labels.db...}`, checking each database's structure & listing everything that's wrong with
+// it. It's meant for working out why the console is ignoring a database.
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("usage: verify [-json] {labels.db...}")
+	}
+
+	failed := false
+	results := make([]verifyResult, 0, len(args))
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		n, problems, err := labelsdb.Verify(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		fatal := 0
+		res := verifyResult{Path: path, Entries: n, Problems: make([]verifyProblem, 0, len(problems))}
+		for _, p := range problems {
+			res.Problems = append(res.Problems, verifyProblem{p.Fatal, p.Msg})
+			if p.Fatal {
+				fatal++
+			}
+		}
+		res.OK = fatal == 0
+		failed = failed || !res.OK
+		if *asJSON {
+			results = append(results, res)
+			continue
+		}
+
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", path, p)
+		}
+		switch {
+		case fatal > 0:
+			fmt.Printf("%s: FAILED with %d errors & %d warnings\n", path, fatal, len(problems)-fatal)
+		case len(problems) > 0:
+			fmt.Printf("%s: OK, %s entries, %d warnings\n", path, formatCount(n), len(problems))
+		default:
+			fmt.Printf("%s: OK, %s entries\n", path, formatCount(n))
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	}
+	if failed {
+		return verifyFailed
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchItem is an image found in a watched directory & the file it came from: the image itself if it's named after
+// its signature, or the manifest that lists it
+type watchItem struct {
+	Image
+	Source string
+}
+
+// pendingChange is a file that has changed but not yet settled
+type pendingChange struct {
+	stamp fileStamp
+	since time.Time
+}
+
+// watch implements `watch {labels.db} -dir {dir}`, which keeps an eye on a directory & adds any image that's saved
+// there, so a label can be tweaked in an editor & tried on the console without running anything in between. Images
+// are found the same way as when adding: named after their signature, or listed in a manifest. Files are polled rather
+// than watched with OS notifications, which keeps it working on network drives & in containers.
+func watch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	dir := fs.String("dir", "", "the directory to watch for images & manifests")
+	interval := fs.Duration("interval", time.Second, "how often to check the directory for changes")
+	settle := fs.Duration("settle", 500*time.Millisecond,
+		"how long a file has to stay unchanged before it's added, so half saved files are skipped")
+	initial := fs.Bool("initial", false, "add everything already in the directory when starting")
+	noProfile := fs.Bool("no-profile", false, "don't apply the calibration profile saved with the calibration command")
+	var scale scaleOptions
+	fs.StringVar(&scale.Mode, "scale-mode", "stretch",
+		"how to fit images to the label: stretch, fit (letterbox), fill (scale & trim), or crop (no scaling)")
+	fs.StringVar(&scale.Filter, "filter", "lanczos",
+		"resampling filter: nearest, box, linear, catmullrom, mitchell, or lanczos")
+	padColor := fs.String("pad-color", "000000", "colour for the space around fitted or cropped images, as RRGGBB[AA]")
+	fs.BoolVar(&scale.TrimEdges, "auto-trim-edges", false,
+		"trim a thin transparent or white edge left by a bad crop, so it doesn't show as a border")
+	fs.Float64Var(&scale.Sharpen, "sharpen", 0,
+		"sharpen the art once it's scaled, as the sigma of an unsharp mask such as 0.5 (0 for none)")
+	preset := registerPreset(fs)
+	var alpha alphaOptions
+	alpha.register(fs)
+	webhook := fs.String("webhook", "", "POST a JSON event to this URL after each write & a summary when stopping "+
+		"(default webhook in the config file)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := applyPreset(fs, *preset); err != nil {
+		return err
+	}
+	if len(args) != 1 || *dir == "" || *interval <= 0 {
+		return errors.New("usage: watch {labels.db} -dir {dir} [-interval 1s] [-settle 500ms] [-initial] " +
+			"[-webhook url] [flags]")
+	}
+	if *webhook == "" {
+		if cfg, err := loadConfig(); err == nil && len(cfg["webhook"]) > 0 {
+			*webhook = cfg["webhook"][0]
+		}
+	}
+	if err := scale.validate(); err != nil {
+		return err
+	}
+	if err := alpha.validate(); err != nil {
+		return err
+	}
+	if scale.PadColor, err = parseColor(*padColor); err != nil {
+		return err
+	}
+
+	// Every change is an intentional edit, so replacements never need confirming. The store is skipped since each
+	// save would leave another conversion in it that's never used again.
+	settings := addSettings{PreservePadding: true, Scale: scale, Alpha: alpha,
+		Policy: confirmPolicy{Yes: true, Threshold: -1}}
+	if !*noProfile {
+		if settings.Colour, err = loadColourProfile(); err != nil {
+			return err
+		}
+	}
+
+	ctx := cancelContext()
+	started := time.Now().UTC().Truncate(time.Second)
+	total := newAddReport(filepath.Clean(args[0]), false)
+
+	seen := make(map[string]fileStamp)
+	pending := make(map[string]pendingChange)
+	first := true
+	log.Printf("Watching %s for changes; press Ctrl-C to stop", *dir)
+	for {
+		items, err := scanWatchDir(*dir)
+		if err != nil {
+			return err
+		}
+
+		// Anything whose stamp differs from the last one added is pending until it's stayed the same for -settle
+		now := time.Now()
+		ready := make(map[string]bool)
+		present := make(map[string]bool)
+		for _, path := range watchPaths(items) {
+			present[path] = true
+			st, err := stampFile(path)
+			if err != nil {
+				continue
+			}
+			if first && !*initial {
+				seen[path] = st
+				continue
+			}
+			if prev, ok := seen[path]; ok && prev == st {
+				delete(pending, path)
+				continue
+			}
+			if p, ok := pending[path]; !ok || p.stamp != st {
+				pending[path] = pendingChange{st, now}
+				continue
+			}
+			if now.Sub(pending[path].since) >= *settle {
+				ready[path] = true
+			}
+		}
+		for path := range seen {
+			if !present[path] {
+				delete(seen, path)
+			}
+		}
+		first = false
+
+		if len(ready) > 0 {
+			imgs := make([]Image, 0, len(ready))
+			for _, it := range items {
+				if ready[it.Filepath] || ready[it.Source] || ready[it.Sidecar] {
+					imgs = append(imgs, it.Image)
+				}
+			}
+			// A failed add is logged & tried again on the next change rather than stopping the watch
+			settings.Report = newAddReport(filepath.Clean(args[0]), false)
+			if _, err := addImages(filepath.Clean(args[0]), imgs, settings); err != nil {
+				log.Print(err)
+			} else if *webhook != "" {
+				postWebhook(*webhook, newWebhookEvent("update", settings.Report))
+			}
+			total.Added = append(total.Added, settings.Report.Added...)
+			total.Replaced = append(total.Replaced, settings.Report.Replaced...)
+			total.Errors = append(total.Errors, settings.Report.Errors...)
+			for path := range ready {
+				seen[path] = pending[path].stamp
+				delete(pending, path)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Print("Stopped watching")
+			if *webhook != "" {
+				e := newWebhookEvent("summary", total)
+				e.Started = started
+				postWebhook(*webhook, e)
+			}
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// scanWatchDir finds every image in dir & its subdirectories that's named after a signature or listed in a manifest.
+// Each image's sidecar is picked up with it. Hidden files & anything else are ignored, since editors leave all sorts of
+// temporary files around.
+func scanWatchDir(dir string) ([]watchItem, error) {
+	items := make([]watchItem, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if isSidecar(path) {
+			// Read along with its image
+			return nil
+		} else if isManifest(path) {
+			imgs, err := loadManifest(path)
+			if err != nil {
+				// Most likely saved halfway; it'll be read again on the next pass
+				return nil
+			}
+			for _, img := range imgs {
+				if !isRemote(img.Filepath) && img.Sidecar == "" {
+					img.Sidecar = findSidecar(img.Filepath)
+				}
+				items = append(items, watchItem{img, path})
+			}
+		} else if isImageFile(path) {
+			sig, err := HexStringTransform(strings.TrimSuffix(d.Name(), filepath.Ext(d.Name())))
+			if err != nil {
+				return nil
+			}
+			items = append(items, watchItem{Image{Filepath: path, Signature: sig, Sidecar: findSidecar(path)}, path})
+		}
+		return nil
+	})
+	return items, err
+}
+
+// watchPaths returns every file the items depend on: the images themselves, their sidecars, & the manifests listing them
+func watchPaths(items []watchItem) []string {
+	seen := make(map[string]bool)
+	paths := make([]string, 0, len(items))
+	for _, it := range items {
+		for _, p := range []string{it.Source, it.Filepath, it.Sidecar} {
+			if p != "" && !seen[p] && !isRemote(p) {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookClient is used for webhooks, which shouldn't hold up a watch for long if the server's slow
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookEvent is what's POSTed to -webhook: an "update" after each batch of entries is written, & a "summary" when the
+// run stops. Content & Text hold the same one line description, so Discord & Slack style webhooks can take the event
+// as it is; anything else can use the rest of the fields. There's no list of removed entries, since a watch only ever
+// adds & replaces them.
+type webhookEvent struct {
+	Event    string          `json:"event"`
+	Time     time.Time       `json:"time"`
+	Database string          `json:"database"`
+	Added    []addReportItem `json:"added"`
+	Replaced []addReportItem `json:"replaced"`
+	Errors   []addReportItem `json:"errors"`
+	// Started is when the run began, in a summary
+	Started time.Time `json:"started,omitzero"`
+	Content string    `json:"content"`
+	Text    string    `json:"text"`
+}
+
+// newWebhookEvent returns an event with the lists from report, along with a description of them
+func newWebhookEvent(event string, report *addReport) webhookEvent {
+	e := webhookEvent{Event: event, Time: time.Now().UTC().Truncate(time.Second), Database: report.Database,
+		Added: report.Added, Replaced: report.Replaced, Errors: report.Errors}
+	e.Content = fmt.Sprintf("%s: %s added, %s replaced", report.Database, formatCount(len(e.Added)),
+		formatCount(len(e.Replaced)))
+	if len(e.Errors) > 0 {
+		e.Content += fmt.Sprintf(", %s failed", formatCount(len(e.Errors)))
+	}
+	e.Text = e.Content
+	return e
+}
+
+// postWebhook sends an event to a webhook. A webhook that fails is only logged, since it's never worth stopping for.
+// Webhook URLs often have a token in the path, so only the host is logged.
+func postWebhook(rawURL string, e webhookEvent) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Webhook to %s failed: %v", host, err)
+		return
+	}
+	resp, err := webhookClient.Post(rawURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		// The error includes the URL
+		log.Printf("Webhook to %s failed", host)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Webhook to %s failed: %s", host, resp.Status)
+		return
+	}
+	debugf("Sent a %s webhook to %s", e.Event, host)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/g026r/analogue3d_labels_tool/labelsdb"
+)
+
+// why implements `why {labels.db} -rom {game.z64}`, walking through how the console finds a label for a ROM: the
+// signature it computes, whether the index has it, whether the entry is stock or custom, & where a custom one came from
+// if that was recorded. It's meant as the first stop when a cartridge shows the wrong label or none at all.
+func why(args []string) error {
+	fs := flag.NewFlagSet("why", flag.ExitOnError)
+	rom := fs.String("rom", "", "the ROM to explain")
+	stock := fs.String("stock", "", "a stock labels.db, to tell stock entries from custom ones")
+	state := fs.String("state", "", "a -since-state file, used to find which image a custom entry came from")
+	roms := fs.String("roms", "", "a ROM index from index-roms, used to find other ROMs with the same signature")
+	registerROMCheck(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *rom == "" {
+		return errors.New("usage: why {labels.db} -rom {rom file} [-stock stock labels.db] [-state state.json] " +
+			"[-roms roms.idx]")
+	}
+
+	// 1. The signature
+	hdr, err := readROMHeader(*rom)
+	if err != nil {
+		return err
+	}
+	order, err := romByteOrder(*rom)
+	if err != nil {
+		return err
+	}
+	sig := hdr.Signature
+	out := fields{w: os.Stdout, width: len("Signature")}
+	out.printf("ROM", "%s", *rom)
+	out.printf("", "%s, %q, %s", order, hdr.Name, hdr.Region)
+	out.printf("Signature", "%08X (CRC32 of the first 8KiB in big endian order)", sig)
+	if known, err := loadSavedCollisions(); err == nil && known.shared(sig) != nil {
+		out.printf("", "known to be shared by %s, which will all show the same label",
+			strings.Join(known.shared(sig), "; "))
+	}
+	if *roms != "" {
+		idx, err := loadROMIndex(*roms)
+		if err != nil {
+			return err
+		}
+		for _, r := range idx.ROMs {
+			if uint32(r.Signature) == sig && filepath.Base(r.Path) != filepath.Base(*rom) {
+				out.printf("", "shared with %s, which will show the same label", r.Path)
+			}
+		}
+	}
+
+	// 2. The index
+	db, err := labelsdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	sigs := db.Signatures()
+	i, found := slices.BinarySearch(sigs, sig)
+	if !found {
+		out.printf("Index", "not found among %s entries, so the console shows no label", formatCount(len(sigs)))
+		for _, s := range sigs {
+			if hexDigitsDiffer(s, sig) == 1 {
+				out.printf("", "%08X is one digit off: was it added under a mistyped signature?", s)
+			}
+		}
+		return nil
+	}
+	out.printf("Index", "found in slot %s, entry at 0x%06X", formatCount(i),
+		labelsdb.ImagesStart+int64(i)*labelsdb.EntrySize)
+
+	// 3. The entry
+	entry, _ := db.Entry(sig)
+	if labelsdb.IsBlank(entry) {
+		out.printf("Entry", "blank: every pixel is the same colour")
+	}
+	switch {
+	case *stock == "":
+		out.printf("Entry", "pass -stock with a stock labels.db to tell whether this is stock art")
+	default:
+		stockDB, err := labelsdb.Open(*stock)
+		if err != nil {
+			return err
+		}
+		if s, ok := stockDB.Entry(sig); !ok {
+			out.printf("Entry", "custom: the stock database has no label for this game")
+		} else if bytes.Equal(s[:len(s)-labelsdb.PaddingSize], entry[:len(entry)-labelsdb.PaddingSize]) {
+			out.printf("Entry", "stock art")
+		} else {
+			out.printf("Entry", "custom: replaces the stock art")
+		}
+	}
+
+	// 4. Where it came from
+	if *state == "" {
+		out.printf("Source", "pass -state with a -since-state file to see which image was added")
+		return nil
+	}
+	st, err := loadRunState(*state)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0)
+	for p, in := range st.Inputs {
+		if uint32(in.Signature) == sig {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	if len(paths) == 0 {
+		out.printf("Source", "not recorded in the state file")
+	}
+	for _, p := range paths {
+		in := st.Inputs[p]
+		note := ""
+		if fs, err := stampFile(p); err != nil {
+			note = " (since deleted)"
+		} else if fs.Size != in.Size || !fs.ModTime.Equal(in.ModTime) {
+			note = " (changed since it was added)"
+		}
+		out.printf("Source", "%s%s", p, note)
+	}
+	return nil
+}
+
+// romByteOrder describes the byte order of the ROM at path
+func romByteOrder(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b := make([]byte, 4)
+	if _, err := f.Read(b); err != nil {
+		return "", err
+	}
+	switch binary.BigEndian.Uint32(b) {
+	case magicV64:
+		return "byte-swapped (.v64)", nil
+	case magicN64:
+		return "little endian (.n64)", nil
+	}
+	return "big endian (.z64)", nil
+}
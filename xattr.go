@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// keepXattrs copies the extended attributes of the file at orig onto f, such as macOS Finder tags & quarantine flags
+// or SELinux labels, so that replacing a file doesn't quietly drop them. Failures are ignored: many filesystems,
+// including the FAT32 & exFAT an SD card is normally formatted with, don't support them at all.
+func keepXattrs(f *os.File, orig string) {
+	size, err := unix.Listxattr(orig, nil)
+	if err != nil || size <= 0 {
+		return
+	}
+	names := make([]byte, size)
+	if size, err = unix.Listxattr(orig, names); err != nil {
+		return
+	}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		n, err := unix.Getxattr(orig, string(name), nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = unix.Getxattr(orig, string(name), value); err != nil {
+			continue
+		}
+		unix.Fsetxattr(int(f.Fd()), string(name), value[:n], 0)
+	}
+}
+
+// setXattr sets the extended attribute name on the file at path
+func setXattr(path, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}
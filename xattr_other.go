@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// keepXattrs does nothing on this platform, where extended attributes aren't supported
+func keepXattrs(f *os.File, orig string) {}
+
+// setXattr always fails on this platform, where extended attributes aren't supported
+func setXattr(path, name string, value []byte) error {
+	return errors.ErrUnsupported
+}